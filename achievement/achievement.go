@@ -0,0 +1,336 @@
+// Package achievement unlocks achievements of CHIP-8 programs when
+// conditions on their memory and registers hold, as a local incentive
+// without any online service.
+//
+// The achievements of a ROM are defined in a text file next to it, with the
+// extension .achievements, an achievement a line:
+//
+//	# id "title" condition [for n frames]
+//	score10 "Ten points" mem[0x3f0] >= 10
+//	level2 "Level two" mem[0x3f0] >= 10 && V5 == 2 for 60 frames
+//
+// Conditions are expressions as in the debugger, and must hold at the start
+// of n frames in a row, 1 by default. The achievements unlocked are kept per
+// ROM in the user's configuration directory, named after the SHA-256 hash of
+// the ROM.
+package achievement
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/expr"
+)
+
+// Achievement is unlocked when its condition holds for a number of frames.
+type Achievement struct {
+	ID    string
+	Title string
+
+	Condition *expr.Expr
+	// Number of frames in a row the condition must hold
+	Frames int
+}
+
+func (a Achievement) String() string {
+	s := fmt.Sprintf("%s %q %s", a.ID, a.Title, a.Condition)
+	if a.Frames > 1 {
+		s += fmt.Sprintf(" for %d frames", a.Frames)
+	}
+
+	return s
+}
+
+var framesSuffix = regexp.MustCompile(`\s+for\s+(\d+)\s+frames?\s*$`)
+
+// Read reads achievements in the definition file format.
+func Read(r io.Reader) ([]Achievement, error) {
+	var achievements []Achievement
+	ids := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		a, err := parseAchievement(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if ids[a.ID] {
+			return nil, fmt.Errorf("line %d: achievement %q already defined", n, a.ID)
+		}
+		ids[a.ID] = true
+		achievements = append(achievements, a)
+	}
+
+	return achievements, scanner.Err()
+}
+
+func parseAchievement(line string) (Achievement, error) {
+	a := Achievement{Frames: 1}
+
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return a, errors.New(`expected id "title" condition [for n frames]`)
+	}
+	a.ID, line = line[:i], strings.TrimSpace(line[i:])
+
+	if line == "" || line[0] != '"' {
+		return a, errors.New("expected a quoted title")
+	}
+	end := strings.IndexByte(line[1:], '"')
+	if end < 0 {
+		return a, errors.New("unterminated title")
+	}
+	a.Title, line = line[1:end+1], line[end+2:]
+
+	if m := framesSuffix.FindStringSubmatchIndex(line); m != nil {
+		frames, err := strconv.Atoi(line[m[2]:m[3]])
+		if err != nil || frames < 1 {
+			return a, fmt.Errorf("invalid number of frames %q", line[m[2]:m[3]])
+		}
+		a.Frames, line = frames, line[:m[0]]
+	}
+
+	if strings.TrimSpace(line) == "" {
+		return a, errors.New("missing condition")
+	}
+	var err error
+	if a.Condition, err = expr.Parse(line); err != nil {
+		return a, fmt.Errorf("condition: %s", err)
+	}
+
+	return a, nil
+}
+
+// Path returns the path of the definition file of the ROM at path.
+func Path(rom string) string {
+	return strings.TrimSuffix(rom, filepath.Ext(rom)) + ".achievements"
+}
+
+// Load reads the definition file at path. A missing file defines no
+// achievements.
+func Load(path string) ([]Achievement, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	achievements, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return achievements, nil
+}
+
+// Unlocks are the times achievements were unlocked, by id.
+type Unlocks map[string]time.Time
+
+// UnlocksPath returns the path of the file keeping the achievements unlocked
+// in a ROM, in the achievements directory of the user's configuration
+// directory.
+func UnlocksPath(rom []byte) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(rom)
+	return filepath.Join(dir, "chip8", "achievements", hex.EncodeToString(sum[:])+".unlocked"), nil
+}
+
+// ReadUnlocks reads unlocks written by Write, a line with the id and the
+// time of each.
+func ReadUnlocks(r io.Reader) (Unlocks, error) {
+	u := make(Unlocks)
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected id time", n)
+		}
+
+		t, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time %q", n, fields[1])
+		}
+		u[fields[0]] = t
+	}
+
+	return u, scanner.Err()
+}
+
+// Write writes the unlocks in the order they were unlocked.
+func (u Unlocks) Write(w io.Writer) error {
+	ids := make([]string, 0, len(u))
+	for id := range u {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ti, tj := u[ids[i]], u[ids[j]]; !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return ids[i] < ids[j]
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, id := range ids {
+		fmt.Fprintf(bw, "%s %s\n", id, u[id].UTC().Format(time.RFC3339))
+	}
+
+	return bw.Flush()
+}
+
+// LoadUnlocks reads the unlocks at path. A missing file holds none.
+func LoadUnlocks(path string) (Unlocks, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return make(Unlocks), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	u, err := ReadUnlocks(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return u, nil
+}
+
+// SaveUnlocks writes the unlocks at path, creating its directory.
+func SaveUnlocks(path string, u Unlocks) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := u.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Engine is a hook evaluating the conditions of the achievements not
+// unlocked yet once a frame: before the first instruction, and before the
+// first instruction after each tick of the timers. Attach it with
+// chip8.State.AddHook. Unlocks may be called while the program runs in
+// another goroutine.
+type Engine struct {
+	state        *chip8.State
+	achievements []Achievement
+	unlocked     func(Achievement, time.Time)
+	now          func() time.Time
+
+	mu      sync.Mutex
+	unlocks Unlocks
+
+	// Number of frames in a row the condition of each achievement held, and
+	// whether a frame started since the conditions were last evaluated
+	held  []int
+	frame bool
+}
+
+// NewEngine returns an engine for the achievements of the program running
+// in state, already unlocked as in unlocks, which may be nil. When an
+// achievement is unlocked, unlocked is called, if set, from the goroutine
+// running the program.
+func NewEngine(state *chip8.State, achievements []Achievement, unlocks Unlocks, unlocked func(Achievement, time.Time)) *Engine {
+	e := &Engine{
+		state:        state,
+		achievements: achievements,
+		unlocked:     unlocked,
+		now:          time.Now,
+		unlocks:      make(Unlocks),
+		held:         make([]int, len(achievements)),
+		frame:        true,
+	}
+	for id, t := range unlocks {
+		e.unlocks[id] = t
+	}
+
+	return e
+}
+
+func (e *Engine) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	if e.frame {
+		e.evaluate()
+		e.frame = false
+	}
+}
+
+func (e *Engine) OnTick() {
+	e.frame = true
+}
+
+func (e *Engine) evaluate() {
+	for i, a := range e.achievements {
+		e.mu.Lock()
+		_, done := e.unlocks[a.ID]
+		e.mu.Unlock()
+		if done {
+			continue
+		}
+
+		if a.Condition.Eval(e.state) == 0 {
+			e.held[i] = 0
+			continue
+		}
+		if e.held[i]++; e.held[i] < a.Frames {
+			continue
+		}
+
+		t := e.now()
+		e.mu.Lock()
+		e.unlocks[a.ID] = t
+		e.mu.Unlock()
+		if e.unlocked != nil {
+			e.unlocked(a, t)
+		}
+	}
+}
+
+// Unlocks returns a copy of the achievements unlocked.
+func (e *Engine) Unlocks() Unlocks {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	u := make(Unlocks, len(e.unlocks))
+	for id, t := range e.unlocks {
+		u[id] = t
+	}
+
+	return u
+}
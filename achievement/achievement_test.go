@@ -0,0 +1,142 @@
+package achievement
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const definitions = `# id "title" condition [for n frames]
+score10 "Ten points" mem[0x3f0] >= 10
+
+level2  "Level two"  mem[0x3f0] >= 10 && V5 == 2 for 3 frames
+`
+
+func TestRead(t *testing.T) {
+	achievements, err := Read(strings.NewReader(definitions))
+	require.NoError(t, err)
+	require.Len(t, achievements, 2)
+
+	assert.Equal(t, "score10", achievements[0].ID)
+	assert.Equal(t, "Ten points", achievements[0].Title)
+	assert.Equal(t, 1, achievements[0].Frames)
+	assert.Equal(t, `score10 "Ten points" mem[0x3f0] >= 10`, achievements[0].String())
+	assert.Equal(t, `level2 "Level two" mem[0x3f0] >= 10 && V5 == 2 for 3 frames`, achievements[1].String())
+
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"score10", `line 1: expected id "title" condition [for n frames]`},
+		{"score10 Ten mem[0x3f0]", "line 1: expected a quoted title"},
+		{`score10 "Ten mem[0x3f0]`, "line 1: unterminated title"},
+		{`score10 "Ten" for 3 frames`, "line 1: missing condition"},
+		{`score10 "Ten" V5 == for 3 frames`, "line 1: condition: column 7: unexpected end of expression"},
+		{`score10 "Ten" V5 for 0 frames`, `line 1: invalid number of frames "0"`},
+		{"a \"A\" V1\na \"B\" V2", `line 2: achievement "a" already defined`},
+	}
+	for _, tt := range tests {
+		_, err := Read(strings.NewReader(tt.src))
+		assert.EqualError(t, err, tt.err, tt.src)
+	}
+}
+
+func TestUnlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "achievement")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "achievements", "game.unlocked")
+	u, err := LoadUnlocks(path)
+	require.NoError(t, err)
+	assert.Empty(t, u)
+
+	first := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	u = Unlocks{"level2": first.Add(time.Hour), "score10": first}
+	var out bytes.Buffer
+	require.NoError(t, u.Write(&out))
+	assert.Equal(t, "score10 2020-05-01T12:00:00Z\nlevel2 2020-05-01T13:00:00Z\n", out.String())
+
+	require.NoError(t, SaveUnlocks(path, u))
+	loaded, err := LoadUnlocks(path)
+	require.NoError(t, err)
+	assert.Equal(t, u, loaded)
+
+	_, err = ReadUnlocks(strings.NewReader("score10 yesterday\n"))
+	assert.EqualError(t, err, `line 1: invalid time "yesterday"`)
+}
+
+func TestPath(t *testing.T) {
+	assert.Equal(t, "games/pong.achievements", Path("games/pong.ch8"))
+
+	path, err := UnlocksPath([]byte{0x12, 0x00})
+	if err != nil {
+		t.Skip("no configuration directory")
+	}
+	assert.Equal(t, "achievements", filepath.Base(filepath.Dir(path)))
+	assert.Len(t, filepath.Base(path), 64+len(".unlocked"))
+}
+
+func TestEngine(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram([]byte{
+		0x70, 0x01, // 0x200: V0 += 1
+		0x12, 0x00, // 0x202: Jump to 0x200
+	})
+	c8.Poke(0x3f0, 10)
+
+	achievements, err := Read(strings.NewReader(definitions))
+	require.NoError(t, err)
+
+	var unlocked []string
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	e := NewEngine(&c8, achievements, nil, func(a Achievement, t time.Time) {
+		unlocked = append(unlocked, a.ID)
+	})
+	e.now = func() time.Time { return now }
+	c8.AddHook(e)
+
+	frame := func() {
+		for i := 0; i < c8.ClockRate()/chip8.TimerRate; i++ {
+			c8.Step()
+		}
+		c8.Tick()
+	}
+
+	// Conditions are evaluated at the start of the frame
+	frame()
+	assert.Equal(t, []string{"score10"}, unlocked)
+
+	c8.V[5] = 2
+	frame()
+	frame()
+	assert.Equal(t, []string{"score10"}, unlocked)
+
+	// The condition must hold for frames in a row
+	c8.V[5] = 0
+	frame()
+	c8.V[5] = 2
+	frame()
+	frame()
+	assert.Equal(t, []string{"score10"}, unlocked)
+	frame()
+	assert.Equal(t, []string{"score10", "level2"}, unlocked)
+	assert.Equal(t, Unlocks{"score10": now, "level2": now}, e.Unlocks())
+
+	// Achievements already unlocked are not unlocked again
+	unlocked = nil
+	e = NewEngine(&c8, achievements, Unlocks{"score10": now}, func(a Achievement, t time.Time) {
+		unlocked = append(unlocked, a.ID)
+	})
+	c8.AddHook(e)
+	frame()
+	assert.Empty(t, unlocked)
+}
@@ -0,0 +1,663 @@
+// Package asm assembles CHIP-8 programs written in the classic assembler
+// syntax, the syntax written by the disassembler, so a disassembled program
+// assembles back into the same bytes.
+//
+// A line holds an optional label, a statement and an optional comment:
+//
+//	loop:   ADD V0, 1        ; comment
+//
+// Mnemonics, directives, registers and keywords are case-insensitive; labels
+// and constants are not. Numbers are decimal, 0x hexadecimal or 0b binary,
+// and operands may be arithmetic expressions over labels, constants and $,
+// the address of the current statement, with the operators of C.
+//
+// The directives are:
+//
+//	name equ expr        define a constant, also written name = expr
+//	db expr|"str", ...   emit bytes
+//	dw expr, ...         emit big-endian 16-bit words
+//	align n              pad with zeros to a multiple of n
+//	org addr             continue assembling at addr
+//	include "file"       assemble another file, relative to this one
+//	macro name a, b      define a macro, up to endm; \@ in its body is
+//	...                  replaced by a number unique to the expansion
+//	endm
+package asm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Address programs are loaded at
+const programAddress = 0x200
+
+// Size of the memory programs are assembled into
+const memorySize = 0x1000
+
+// Deepest nesting of includes and macro expansions
+const maxDepth = 64
+
+// Program is an assembled program.
+type Program struct {
+	// Bytes of the program, loaded at 0x200
+	ROM []byte
+
+	// Addresses of the labels, by name
+	Labels map[string]uint16
+
+	// Values of the constants, by name
+	Constants map[string]int
+
+	// Source of the bytes of the program
+	Lines SourceMap
+
+	// Positions the labels and constants are defined at, by name
+	Definitions map[string]Pos
+
+	// Uses of labels and constants in the source, in source order
+	References []Reference
+}
+
+// Reference is a use of a label or constant.
+type Reference struct {
+	Name string
+	Pos  Pos
+}
+
+// Line maps bytes of an assembled program to the line they were assembled
+// from.
+type Line struct {
+	Addr uint16
+	Size int
+	Pos  Pos
+}
+
+// SourceMap maps the bytes of a program to the lines of source they were
+// built from. Its lines are in address order and do not overlap.
+type SourceMap []Line
+
+// Lookup returns the line the byte at addr was built from.
+func (m SourceMap) Lookup(addr uint16) (Line, bool) {
+	i := sort.Search(len(m), func(i int) bool { return int(m[i].Addr)+m[i].Size > int(addr) })
+	if i < len(m) && m[i].Addr <= addr {
+		return m[i], true
+	}
+
+	return Line{}, false
+}
+
+// Assembler assembles programs.
+type Assembler struct {
+	// ReadFile reads included files. When nil, files are read from the file
+	// system.
+	ReadFile func(name string) ([]byte, error)
+}
+
+// Assemble assembles src, read from the file name, reading included files
+// from the file system. Errors are returned as an ErrorList.
+func Assemble(name string, src []byte) (*Program, error) {
+	return (&Assembler{}).Assemble(name, src)
+}
+
+// AssembleFile assembles the file at path.
+func AssembleFile(path string) (*Program, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Assemble(path, src)
+}
+
+// Assemble assembles src, read from the file name. Errors are returned as an
+// ErrorList.
+func (as *Assembler) Assemble(name string, src []byte) (*Program, error) {
+	a := &assembler{
+		readFile: as.ReadFile,
+		symbols:  make(map[string]*symbol),
+		macros:   make(map[string]*macro),
+		pc:       programAddress,
+	}
+	if a.readFile == nil {
+		a.readFile = ioutil.ReadFile
+	}
+
+	a.source(name, src)
+	if a.macroDef != nil {
+		a.errorf(a.macroDef.pos, "macro %q has no endm", a.macroDef.name)
+	}
+
+	p := a.emit()
+	if len(a.errors) > 0 {
+		sort.SliceStable(a.errors, func(i, j int) bool {
+			return a.errors[i].Pos.less(a.errors[j].Pos)
+		})
+		return nil, a.errors
+	}
+
+	return p, nil
+}
+
+type symbol struct {
+	pos Pos
+
+	// Address of the label, or of the statement the constant is defined at
+	pc int
+
+	// Expression of a constant, nil for labels
+	expr expr
+
+	// Whether the value of a constant is being evaluated, to detect cycles
+	resolving bool
+}
+
+type macro struct {
+	name   string
+	params []string
+	body   []sourceLine
+	pos    Pos
+}
+
+type sourceLine struct {
+	pos  Pos
+	text string
+}
+
+// statement is a statement which emits bytes.
+type statement struct {
+	pos Pos
+
+	// db, dw, align or an instruction mnemonic
+	name     string
+	operands [][]token
+	end      Pos
+
+	pc   int
+	size int
+}
+
+type assembler struct {
+	readFile func(name string) ([]byte, error)
+
+	symbols    map[string]*symbol
+	macros     map[string]*macro
+	statements []*statement
+	errors     ErrorList
+
+	// Address of the next statement
+	pc int
+
+	// Macro being defined
+	macroDef *macro
+
+	// Files being included and macros being expanded, innermost last
+	stack []string
+
+	// Number of macro expansions, to make \@ unique
+	expansions int
+
+	// Uses of symbols seen while evaluating expressions, which may evaluate
+	// more than once
+	references map[Reference]bool
+}
+
+func (a *assembler) errorf(pos Pos, format string, args ...interface{}) {
+	a.error(&Error{pos, fmt.Sprintf(format, args...)})
+}
+
+// error records err, unless it has been recorded already, which happens when
+// a constant is evaluated more than once.
+func (a *assembler) error(err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Msg: err.Error()}
+	}
+
+	for _, prev := range a.errors {
+		if *prev == *e {
+			return
+		}
+	}
+	a.errors = append(a.errors, e)
+}
+
+// source assembles the lines of a file.
+func (a *assembler) source(name string, src []byte) {
+	a.stack = append(a.stack, name)
+	defer func() { a.stack = a.stack[:len(a.stack)-1] }()
+
+	lines := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+	for i, text := range lines {
+		a.line(Pos{File: name, Line: i + 1, Col: 1}, text)
+	}
+}
+
+// line assembles a line of source, or adds it to the macro being defined.
+func (a *assembler) line(pos Pos, text string) {
+	tokens, err := lex(pos, text)
+	if err != nil && a.macroDef == nil {
+		a.error(err)
+		return
+	}
+
+	if a.macroDef != nil {
+		if len(tokens) > 0 && strings.EqualFold(tokens[0].text, "endm") {
+			a.macros[a.macroDef.name] = a.macroDef
+			a.macroDef = nil
+			return
+		}
+		a.macroDef.body = append(a.macroDef.body, sourceLine{pos, text})
+		return
+	}
+
+	if len(tokens) >= 2 && tokens[0].kind == tokIdent && tokens[1].text == ":" {
+		a.defineLabel(tokens[0])
+		tokens = tokens[2:]
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	head := tokens[0]
+	if head.kind != tokIdent {
+		a.errorf(head.pos, "unexpected %q at the start of a statement", head.text)
+		return
+	}
+
+	end := pos
+	end.Col = tokens[len(tokens)-1].end + 1
+
+	if len(tokens) >= 2 && (strings.EqualFold(tokens[1].text, "equ") || tokens[1].text == "=") {
+		a.defineConstant(head, tokens[2:], end)
+		return
+	}
+
+	switch name := strings.ToLower(head.text); name {
+	case "macro":
+		a.defineMacro(head, tokens[1:])
+	case "endm":
+		a.errorf(head.pos, "endm outside of a macro")
+	case "include":
+		a.include(head, tokens[1:])
+	case "org":
+		if v, ok := a.directiveValue(head, tokens[1:], end); ok {
+			if v < programAddress || v >= memorySize {
+				a.errorf(tokens[1].pos, "org address out of range: 0x%x", v)
+				return
+			}
+			a.pc = v
+		}
+	case "align":
+		if v, ok := a.directiveValue(head, tokens[1:], end); ok {
+			if v < 1 {
+				a.errorf(tokens[1].pos, "alignment must be positive, not %d", v)
+				return
+			}
+			a.add(&statement{pos: head.pos, name: name, size: (v - a.pc%v) % v})
+		}
+	case "db", "dw":
+		operands := splitOperands(tokens[1:])
+		if len(operands) == 0 {
+			a.errorf(end, "missing operand")
+			return
+		}
+
+		size := 0
+		for _, o := range operands {
+			switch {
+			case name == "dw":
+				size += 2
+			case len(o) == 1 && o[0].kind == tokString:
+				size += len(o[0].text)
+			default:
+				size++
+			}
+		}
+		a.add(&statement{pos: head.pos, name: name, operands: operands, end: end, size: size})
+	default:
+		if m, ok := a.macros[head.text]; ok {
+			a.expand(m, head, tokens[1:], text)
+			return
+		}
+
+		mnemonic := strings.ToUpper(head.text)
+		if _, ok := forms[mnemonic]; !ok {
+			a.errorf(head.pos, "unknown instruction %q", head.text)
+			return
+		}
+		a.add(&statement{pos: head.pos, name: mnemonic, operands: splitOperands(tokens[1:]), end: end, size: 2})
+	}
+}
+
+// add adds a statement emitting bytes at the current address.
+func (a *assembler) add(s *statement) {
+	if a.pc+s.size > memorySize && a.pc <= memorySize {
+		a.errorf(s.pos, "program does not fit in memory")
+	}
+
+	s.pc = a.pc
+	a.pc += s.size
+	a.statements = append(a.statements, s)
+}
+
+func (a *assembler) define(t token, sym *symbol) bool {
+	if reserved(t.text) {
+		a.errorf(t.pos, "%q is reserved and cannot be defined", t.text)
+		return false
+	}
+	if prev, ok := a.symbols[t.text]; ok {
+		a.errorf(t.pos, "%q redefined, previous definition at %s", t.text, prev.pos)
+		return false
+	}
+
+	a.symbols[t.text] = sym
+	return true
+}
+
+func (a *assembler) defineLabel(t token) {
+	a.define(t, &symbol{pos: t.pos, pc: a.pc})
+}
+
+func (a *assembler) defineConstant(t token, tokens []token, end Pos) {
+	e, err := parseExpr(tokens, end)
+	if err != nil {
+		a.error(err)
+		return
+	}
+
+	a.define(t, &symbol{pos: t.pos, pc: a.pc, expr: e})
+}
+
+// reference records a use of a symbol.
+func (a *assembler) reference(name string, pos Pos) {
+	if a.references == nil {
+		a.references = make(map[Reference]bool)
+	}
+	a.references[Reference{name, pos}] = true
+}
+
+// lookup returns the value of a symbol. Labels after the statement being
+// assembled are only known once all the source has been read.
+func (a *assembler) lookup(name string, pos Pos) (int, error) {
+	sym, ok := a.symbols[name]
+	if !ok {
+		return 0, &Error{pos, fmt.Sprintf("undefined symbol %q", name)}
+	}
+	if sym.expr == nil {
+		return sym.pc, nil
+	}
+	if sym.resolving {
+		return 0, &Error{pos, fmt.Sprintf("constant %q is defined in terms of itself", name)}
+	}
+
+	sym.resolving = true
+	defer func() { sym.resolving = false }()
+
+	return sym.expr(&evalContext{a: a, pc: sym.pc})
+}
+
+// directiveValue evaluates the single operand of org and align, which must
+// only refer to symbols defined before it.
+func (a *assembler) directiveValue(head token, tokens []token, end Pos) (int, bool) {
+	if len(tokens) == 0 {
+		a.errorf(end, "%s needs an operand", strings.ToLower(head.text))
+		return 0, false
+	}
+
+	e, err := parseExpr(tokens, end)
+	if err == nil {
+		var v int
+		if v, err = e(&evalContext{a: a, pc: a.pc}); err == nil {
+			return v, true
+		}
+	}
+
+	a.error(err)
+	return 0, false
+}
+
+func (a *assembler) defineMacro(head token, tokens []token) {
+	if len(tokens) == 0 || tokens[0].kind != tokIdent {
+		a.errorf(head.pos, "macro needs a name")
+		return
+	}
+
+	m := &macro{name: tokens[0].text, pos: head.pos}
+	for _, param := range splitOperands(tokens[1:]) {
+		if len(param) != 1 || param[0].kind != tokIdent {
+			a.errorf(tokens[0].pos, "invalid parameter list of macro %q", m.name)
+			return
+		}
+		m.params = append(m.params, param[0].text)
+	}
+
+	if prev, ok := a.macros[m.name]; ok {
+		a.errorf(tokens[0].pos, "macro %q redefined, previous definition at %s", m.name, prev.pos)
+	}
+	if _, ok := forms[strings.ToUpper(m.name)]; ok {
+		a.errorf(tokens[0].pos, "macro %q has the name of an instruction", m.name)
+	}
+
+	a.macroDef = m
+}
+
+// expand assembles the body of a macro with its parameters replaced by the
+// text of the arguments.
+func (a *assembler) expand(m *macro, head token, tokens []token, text string) {
+	if len(a.stack) >= maxDepth {
+		a.errorf(head.pos, "macro %q nested too deeply", m.name)
+		return
+	}
+
+	args := splitOperands(tokens)
+	if len(args) != len(m.params) {
+		a.errorf(head.pos, "macro %q takes %d arguments, not %d", m.name, len(m.params), len(args))
+		return
+	}
+
+	params := make([]*regexp.Regexp, len(args))
+	values := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) == 0 {
+			a.errorf(head.pos, "argument %d of macro %q is empty", i+1, m.name)
+			return
+		}
+
+		params[i] = regexp.MustCompile(`\b` + regexp.QuoteMeta(m.params[i]) + `\b`)
+		values[i] = text[arg[0].pos.Col-1 : arg[len(arg)-1].end]
+	}
+
+	a.expansions++
+	unique := fmt.Sprint(a.expansions)
+
+	a.stack = append(a.stack, m.name)
+	defer func() { a.stack = a.stack[:len(a.stack)-1] }()
+
+	for _, l := range m.body {
+		line := strings.Replace(l.text, `\@`, unique, -1)
+		for i, re := range params {
+			line = re.ReplaceAllLiteralString(line, values[i])
+		}
+		a.line(l.pos, line)
+	}
+}
+
+func (a *assembler) include(head token, tokens []token) {
+	if len(tokens) != 1 || tokens[0].kind != tokString {
+		a.errorf(head.pos, "include needs a file name in quotes")
+		return
+	}
+
+	name := tokens[0].text
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(head.pos.File), name)
+	}
+
+	for _, s := range a.stack {
+		if s == name {
+			a.errorf(tokens[0].pos, "%s includes itself", name)
+			return
+		}
+	}
+	if len(a.stack) >= maxDepth {
+		a.errorf(head.pos, "includes nested too deeply")
+		return
+	}
+
+	src, err := a.readFile(name)
+	if err != nil {
+		a.errorf(tokens[0].pos, "%s", err)
+		return
+	}
+
+	a.source(name, src)
+}
+
+// emit assembles the statements into the program, now that all labels are
+// known.
+func (a *assembler) emit() *Program {
+	var memory [memorySize]byte
+	var written [memorySize]bool
+
+	p := &Program{
+		Labels:      make(map[string]uint16),
+		Constants:   make(map[string]int),
+		Definitions: make(map[string]Pos),
+	}
+
+	end := programAddress
+	for _, s := range a.statements {
+		if s.pc+s.size > memorySize {
+			continue
+		}
+
+		b, err := a.bytes(s)
+		if err != nil {
+			a.error(err)
+			continue
+		}
+
+		overlap := false
+		for i, v := range b {
+			overlap = overlap || written[s.pc+i]
+			memory[s.pc+i] = v
+			written[s.pc+i] = true
+		}
+		if overlap {
+			a.errorf(s.pos, "%s at 0x%03x overlaps code or data assembled before", strings.ToLower(s.name), s.pc)
+		}
+
+		if s.size > 0 {
+			p.Lines = append(p.Lines, Line{Addr: uint16(s.pc), Size: s.size, Pos: s.pos})
+		}
+		if s.pc+s.size > end {
+			end = s.pc + s.size
+		}
+	}
+
+	sort.SliceStable(p.Lines, func(i, j int) bool { return p.Lines[i].Addr < p.Lines[j].Addr })
+	p.ROM = append([]byte(nil), memory[programAddress:end]...)
+
+	for name, sym := range a.symbols {
+		p.Definitions[name] = sym.pos
+		if sym.expr == nil {
+			p.Labels[name] = uint16(sym.pc)
+			continue
+		}
+
+		v, err := a.lookup(name, sym.pos)
+		if err != nil {
+			a.error(err)
+			continue
+		}
+		p.Constants[name] = v
+	}
+
+	for ref := range a.references {
+		p.References = append(p.References, ref)
+	}
+	sort.Slice(p.References, func(i, j int) bool {
+		return p.References[i].Pos.less(p.References[j].Pos)
+	})
+
+	return p
+}
+
+// bytes assembles a statement.
+func (a *assembler) bytes(s *statement) ([]byte, error) {
+	ctx := &evalContext{a: a, pc: s.pc}
+
+	switch s.name {
+	case "align":
+		return make([]byte, s.size), nil
+	case "db", "dw":
+		b := make([]byte, 0, s.size)
+		for _, tokens := range s.operands {
+			if len(tokens) == 1 && tokens[0].kind == tokString && s.name == "db" {
+				b = append(b, tokens[0].text...)
+				continue
+			}
+
+			o, err := dataOperand(tokens, s.end)
+			if err != nil {
+				return nil, err
+			}
+
+			if s.name == "db" {
+				v, err := value(ctx, o, -0x80, 0xff, "byte")
+				if err != nil {
+					return nil, err
+				}
+				b = append(b, byte(v))
+				continue
+			}
+
+			v, err := value(ctx, o, -0x8000, 0xffff, "word")
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, byte(v>>8), byte(v))
+		}
+		return b, nil
+	}
+
+	operands := make([]operand, len(s.operands))
+	for i, tokens := range s.operands {
+		o, err := parseOperand(tokens, s.end)
+		if err != nil {
+			return nil, err
+		}
+		operands[i] = o
+	}
+
+	f, ok := match(s.name, operands)
+	if !ok {
+		return nil, &Error{s.pos, fmt.Sprintf("invalid operands for %s, expected %s", s.name, describe(s.name))}
+	}
+
+	opcode, err := encode(ctx, f, operands)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte{byte(opcode >> 8), byte(opcode)}, nil
+}
+
+// dataOperand parses an operand of db or dw, which must be an expression.
+func dataOperand(tokens []token, end Pos) (operand, error) {
+	if len(tokens) == 0 {
+		return operand{}, &Error{end, "missing operand"}
+	}
+
+	e, err := parseExpr(tokens, end)
+	if err != nil {
+		return operand{}, err
+	}
+
+	return operand{class: classExpr, expr: e, pos: tokens[0].pos}, nil
+}
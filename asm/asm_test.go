@@ -0,0 +1,265 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssemble(t *testing.T) {
+	src := `
+; Draws a digit and waits
+digit   equ 7
+x       = digit * 2 + (1 << 2)
+
+start:
+	CLS
+	ld v0, x                ; 0x12
+	LD V1, -1
+	LD F, V0
+	DRW V0, V1, 5
+	JP V0, table
+	CALL sub
+loop:	JP $
+
+sub:	LD I, sprite
+	RET
+
+table:	DW sub, 0x1234
+	DB "AB", 0b101
+	align 4
+sprite:	DB 0xf0
+`
+
+	p, err := Assemble("digit.asm", []byte(src))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{
+		0x00, 0xe0, // 0x200
+		0x60, 0x12, // 0x202
+		0x61, 0xff, // 0x204
+		0xf0, 0x29, // 0x206
+		0xd0, 0x15, // 0x208
+		0xb2, 0x14, // 0x20a
+		0x22, 0x10, // 0x20c
+		0x12, 0x0e, // 0x20e
+		0xa2, 0x1c, // 0x210: sub
+		0x00, 0xee, // 0x212
+		0x02, 0x10, 0x12, 0x34, // 0x214: table
+		'A', 'B', 0x05, // 0x218
+		0x00, // 0x21b: align
+		0xf0, // 0x21c: sprite
+	}, p.ROM)
+
+	assert.Equal(t, map[string]uint16{
+		"start":  0x200,
+		"loop":   0x20e,
+		"sub":    0x210,
+		"table":  0x214,
+		"sprite": 0x21c,
+	}, p.Labels)
+	assert.Equal(t, map[string]int{"digit": 7, "x": 0x12}, p.Constants)
+
+	assert.Equal(t, Line{Addr: 0x202, Size: 2, Pos: Pos{"digit.asm", 8, 2}}, p.Lines[1])
+	assert.Equal(t, Line{Addr: 0x214, Size: 4, Pos: Pos{"digit.asm", 19, 8}}, p.Lines[10])
+}
+
+func TestAssemble_org(t *testing.T) {
+	p, err := Assemble("org.asm", []byte(`
+	JP main
+	org 0x210
+main:
+	JP main
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{0x12, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x12, 0x10}, p.ROM)
+}
+
+func TestAssemble_macro(t *testing.T) {
+	p, err := Assemble("macro.asm", []byte(`
+macro wait reg, frames
+	LD reg, frames
+	LD DT, reg
+wait_\@:
+	LD reg, DT
+	SE reg, 0
+	JP wait_\@
+endm
+
+	wait V1, 60
+	wait V2, 2 * 30
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{
+		0x61, 0x3c, 0xf1, 0x15, 0xf1, 0x07, 0x31, 0x00, 0x12, 0x04,
+		0x62, 0x3c, 0xf2, 0x15, 0xf2, 0x07, 0x32, 0x00, 0x12, 0x0e,
+	}, p.ROM)
+	assert.Equal(t, map[string]uint16{"wait_1": 0x204, "wait_2": 0x20e}, p.Labels)
+}
+
+func TestAssemble_include(t *testing.T) {
+	files := map[string]string{
+		"lib/font.asm":   "glyph: DB 0xf0, 0x90\n\tinclude \"consts.asm\"\n",
+		"lib/consts.asm": "height equ 2\n",
+	}
+
+	as := &Assembler{ReadFile: func(name string) ([]byte, error) {
+		src, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("open %s: %s", name, os.ErrNotExist)
+		}
+		return []byte(src), nil
+	}}
+
+	p, err := as.Assemble("main.asm", []byte("\tLD I, glyph\n\tDRW V0, V0, height\n\tinclude \"lib/font.asm\"\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{0xa2, 0x04, 0xd0, 0x02, 0xf0, 0x90}, p.ROM)
+	assert.Equal(t, "lib/font.asm", p.Lines[2].Pos.File)
+
+	_, err = as.Assemble("main.asm", []byte("\n\tinclude \"missing.asm\"\n"))
+	assert.EqualError(t, err, "main.asm:2:10: open missing.asm: file does not exist")
+
+	files["lib/loop.asm"] = "include \"loop.asm\"\n"
+	_, err = as.Assemble("main.asm", []byte("include \"lib/loop.asm\"\n"))
+	assert.EqualError(t, err, "lib/loop.asm:1:9: lib/loop.asm includes itself")
+}
+
+func TestAssemble_errors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"\tFOO V0", `test.asm:1:2: unknown instruction "FOO"`},
+		{"\tLD V0", "test.asm:1:2: invalid operands for LD, expected " +
+			"LD Vx, byte; LD Vx, Vy; LD I, addr; LD Vx, DT; LD Vx, K; LD DT, Vx; LD ST, Vx; LD F, Vx; LD B, Vx; LD [I], Vx; LD Vx, [I]"},
+		{"\tJP V1, 0x200", "test.asm:1:2: invalid operands for JP, expected JP addr; JP V0, addr"},
+		{"\tLD V0, 0x100", "test.asm:1:9: byte out of range: 256 (0x100)"},
+		{"\tDRW V0, V1, 16", "test.asm:1:14: nibble out of range: 16 (0x10)"},
+		{"\tJP 0x1000", "test.asm:1:5: address out of range: 4096 (0x1000)"},
+		{"\tJP nowhere", `test.asm:1:5: undefined symbol "nowhere"`},
+		{"\tLD V0, (1 + 2", "test.asm:1:15: expected ')'"},
+		{"\tLD V0, 1 +", "test.asm:1:12: expected an expression"},
+		{"\tLD V0, 1 / 0", "test.asm:1:11: division by zero"},
+		{"\tLD V0, 1 ? 2", "test.asm:1:11: unexpected character '?'"},
+		{"\tDB \"abc", "test.asm:1:5: unterminated string"},
+		{"\tDB 1,", "test.asm:1:7: missing operand"},
+		{"\tDW \"ab\"", `test.asm:1:5: unexpected "ab" in expression`},
+		{"a: CLS\na: CLS", `test.asm:2:1: "a" redefined, previous definition at test.asm:1:1`},
+		{"V1: CLS", `test.asm:1:1: "V1" is reserved and cannot be defined`},
+		{"p equ q\nq equ p\n\tJP p", `test.asm:1:7: constant "q" is defined in terms of itself (and 1 more errors)`},
+		{"\torg later\nlater:", `test.asm:1:6: undefined symbol "later"`},
+		{"\torg 0x100", "test.asm:1:6: org address out of range: 0x100"},
+		{"\torg 0xfff\n\tCLS", "test.asm:2:2: program does not fit in memory"},
+		{"\tCLS\n\torg 0x200\n\tRET", "test.asm:3:2: ret at 0x200 overlaps code or data assembled before"},
+		{"macro m a\n\tLD V0, a\n", `test.asm:1:1: macro "m" has no endm`},
+		{"macro m a\nendm\n\tm", `test.asm:3:2: macro "m" takes 1 arguments, not 0`},
+		{"macro m\n\tm\nendm\n\tm", `test.asm:2:2: macro "m" nested too deeply`},
+		{"\tendm", "test.asm:1:2: endm outside of a macro"},
+		{"\tJP 0x1000\n\tJP 0x1001", "test.asm:1:5: address out of range: 4096 (0x1000) (and 1 more errors)"},
+	}
+
+	for _, test := range tests {
+		_, err := Assemble("test.asm", []byte(test.src))
+		assert.EqualError(t, err, test.err, test.src)
+	}
+}
+
+// Every opcode disassembles to a line which assembles back into it
+func TestAssemble_roundTripClassic(t *testing.T) {
+	const chunk = 0x800
+
+	program := make([]byte, chunk)
+	for start := 0; start < 0x10000; start += chunk / 2 {
+		for i := 0; i < chunk/2; i++ {
+			program[2*i] = byte((start + i) >> 8)
+			program[2*i+1] = byte(start + i)
+		}
+
+		src := &bytes.Buffer{}
+		require.NoError(t, chip8.WriteClassic(src, program))
+
+		p, err := Assemble("classic.asm", src.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, program, p.ROM, "opcodes from %04x", start)
+	}
+
+	src := &bytes.Buffer{}
+	require.NoError(t, chip8.WriteClassic(src, []byte{0x00, 0xe0, 0x12}))
+	p, err := Assemble("odd.asm", src.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0xe0, 0x12}, p.ROM)
+}
+
+func TestAssemble_roundTripListing(t *testing.T) {
+	programs := [][]byte{
+		{
+			0xa2, 0x0d, 0x23, 0x00, 0x22, 0x09, 0x12, 0x04, 0xff,
+			0xd0, 0x12, 0x00, 0xee, 0xf0, 0x90, 0x01, 0x02, 0x03,
+		},
+		{0x30, 0x00, 0x00, 0xe0, 0x12, 0x05, 0x12},
+		{0x6a, 0x02, 0xb2, 0x06, 0xff, 0xff, 0x22, 0x0a, 0x12, 0x08, 0xa2, 0x00, 0x00, 0xee},
+	}
+
+	for _, program := range programs {
+		src := &bytes.Buffer{}
+		require.NoError(t, chip8.Analyze(program).WriteListing(src))
+
+		p, err := Assemble("listing.asm", src.Bytes())
+		require.NoError(t, err, src.String())
+		assert.Equal(t, program, p.ROM, src.String())
+	}
+}
+
+func TestSourceMap_Lookup(t *testing.T) {
+	m := SourceMap{
+		{Addr: 0x200, Size: 2, Pos: Pos{"a.asm", 1, 2}},
+		{Addr: 0x204, Size: 3, Pos: Pos{"a.asm", 3, 2}},
+	}
+
+	line, ok := m.Lookup(0x201)
+	assert.True(t, ok)
+	assert.Equal(t, 1, line.Pos.Line)
+
+	line, ok = m.Lookup(0x206)
+	assert.True(t, ok)
+	assert.Equal(t, 3, line.Pos.Line)
+
+	_, ok = m.Lookup(0x202)
+	assert.False(t, ok)
+	_, ok = m.Lookup(0x207)
+	assert.False(t, ok)
+	_, ok = m.Lookup(0x1ff)
+	assert.False(t, ok)
+}
+
+func TestAssemble_references(t *testing.T) {
+	p, err := Assemble("refs.asm", []byte(`SIZE equ 5
+start:  LD V0, SIZE
+        JP start
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]Pos{
+		"SIZE":  {File: "refs.asm", Line: 1, Col: 1},
+		"start": {File: "refs.asm", Line: 2, Col: 1},
+	}, p.Definitions)
+	assert.Equal(t, []Reference{
+		{"SIZE", Pos{File: "refs.asm", Line: 2, Col: 16}},
+		{"start", Pos{File: "refs.asm", Line: 3, Col: 12}},
+	}, p.References)
+}
+
+func TestMnemonics(t *testing.T) {
+	mnemonics := Mnemonics()
+	assert.Contains(t, mnemonics, "DRW")
+	assert.True(t, sort.StringsAreSorted(mnemonics))
+}
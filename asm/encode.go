@@ -0,0 +1,251 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Operand classes. Registers are numbered by their value, the other classes
+// are negative.
+const (
+	classExpr = -1 - iota
+	classI
+	classIndirect
+	classDelayTimer
+	classSoundTimer
+	classKey
+	classFont
+	classBCD
+)
+
+// operand is a parsed instruction operand.
+type operand struct {
+	class int
+	expr  expr
+	pos   Pos
+}
+
+// form is an accepted combination of operands of an instruction. Each
+// character of operands stands for an operand:
+//
+//	x, y  a register, stored in the X or Y nibble
+//	0     register V0
+//	a     a 12-bit address
+//	b     a byte
+//	n     a nibble
+//	I, [, D, S, K, F, B  I, [I], DT, ST, K, F and B
+type form struct {
+	operands string
+	opcode   uint16
+}
+
+var forms = map[string][]form{
+	"CLS":  {{"", 0x00e0}},
+	"RET":  {{"", 0x00ee}},
+	"SYS":  {{"a", 0x0000}},
+	"JP":   {{"a", 0x1000}, {"0a", 0xb000}},
+	"CALL": {{"a", 0x2000}},
+	"SE":   {{"xb", 0x3000}, {"xy", 0x5000}},
+	"SNE":  {{"xb", 0x4000}, {"xy", 0x9000}},
+	"LD": {
+		{"xb", 0x6000}, {"xy", 0x8000}, {"Ia", 0xa000}, {"xD", 0xf007},
+		{"xK", 0xf00a}, {"Dx", 0xf015}, {"Sx", 0xf018}, {"Fx", 0xf029},
+		{"Bx", 0xf033}, {"[x", 0xf055}, {"x[", 0xf065},
+	},
+	"ADD":  {{"xb", 0x7000}, {"xy", 0x8004}, {"Ix", 0xf01e}},
+	"OR":   {{"xy", 0x8001}},
+	"AND":  {{"xy", 0x8002}},
+	"XOR":  {{"xy", 0x8003}},
+	"SUB":  {{"xy", 0x8005}},
+	"SHR":  {{"xy", 0x8006}, {"x", 0x8006}},
+	"SUBN": {{"xy", 0x8007}},
+	"SHL":  {{"xy", 0x800e}, {"x", 0x800e}},
+	"RND":  {{"xb", 0xc000}},
+	"DRW":  {{"xyn", 0xd000}},
+	"SKP":  {{"x", 0xe09e}},
+	"SKNP": {{"x", 0xe0a1}},
+}
+
+// Classes of the non-register form characters
+var formClasses = map[byte]int{
+	'a': classExpr,
+	'b': classExpr,
+	'n': classExpr,
+	'I': classI,
+	'[': classIndirect,
+	'D': classDelayTimer,
+	'S': classSoundTimer,
+	'K': classKey,
+	'F': classFont,
+	'B': classBCD,
+}
+
+// Operands written as a keyword
+var keywords = map[string]int{
+	"I":  classI,
+	"DT": classDelayTimer,
+	"ST": classSoundTimer,
+	"K":  classKey,
+	"F":  classFont,
+	"B":  classBCD,
+}
+
+// Mnemonics returns the mnemonics of the instructions, in alphabetical order.
+func Mnemonics() []string {
+	names := make([]string, 0, len(forms))
+	for name := range forms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Directives returns the names of the directives, in alphabetical order.
+func Directives() []string {
+	return []string{"align", "db", "dw", "endm", "equ", "include", "macro", "org"}
+}
+
+// register returns the number of the register named name, or -1.
+func register(name string) int {
+	if len(name) != 2 || name[0] != 'V' && name[0] != 'v' {
+		return -1
+	}
+
+	v, err := parseNumber("0x" + name[1:])
+	if err != nil {
+		return -1
+	}
+
+	return v
+}
+
+// reserved reports whether name is a register or keyword, and so cannot be
+// used as a symbol.
+func reserved(name string) bool {
+	_, ok := keywords[strings.ToUpper(name)]
+	return ok || register(name) >= 0
+}
+
+// parseOperand parses the tokens of an instruction operand.
+func parseOperand(tokens []token, end Pos) (operand, error) {
+	if len(tokens) == 0 {
+		return operand{}, &Error{end, "missing operand"}
+	}
+
+	o := operand{pos: tokens[0].pos}
+	if len(tokens) == 1 && tokens[0].kind == tokIdent {
+		if r := register(tokens[0].text); r >= 0 {
+			o.class = r
+			return o, nil
+		}
+		if class, ok := keywords[strings.ToUpper(tokens[0].text)]; ok {
+			o.class = class
+			return o, nil
+		}
+	}
+	if len(tokens) == 3 && tokens[0].text == "[" && strings.ToUpper(tokens[1].text) == "I" && tokens[2].text == "]" {
+		o.class = classIndirect
+		return o, nil
+	}
+
+	e, err := parseExpr(tokens, end)
+	if err != nil {
+		return operand{}, err
+	}
+	o.class, o.expr = classExpr, e
+
+	return o, nil
+}
+
+// match returns the form of the instruction accepting operands.
+func match(mnemonic string, operands []operand) (form, bool) {
+	for _, f := range forms[mnemonic] {
+		if len(f.operands) != len(operands) {
+			continue
+		}
+
+		ok := true
+		for i, o := range operands {
+			switch c := f.operands[i]; c {
+			case 'x', 'y':
+				ok = ok && o.class >= 0
+			case '0':
+				ok = ok && o.class == 0
+			default:
+				ok = ok && o.class == formClasses[c]
+			}
+		}
+		if ok {
+			return f, true
+		}
+	}
+
+	return form{}, false
+}
+
+// encode assembles an instruction whose operands match f.
+func encode(ctx *evalContext, f form, operands []operand) (uint16, error) {
+	opcode := f.opcode
+	for i, o := range operands {
+		switch f.operands[i] {
+		case 'x':
+			opcode |= uint16(o.class) << 8
+		case 'y':
+			opcode |= uint16(o.class) << 4
+		case 'a':
+			v, err := value(ctx, o, 0, 0xfff, "address")
+			if err != nil {
+				return 0, err
+			}
+			opcode |= uint16(v)
+		case 'b':
+			v, err := value(ctx, o, -0x80, 0xff, "byte")
+			if err != nil {
+				return 0, err
+			}
+			opcode |= uint16(v & 0xff)
+		case 'n':
+			v, err := value(ctx, o, 0, 0xf, "nibble")
+			if err != nil {
+				return 0, err
+			}
+			opcode |= uint16(v)
+		}
+	}
+
+	return opcode, nil
+}
+
+// value evaluates an operand and checks it is in [min, max].
+func value(ctx *evalContext, o operand, min, max int, what string) (int, error) {
+	v, err := o.expr(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if v < min || v > max {
+		return 0, &Error{o.pos, fmt.Sprintf("%s out of range: %d (0x%x)", what, v, v)}
+	}
+
+	return v, nil
+}
+
+// describe lists the forms of an instruction, for error messages.
+func describe(mnemonic string) string {
+	names := map[byte]string{
+		'x': "Vx", 'y': "Vy", '0': "V0", 'a': "addr", 'b': "byte", 'n': "nibble",
+		'I': "I", '[': "[I]", 'D': "DT", 'S': "ST", 'K': "K", 'F': "F", 'B': "B",
+	}
+
+	var list []string
+	for _, f := range forms[mnemonic] {
+		var operands []string
+		for i := 0; i < len(f.operands); i++ {
+			operands = append(operands, names[f.operands[i]])
+		}
+		list = append(list, strings.TrimSpace(mnemonic+" "+strings.Join(operands, ", ")))
+	}
+
+	return strings.Join(list, "; ")
+}
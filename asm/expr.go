@@ -0,0 +1,221 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An expr is a compiled arithmetic expression. It is evaluated once the
+// symbols it refers to are known.
+type expr func(ctx *evalContext) (int, error)
+
+type evalContext struct {
+	a *assembler
+
+	// Address of the statement the expression belongs to, the value of $
+	pc int
+}
+
+// Binary operators, from the lowest precedence to the highest
+var binaryOperators = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parseExpr compiles tokens, which must form a single expression.
+func parseExpr(tokens []token, end Pos) (expr, error) {
+	p := &exprParser{tokens: tokens, end: end}
+
+	e, err := p.parse(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(tokens) {
+		t := tokens[p.i]
+		return nil, &Error{t.pos, fmt.Sprintf("unexpected %q in expression", t.text)}
+	}
+
+	return e, nil
+}
+
+type exprParser struct {
+	tokens []token
+	i      int
+
+	// Position reported for errors at the end of the expression
+	end Pos
+}
+
+func (p *exprParser) peek() *token {
+	if p.i < len(p.tokens) {
+		return &p.tokens[p.i]
+	}
+
+	return nil
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	pos := p.end
+	if t := p.peek(); t != nil {
+		pos = t.pos
+	}
+
+	return &Error{pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) parse(level int) (expr, error) {
+	if level == len(binaryOperators) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parse(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t == nil || t.kind != tokPunct || !contains(binaryOperators[level], t.text) {
+			return left, nil
+		}
+		p.i++
+
+		right, err := p.parse(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = binary(*t, left, right)
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	t := p.peek()
+	if t != nil && t.kind == tokPunct && (t.text == "-" || t.text == "~" || t.text == "+") {
+		p.i++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t.text {
+		case "-":
+			return func(ctx *evalContext) (int, error) {
+				v, err := operand(ctx)
+				return -v, err
+			}, nil
+		case "~":
+			return func(ctx *evalContext) (int, error) {
+				v, err := operand(ctx)
+				return ^v, err
+			}, nil
+		}
+
+		return operand, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, p.errorf("expected an expression")
+	}
+	p.i++
+
+	switch {
+	case t.kind == tokNumber:
+		v, err := parseNumber(t.text)
+		if err != nil {
+			return nil, &Error{t.pos, err.Error()}
+		}
+		return func(*evalContext) (int, error) { return v, nil }, nil
+	case t.kind == tokIdent:
+		name, pos := t.text, t.pos
+		return func(ctx *evalContext) (int, error) {
+			ctx.a.reference(name, pos)
+			return ctx.a.lookup(name, pos)
+		}, nil
+	case t.kind == tokPunct && t.text == "$":
+		return func(ctx *evalContext) (int, error) { return ctx.pc, nil }, nil
+	case t.kind == tokPunct && t.text == "(":
+		inner, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		if c := p.peek(); c == nil || c.text != ")" {
+			return nil, p.errorf("expected ')'")
+		}
+		p.i++
+		return inner, nil
+	}
+
+	p.i--
+	return nil, p.errorf("unexpected %q in expression", t.text)
+}
+
+func binary(op token, left, right expr) expr {
+	return func(ctx *evalContext) (int, error) {
+		l, err := left(ctx)
+		if err != nil {
+			return 0, err
+		}
+		r, err := right(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		switch op.text {
+		case "|":
+			return l | r, nil
+		case "^":
+			return l ^ r, nil
+		case "&":
+			return l & r, nil
+		case "<<":
+			return l << uint(r&31), nil
+		case ">>":
+			return l >> uint(r&31), nil
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		}
+
+		if r == 0 {
+			return 0, &Error{op.pos, "division by zero"}
+		}
+		if op.text == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	}
+}
+
+// parseNumber parses decimal, 0x hexadecimal and 0b binary numbers.
+func parseNumber(s string) (int, error) {
+	v, err := strconv.ParseInt(strings.ToLower(s), 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	return int(v), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,194 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pos is a position in a source file. Lines and columns start at 1.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// less reports whether p comes before q, ordering by file name first.
+func (p Pos) less(q Pos) bool {
+	if p.File != q.File {
+		return p.File < q.File
+	}
+	if p.Line != q.Line {
+		return p.Line < q.Line
+	}
+
+	return p.Col < q.Col
+}
+
+// Error is an assembly error at a position in the source.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// ErrorList is the list of errors found while assembling a program.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  Pos
+
+	// Offset of the end of the token in the line
+	end int
+}
+
+// Punctuation, longest first
+var punctuation = []string{"<<", ">>", ",", ":", "(", ")", "[", "]", "+", "-", "*", "/", "%", "&", "|", "^", "~", "=", "$"}
+
+// lex splits a line of source into tokens, dropping the comment.
+func lex(pos Pos, line string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(line); {
+		c := line[i]
+		p := pos
+		p.Col = i + 1
+
+		switch {
+		case c == ';':
+			return tokens, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i++; i < len(line) && isIdentChar(line[i]); i++ {
+			}
+			tokens = append(tokens, token{tokIdent, line[start:i], p, i})
+		case c >= '0' && c <= '9':
+			start := i
+			for i++; i < len(line) && isIdentChar(line[i]); i++ {
+			}
+			tokens = append(tokens, token{tokNumber, line[start:i], p, i})
+		case c == '"':
+			s, n, err := unquote(line[i:])
+			if err != nil {
+				return nil, &Error{p, err.Error()}
+			}
+			i += n
+			tokens = append(tokens, token{tokString, s, p, i})
+		default:
+			found := false
+			for _, punct := range punctuation {
+				if strings.HasPrefix(line[i:], punct) {
+					i += len(punct)
+					tokens = append(tokens, token{tokPunct, punct, p, i})
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, &Error{p, fmt.Sprintf("unexpected character %q", c)}
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// unquote reads the string literal at the start of s, returning its value
+// and length in s.
+func unquote(s string) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case '0':
+				sb.WriteByte(0)
+			case '"', '\\':
+				sb.WriteByte(s[i])
+			default:
+				return "", 0, fmt.Errorf("unknown escape sequence \\%c", s[i])
+			}
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '.'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// splitOperands splits tokens at the commas outside of parentheses and
+// brackets.
+func splitOperands(tokens []token) [][]token {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var operands [][]token
+	depth, start := 0, 0
+	for i, t := range tokens {
+		if t.kind != tokPunct {
+			continue
+		}
+
+		switch t.text {
+		case "(", "[":
+			depth++
+		case ")", "]":
+			depth--
+		case ",":
+			if depth == 0 {
+				operands = append(operands, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(operands, tokens[start:])
+}
@@ -0,0 +1,304 @@
+// Package cart reads and writes Octo cartridges: GIF images of a labelled
+// cartridge which carry the source of a program and the options to run it
+// with in their pixels.
+//
+// The payload of a cartridge is a JSON object holding the options and the
+// program, {"options": {...}, "program": "..."}, preceded by its length as a
+// 32-bit big-endian number. Every pixel of every frame holds two bits of the
+// payload, most significant first, in the low two bits of its palette index;
+// the rest of the index selects the colour of the label.
+package cart
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/octo"
+)
+
+// Size of the frames of written cartridges
+const (
+	width  = 128
+	height = 64
+)
+
+// Cartridge is a program with the options to run it with.
+type Cartridge struct {
+	Options Options `json:"options"`
+
+	// Octo source of the program
+	Program string `json:"program"`
+}
+
+// Options are the settings of the Octo emulator a program is meant to run
+// with. Colours are written as #rrggbb.
+type Options struct {
+	// Instructions executed per frame
+	TickRate int `json:"tickrate"`
+
+	FillColor       string `json:"fillColor"`
+	FillColor2      string `json:"fillColor2"`
+	BlendColor      string `json:"blendColor"`
+	BackgroundColor string `json:"backgroundColor"`
+	BuzzColor       string `json:"buzzColor"`
+	QuietColor      string `json:"quietColor"`
+
+	ShiftQuirks     bool `json:"shiftQuirks"`
+	LoadStoreQuirks bool `json:"loadStoreQuirks"`
+	VFOrderQuirks   bool `json:"vfOrderQuirks"`
+	ClipQuirks      bool `json:"clipQuirks"`
+	VBlankQuirks    bool `json:"vBlankQuirks"`
+	JumpQuirks      bool `json:"jumpQuirks"`
+	LogicQuirks     bool `json:"logicQuirks"`
+
+	ScreenRotation int    `json:"screenRotation"`
+	MaxSize        int    `json:"maxSize"`
+	TouchInputMode string `json:"touchInputMode"`
+	FontStyle      string `json:"fontStyle"`
+}
+
+// DefaultOptions returns the options programs run with in this emulator by
+// default.
+func DefaultOptions() Options {
+	o := Options{
+		TickRate:        chip8.ClockRate / chip8.TimerRate,
+		FillColor:       "#FFFFFF",
+		FillColor2:      "#FF00FF",
+		BlendColor:      "#00FFFF",
+		BackgroundColor: "#000000",
+		BuzzColor:       "#FFAA00",
+		QuietColor:      "#000000",
+		MaxSize:         3584,
+		TouchInputMode:  "none",
+		FontStyle:       "octo",
+	}
+	o.SetQuirks(chip8.DefaultQuirks)
+
+	return o
+}
+
+// Quirks returns the quirks selected by the options.
+func (o Options) Quirks() chip8.Quirks {
+	return chip8.Quirks{
+		Shift:     o.ShiftQuirks,
+		LoadStore: o.LoadStoreQuirks,
+		Jump:      o.JumpQuirks,
+		Logic:     o.LogicQuirks,
+		Clip:      o.ClipQuirks,
+		VBlank:    o.VBlankQuirks,
+		VFOrder:   o.VFOrderQuirks,
+	}
+}
+
+// SetQuirks selects quirks in the options.
+func (o *Options) SetQuirks(q chip8.Quirks) {
+	o.ShiftQuirks = q.Shift
+	o.LoadStoreQuirks = q.LoadStore
+	o.JumpQuirks = q.Jump
+	o.LogicQuirks = q.Logic
+	o.ClipQuirks = q.Clip
+	o.VBlankQuirks = q.VBlank
+	o.VFOrderQuirks = q.VFOrder
+}
+
+// Configure sets up c to run with the tick rate, colours and quirks of the
+// options.
+func (o Options) Configure(c *chip8.State) error {
+	background, err := ParseColor(o.BackgroundColor)
+	if err != nil {
+		return fmt.Errorf("background colour: %s", err)
+	}
+	fill, err := ParseColor(o.FillColor)
+	if err != nil {
+		return fmt.Errorf("fill colour: %s", err)
+	}
+
+	c.SetColors(background, fill)
+	c.SetQuirks(o.Quirks())
+	if o.TickRate > 0 {
+		c.SetClockRate(o.TickRate * chip8.TimerRate)
+	}
+
+	return nil
+}
+
+// ParseColor parses a colour written as #rrggbb or #rgb.
+func ParseColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	var r, g, b uint8
+	if n, err := fmt.Sscanf(hex, "%02x%02x%02x", &r, &g, &b); len(hex) != 6 || n != 3 || err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q", s)
+	}
+
+	return color.RGBA{r, g, b, 0xff}, nil
+}
+
+// Read reads a cartridge from a GIF image.
+func Read(r io.Reader) (*Cartridge, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var b byte
+	n := 0
+	for _, frame := range g.Image {
+		bounds := frame.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				b = b<<2 | frame.ColorIndexAt(x, y)&3
+				n++
+				if n%4 == 0 {
+					data = append(data, b)
+				}
+			}
+		}
+	}
+
+	if len(data) < 4 {
+		return nil, errors.New("not an Octo cartridge: no payload")
+	}
+	size := binary.BigEndian.Uint32(data)
+	if uint64(size) > uint64(len(data)-4) {
+		return nil, fmt.Errorf("not an Octo cartridge: payload of %d bytes in an image holding %d", size, len(data)-4)
+	}
+
+	// Options missing from the cartridge keep their defaults, with the
+	// quirks Octo programs run with
+	c := &Cartridge{Options: DefaultOptions()}
+	c.Options.SetQuirks(octo.Quirks())
+	if err := json.Unmarshal(data[4:4+size], c); err != nil {
+		return nil, fmt.Errorf("not an Octo cartridge: %s", err)
+	}
+
+	return c, nil
+}
+
+// Write writes c as a GIF image, labelled with label.
+func Write(w io.Writer, c *Cartridge, label string) error {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(data, uint32(len(payload)))
+	data = append(data, payload...)
+
+	labelColors, err := colors(c.Options)
+	if err != nil {
+		return err
+	}
+	palette := make(color.Palette, 0, 16)
+	for _, base := range labelColors {
+		for bits := uint8(0); bits < 4; bits++ {
+			// The data bits barely change the colour of the label
+			palette = append(palette, color.RGBA{base.R, base.G, base.B&^3 | bits, 0xff})
+		}
+	}
+
+	art := drawLabel(label)
+
+	g := &gif.GIF{}
+	pixels := len(data) * 4
+	for start := 0; start < pixels || start == 0; start += width * height {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		for i := range frame.Pix {
+			frame.Pix[i] = art[i] << 2
+			if p := start + i; p < pixels {
+				frame.Pix[i] |= data[p/4] >> uint(6-2*(p%4)) & 3
+			}
+		}
+
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 0)
+	}
+
+	return gif.EncodeAll(w, g)
+}
+
+// colors returns the four colours of the label: the cartridge, its border,
+// the label and the text.
+func colors(o Options) ([4]color.RGBA, error) {
+	var c [4]color.RGBA
+	for i, s := range []string{o.BackgroundColor, o.FillColor, "#F0F0F0", "#202020"} {
+		var err error
+		if c[i], err = ParseColor(s); err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// drawLabel draws the cartridge, returning the colour of every pixel.
+func drawLabel(label string) []byte {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{
+		color.Gray{0}, color.Gray{1}, color.Gray{2}, color.Gray{3},
+	})
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			switch {
+			case x < 2 || y < 2 || x >= width-2 || y >= height-2:
+				img.SetColorIndex(x, y, 1)
+			case x >= 8 && x < width-8 && y >= 16 && y < height-12:
+				img.SetColorIndex(x, y, 2)
+			}
+		}
+	}
+
+	// The label fits a line of 15 characters
+	if len(label) > 15 {
+		label = label[:12] + "..."
+	}
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.Gray{3}),
+		Face: basicfont.Face7x13,
+	}
+	d.Dot = fixed.Point26_6{
+		X: (fixed.I(width) - d.MeasureString(label)) / 2,
+		Y: fixed.I(height/2 + 6),
+	}
+	d.DrawString(label)
+
+	return img.Pix
+}
+
+// ROMSource returns Octo source for a program only available as a ROM, which
+// compiles back into the same bytes.
+func ROMSource(rom []byte) string {
+	var sb strings.Builder
+	sb.WriteString(": main\n")
+	for i, b := range rom {
+		if i%16 == 0 {
+			sb.WriteString("\t")
+		}
+		fmt.Fprintf(&sb, "0x%02X", b)
+		if i%16 == 15 || i == len(rom)-1 {
+			sb.WriteString("\n")
+		} else {
+			sb.WriteString(" ")
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,135 @@
+package cart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/octo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	c := &Cartridge{
+		Options: DefaultOptions(),
+		Program: ": main\n\tclear\n\tloop again\n",
+	}
+	c.Options.TickRate = 20
+	c.Options.JumpQuirks = true
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, c, "a cartridge with a long label"))
+
+	g, err := gif.DecodeAll(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Len(t, g.Image, 1)
+	assert.Equal(t, 128, g.Config.Width)
+	assert.Equal(t, 64, g.Config.Height)
+
+	read, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, c, read)
+}
+
+func TestWrite_frames(t *testing.T) {
+	// A payload of more than 2K takes several frames
+	c := &Cartridge{Options: DefaultOptions(), Program: ROMSource(make([]byte, 1024))}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, c, "zeros"))
+
+	g, err := gif.DecodeAll(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.True(t, len(g.Image) > 1)
+
+	read, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, c.Program, read.Program)
+}
+
+func TestRead_errors(t *testing.T) {
+	_, err := Read(strings.NewReader("GIF89a"))
+	assert.Error(t, err)
+
+	// An image too small for a payload
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	require.NoError(t, gif.Encode(&buf, img, nil))
+	_, err = Read(&buf)
+	assert.EqualError(t, err, "not an Octo cartridge: no payload")
+
+	// An image of an empty payload
+	buf.Reset()
+	img = image.NewPaletted(image.Rect(0, 0, 64, 64), color.Palette{color.Black, color.White})
+	require.NoError(t, gif.Encode(&buf, img, nil))
+	_, err = Read(&buf)
+	assert.EqualError(t, err, "not an Octo cartridge: unexpected end of JSON input")
+}
+
+func TestRead_defaults(t *testing.T) {
+	// A cartridge holding nothing but the program, as 2 bits a pixel
+	payload := `{"program": ": main loop again"}`
+	data := append([]byte{0, 0, 0, byte(len(payload))}, payload...)
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.Black, color.White, color.Black, color.White})
+	for p := 0; p < len(data)*4; p++ {
+		img.Pix[p] = data[p/4] >> uint(6-2*(p%4)) & 3
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gif.Encode(&buf, img, nil))
+
+	c, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, ": main loop again", c.Program)
+	assert.Equal(t, DefaultOptions().TickRate, c.Options.TickRate)
+	assert.Equal(t, octo.Quirks(), c.Options.Quirks())
+
+	c8 := chip8.Init(nil)
+	assert.NoError(t, c.Options.Configure(&c8))
+}
+
+func TestROMSource(t *testing.T) {
+	rom := make([]byte, 35)
+	for i := range rom {
+		rom[i] = byte(i * 7)
+	}
+
+	p, err := octo.Compile("rom.8o", []byte(ROMSource(rom)))
+	require.NoError(t, err)
+	assert.Equal(t, rom, p.ROM)
+}
+
+func TestOptions_Configure(t *testing.T) {
+	o := DefaultOptions()
+	o.TickRate = 15
+	o.BackgroundColor = "#102030"
+	o.FillColor = "#FA0"
+	o.ShiftQuirks = false
+	o.VFOrderQuirks = true
+
+	c8 := chip8.Init(nil)
+	require.NoError(t, o.Configure(&c8))
+
+	assert.Equal(t, 15*chip8.TimerRate, c8.ClockRate())
+	assert.Equal(t, chip8.Quirks{LoadStore: true, Clip: true, VBlank: true, VFOrder: true}, c8.Quirks())
+
+	frame := c8.GetFrame(64, 32)
+	assert.Equal(t, color.RGBA{0x10, 0x20, 0x30, 0xff}, color.RGBAModel.Convert(frame.At(0, 0)))
+
+	o.FillColor = "orange"
+	assert.EqualError(t, o.Configure(&c8), `fill colour: invalid colour "orange"`)
+}
+
+func TestDefaultOptions(t *testing.T) {
+	o := DefaultOptions()
+	assert.Equal(t, chip8.DefaultQuirks, o.Quirks())
+
+	c8 := chip8.Init(nil)
+	require.NoError(t, o.Configure(&c8))
+	// Octo runs a whole number of instructions per frame
+	assert.Equal(t, chip8.ClockRate/chip8.TimerRate*chip8.TimerRate, c8.ClockRate())
+}
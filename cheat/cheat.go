@@ -0,0 +1,320 @@
+// Package cheat freezes bytes of memory and registers of a running CHIP-8
+// program to fixed values, and searches memory across frames for the
+// variables worth freezing, such as lives, score or level.
+//
+// Cheats are kept in a text file per ROM, named after the SHA-256 hash of
+// the ROM, with a cheat a line:
+//
+//	# lives never run out
+//	on lives 0x3a0 = 3
+//	off level V5 = 0x09
+//
+// giving whether the cheat is enabled, its name, the address or V register
+// it freezes and the value.
+package cheat
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// Target is a byte of memory or a V register frozen by a cheat.
+type Target struct {
+	// Register is the number of the V register, or -1 for memory
+	Register int
+	Addr     uint16
+}
+
+// ParseTarget parses an address, as in 0x3a0, or a register, as in V5.
+func ParseTarget(s string) (Target, error) {
+	if len(s) == 2 && (s[0] == 'V' || s[0] == 'v') {
+		if x, err := strconv.ParseUint(s[1:], 16, 4); err == nil {
+			return Target{Register: int(x)}, nil
+		}
+	}
+
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil || v > 0xfff {
+		return Target{}, fmt.Errorf("invalid address or register %q", s)
+	}
+
+	return Target{Register: -1, Addr: uint16(v)}, nil
+}
+
+func (t Target) String() string {
+	if t.Register >= 0 {
+		return fmt.Sprintf("V%X", t.Register)
+	}
+
+	return fmt.Sprintf("0x%03x", t.Addr)
+}
+
+// Cheat freezes a target to a value while it is enabled.
+type Cheat struct {
+	Name    string
+	Target  Target
+	Value   byte
+	Enabled bool
+}
+
+func (c Cheat) String() string {
+	state := "off"
+	if c.Enabled {
+		state = "on"
+	}
+
+	return fmt.Sprintf("%s %s %s = 0x%02x", state, c.Name, c.Target, c.Value)
+}
+
+// Engine is a hook applying the enabled cheats before the first instruction,
+// after every tick of the timers, and before the next instruction when they
+// change. Attach it with chip8.State.AddHook. Its methods may be called while
+// the program runs in another goroutine, except for Apply.
+type Engine struct {
+	state *chip8.State
+	poke  func(addr uint16, value byte)
+
+	mu     sync.Mutex
+	cheats []Cheat
+
+	// Set when the cheats changed or the timers ticked since they were last
+	// applied
+	changed int32
+}
+
+// NewEngine returns an engine applying cheats to the program running in
+// state.
+func NewEngine(state *chip8.State, cheats []Cheat) *Engine {
+	return &Engine{state: state, poke: state.Poke, cheats: cheats, changed: 1}
+}
+
+// SetPoke replaces the function storing the values of cheats in memory,
+// State.Poke by default, so that a debugger can record them. It must not be
+// called while the program runs.
+func (e *Engine) SetPoke(poke func(addr uint16, value byte)) {
+	e.poke = poke
+}
+
+func (e *Engine) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	if atomic.SwapInt32(&e.changed, 0) != 0 {
+		e.Apply()
+	}
+}
+
+func (e *Engine) OnTick() {
+	atomic.StoreInt32(&e.changed, 1)
+}
+
+// Apply writes the values of the enabled cheats. It must not be called
+// while the program runs.
+func (e *Engine) Apply() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, c := range e.cheats {
+		if c.Enabled {
+			e.apply(c)
+		}
+	}
+}
+
+func (e *Engine) apply(c Cheat) {
+	if c.Target.Register >= 0 {
+		e.state.V[c.Target.Register&0xf] = c.Value
+	} else {
+		e.poke(c.Target.Addr, c.Value)
+	}
+}
+
+// Cheats returns a copy of the cheats.
+func (e *Engine) Cheats() []Cheat {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]Cheat(nil), e.cheats...)
+}
+
+// Add adds a cheat.
+func (e *Engine) Add(c Cheat) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if strings.ContainsAny(c.Name, " \t#") || c.Name == "" {
+		return fmt.Errorf("invalid cheat name %q", c.Name)
+	}
+	for _, other := range e.cheats {
+		if other.Name == c.Name {
+			return fmt.Errorf("cheat %q already exists", c.Name)
+		}
+	}
+
+	e.cheats = append(e.cheats, c)
+	atomic.StoreInt32(&e.changed, 1)
+
+	return nil
+}
+
+// Remove removes the named cheat.
+func (e *Engine) Remove(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, c := range e.cheats {
+		if c.Name == name {
+			e.cheats = append(e.cheats[:i], e.cheats[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no cheat %q", name)
+}
+
+// Enable enables or disables the named cheat.
+func (e *Engine) Enable(name string, enabled bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.cheats {
+		if e.cheats[i].Name == name {
+			e.cheats[i].Enabled = enabled
+			atomic.StoreInt32(&e.changed, 1)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no cheat %q", name)
+}
+
+// Toggle flips the i-th cheat, counting from 0, returning it as toggled.
+func (e *Engine) Toggle(i int) (Cheat, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if i < 0 || i >= len(e.cheats) {
+		return Cheat{}, fmt.Errorf("no cheat %d", i+1)
+	}
+	e.cheats[i].Enabled = !e.cheats[i].Enabled
+	atomic.StoreInt32(&e.changed, 1)
+
+	return e.cheats[i], nil
+}
+
+// Path returns the path of the cheat file of a ROM, in the cheats directory
+// of the user's configuration directory.
+func Path(rom []byte) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(rom)
+	return filepath.Join(dir, "chip8", "cheats", hex.EncodeToString(sum[:])+".cheats"), nil
+}
+
+// Read reads cheats in the cheat file format.
+func Read(r io.Reader) ([]Cheat, error) {
+	var cheats []Cheat
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		c, err := parseCheat(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		cheats = append(cheats, c)
+	}
+
+	return cheats, scanner.Err()
+}
+
+func parseCheat(fields []string) (Cheat, error) {
+	if len(fields) != 5 || fields[3] != "=" {
+		return Cheat{}, errors.New("expected on|off name target = value")
+	}
+
+	var c Cheat
+	switch fields[0] {
+	case "on":
+		c.Enabled = true
+	case "off":
+	default:
+		return c, fmt.Errorf("expected on or off, got %q", fields[0])
+	}
+	c.Name = fields[1]
+
+	var err error
+	if c.Target, err = ParseTarget(fields[2]); err != nil {
+		return c, err
+	}
+
+	v, err := strconv.ParseUint(fields[4], 0, 8)
+	if err != nil {
+		return c, fmt.Errorf("invalid value %q", fields[4])
+	}
+	c.Value = byte(v)
+
+	return c, nil
+}
+
+// Write writes cheats in the cheat file format.
+func Write(w io.Writer, cheats []Cheat) error {
+	bw := bufio.NewWriter(w)
+	for _, c := range cheats {
+		fmt.Fprintln(bw, c)
+	}
+
+	return bw.Flush()
+}
+
+// Load reads the cheat file at path. A missing file holds no cheats.
+func Load(path string) ([]Cheat, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Save writes the cheat file at path, creating its directory.
+func Save(path string, cheats []Cheat) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, cheats); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,151 @@
+package cheat
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("0x3a0")
+	require.NoError(t, err)
+	assert.Equal(t, Target{Register: -1, Addr: 0x3a0}, target)
+	assert.Equal(t, "0x3a0", target.String())
+
+	target, err = ParseTarget("vA")
+	require.NoError(t, err)
+	assert.Equal(t, Target{Register: 0xa}, target)
+	assert.Equal(t, "VA", target.String())
+
+	for _, s := range []string{"0x1000", "VG", "lives", ""} {
+		_, err = ParseTarget(s)
+		assert.Error(t, err, s)
+	}
+}
+
+const cheatFile = `# lives never run out
+on lives 0x3a0 = 3
+off   level V5 = 0x09   # the last level
+`
+
+func TestRead(t *testing.T) {
+	cheats, err := Read(strings.NewReader(cheatFile))
+	require.NoError(t, err)
+	assert.Equal(t, []Cheat{
+		{"lives", Target{-1, 0x3a0}, 3, true},
+		{"level", Target{5, 0}, 9, false},
+	}, cheats)
+
+	var out bytes.Buffer
+	require.NoError(t, Write(&out, cheats))
+	assert.Equal(t, "on lives 0x3a0 = 0x03\noff level V5 = 0x09\n", out.String())
+
+	_, err = Read(strings.NewReader("\non lives 0x3a0 3\n"))
+	assert.EqualError(t, err, "line 2: expected on|off name target = value")
+	_, err = Read(strings.NewReader("maybe lives 0x3a0 = 3\n"))
+	assert.EqualError(t, err, `line 1: expected on or off, got "maybe"`)
+	_, err = Read(strings.NewReader("on lives 0x3a0 = 256\n"))
+	assert.EqualError(t, err, `line 1: invalid value "256"`)
+}
+
+func TestLoadSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cheat")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cheats", "game.cheats")
+	cheats, err := Load(path)
+	require.NoError(t, err)
+	assert.Empty(t, cheats)
+
+	want := []Cheat{{"lives", Target{-1, 0x3a0}, 3, true}}
+	require.NoError(t, Save(path, want))
+	cheats, err = Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, cheats)
+}
+
+func TestPath(t *testing.T) {
+	path, err := Path([]byte{0x12, 0x00})
+	if err != nil {
+		t.Skip("no configuration directory")
+	}
+
+	assert.Equal(t, "cheats", filepath.Base(filepath.Dir(path)))
+	assert.Equal(t, ".cheats", filepath.Ext(path))
+	assert.Len(t, filepath.Base(path), 64+len(".cheats"))
+}
+
+func TestEngine(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram([]byte{
+		0x70, 0xff, // 0x200: V0 += 0xff
+		0xa3, 0xa0, // 0x202: I = 0x3a0
+		0xf0, 0x55, // 0x204: Store V0 at I
+		0x12, 0x00, // 0x206: Jump to 0x200
+	})
+
+	e := NewEngine(&c8, []Cheat{{"lives", Target{-1, 0x3a1}, 3, true}})
+	c8.AddHook(e)
+
+	require.NoError(t, e.Add(Cheat{"counter", Target{0, 0}, 5, false}))
+	assert.Error(t, e.Add(Cheat{"counter", Target{1, 0}, 5, false}))
+	assert.Error(t, e.Add(Cheat{"two words", Target{1, 0}, 5, false}))
+
+	c8.Step()
+	assert.Equal(t, byte(3), c8.Peek(0x3a1))
+	assert.Equal(t, byte(0xff), c8.V[0])
+
+	// Enabled cheats apply before the next instruction, then after every tick
+	require.NoError(t, e.Enable("counter", true))
+	c8.Step()
+	assert.Equal(t, byte(5), c8.V[0])
+	c8.Poke(0x3a1, 0)
+	c8.Step()
+	assert.Equal(t, byte(0), c8.Peek(0x3a1))
+	c8.Tick()
+	c8.Step()
+	assert.Equal(t, byte(3), c8.Peek(0x3a1))
+
+	c, err := e.Toggle(1)
+	require.NoError(t, err)
+	assert.False(t, c.Enabled)
+	_, err = e.Toggle(2)
+	assert.EqualError(t, err, "no cheat 3")
+
+	require.NoError(t, e.Remove("lives"))
+	assert.Error(t, e.Remove("lives"))
+	assert.Equal(t, []Cheat{{"counter", Target{0, 0}, 5, false}}, e.Cheats())
+}
+
+func TestSearch(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.Poke(0x3a0, 3)
+	c8.Poke(0x3a1, 3)
+
+	s := NewSearch(&c8)
+	assert.Len(t, s.Candidates(), 0x1000)
+
+	assert.Equal(t, 2, s.Filter(Equal, 3))
+
+	c8.Poke(0x3a0, 2)
+	assert.Equal(t, 1, s.Filter(Decreased, 0))
+	assert.Equal(t, []uint16{0x3a0}, s.Candidates())
+
+	assert.Equal(t, 1, s.Filter(Unchanged, 0))
+	c8.Poke(0x3a0, 3)
+	assert.Equal(t, 0, s.Filter(Decreased, 0))
+
+	c, err := ParseComparison("inc")
+	require.NoError(t, err)
+	assert.Equal(t, Increased, c)
+	_, err = ParseComparison("bigger")
+	assert.Error(t, err)
+}
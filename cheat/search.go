@@ -0,0 +1,105 @@
+package cheat
+
+import (
+	"fmt"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+const memorySize = 0x1000
+
+// Comparison selects the bytes kept by a search step.
+type Comparison int
+
+const (
+	// Equal keeps the bytes equal to a value.
+	Equal Comparison = iota
+	// Changed keeps the bytes which changed since the last step.
+	Changed
+	// Unchanged keeps the bytes which did not change since the last step.
+	Unchanged
+	// Increased keeps the bytes which grew since the last step.
+	Increased
+	// Decreased keeps the bytes which shrank since the last step.
+	Decreased
+)
+
+var comparisonNames = map[string]Comparison{
+	"eq":        Equal,
+	"changed":   Changed,
+	"unchanged": Unchanged,
+	"inc":       Increased,
+	"dec":       Decreased,
+}
+
+// ParseComparison parses the name of a comparison: eq, changed, unchanged,
+// inc or dec.
+func ParseComparison(name string) (Comparison, error) {
+	c, ok := comparisonNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown comparison %q", name)
+	}
+
+	return c, nil
+}
+
+// Search narrows down the addresses of memory holding a variable, by
+// comparing memory between steps taken as the program runs. Every address
+// is a candidate at first.
+type Search struct {
+	state      *chip8.State
+	candidates []uint16
+	last       [memorySize]byte
+}
+
+// NewSearch starts a search of the memory of the program running in state.
+func NewSearch(state *chip8.State) *Search {
+	s := &Search{state: state, candidates: make([]uint16, memorySize)}
+	for addr := range s.candidates {
+		s.candidates[addr] = uint16(addr)
+	}
+	s.snapshot()
+
+	return s
+}
+
+func (s *Search) snapshot() {
+	for addr := range s.last {
+		s.last[addr] = s.state.Peek(uint16(addr))
+	}
+}
+
+// Filter keeps the candidates whose value compares to the value of the last
+// step, or for Equal to value, and returns the number left.
+func (s *Search) Filter(c Comparison, value byte) int {
+	kept := s.candidates[:0]
+	for _, addr := range s.candidates {
+		old, now := s.last[addr], s.state.Peek(addr)
+
+		var keep bool
+		switch c {
+		case Equal:
+			keep = now == value
+		case Changed:
+			keep = now != old
+		case Unchanged:
+			keep = now == old
+		case Increased:
+			keep = now > old
+		case Decreased:
+			keep = now < old
+		}
+		if keep {
+			kept = append(kept, addr)
+		}
+	}
+	s.candidates = kept
+	s.snapshot()
+
+	return len(kept)
+}
+
+// Candidates returns the addresses still matching, in ascending order.
+func (s *Search) Candidates() []uint16 {
+	return s.candidates
+}
@@ -0,0 +1,265 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Address programs are loaded at
+const programAddress = 0x200
+
+// Flags describing a byte of an analysed program
+const (
+	// The byte belongs to an instruction
+	byteCode byte = 1 << iota
+	// An instruction starts at the byte
+	byteInstruction
+	// The byte is loaded into I by ANNN
+	byteDataRef
+	// The byte is the target of a jump
+	byteJumpTarget
+	// The byte is the target of a call
+	byteCallTarget
+)
+
+// Analysis separates the code of a program from its data, by following its
+// control flow from the entry point at 0x200.
+type Analysis struct {
+	Program []byte
+
+	// Instructions reached by following control flow, by address
+	Instructions map[uint16]Instruction
+
+	// Labels of the jump and call targets and of the data loaded into I
+	Labels map[uint16]string
+
+	// Annotate, when set, returns a note for the comment of the listing
+	// line at an address, such as the source line it was built from
+	Annotate func(addr uint16) string
+
+	flags []byte
+}
+
+// Analyze disassembles program, loaded at 0x200, by recursive traversal. It
+// follows jumps, calls, returns and skips, so it finds code at odd addresses
+// and does not decode data as instructions. Computed jumps (BNNN) can only be
+// followed to their base address, so code reached only through jump tables
+// may be reported as data.
+func Analyze(program []byte) *Analysis {
+	a := &Analysis{
+		Program:      program,
+		Instructions: make(map[uint16]Instruction),
+		Labels:       make(map[uint16]string),
+		flags:        make([]byte, len(program)),
+	}
+
+	work := []uint16{programAddress}
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if !a.contains(addr) || !a.contains(addr+1) || a.flag(addr, byteInstruction) {
+			continue
+		}
+
+		ins := Disassemble(NewOpCode([2]byte{a.byteAt(addr), a.byteAt(addr + 1)}))
+		a.Instructions[addr] = ins
+		a.setFlag(addr, byteCode|byteInstruction)
+		a.setFlag(addr+1, byteCode)
+
+		switch ins.Flow {
+		case FlowNext:
+			work = append(work, addr+2)
+		case FlowSkip:
+			work = append(work, addr+2, addr+4)
+		case FlowJump:
+			a.setFlag(ins.Target, byteJumpTarget)
+			work = append(work, ins.Target)
+		case FlowIndirectJump:
+			// The base of a jump table is usually its first entry
+			a.setFlag(ins.Target, byteJumpTarget)
+			work = append(work, ins.Target)
+		case FlowCall:
+			a.setFlag(ins.Target, byteCallTarget)
+			work = append(work, ins.Target, addr+2)
+		}
+
+		if ins.Op.B00 == 0x0a {
+			a.setFlag(ins.Op.Addr(), byteDataRef)
+		}
+	}
+
+	a.label()
+
+	return a
+}
+
+func (a *Analysis) contains(addr uint16) bool {
+	return addr >= programAddress && int(addr-programAddress) < len(a.Program)
+}
+
+func (a *Analysis) byteAt(addr uint16) byte {
+	return a.Program[addr-programAddress]
+}
+
+func (a *Analysis) flag(addr uint16, flag byte) bool {
+	return a.contains(addr) && a.flags[addr-programAddress]&flag != 0
+}
+
+func (a *Analysis) setFlag(addr uint16, flag byte) {
+	if a.contains(addr) {
+		a.flags[addr-programAddress] |= flag
+	}
+}
+
+// IsCode reports whether the byte at addr belongs to a reachable
+// instruction.
+func (a *Analysis) IsCode(addr uint16) bool {
+	return a.flag(addr, byteCode)
+}
+
+// IsDataRef reports whether addr is loaded into I by an ANNN instruction.
+func (a *Analysis) IsDataRef(addr uint16) bool {
+	return a.flag(addr, byteDataRef)
+}
+
+// Addresses returns the addresses of the instructions in ascending order.
+func (a *Analysis) Addresses() []uint16 {
+	addrs := make([]uint16, 0, len(a.Instructions))
+	for addr := range a.Instructions {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	return addrs
+}
+
+// lineStart reports whether a line of the listing starts at addr, that is
+// addr is not the second byte of an instruction.
+func (a *Analysis) lineStart(addr uint16) bool {
+	return !a.flag(addr, byteCode) || a.flag(addr, byteInstruction)
+}
+
+// overlaps reports whether the instruction at addr overlaps the one after it,
+// which happens when code is reached both at even and odd alignment.
+func (a *Analysis) overlaps(addr uint16) bool {
+	return a.flag(addr, byteInstruction) && a.flag(addr+1, byteInstruction)
+}
+
+func (a *Analysis) label() {
+	for i, f := range a.flags {
+		addr := uint16(programAddress + i)
+		if !a.lineStart(addr) {
+			continue
+		}
+
+		switch {
+		case f&byteCallTarget != 0:
+			a.Labels[addr] = fmt.Sprintf("sub_%03x", addr)
+		case f&byteJumpTarget != 0:
+			a.Labels[addr] = fmt.Sprintf("label_%03x", addr)
+		case f&byteDataRef != 0:
+			a.Labels[addr] = fmt.Sprintf("data_%03x", addr)
+		}
+	}
+}
+
+// SetLabels names addresses with known labels, such as those of a symbol
+// file, replacing the generated names. Characters the assembler does not
+// accept in labels, like the dashes of Octo names, are replaced with
+// underscores. Labels inside of instructions are ignored, as the listing has
+// no line to put them on.
+func (a *Analysis) SetLabels(labels map[uint16]string) {
+	for addr, name := range labels {
+		if a.contains(addr) && a.lineStart(addr) {
+			a.Labels[addr] = strings.Map(func(r rune) rune {
+				if r == '_' || r == '.' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+					return r
+				}
+				return '_'
+			}, name)
+		}
+	}
+}
+
+// note returns the annotation of addr, preceded by a space.
+func (a *Analysis) note(addr uint16) string {
+	if a.Annotate == nil {
+		return ""
+	}
+	if note := a.Annotate(addr); note != "" {
+		return " " + note
+	}
+
+	return ""
+}
+
+// WriteListing writes an annotated listing of the program in classic
+// assembler syntax, which assembles back into the program. Data loaded into I
+// is written a byte per line, with the byte drawn as a sprite row in the
+// comment; other data is written eight bytes per line.
+func (a *Analysis) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "; %d bytes, %d instructions\n", len(a.Program), len(a.Instructions))
+
+	// Whether the data being written is loaded into I, and so likely a
+	// sprite
+	sprite := false
+
+	end := uint16(programAddress + len(a.Program))
+	for addr := uint16(programAddress); addr < end; {
+		if label, ok := a.Labels[addr]; ok {
+			fmt.Fprintf(bw, "\n%s:\n", label)
+		}
+
+		switch {
+		case a.flag(addr, byteInstruction) && !a.overlaps(addr):
+			ins := a.Instructions[addr]
+			fmt.Fprintf(bw, "\t%-24s ; 0x%03x: %02x%02x%s\n", ins.Format(SyntaxClassic, a.Labels), addr, ins.Op.B0, ins.Op.B1, a.note(addr))
+			addr += 2
+			sprite = false
+		case a.flag(addr, byteInstruction):
+			b := a.byteAt(addr)
+			fmt.Fprintf(bw, "\t%-24s ; 0x%03x: %02x, overlaps the instruction at 0x%03x\n", fmt.Sprintf("DB 0x%02x", b), addr, b, addr+1)
+			addr++
+		default:
+			sprite = sprite || a.flag(addr, byteDataRef)
+			if sprite {
+				b := a.byteAt(addr)
+				fmt.Fprintf(bw, "\t%-24s ; 0x%03x: %s%s\n", fmt.Sprintf("DB 0x%02x", b), addr, spriteRow(b), a.note(addr))
+				addr++
+				continue
+			}
+
+			var bytes []string
+			start := addr
+			for ; addr < end && len(bytes) < 8 && !a.flag(addr, byteCode); addr++ {
+				if addr != start && (a.Labels[addr] != "" || a.flag(addr, byteDataRef)) {
+					break
+				}
+				bytes = append(bytes, fmt.Sprintf("0x%02x", a.byteAt(addr)))
+			}
+			fmt.Fprintf(bw, "\t%-24s ; 0x%03x%s\n", "DB "+strings.Join(bytes, ", "), start, a.note(start))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// spriteRow draws a byte of sprite data.
+func spriteRow(b byte) string {
+	var sb strings.Builder
+	for i := 7; i >= 0; i-- {
+		if b>>i&1 == 1 {
+			sb.WriteByte('#')
+		} else {
+			sb.WriteByte('.')
+		}
+	}
+
+	return sb.String()
+}
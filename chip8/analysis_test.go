@@ -0,0 +1,107 @@
+package chip8
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var analysisProgram = []byte{
+	0xa2, 0x0c, // 0x200: I = 0x20c, inside an instruction
+	0x23, 0x00, // 0x202: Call 0x300, outside of the program
+	0x22, 0x09, // 0x204: Call sub_209, at an odd address
+	0x12, 0x04, // 0x206: Jump to label_204
+	0xff,       // 0x208: padding
+	0xd0, 0x12, // 0x209: Draw 8x2 sprite at (V0, V1)
+	0x00, 0xee, // 0x20b: Return
+	0xf0, 0x90, // 0x20d: sprite data
+	0x01, 0x02, 0x03, // 0x20f: unreferenced data
+}
+
+func TestAnalyze(t *testing.T) {
+	a := Analyze(analysisProgram)
+
+	assert.Equal(t, []uint16{0x200, 0x202, 0x204, 0x206, 0x209, 0x20b}, a.Addresses())
+	assert.True(t, a.IsCode(0x20a))
+	assert.False(t, a.IsCode(0x208))
+	assert.False(t, a.IsCode(0x20d))
+	assert.True(t, a.IsDataRef(0x20c), "expected the second byte of the return to be loaded into I")
+
+	assert.Equal(t, map[uint16]string{
+		0x204: "label_204",
+		0x209: "sub_209",
+	}, a.Labels)
+}
+
+func TestAnalysis_WriteListing(t *testing.T) {
+	program := append([]byte{}, analysisProgram...)
+	program[1] = 0x0d // I = 0x20d
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, Analyze(program).WriteListing(out))
+
+	assert.Equal(t, `; 18 bytes, 6 instructions
+	LD I, data_20d           ; 0x200: a20d
+	CALL 0x300               ; 0x202: 2300
+
+label_204:
+	CALL sub_209             ; 0x204: 2209
+	JP label_204             ; 0x206: 1204
+	DB 0xff                  ; 0x208
+
+sub_209:
+	DRW V0, V1, 2            ; 0x209: d012
+	RET                      ; 0x20b: 00ee
+
+data_20d:
+	DB 0xf0                  ; 0x20d: ####....
+	DB 0x90                  ; 0x20e: #..#....
+	DB 0x01                  ; 0x20f: .......#
+	DB 0x02                  ; 0x210: ......#.
+	DB 0x03                  ; 0x211: ......##
+`, out.String())
+}
+
+func TestAnalysis_SetLabels(t *testing.T) {
+	a := Analyze(analysisProgram)
+	a.SetLabels(map[uint16]string{
+		0x204: "main-loop",
+		0x209: "draw",
+		0x20a: "inside", // the second byte of an instruction
+	})
+	a.Annotate = func(addr uint16) string {
+		if addr == 0x209 {
+			return "game.8o:12"
+		}
+		return ""
+	}
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, a.WriteListing(out))
+
+	assert.Contains(t, out.String(), "\nmain_loop:\n\tCALL draw                ; 0x204: 2209\n")
+	assert.Contains(t, out.String(), "\ndraw:\n\tDRW V0, V1, 2            ; 0x209: d012 game.8o:12\n")
+	assert.NotContains(t, out.String(), "inside")
+}
+
+func TestAnalysis_WriteListing_overlap(t *testing.T) {
+	program := []byte{
+		0x30, 0x00, // 0x200: Skip next instruction if V0 == 0x00
+		0x00, 0xe0, // 0x202: Clear the screen
+		0x12, 0x05, // 0x204: Jump to 0x205, into its own second byte
+		0x12, // 0x206: 0x205 decodes as 0512
+	}
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, Analyze(program).WriteListing(out))
+
+	assert.Equal(t, `; 7 bytes, 4 instructions
+	SE V0, 0x00              ; 0x200: 3000
+	CLS                      ; 0x202: 00e0
+	DB 0x12                  ; 0x204: 12, overlaps the instruction at 0x205
+
+label_205:
+	SYS 0x512                ; 0x205: 0512
+`, out.String())
+}
@@ -0,0 +1,126 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMnemonic(t *testing.T) {
+	tests := map[[2]byte]string{
+		{0x00, 0xe0}: "CLS",
+		{0x00, 0xee}: "RET",
+		{0x03, 0x00}: "SYS 0x300",
+		{0x12, 0x34}: "JP 0x234",
+		{0x3a, 0x12}: "SE VA, 0x12",
+		{0x5a, 0xb0}: "SE VA, VB",
+		{0x5a, 0xb1}: "DW 0x5ab1",
+		{0x8a, 0xb7}: "SUBN VA, VB",
+		{0x8a, 0xb8}: "DW 0x8ab8",
+		{0xb2, 0x00}: "JP V0, 0x200",
+		{0xd1, 0x25}: "DRW V1, V2, 5",
+		{0xe3, 0xa1}: "SKNP V3",
+		{0xf3, 0x55}: "LD [I], V3",
+		{0xf3, 0x65}: "LD V3, [I]",
+		{0xf3, 0x99}: "DW 0xf399",
+	}
+
+	for b, want := range tests {
+		assert.Equal(t, want, Mnemonic(NewOpCode(b)))
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	ins := Disassemble(NewOpCode([2]byte{0xd1, 0x25}))
+
+	assert.Equal(t, "DRW", ins.Mnemonic)
+	assert.Equal(t, []Operand{
+		{Kind: OperandRegister, Value: 0x1},
+		{Kind: OperandRegister, Value: 0x2},
+		{Kind: OperandNibble, Value: 0x5},
+	}, ins.Operands)
+	assert.Equal(t, "Draw 8x5 sprite at (V1, V2)", ins.Description)
+	assert.Equal(t, FlowNext, ins.Flow)
+	assert.Equal(t, "DXYN", ins.Pattern())
+
+	ins = Disassemble(NewOpCode([2]byte{0x23, 0x00}))
+	assert.Equal(t, FlowCall, ins.Flow)
+	assert.Equal(t, uint16(0x300), ins.Target)
+
+	ins = Disassemble(NewOpCode([2]byte{0xe1, 0x9e}))
+	assert.Equal(t, FlowSkip, ins.Flow)
+	assert.Equal(t, "EX9E", ins.Pattern())
+
+	ins = Disassemble(NewOpCode([2]byte{0xff, 0xff}))
+	assert.Equal(t, FlowHalt, ins.Flow)
+	assert.Equal(t, "", ins.Pattern())
+}
+
+func TestInstruction_Format(t *testing.T) {
+	labels := map[uint16]string{0x300: "draw_player"}
+
+	tests := []struct {
+		op      [2]byte
+		classic string
+		octo    string
+	}{
+		{[2]byte{0x00, 0xe0}, "CLS", "clear"},
+		{[2]byte{0x23, 0x00}, "CALL draw_player", ":call draw_player"},
+		{[2]byte{0x13, 0x02}, "JP 0x302", "jump 0x302"},
+		{[2]byte{0x3a, 0x12}, "SE VA, 0x12", "if va != 0x12 then"},
+		{[2]byte{0x9a, 0xb0}, "SNE VA, VB", "if va == vb then"},
+		{[2]byte{0x8a, 0xb7}, "SUBN VA, VB", "va =- vb"},
+		{[2]byte{0xa3, 0x00}, "LD I, draw_player", "i := draw_player"},
+		{[2]byte{0xb3, 0x00}, "JP V0, draw_player", "jump0 draw_player"},
+		{[2]byte{0xc1, 0x0f}, "RND V1, 0x0f", "v1 := random 0x0f"},
+		{[2]byte{0xd1, 0x25}, "DRW V1, V2, 5", "sprite v1 v2 5"},
+		{[2]byte{0xe4, 0xa1}, "SKNP V4", "if v4 key then"},
+		{[2]byte{0xf4, 0x18}, "LD ST, V4", "buzzer := v4"},
+		{[2]byte{0xf4, 0x65}, "LD V4, [I]", "load v4"},
+		{[2]byte{0x01, 0x23}, "SYS 0x123", "0x01 0x23"},
+		{[2]byte{0xf4, 0x99}, "DW 0xf499", "0xf4 0x99"},
+	}
+
+	for _, tt := range tests {
+		ins := Disassemble(NewOpCode(tt.op))
+		assert.Equal(t, tt.classic, ins.Format(SyntaxClassic, labels))
+		assert.Equal(t, tt.octo, ins.Format(SyntaxOcto, labels))
+	}
+}
+
+func TestWriteClassic(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.NoError(t, WriteClassic(out, []byte{0x60, 0x22, 0x12, 0x00, 0xf0}))
+
+	assert.Equal(t, "\tLD V0, 0x22              ; 0x200: 6022\n"+
+		"\tJP 0x200                 ; 0x202: 1200\n"+
+		"\tDB 0xf0                  ; 0x204: f0\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, WriteOcto(out, []byte{0x60, 0x22, 0xf0}))
+
+	assert.Equal(t, "\tv0 := 0x22               # 0x200: 6022\n"+
+		"\t0xf0                     # 0x202: f0\n", out.String())
+}
+
+func TestWriteJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.NoError(t, WriteJSON(out, []byte{0x60, 0x22, 0x12, 0x00}))
+
+	var instructions []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &instructions))
+	assert.Len(t, instructions, 2)
+
+	assert.Equal(t, "6022", instructions[0]["opcode"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"kind": "register", "value": 0.0},
+		map[string]interface{}{"kind": "immediate", "value": 34.0},
+	}, instructions[0]["operands"])
+	assert.NotContains(t, instructions[0], "target")
+
+	assert.Equal(t, 514.0, instructions[1]["address"])
+	assert.Equal(t, "jump", instructions[1]["flow"])
+	assert.Equal(t, 512.0, instructions[1]["target"])
+}
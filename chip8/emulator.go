@@ -4,7 +4,8 @@ import (
 	"fmt"
 	"image"
 	"image/color"
-	"math/rand"
+	"io"
+	"os"
 	"time"
 
 	"golang.org/x/image/draw"
@@ -12,6 +13,13 @@ import (
 
 const screenAddress = 0xf00
 
+const (
+	// ClockRate is the number of instructions executed per second.
+	ClockRate = 500
+	// TimerRate is the frequency of the delay and sound timers.
+	TimerRate = 60
+)
+
 type Machine interface {
 	GetKeyAt(key byte) bool
 	WaitForKeyPress() byte
@@ -39,12 +47,27 @@ type State struct {
 	keyChannel chan keyEvent
 	keyboard   [16]bool
 
-	halt bool
+	halt    bool
+	running bool
 
 	clock *time.Ticker
 	timer *time.Ticker
 
 	machine Machine
+
+	random Random
+
+	// Messages of the emulator, such as why a program halted
+	out io.Writer
+
+	hooks *hooks
+
+	quirks    Quirks
+	clockRate int
+
+	// Colours of unset and set pixels
+	background color.Color
+	foreground color.Color
 }
 
 func Init(machine Machine) State {
@@ -60,48 +83,167 @@ func Init(machine Machine) State {
 		machine: machine,
 
 		// 500Hz clock
-		clock: time.NewTicker(time.Second / 500),
+		clock: time.NewTicker(time.Second / ClockRate),
 		// 60Hz clock for timers
-		timer: time.NewTicker(time.Second / 60),
+		timer: time.NewTicker(time.Second / TimerRate),
 
 		// Channel for keypresses
 		keyChannel: make(chan keyEvent, 1),
+
+		random: NewSeededRandom(time.Now().UnixNano()),
+		out:    os.Stdout,
+
+		quirks:     DefaultQuirks,
+		clockRate:  ClockRate,
+		background: color.Black,
+		foreground: color.White,
 	}
 }
 
+// SetRandom replaces the source of random bytes used by CXNN.
+func (c *State) SetRandom(r Random) {
+	c.random = r
+}
+
+// SetOutput sets where the emulator writes its messages, such as why a
+// program halted. They go to stdout by default.
+func (c *State) SetOutput(w io.Writer) {
+	c.out = w
+}
+
 func (c *State) next() {
 	c.PC += 2
 }
 
 func (c *State) RunProgram(program []byte) {
 	c.LoadProgram(program)
+	c.running = true
 
 	for {
 		select {
 		case <-c.clock.C:
-			if c.PC >= 0xFFF || c.halt {
-				c.halt = true
+			c.Step()
+			if c.halt {
+				c.running = false
 				c.timer.Stop()
 				c.clock.Stop()
 				return
 			}
-
-			op := NewOpCode([2]byte{c.memory[c.PC], c.memory[c.PC+1]})
-			c.RunOp(op)
 		case <-c.timer.C:
-			if c.delayTimer > 0 {
-				c.delayTimer--
-			}
-
-			if c.soundTimer > 0 {
-				c.soundTimer--
-			}
+			c.Tick()
 		case e := <-c.keyChannel:
 			c.keyboard[e.key] = e.pressed
 		}
 	}
 }
 
+// Step executes the instruction at PC. Once the program has halted, Step does
+// nothing.
+func (c *State) Step() {
+	if c.halt {
+		return
+	}
+	if c.PC >= 0xFFF {
+		c.stop(HaltEndOfMemory)
+		return
+	}
+
+	c.RunOp(c.OpAt(c.PC))
+}
+
+// Tick decrements the delay and sound timers. RunProgram calls it at
+// TimerRate; callers driving the program with Step are expected to do the
+// same.
+func (c *State) Tick() {
+	if c.delayTimer > 0 {
+		c.delayTimer--
+	}
+
+	if c.soundTimer > 0 {
+		c.soundTimer--
+	}
+
+	if r, ok := c.random.(frameTicker); ok {
+		r.Tick()
+	}
+
+	if c.hooks != nil {
+		for _, h := range c.hooks.tick {
+			h.OnTick()
+		}
+	}
+}
+
+// Halted reports whether the program has stopped.
+func (c *State) Halted() bool {
+	return c.halt
+}
+
+// Resume clears the halted state, so that a program restored to a state
+// before it halted can run again.
+func (c *State) Resume() {
+	c.halt = false
+}
+
+// OpAt returns the opcode stored at addr.
+func (c *State) OpAt(addr uint16) OpCode {
+	return NewOpCode([2]byte{c.memory[addr&0xfff], c.memory[(addr+1)&0xfff]})
+}
+
+// Peek returns the byte stored at addr.
+func (c *State) Peek(addr uint16) byte {
+	return c.memory[addr&0xfff]
+}
+
+// Poke stores value at addr.
+func (c *State) Poke(addr uint16, value byte) {
+	c.memory[addr&0xfff] = value
+}
+
+func (c *State) DelayTimer() byte {
+	return c.delayTimer
+}
+
+func (c *State) SetDelayTimer(value byte) {
+	c.delayTimer = value
+}
+
+func (c *State) SoundTimer() byte {
+	return c.soundTimer
+}
+
+func (c *State) SetSoundTimer(value byte) {
+	c.soundTimer = value
+}
+
+// CallStack returns the addresses of the 2NNN instructions on the stack, the
+// innermost call first.
+func (c *State) CallStack() []uint16 {
+	var stack []uint16
+	for sp := c.SP; sp < 0xefe; sp += 2 {
+		stack = append(stack, uint16(c.memory[sp])<<8|uint16(c.memory[sp+1]))
+	}
+
+	return stack
+}
+
+// Key reports whether key is pressed.
+func (c *State) Key(key byte) bool {
+	return c.keyboard[key&0xf]
+}
+
+// SetKey presses or releases key immediately, unlike SendKey which hands the
+// event to RunProgram.
+func (c *State) SetKey(key byte, pressed bool) {
+	c.keyboard[key&0xf] = pressed
+}
+
+// Pixel reports whether the pixel at (x, y) is lit.
+func (c *State) Pixel(x, y int) bool {
+	b := c.memory[screenAddress+y*8+x/8]
+	return b&(0x80>>(x%8)) != 0
+}
+
 func (c *State) LoadProgram(program []byte) {
 	copy(c.memory[0x200:0x200+len(program)], program)
 }
@@ -118,14 +260,14 @@ func (c *State) GetFrame(X, Y int) *Frame {
 	x, y := 0, 0
 	for _, b := range c.memory[0xF00:] {
 		for i := 0; i < 8; i++ {
-			c := color.Black
+			col := c.background
 
 			bit := (b >> byte(7-i)) & 1
 			if bit == 1 {
-				c = color.White
+				col = c.foreground
 			}
 
-			img.Set(x*8+i, y, c)
+			img.Set(x*8+i, y, col)
 		}
 		x++
 		if x == 8 {
@@ -152,19 +294,20 @@ func (c *State) Beep() bool {
 
 func (c *State) op0(op OpCode) {
 	if op.B01 != 0x0 {
-		fmt.Printf("Call RCA 1802 program at 0x%03x (not implemented)\n", op.Addr())
-		c.halt = true
+		fmt.Fprintf(c.out, "Call RCA 1802 program at 0x%03x (not implemented)\n", op.Addr())
+		c.stop(HaltMachineCode)
 		return
 	}
 
 	switch op.B1 {
 	case 0xe0:
 		// Clear the screen.
-		copy(c.memory[0xF00:], make([]byte, 256))
-		c.next()
+		for addr := uint16(screenAddress); addr <= 0xfff; addr++ {
+			c.write(addr, 0)
+		}
 	case 0xee:
 		// Stack pop
-		c.PC = uint16(c.memory[c.SP])<<8 | uint16(c.memory[c.SP+1])
+		c.PC = uint16(c.read(c.SP))<<8 | uint16(c.read(c.SP+1))
 		c.SP += 2
 	default:
 		c.notImplemented(op)
@@ -174,8 +317,8 @@ func (c *State) op0(op OpCode) {
 
 func (c *State) op1(op OpCode) {
 	if op.Addr() == c.PC {
-		fmt.Println("Infinite loop found, exiting.")
-		c.halt = true
+		fmt.Fprintln(c.out, "Infinite loop found, exiting.")
+		c.stop(HaltInfiniteLoop)
 	}
 
 	c.PC = op.Addr()
@@ -184,8 +327,8 @@ func (c *State) op1(op OpCode) {
 func (c *State) op2(op OpCode) {
 	// Stack push
 	c.SP -= 2
-	c.memory[c.SP] = byte((c.PC & 0xff00) >> 8)
-	c.memory[c.SP+1] = byte(c.PC & 0xff)
+	c.write(c.SP, byte((c.PC&0xff00)>>8))
+	c.write(c.SP+1, byte(c.PC&0xff))
 	c.PC = op.Addr()
 }
 
@@ -229,51 +372,67 @@ func (c *State) op8(op OpCode) {
 		c.V[x] = c.V[y]
 	case 0x01:
 		c.V[x] |= c.V[y]
+		c.resetFlag()
 	case 0x02:
 		c.V[x] &= c.V[y]
+		c.resetFlag()
 	case 0x03:
 		c.V[x] ^= c.V[y]
+		c.resetFlag()
 	case 0x04:
 		res := uint16(c.V[x]) + uint16(c.V[y])
-
-		carry := res&0xff00 > 0
-		if carry {
-			c.V[0x0f] = 1
-		} else {
-			c.V[0x0f] = 0
-		}
-
-		c.V[x] = byte(res & 0xff)
+		c.setResult(x, byte(res&0xff), res&0xff00 > 0)
 	case 0x05:
-		borrow := c.V[x] < c.V[y]
-		if borrow {
-			c.V[0x0f] = 0
-		} else {
-			c.V[0x0f] = 1
-		}
-
-		c.V[x] -= c.V[y]
+		c.setResult(x, c.V[x]-c.V[y], c.V[x] >= c.V[y])
 	case 0x06:
-		c.V[0x0f] = c.V[x] & 0x01
-		c.V[x] >>= 1
+		src := c.shiftSource(x, y)
+		c.setResult(x, src>>1, src&0x01 == 1)
 	case 0x07:
-		borrow := c.V[x] > c.V[y]
-		if borrow {
-			c.V[0x0f] = 0
-		} else {
-			c.V[0x0f] = 1
-		}
-
-		c.V[x] = c.V[y] - c.V[x]
+		c.setResult(x, c.V[y]-c.V[x], c.V[y] >= c.V[x])
 	case 0x0e:
-		c.V[0x0f] = c.V[x] >> 7
-		c.V[x] <<= 1
+		src := c.shiftSource(x, y)
+		c.setResult(x, src<<1, src>>7 == 1)
 	default:
 		c.notImplemented(op)
 	}
 	c.next()
 }
 
+// setResult stores the result of an arithmetic instruction in VX and its
+// flag in VF. VF is written last, so the flag wins when VF is also the
+// destination, unless the VFOrder quirk is set.
+func (c *State) setResult(x byte, result byte, flag bool) {
+	var f byte
+	if flag {
+		f = 1
+	}
+
+	if c.quirks.VFOrder {
+		c.V[0x0f] = f
+		c.V[x] = result
+		return
+	}
+
+	c.V[x] = result
+	c.V[0x0f] = f
+}
+
+// shiftSource returns the register 8XY6 and 8XYE shift.
+func (c *State) shiftSource(x, y byte) byte {
+	if c.quirks.Shift {
+		return c.V[x]
+	}
+
+	return c.V[y]
+}
+
+// resetFlag clears VF after a logic instruction with the Logic quirk.
+func (c *State) resetFlag() {
+	if c.quirks.Logic {
+		c.V[0x0f] = 0
+	}
+}
+
 func (c *State) op9(op OpCode) {
 	if c.V[op.B01] != c.V[op.B10] {
 		c.next()
@@ -287,37 +446,53 @@ func (c *State) opA(op OpCode) {
 }
 
 func (c *State) opB(op OpCode) {
+	if c.quirks.Jump {
+		c.PC = uint16(c.V[op.B01]) + op.Addr()
+		return
+	}
+
 	c.PC = uint16(c.V[0x00]) + op.Addr()
 }
 
 func (c *State) opC(op OpCode) {
 	// VX = random(0,255) & NN
-	c.V[op.B01] = byte(rand.Intn(0xff)) & op.B1
+	c.V[op.B01] = c.random.Byte() & op.B1
 	c.next()
 }
 
 func (c *State) opD(op OpCode) {
-	<-c.timer.C
+	// Wait for the vertical blank when running in real time
+	if c.running && c.quirks.VBlank {
+		<-c.timer.C
+	}
+
 	x := int(c.V[op.B01])
 	y := int(c.V[op.B10])
 	n := int(op.B11)
 
+	// Rows and columns past the edges wrap around unless they are clipped
+	rows, cols := 32, 64
+	if !c.quirks.Clip {
+		x, y = x%64, y%32
+		rows, cols = y+n, x+8
+	}
+
 	// Set collision to 0
 	c.V[0x0F] = 0
 
-	for i := 0; i < n && (i+y) < 32; i++ {
-		spriteAddr := int(c.I) + i
-		sprite := c.memory[spriteAddr]
+	for i := 0; i < n && (i+y) < rows; i++ {
+		spriteAddr := c.I + uint16(i)
+		sprite := c.read(spriteAddr)
 
-		for j := x; j < (x+8) && j < 64; j++ {
+		for j := x; j < (x+8) && j < cols; j++ {
 			spritePixel := (sprite >> (x + 7 - j)) & 0x01
 			if spritePixel == 0 {
 				continue
 			}
 
-			pixelAddr := screenAddress + ((i+y)*8 + (j / 8))
+			pixelAddr := uint16(screenAddress + ((i+y)%32*8 + (j%64)/8))
 
-			pixelByte := c.memory[pixelAddr]
+			pixelByte := c.read(pixelAddr)
 			pixel := pixelByte & (0x80 >> (j % 8))
 			sp := spritePixel << (7 - (j % 8))
 
@@ -328,7 +503,13 @@ func (c *State) opD(op OpCode) {
 
 			pixelByte ^= sp
 
-			c.memory[pixelAddr] = pixelByte
+			c.write(pixelAddr, pixelByte)
+		}
+	}
+
+	if c.hooks != nil {
+		for _, h := range c.hooks.draw {
+			h.OnDraw(byte(x), byte(y), byte(n), c.V[0xF] == 1)
 		}
 	}
 
@@ -358,8 +539,24 @@ func (c *State) opF(op OpCode) {
 	case 0x07:
 		c.V[op.B01] = c.delayTimer
 	case 0x0a:
-		<-c.keyChannel
-		c.V[op.B01] = c.machine.WaitForKeyPress()
+		if c.machine != nil {
+			<-c.keyChannel
+			c.V[op.B01] = c.machine.WaitForKeyPress()
+			break
+		}
+
+		// Without a machine, repeat the instruction until a key is down
+		key := -1
+		for k, pressed := range c.keyboard {
+			if pressed {
+				key = k
+				break
+			}
+		}
+		if key < 0 {
+			return
+		}
+		c.V[op.B01] = byte(key)
 	case 0x15:
 		// Set delay timer to the value of VX
 		c.delayTimer = c.V[op.B01]
@@ -392,16 +589,22 @@ func (c *State) opF(op OpCode) {
 		tens := value % 10
 		hundreds := value / 10
 
-		c.memory[c.I] = hundreds
-		c.memory[c.I+1] = tens
-		c.memory[c.I+2] = ones
+		c.write(c.I, hundreds)
+		c.write(c.I+1, tens)
+		c.write(c.I+2, ones)
 	case 0x55:
 		for i, v := range c.V[0 : op.B01+1] {
-			c.memory[c.I+uint16(i)] = v
+			c.write(c.I+uint16(i), v)
+		}
+		if !c.quirks.LoadStore {
+			c.I = (c.I + uint16(op.B01) + 1) & 0x0fff
 		}
 	case 0x65:
 		for i := range c.V[0 : op.B01+1] {
-			c.V[i] = c.memory[c.I+uint16(i)]
+			c.V[i] = c.read(c.I + uint16(i))
+		}
+		if !c.quirks.LoadStore {
+			c.I = (c.I + uint16(op.B01) + 1) & 0x0fff
 		}
 	default:
 		c.notImplemented(op)
@@ -411,7 +614,12 @@ func (c *State) opF(op OpCode) {
 }
 
 func (c *State) RunOp(op OpCode) {
-	// DisassembleChip8Op(op)
+	pc := c.PC
+	if c.hooks != nil {
+		for _, h := range c.hooks.beforeInstruction {
+			h.OnBeforeInstruction(pc, op)
+		}
+	}
 
 	switch op.B00 {
 	case 0x00:
@@ -447,9 +655,15 @@ func (c *State) RunOp(op OpCode) {
 	case 0x0f:
 		c.opF(op)
 	}
+
+	if c.hooks != nil {
+		for _, h := range c.hooks.afterInstruction {
+			h.OnAfterInstruction(pc, op)
+		}
+	}
 }
 
 func (c *State) notImplemented(op OpCode) {
-	fmt.Printf("Opcode not implemented: %s\n", op)
-	c.halt = true
+	fmt.Fprintf(c.out, "Opcode not implemented: %s\n", op)
+	c.stop(HaltNotImplemented)
 }
@@ -23,6 +23,8 @@ func TestChip8State_Run_op0(t *testing.T) {
 		}
 
 		assert.Equal(t, byte(0), sum, "expected screen to be cleared")
+		// 00E0 used to advance PC twice, skipping the next instruction
+		assert.Equal(t, uint16(0x202), c8.PC, "expected PC to be set to 0x202")
 	})
 
 	t.Run("00EE", func(t *testing.T) {
@@ -54,15 +56,21 @@ func TestChip8State_Run_op1(t *testing.T) {
 	c8.RunOp(op)
 
 	assert.True(t, c8.halt, "expected the halt flag to be set because of infinite loop")
+
+	c8.Resume()
+	assert.False(t, c8.Halted(), "expected Resume to clear the halt flag")
 }
 
 func TestChip8State_Run_op2(t *testing.T) {
 	op := NewOpCode([2]byte{0x23, 0x00}) // Call subroutine at 0x300
 
 	c8 := Init(nil)
+	sp := c8.SP
 	c8.RunOp(op)
 
-	assert.Equal(t, uint16(0xf9e), c8.SP, "expected SP to be set to 0xf9e")
+	// The stack grows down from the initial SP of 0xefe, a return address
+	// at a time
+	assert.Equal(t, sp-2, c8.SP, "expected SP to be set to 0xefc")
 	assert.Equal(t, uint16(0x300), c8.PC, "expected PC to be set to 0x300")
 	assert.Equal(t, []byte{0x2, 0x00}, []byte{c8.memory[c8.SP], c8.memory[c8.SP+1]}, "expected address to be on stack")
 }
@@ -289,6 +297,18 @@ func TestChip8State_Run_op8(t *testing.T) {
 		assert.Equal(t, byte(0x16), c8.V[0x00], "expected V0 to contain 0x16")
 		assert.Equal(t, byte(0x01), c8.V[0x0f], "expected Vf to contain 0x01")
 	})
+
+	t.Run("8FY5", func(t *testing.T) {
+		op := NewOpCode([2]byte{0x8f, 0x15}) // Vf -= V1
+
+		c8 := Init(nil)
+		c8.V[0xf] = 0x05
+		c8.V[0x1] = 0x03
+
+		c8.RunOp(op)
+
+		assert.Equal(t, byte(0x02), c8.V[0x0f], "expected the result to overwrite the flag in Vf")
+	})
 }
 
 func TestChip8State_Run_op9(t *testing.T) {
@@ -335,6 +355,7 @@ func TestChip8State_Run_opC(t *testing.T) {
 	op := NewOpCode([2]byte{0xc0, 0x13}) // // VX = random(0,255) & 0x13
 
 	c8 := Init(nil)
+	c8.SetRandom(constRandom(0xfe))
 	c8.RunOp(op)
 
 	assert.Equal(t, byte(0x12), c8.V[0x0], "expected V0 to be set to 0x12")
@@ -0,0 +1,184 @@
+package chip8
+
+// Hooks let debuggers, profilers and other tools observe a program as it
+// runs. A hook is any value implementing one or more of the interfaces below,
+// attached to a State with AddHook. A State without hooks only pays for a nil
+// check per instruction and memory access.
+
+// BeforeInstructionHook is called before the instruction at pc is executed.
+type BeforeInstructionHook interface {
+	OnBeforeInstruction(pc uint16, op OpCode)
+}
+
+// AfterInstructionHook is called after the instruction at pc was executed.
+type AfterInstructionHook interface {
+	OnAfterInstruction(pc uint16, op OpCode)
+}
+
+// MemoryReadHook is called for every byte an instruction reads from memory.
+// Instruction fetches are not reported.
+type MemoryReadHook interface {
+	OnMemoryRead(addr uint16, value byte)
+}
+
+// MemoryWriteHook is called for every byte an instruction writes to memory,
+// including the display buffer. It is called before the byte is stored, so
+// Peek still returns the old value.
+type MemoryWriteHook interface {
+	OnMemoryWrite(addr uint16, value byte)
+}
+
+// DrawHook is called after DXYN drew a sprite of the given height at (x, y).
+type DrawHook interface {
+	OnDraw(x, y, height byte, collision bool)
+}
+
+// HaltHook is called when the program halts.
+type HaltHook interface {
+	OnHalt(pc uint16, reason HaltReason)
+}
+
+// TickHook is called at the end of Tick, after the timers were decremented.
+// Tick is called once a frame, so the hook marks where frames end.
+type TickHook interface {
+	OnTick()
+}
+
+// HaltReason describes why a program halted.
+type HaltReason int
+
+const (
+	// HaltEndOfMemory means PC ran past the end of memory.
+	HaltEndOfMemory HaltReason = iota
+	// HaltInfiniteLoop means the program jumped to the jump itself.
+	HaltInfiniteLoop
+	// HaltMachineCode means the program called an RCA 1802 routine (0NNN).
+	HaltMachineCode
+	// HaltNotImplemented means the opcode is unknown.
+	HaltNotImplemented
+)
+
+func (r HaltReason) String() string {
+	switch r {
+	case HaltEndOfMemory:
+		return "end of memory"
+	case HaltInfiniteLoop:
+		return "infinite loop"
+	case HaltMachineCode:
+		return "machine code call"
+	case HaltNotImplemented:
+		return "opcode not implemented"
+	}
+
+	return "unknown"
+}
+
+type hooks struct {
+	all []interface{}
+
+	beforeInstruction []BeforeInstructionHook
+	afterInstruction  []AfterInstructionHook
+	memoryRead        []MemoryReadHook
+	memoryWrite       []MemoryWriteHook
+	draw              []DrawHook
+	halt              []HaltHook
+	tick              []TickHook
+}
+
+// AddHook attaches h to the state. h must implement at least one of the hook
+// interfaces. Hooks must not be added or removed while RunProgram is running.
+func (c *State) AddHook(h interface{}) {
+	all := []interface{}{h}
+	if c.hooks != nil {
+		all = append(c.hooks.all, h)
+	}
+
+	c.setHooks(all)
+}
+
+// RemoveHook detaches h from the state.
+func (c *State) RemoveHook(h interface{}) {
+	if c.hooks == nil {
+		return
+	}
+
+	var all []interface{}
+	for _, hook := range c.hooks.all {
+		if hook != h {
+			all = append(all, hook)
+		}
+	}
+
+	c.setHooks(all)
+}
+
+func (c *State) setHooks(all []interface{}) {
+	if len(all) == 0 {
+		c.hooks = nil
+		return
+	}
+
+	hs := &hooks{all: all}
+	for _, h := range all {
+		if h, ok := h.(BeforeInstructionHook); ok {
+			hs.beforeInstruction = append(hs.beforeInstruction, h)
+		}
+		if h, ok := h.(AfterInstructionHook); ok {
+			hs.afterInstruction = append(hs.afterInstruction, h)
+		}
+		if h, ok := h.(MemoryReadHook); ok {
+			hs.memoryRead = append(hs.memoryRead, h)
+		}
+		if h, ok := h.(MemoryWriteHook); ok {
+			hs.memoryWrite = append(hs.memoryWrite, h)
+		}
+		if h, ok := h.(DrawHook); ok {
+			hs.draw = append(hs.draw, h)
+		}
+		if h, ok := h.(HaltHook); ok {
+			hs.halt = append(hs.halt, h)
+		}
+		if h, ok := h.(TickHook); ok {
+			hs.tick = append(hs.tick, h)
+		}
+	}
+
+	c.hooks = hs
+}
+
+// read returns the byte at addr, reporting the access to the hooks. Like
+// Peek, it wraps addr around the 4KB of memory.
+func (c *State) read(addr uint16) byte {
+	addr &= 0xfff
+	value := c.memory[addr]
+	if c.hooks != nil {
+		for _, h := range c.hooks.memoryRead {
+			h.OnMemoryRead(addr, value)
+		}
+	}
+
+	return value
+}
+
+// write stores value at addr, reporting the access to the hooks. Like
+// Poke, it wraps addr around the 4KB of memory.
+func (c *State) write(addr uint16, value byte) {
+	addr &= 0xfff
+	if c.hooks != nil {
+		for _, h := range c.hooks.memoryWrite {
+			h.OnMemoryWrite(addr, value)
+		}
+	}
+
+	c.memory[addr] = value
+}
+
+// stop halts the program.
+func (c *State) stop(reason HaltReason) {
+	c.halt = true
+	if c.hooks != nil {
+		for _, h := range c.hooks.halt {
+			h.OnHalt(c.PC, reason)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package chip8
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingHook struct {
+	events []string
+}
+
+func (h *recordingHook) OnBeforeInstruction(pc uint16, op OpCode) {
+	h.events = append(h.events, fmt.Sprintf("before 0x%03x %s", pc, op))
+}
+
+func (h *recordingHook) OnAfterInstruction(pc uint16, op OpCode) {
+	h.events = append(h.events, fmt.Sprintf("after 0x%03x %s", pc, op))
+}
+
+func (h *recordingHook) OnMemoryRead(addr uint16, value byte) {
+	h.events = append(h.events, fmt.Sprintf("read 0x%03x 0x%02x", addr, value))
+}
+
+func (h *recordingHook) OnMemoryWrite(addr uint16, value byte) {
+	h.events = append(h.events, fmt.Sprintf("write 0x%03x 0x%02x", addr, value))
+}
+
+func (h *recordingHook) OnDraw(x, y, height byte, collision bool) {
+	h.events = append(h.events, fmt.Sprintf("draw %d %d %d %t", x, y, height, collision))
+}
+
+func (h *recordingHook) OnHalt(pc uint16, reason HaltReason) {
+	h.events = append(h.events, fmt.Sprintf("halt 0x%03x %s", pc, reason))
+}
+
+func (h *recordingHook) OnTick() {
+	h.events = append(h.events, "tick")
+}
+
+type haltHook struct {
+	halts int
+}
+
+func (h *haltHook) OnHalt(pc uint16, reason HaltReason) {
+	h.halts++
+}
+
+func TestState_AddHook(t *testing.T) {
+	c8 := Init(nil)
+	c8.LoadProgram([]byte{
+		0x23, 0x00, // Call 0x300
+	})
+	c8.memory[0x300] = 0x00 // Return
+	c8.memory[0x301] = 0xee
+
+	h := &recordingHook{}
+	c8.AddHook(h)
+
+	c8.Step()
+	c8.Step()
+
+	assert.Equal(t, []string{
+		"before 0x200 0x2300",
+		"write 0xefc 0x02",
+		"write 0xefd 0x00",
+		"after 0x200 0x2300",
+		"before 0x300 0x00ee",
+		"read 0xefc 0x02",
+		"read 0xefd 0x00",
+		"after 0x300 0x00ee",
+	}, h.events)
+}
+
+func TestState_AddHook_tick(t *testing.T) {
+	c8 := Init(nil)
+	c8.LoadProgram([]byte{
+		0x60, 0x02, // V0 = 0x02
+		0xf0, 0x15, // Set delay timer to V0
+	})
+
+	h := &recordingHook{}
+	c8.AddHook(h)
+
+	c8.Step()
+	c8.Tick()
+	c8.Step()
+	c8.Tick()
+
+	assert.Equal(t, []string{
+		"before 0x200 0x6002",
+		"after 0x200 0x6002",
+		"tick",
+		"before 0x202 0xf015",
+		"after 0x202 0xf015",
+		"tick",
+	}, h.events)
+	assert.Equal(t, byte(1), c8.DelayTimer(), "expected the timer to be decremented before the hook")
+}
+
+func TestState_AddHook_draw(t *testing.T) {
+	c8 := Init(nil)
+	c8.LoadProgram([]byte{
+		0x61, 0x02, // V1 = 0x02
+		0xd1, 0x11, // Draw 8x1 sprite at (V1, V1)
+		0xd1, 0x11, // Draw 8x1 sprite at (V1, V1)
+		0x12, 0x06, // Jump to 0x206
+	})
+	c8.I = 0x300
+	c8.memory[0x300] = 0x80
+
+	h := &recordingHook{}
+	c8.AddHook(h)
+
+	for !c8.Halted() {
+		c8.Step()
+	}
+
+	assert.Contains(t, h.events, "draw 2 2 1 false")
+	assert.Contains(t, h.events, "write 0xf10 0x20")
+	assert.Contains(t, h.events, "draw 2 2 1 true")
+	assert.Contains(t, h.events, "write 0xf10 0x00")
+	assert.Equal(t, "halt 0x206 infinite loop", h.events[len(h.events)-2])
+}
+
+func TestState_RemoveHook(t *testing.T) {
+	c8 := Init(nil)
+
+	a, b := &haltHook{}, &haltHook{}
+	c8.AddHook(a)
+	c8.AddHook(b)
+	c8.RemoveHook(a)
+
+	c8.RunOp(NewOpCode([2]byte{0xff, 0xff}))
+
+	assert.Equal(t, 0, a.halts)
+	assert.Equal(t, 1, b.halts)
+
+	c8.RemoveHook(b)
+	assert.Nil(t, c8.hooks, "expected no hooks to be left")
+}
+
+func TestState_Step_wrapsMemory(t *testing.T) {
+	t.Run("00EE", func(t *testing.T) {
+		c8 := Init(nil)
+		c8.LoadProgram([]byte{
+			0x00, 0xee, // Return from a subroutine
+		})
+		c8.SP = 0xfff
+		// Return address 0x300, its low byte wrapped around to 0x000
+		c8.memory[0xfff] = 0x03
+		c8.memory[0x000] = 0x00
+
+		h := &recordingHook{}
+		c8.AddHook(h)
+		c8.Step()
+
+		assert.Equal(t, uint16(0x302), c8.PC)
+		assert.Contains(t, h.events, "read 0x000 0x00")
+	})
+
+	t.Run("DXYN", func(t *testing.T) {
+		c8 := Init(nil)
+		c8.LoadProgram([]byte{
+			0xd0, 0x02, // Draw 8x2 sprite at (V0, V0)
+		})
+		c8.I = 0xfff
+		// The second row wraps around to 0x000
+		c8.memory[0xfff] = 0x80
+		c8.memory[0x000] = 0xf0
+
+		h := &recordingHook{}
+		c8.AddHook(h)
+		c8.Step()
+
+		assert.True(t, c8.Pixel(0, 0))
+		assert.True(t, c8.Pixel(3, 1))
+		assert.Contains(t, h.events, "read 0x000 0xf0")
+	})
+
+	t.Run("FX55", func(t *testing.T) {
+		c8 := Init(nil)
+		c8.SetQuirks(Quirks{})
+		c8.LoadProgram([]byte{
+			0xf1, 0x55, // Store V0:V1 at I
+		})
+		c8.I = 0xfff
+		c8.V[0] = 0x12
+		c8.V[1] = 0x34
+
+		h := &recordingHook{}
+		c8.AddHook(h)
+		c8.Step()
+
+		assert.Equal(t, byte(0x12), c8.Peek(0xfff))
+		assert.Equal(t, byte(0x34), c8.Peek(0x000))
+		assert.Contains(t, h.events, "write 0x000 0x34")
+		// Without the load/store quirk I moves past the stored registers
+		assert.Equal(t, uint16(0x001), c8.I)
+	})
+}
+
+var benchmarkProgram = []byte{
+	0x60, 0x22, // V0 = 0x22
+	0x70, 0x01, // V0 += 0x01
+	0x80, 0x14, // V0 += V1
+	0xa3, 0x00, // I = 0x300
+	0xf0, 0x33, // Store BCD of V0 at I
+	0xf2, 0x65, // Load V0:V2 from I
+	0xd0, 0x15, // Draw 8x5 sprite at (V0, V1)
+	0x12, 0x00, // Jump to 0x200
+}
+
+type nopHook struct{}
+
+func (nopHook) OnBeforeInstruction(pc uint16, op OpCode) {}
+func (nopHook) OnAfterInstruction(pc uint16, op OpCode)  {}
+func (nopHook) OnMemoryRead(addr uint16, value byte)     {}
+func (nopHook) OnMemoryWrite(addr uint16, value byte)    {}
+
+func benchmarkStep(b *testing.B, hook interface{}) {
+	c8 := Init(nil)
+	c8.LoadProgram(benchmarkProgram)
+	if hook != nil {
+		c8.AddHook(hook)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c8.Step()
+	}
+}
+
+// Compare the two to see the cost of a hook on every instruction and memory
+// access. TestState_Step_noHooksAllocs checks that Step without hooks does
+// not allocate.
+func BenchmarkState_Step(b *testing.B) {
+	b.Run("no hooks", func(b *testing.B) {
+		benchmarkStep(b, nil)
+	})
+
+	b.Run("nop hook", func(b *testing.B) {
+		benchmarkStep(b, nopHook{})
+	})
+}
+
+func TestState_Step_noHooksAllocs(t *testing.T) {
+	c8 := Init(nil)
+	c8.LoadProgram(benchmarkProgram)
+
+	allocs := testing.AllocsPerRun(1000, c8.Step)
+
+	assert.Equal(t, 0.0, allocs, "expected Step without hooks not to allocate")
+}
@@ -0,0 +1,377 @@
+package chip8
+
+import "fmt"
+
+// OperandKind is the kind of an instruction operand.
+type OperandKind int
+
+const (
+	// OperandRegister is one of V0-VF; the value is the register index.
+	OperandRegister OperandKind = iota
+	// OperandAddress is a 12-bit memory address.
+	OperandAddress
+	// OperandImmediate is a byte constant.
+	OperandImmediate
+	// OperandNibble is a 4-bit constant, such as the height of a sprite.
+	OperandNibble
+	// OperandI is the I register.
+	OperandI
+	// OperandIndirect is the memory at I, written [I].
+	OperandIndirect
+	// OperandDelayTimer is the delay timer, DT.
+	OperandDelayTimer
+	// OperandSoundTimer is the sound timer, ST.
+	OperandSoundTimer
+	// OperandKey is a key press, K.
+	OperandKey
+	// OperandFont is the font sprite of a digit, F.
+	OperandFont
+	// OperandBCD is the binary coded decimal representation, B.
+	OperandBCD
+	// OperandWord is a 16-bit constant, the operand of DW.
+	OperandWord
+)
+
+var operandKindNames = []string{
+	OperandRegister:   "register",
+	OperandAddress:    "address",
+	OperandImmediate:  "immediate",
+	OperandNibble:     "nibble",
+	OperandI:          "i",
+	OperandIndirect:   "indirect",
+	OperandDelayTimer: "delay-timer",
+	OperandSoundTimer: "sound-timer",
+	OperandKey:        "key",
+	OperandFont:       "font",
+	OperandBCD:        "bcd",
+	OperandWord:       "word",
+}
+
+func (k OperandKind) String() string {
+	if int(k) < len(operandKindNames) {
+		return operandKindNames[k]
+	}
+
+	return fmt.Sprintf("OperandKind(%d)", int(k))
+}
+
+func (k OperandKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+type Operand struct {
+	Kind  OperandKind `json:"kind"`
+	Value uint16      `json:"value"`
+}
+
+// Flow is the effect of an instruction on control flow.
+type Flow int
+
+const (
+	// FlowNext continues with the next instruction.
+	FlowNext Flow = iota
+	// FlowJump jumps to Target.
+	FlowJump
+	// FlowIndirectJump jumps to Target plus V0.
+	FlowIndirectJump
+	// FlowCall calls the subroutine at Target.
+	FlowCall
+	// FlowReturn returns from a subroutine.
+	FlowReturn
+	// FlowSkip conditionally skips the next instruction.
+	FlowSkip
+	// FlowHalt stops the interpreter, as unknown opcodes and machine code
+	// calls do.
+	FlowHalt
+)
+
+var flowNames = []string{
+	FlowNext:         "next",
+	FlowJump:         "jump",
+	FlowIndirectJump: "indirect-jump",
+	FlowCall:         "call",
+	FlowReturn:       "return",
+	FlowSkip:         "skip",
+	FlowHalt:         "halt",
+}
+
+func (f Flow) String() string {
+	if int(f) < len(flowNames) {
+		return flowNames[f]
+	}
+
+	return fmt.Sprintf("Flow(%d)", int(f))
+}
+
+func (f Flow) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// Instruction is a decoded opcode.
+type Instruction struct {
+	Op OpCode
+
+	// Mnemonic in classic assembler syntax, such as LD or DRW. Opcodes
+	// which are not instructions have the mnemonic DW.
+	Mnemonic string
+	Operands []Operand
+
+	Description string
+
+	Flow Flow
+	// Target of jumps and calls
+	Target uint16
+}
+
+func register(i byte) Operand {
+	return Operand{Kind: OperandRegister, Value: uint16(i)}
+}
+
+func address(addr uint16) Operand {
+	return Operand{Kind: OperandAddress, Value: addr}
+}
+
+func immediate(b byte) Operand {
+	return Operand{Kind: OperandImmediate, Value: uint16(b)}
+}
+
+func operand(kind OperandKind) Operand {
+	return Operand{Kind: kind}
+}
+
+// Disassemble decodes op.
+func Disassemble(op OpCode) Instruction {
+	ins := decode(op)
+	ins.Op = op
+
+	switch ins.Flow {
+	case FlowJump, FlowIndirectJump, FlowCall:
+		ins.Target = op.Addr()
+	}
+
+	return ins
+}
+
+func decode(op OpCode) Instruction {
+	x, y := op.B01, op.B10
+	vx, vy := register(x), register(y)
+
+	switch op.B00 {
+	case 0x00:
+		switch {
+		case op.B0 == 0x00 && op.B1 == 0xe0:
+			return Instruction{Mnemonic: "CLS", Description: "Clear the screen"}
+		case op.B0 == 0x00 && op.B1 == 0xee:
+			return Instruction{Mnemonic: "RET", Description: "Return from a subroutine", Flow: FlowReturn}
+		case op.B0 != 0x00:
+			return Instruction{
+				Mnemonic:    "SYS",
+				Operands:    []Operand{address(op.Addr())},
+				Description: fmt.Sprintf("Call RCA 1802 program at 0x%03x (not implemented)", op.Addr()),
+				Flow:        FlowHalt,
+			}
+		}
+	case 0x01:
+		return Instruction{
+			Mnemonic:    "JP",
+			Operands:    []Operand{address(op.Addr())},
+			Description: fmt.Sprintf("Jump to address 0x%03x", op.Addr()),
+			Flow:        FlowJump,
+		}
+	case 0x02:
+		return Instruction{
+			Mnemonic:    "CALL",
+			Operands:    []Operand{address(op.Addr())},
+			Description: fmt.Sprintf("Call subroutine at 0x%03x", op.Addr()),
+			Flow:        FlowCall,
+		}
+	case 0x03:
+		return Instruction{
+			Mnemonic:    "SE",
+			Operands:    []Operand{vx, immediate(op.B1)},
+			Description: fmt.Sprintf("Skip next instruction if V%1x == 0x%02x", x, op.B1),
+			Flow:        FlowSkip,
+		}
+	case 0x04:
+		return Instruction{
+			Mnemonic:    "SNE",
+			Operands:    []Operand{vx, immediate(op.B1)},
+			Description: fmt.Sprintf("Skip next instruction if V%1x != 0x%02x", x, op.B1),
+			Flow:        FlowSkip,
+		}
+	case 0x05:
+		if op.B11 == 0x0 {
+			return Instruction{
+				Mnemonic:    "SE",
+				Operands:    []Operand{vx, vy},
+				Description: fmt.Sprintf("Skip next instruction if V%1x == V%1x", x, y),
+				Flow:        FlowSkip,
+			}
+		}
+	case 0x06:
+		return Instruction{
+			Mnemonic:    "LD",
+			Operands:    []Operand{vx, immediate(op.B1)},
+			Description: fmt.Sprintf("V%1x = 0x%02x", x, op.B1),
+		}
+	case 0x07:
+		return Instruction{
+			Mnemonic:    "ADD",
+			Operands:    []Operand{vx, immediate(op.B1)},
+			Description: fmt.Sprintf("V%1x += 0x%02x", x, op.B1),
+		}
+	case 0x08:
+		ins := Instruction{Operands: []Operand{vx, vy}}
+		switch op.B11 {
+		case 0x00:
+			ins.Mnemonic = "LD"
+			ins.Description = fmt.Sprintf("V%1x = V%1x", x, y)
+		case 0x01:
+			ins.Mnemonic = "OR"
+			ins.Description = fmt.Sprintf("V%1x = V%1x | V%1x", x, x, y)
+		case 0x02:
+			ins.Mnemonic = "AND"
+			ins.Description = fmt.Sprintf("V%1x = V%1x & V%1x", x, x, y)
+		case 0x03:
+			ins.Mnemonic = "XOR"
+			ins.Description = fmt.Sprintf("V%1x = V%1x ^ V%1x", x, x, y)
+		case 0x04:
+			ins.Mnemonic = "ADD"
+			ins.Description = fmt.Sprintf("V%1x += V%1x. VF is set to 1 when there's a carry, and to 0 when there isn't", x, y)
+		case 0x05:
+			ins.Mnemonic = "SUB"
+			ins.Description = fmt.Sprintf("V%1x -= V%1x. VF is set to 0 when there's a borrow, and 1 when there isn't", x, y)
+		case 0x06:
+			ins.Mnemonic = "SHR"
+			ins.Description = fmt.Sprintf("Stores the least significant bit of V%1x in VF and then shifts V%1x to the right by 1", x, x)
+		case 0x07:
+			ins.Mnemonic = "SUBN"
+			ins.Description = fmt.Sprintf("V%1x=V%1x-V%1x. VF is set to 0 when there's a borrow, and 1 when there isn't", x, y, x)
+		case 0x0e:
+			ins.Mnemonic = "SHL"
+			ins.Description = fmt.Sprintf("Stores the most significant bit of V%1x in VF and then shifts V%1x to the left by 1", x, x)
+		default:
+			return unknown(op)
+		}
+		return ins
+	case 0x09:
+		if op.B11 == 0x0 {
+			return Instruction{
+				Mnemonic:    "SNE",
+				Operands:    []Operand{vx, vy},
+				Description: fmt.Sprintf("Skip next instruction if V%1x != V%1x", x, y),
+				Flow:        FlowSkip,
+			}
+		}
+	case 0x0a:
+		return Instruction{
+			Mnemonic:    "LD",
+			Operands:    []Operand{operand(OperandI), address(op.Addr())},
+			Description: fmt.Sprintf("I = 0x%03x", op.Addr()),
+		}
+	case 0x0b:
+		return Instruction{
+			Mnemonic:    "JP",
+			Operands:    []Operand{register(0), address(op.Addr())},
+			Description: fmt.Sprintf("PC = V0 + 0x%03x", op.Addr()),
+			Flow:        FlowIndirectJump,
+		}
+	case 0x0c:
+		return Instruction{
+			Mnemonic:    "RND",
+			Operands:    []Operand{vx, immediate(op.B1)},
+			Description: fmt.Sprintf("V%1x=random(0,255) & 0x%02x", x, op.B1),
+		}
+	case 0x0d:
+		return Instruction{
+			Mnemonic:    "DRW",
+			Operands:    []Operand{vx, vy, {Kind: OperandNibble, Value: uint16(op.B11)}},
+			Description: fmt.Sprintf("Draw 8x%1x sprite at (V%1x, V%1x)", op.B11, x, y),
+		}
+	case 0x0e:
+		switch op.B1 {
+		case 0x9e:
+			return Instruction{
+				Mnemonic:    "SKP",
+				Operands:    []Operand{vx},
+				Description: fmt.Sprintf("Skip next instruction if key stored in V%1x is pressed", x),
+				Flow:        FlowSkip,
+			}
+		case 0xa1:
+			return Instruction{
+				Mnemonic:    "SKNP",
+				Operands:    []Operand{vx},
+				Description: fmt.Sprintf("Skip next instruction if key stored in V%1x is not pressed", x),
+				Flow:        FlowSkip,
+			}
+		}
+	case 0x0f:
+		switch op.B1 {
+		case 0x07:
+			return Instruction{
+				Mnemonic:    "LD",
+				Operands:    []Operand{vx, operand(OperandDelayTimer)},
+				Description: fmt.Sprintf("Set V%1x to the value of delay timer", x),
+			}
+		case 0x0a:
+			return Instruction{
+				Mnemonic:    "LD",
+				Operands:    []Operand{vx, operand(OperandKey)},
+				Description: fmt.Sprintf("A key press is awaited, and then stored in V%1x. (Blocking Operation. All instruction halted until next key event)", x),
+			}
+		case 0x15:
+			return Instruction{
+				Mnemonic:    "LD",
+				Operands:    []Operand{operand(OperandDelayTimer), vx},
+				Description: fmt.Sprintf("Set delay timer to V%1x", x),
+			}
+		case 0x18:
+			return Instruction{
+				Mnemonic:    "LD",
+				Operands:    []Operand{operand(OperandSoundTimer), vx},
+				Description: fmt.Sprintf("Set sound timer to V%1x", x),
+			}
+		case 0x1e:
+			return Instruction{
+				Mnemonic:    "ADD",
+				Operands:    []Operand{operand(OperandI), vx},
+				Description: fmt.Sprintf("Adds V%1x to I. VF is set to 1 when there is a range overflow (I+V%1x>0xFFF), and to 0 when there isn't", x, x),
+			}
+		case 0x29:
+			return Instruction{
+				Mnemonic:    "LD",
+				Operands:    []Operand{operand(OperandFont), vx},
+				Description: fmt.Sprintf("Sets I to the location of the sprite for the character in V%1x. Characters 0-F (in hexadecimal) are represented by a 4x5 font", x),
+			}
+		case 0x33:
+			return Instruction{
+				Mnemonic:    "LD",
+				Operands:    []Operand{operand(OperandBCD), vx},
+				Description: fmt.Sprintf("Take the decimal representation of V%1x, place the hundreds digit in memory at location in I, the tens digit at location I+1, and the ones digit at location I+2", x),
+			}
+		case 0x55:
+			return Instruction{
+				Mnemonic:    "LD",
+				Operands:    []Operand{operand(OperandIndirect), vx},
+				Description: fmt.Sprintf("Stores V0 to (including) V%1x in memory starting at address I. I is left unmodified with the LoadStore quirk, and set to the address after the last byte written otherwise", x),
+			}
+		case 0x65:
+			return Instruction{
+				Mnemonic:    "LD",
+				Operands:    []Operand{vx, operand(OperandIndirect)},
+				Description: fmt.Sprintf("Fills V0 to (including) V%1x with values from memory starting at address I. I is left unmodified with the LoadStore quirk, and set to the address after the last byte read otherwise", x),
+			}
+		}
+	}
+
+	return unknown(op)
+}
+
+func unknown(op OpCode) Instruction {
+	return Instruction{
+		Mnemonic:    "DW",
+		Operands:    []Operand{{Kind: OperandWord, Value: uint16(op.B0)<<8 | uint16(op.B1)}},
+		Description: fmt.Sprintf("%02x%02x not implemented", op.B0, op.B1),
+		Flow:        FlowHalt,
+	}
+}
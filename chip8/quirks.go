@@ -0,0 +1,64 @@
+package chip8
+
+import (
+	"image/color"
+	"time"
+)
+
+// Quirks select between the behaviours of CHIP-8 interpreters where they
+// differ. Programs written for one interpreter often misbehave on another, so
+// they are set to match the interpreter a program was written for.
+type Quirks struct {
+	// 8XY6 and 8XYE shift VX in place instead of storing VY shifted into VX
+	Shift bool
+	// FX55 and FX65 leave I unchanged instead of incrementing it past the
+	// last register
+	LoadStore bool
+	// BNNN jumps to NNN plus VX, where X is the high nibble of NNN, instead of
+	// NNN plus V0
+	Jump bool
+	// 8XY1, 8XY2 and 8XY3 clear VF
+	Logic bool
+	// Sprites are clipped at the edges of the screen instead of wrapping
+	// around
+	Clip bool
+	// DXYN waits for the next frame when running in real time
+	VBlank bool
+	// 8XY4 to 8XYE write VF before the result, so the result wins when VF is
+	// the destination
+	VFOrder bool
+}
+
+// DefaultQuirks are the quirks a State starts with, which keep the
+// behaviour of this emulator from before quirks could be selected.
+var DefaultQuirks = Quirks{Shift: true, LoadStore: true, Clip: true, VBlank: true, VFOrder: true}
+
+// SetQuirks sets the quirks the program runs with.
+func (c *State) SetQuirks(q Quirks) {
+	c.quirks = q
+}
+
+// Quirks returns the quirks the program runs with.
+func (c *State) Quirks() Quirks {
+	return c.quirks
+}
+
+// SetClockRate sets the number of instructions RunProgram executes per
+// second. Call it before RunProgram.
+func (c *State) SetClockRate(hz int) {
+	c.clockRate = hz
+	c.clock.Stop()
+	c.clock = time.NewTicker(time.Second / time.Duration(hz))
+}
+
+// ClockRate returns the number of instructions RunProgram executes per
+// second.
+func (c *State) ClockRate() int {
+	return c.clockRate
+}
+
+// SetColors sets the colours GetFrame draws unset and set pixels with.
+func (c *State) SetColors(background, foreground color.Color) {
+	c.background = background
+	c.foreground = foreground
+}
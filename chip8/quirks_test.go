@@ -0,0 +1,126 @@
+package chip8
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuirks(t *testing.T) {
+	run := func(q Quirks, setup func(c8 *State), ops ...byte) *State {
+		c8 := Init(nil)
+		c8.SetQuirks(q)
+		setup(&c8)
+		for i := 0; i < len(ops); i += 2 {
+			c8.RunOp(NewOpCode([2]byte{ops[i], ops[i+1]}))
+		}
+		return &c8
+	}
+
+	t.Run("Shift", func(t *testing.T) {
+		setup := func(c8 *State) { c8.V[0], c8.V[1] = 0x04, 0x81 }
+
+		c8 := run(Quirks{Shift: true}, setup, 0x80, 0x16) // V0 >>= 1
+		assert.Equal(t, byte(0x02), c8.V[0])
+		assert.Equal(t, byte(0), c8.V[0xf])
+
+		c8 = run(Quirks{}, setup, 0x80, 0x16) // V0 = V1 >> 1
+		assert.Equal(t, byte(0x40), c8.V[0])
+		assert.Equal(t, byte(1), c8.V[0xf])
+
+		c8 = run(Quirks{}, setup, 0x80, 0x1e) // V0 = V1 << 1
+		assert.Equal(t, byte(0x02), c8.V[0])
+		assert.Equal(t, byte(1), c8.V[0xf])
+	})
+
+	t.Run("LoadStore", func(t *testing.T) {
+		setup := func(c8 *State) { c8.I = 0x300 }
+
+		c8 := run(Quirks{LoadStore: true}, setup, 0xf2, 0x55)
+		assert.Equal(t, uint16(0x300), c8.I)
+
+		c8 = run(Quirks{}, setup, 0xf2, 0x55, 0xf1, 0x65)
+		assert.Equal(t, uint16(0x305), c8.I)
+	})
+
+	t.Run("Jump", func(t *testing.T) {
+		setup := func(c8 *State) { c8.V[0], c8.V[3] = 0x01, 0x10 }
+
+		c8 := run(Quirks{}, setup, 0xb3, 0x00)
+		assert.Equal(t, uint16(0x301), c8.PC)
+
+		c8 = run(Quirks{Jump: true}, setup, 0xb3, 0x00)
+		assert.Equal(t, uint16(0x310), c8.PC)
+	})
+
+	t.Run("Logic", func(t *testing.T) {
+		setup := func(c8 *State) { c8.V[0xf] = 1 }
+
+		c8 := run(Quirks{}, setup, 0x80, 0x11)
+		assert.Equal(t, byte(1), c8.V[0xf])
+
+		c8 = run(Quirks{Logic: true}, setup, 0x80, 0x11)
+		assert.Equal(t, byte(0), c8.V[0xf])
+	})
+
+	t.Run("Clip", func(t *testing.T) {
+		// Draw 0xff at (60, 31), two rows high
+		setup := func(c8 *State) {
+			c8.V[0], c8.V[1] = 60, 31
+			c8.I = 0x300
+			c8.memory[0x300], c8.memory[0x301] = 0xff, 0xff
+		}
+
+		c8 := run(Quirks{Clip: true}, setup, 0xd0, 0x12)
+		assert.True(t, c8.Pixel(63, 31))
+		assert.False(t, c8.Pixel(0, 31))
+		assert.False(t, c8.Pixel(60, 0))
+
+		c8 = run(Quirks{}, setup, 0xd0, 0x12)
+		assert.True(t, c8.Pixel(63, 31))
+		assert.True(t, c8.Pixel(3, 31))
+		assert.True(t, c8.Pixel(60, 0))
+		assert.True(t, c8.Pixel(3, 0))
+		assert.False(t, c8.Pixel(4, 0))
+
+		// The start position always wraps without clipping
+		setup = func(c8 *State) {
+			c8.V[0], c8.V[1] = 64+8, 32+2
+			c8.I = 0x300
+			c8.memory[0x300] = 0x80
+		}
+		c8 = run(Quirks{}, setup, 0xd0, 0x11)
+		assert.True(t, c8.Pixel(8, 2))
+	})
+
+	t.Run("VFOrder", func(t *testing.T) {
+		setup := func(c8 *State) { c8.V[0xf], c8.V[1] = 0x05, 0x03 }
+
+		c8 := run(Quirks{}, setup, 0x8f, 0x15)
+		assert.Equal(t, byte(0x01), c8.V[0xf])
+
+		c8 = run(Quirks{VFOrder: true}, setup, 0x8f, 0x15)
+		assert.Equal(t, byte(0x02), c8.V[0xf])
+	})
+}
+
+func TestState_SetClockRate(t *testing.T) {
+	c8 := Init(nil)
+	assert.Equal(t, ClockRate, c8.ClockRate())
+
+	c8.SetClockRate(1200)
+	assert.Equal(t, 1200, c8.ClockRate())
+}
+
+func TestState_SetColors(t *testing.T) {
+	c8 := Init(nil)
+	c8.memory[screenAddress] = 0x80
+
+	bg, fg := color.RGBA{0x99, 0x66, 0x00, 0xff}, color.RGBA{0xff, 0xcc, 0x00, 0xff}
+	c8.SetColors(bg, fg)
+
+	frame := c8.GetFrame(64, 32)
+	assert.Equal(t, fg, frame.At(0, 0))
+	assert.Equal(t, bg, frame.At(1, 0))
+}
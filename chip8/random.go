@@ -0,0 +1,85 @@
+package chip8
+
+import (
+	crand "crypto/rand"
+	"math/rand"
+)
+
+// Random is the source of the random bytes used by CXNN.
+type Random interface {
+	Byte() byte
+}
+
+// frameTicker is implemented by random sources that advance on every 60Hz
+// timer tick, in addition to every CXNN.
+type frameTicker interface {
+	Tick()
+}
+
+type seededRandom struct {
+	r *rand.Rand
+}
+
+// NewSeededRandom returns a pseudo random source. Sources created with the
+// same seed produce the same sequence of bytes.
+func NewSeededRandom(seed int64) Random {
+	return &seededRandom{r: rand.New(rand.NewSource(seed))}
+}
+
+func (r *seededRandom) Byte() byte {
+	return byte(r.r.Intn(0x100))
+}
+
+type cryptoRandom struct{}
+
+// CryptoRandom reads its bytes from crypto/rand.
+var CryptoRandom Random = cryptoRandom{}
+
+func (cryptoRandom) Byte() byte {
+	var b [1]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic(err)
+	}
+
+	return b[0]
+}
+
+// VIPRandom emulates the random number routine of the original COSMAC VIP
+// CHIP-8 interpreter.
+//
+// The interpreter keeps a 16-bit seed in register R9. Its low byte is
+// incremented by the interrupt routine on every frame and once more by every
+// CXNN. The low byte then indexes a byte of the interpreter's own code page
+// (0x0100-0x01ff), which is added to the high byte. The new high byte is the
+// random value.
+type VIPRandom struct {
+	seed uint16
+	page [256]byte
+}
+
+// NewVIPRandom returns a VIPRandom with the given seed, reading from page,
+// which should hold the 256 bytes found at 0x0100 in the VIP interpreter.
+// Shorter pages are repeated to fill 256 bytes.
+func NewVIPRandom(page []byte, seed uint16) *VIPRandom {
+	r := &VIPRandom{seed: seed}
+	for i := 0; len(page) > 0 && i < len(r.page); i++ {
+		r.page[i] = page[i%len(page)]
+	}
+
+	return r
+}
+
+func (r *VIPRandom) Byte() byte {
+	r.Tick()
+
+	lo := byte(r.seed)
+	hi := byte(r.seed>>8) + r.page[lo]
+	r.seed = uint16(hi)<<8 | uint16(lo)
+
+	return hi
+}
+
+// Tick increments the low byte of the seed, like the VIP interrupt routine.
+func (r *VIPRandom) Tick() {
+	r.seed = r.seed&0xff00 | uint16(byte(r.seed)+1)
+}
@@ -0,0 +1,61 @@
+package chip8
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type constRandom byte
+
+func (r constRandom) Byte() byte {
+	return byte(r)
+}
+
+func TestNewSeededRandom(t *testing.T) {
+	a := NewSeededRandom(42)
+	b := NewSeededRandom(42)
+
+	seen := make(map[byte]bool)
+	for i := 0; i < 4096; i++ {
+		v := a.Byte()
+		assert.Equal(t, v, b.Byte(), "expected equal seeds to produce equal sequences")
+		seen[v] = true
+	}
+
+	assert.True(t, seen[0xff], "expected 0xff to be a possible value")
+}
+
+func TestVIPRandom(t *testing.T) {
+	page := make([]byte, 256)
+	for i := range page {
+		page[i] = byte(i)
+	}
+
+	r := NewVIPRandom(page, 0x1000)
+
+	// Low byte becomes 0x01, high byte 0x10 + page[0x01]
+	assert.Equal(t, byte(0x11), r.Byte())
+	// Low byte becomes 0x02, high byte 0x11 + page[0x02]
+	assert.Equal(t, byte(0x13), r.Byte())
+
+	r.Tick()
+	// Low byte becomes 0x04 after the tick, high byte 0x13 + page[0x04]
+	assert.Equal(t, byte(0x17), r.Byte())
+}
+
+func TestVIPRandom_tick(t *testing.T) {
+	c8 := Init(nil)
+	c8.LoadProgram([]byte{
+		0xc0, 0xff, // 0x200: V0 = random & 0xff
+		0xc1, 0xff, // 0x202: V1 = random & 0xff
+	})
+	c8.SetRandom(NewVIPRandom([]byte{0, 1, 2, 3}, 0x1000))
+
+	// The timer tick advances the seed between the two
+	c8.Step()
+	c8.Tick()
+	c8.Step()
+	assert.Equal(t, byte(0x11), c8.V[0])
+	assert.Equal(t, byte(0x14), c8.V[1])
+}
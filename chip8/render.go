@@ -0,0 +1,232 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Syntax is an assembler syntax instructions can be formatted in.
+type Syntax int
+
+const (
+	// SyntaxClassic is the classic CHIP-8 assembler syntax, as in
+	// "LD V0, 0x22".
+	SyntaxClassic Syntax = iota
+	// SyntaxOcto is the syntax of the Octo language, as in "v0 := 0x22".
+	SyntaxOcto
+)
+
+// Format returns ins in the given syntax. Addresses with an entry in labels
+// are replaced by the label; labels may be nil.
+func (ins Instruction) Format(syntax Syntax, labels map[uint16]string) string {
+	if syntax == SyntaxOcto {
+		return ins.formatOcto(labels)
+	}
+
+	operands := make([]string, len(ins.Operands))
+	for i, o := range ins.Operands {
+		operands[i] = o.format(syntax, labels)
+	}
+
+	if len(operands) == 0 {
+		return ins.Mnemonic
+	}
+
+	return ins.Mnemonic + " " + strings.Join(operands, ", ")
+}
+
+func (o Operand) format(syntax Syntax, labels map[uint16]string) string {
+	switch o.Kind {
+	case OperandRegister:
+		if syntax == SyntaxOcto {
+			return fmt.Sprintf("v%x", o.Value)
+		}
+		return fmt.Sprintf("V%X", o.Value)
+	case OperandAddress:
+		if label, ok := labels[o.Value]; ok {
+			return label
+		}
+		return fmt.Sprintf("0x%03x", o.Value)
+	case OperandImmediate:
+		return fmt.Sprintf("0x%02x", o.Value)
+	case OperandNibble:
+		return fmt.Sprintf("%d", o.Value)
+	case OperandWord:
+		return fmt.Sprintf("0x%04x", o.Value)
+	}
+
+	return map[OperandKind]string{
+		OperandI:          "I",
+		OperandIndirect:   "[I]",
+		OperandDelayTimer: "DT",
+		OperandSoundTimer: "ST",
+		OperandKey:        "K",
+		OperandFont:       "F",
+		OperandBCD:        "B",
+	}[o.Kind]
+}
+
+// Octo statements, by opcode pattern; %[n]s is the nth operand
+var octoTemplates = map[string]string{
+	"00E0": "clear",
+	"00EE": "return",
+	"1NNN": "jump %[1]s",
+	"2NNN": ":call %[1]s",
+	"3XNN": "if %[1]s != %[2]s then",
+	"4XNN": "if %[1]s == %[2]s then",
+	"5XY0": "if %[1]s != %[2]s then",
+	"6XNN": "%[1]s := %[2]s",
+	"7XNN": "%[1]s += %[2]s",
+	"8XY0": "%[1]s := %[2]s",
+	"8XY1": "%[1]s |= %[2]s",
+	"8XY2": "%[1]s &= %[2]s",
+	"8XY3": "%[1]s ^= %[2]s",
+	"8XY4": "%[1]s += %[2]s",
+	"8XY5": "%[1]s -= %[2]s",
+	"8XY6": "%[1]s >>= %[2]s",
+	"8XY7": "%[1]s =- %[2]s",
+	"8XYE": "%[1]s <<= %[2]s",
+	"9XY0": "if %[1]s == %[2]s then",
+	"ANNN": "i := %[2]s",
+	"BNNN": "jump0 %[2]s",
+	"CXNN": "%[1]s := random %[2]s",
+	"DXYN": "sprite %[1]s %[2]s %[3]s",
+	"EX9E": "if %[1]s -key then",
+	"EXA1": "if %[1]s key then",
+	"FX07": "%[1]s := delay",
+	"FX0A": "%[1]s := key",
+	"FX15": "delay := %[2]s",
+	"FX18": "buzzer := %[2]s",
+	"FX1E": "i += %[2]s",
+	"FX29": "i := hex %[2]s",
+	"FX33": "bcd %[2]s",
+	"FX55": "save %[2]s",
+	"FX65": "load %[1]s",
+}
+
+// Pattern returns the opcode pattern of the instruction, such as 6XNN or
+// DXYN, or the opcode itself for instructions without operands. Opcodes
+// which are not instructions return an empty pattern.
+func (ins Instruction) Pattern() string {
+	if ins.Mnemonic == "DW" {
+		return ""
+	}
+
+	op := ins.Op
+	switch op.B00 {
+	case 0x00:
+		if ins.Mnemonic == "SYS" {
+			return "0NNN"
+		}
+	case 0x01, 0x02, 0x0a, 0x0b:
+		return fmt.Sprintf("%XNNN", op.B00)
+	case 0x03, 0x04, 0x06, 0x07, 0x0c:
+		return fmt.Sprintf("%XXNN", op.B00)
+	case 0x05, 0x08, 0x09:
+		return fmt.Sprintf("%XXY%X", op.B00, op.B11)
+	case 0x0d:
+		return "DXYN"
+	case 0x0e, 0x0f:
+		return fmt.Sprintf("%XX%02X", op.B00, op.B1)
+	}
+
+	return fmt.Sprintf("%02X%02X", op.B0, op.B1)
+}
+
+func (ins Instruction) formatOcto(labels map[uint16]string) string {
+	template, ok := octoTemplates[ins.Pattern()]
+	if !ok {
+		// Octo has no mnemonic for these, so emit the raw bytes
+		return fmt.Sprintf("0x%02x 0x%02x", ins.Op.B0, ins.Op.B1)
+	}
+	if !strings.Contains(template, "%") {
+		return template
+	}
+
+	operands := make([]interface{}, 3)
+	for i := range operands {
+		operands[i] = ""
+		if i < len(ins.Operands) {
+			operands[i] = ins.Operands[i].format(SyntaxOcto, labels)
+		}
+	}
+
+	return fmt.Sprintf(template, operands...)
+}
+
+// WriteClassic disassembles program, loaded at 0x200, to w in classic
+// assembler syntax. The output can be assembled back into program.
+func WriteClassic(w io.Writer, program []byte) error {
+	return writeText(w, program, SyntaxClassic, ";", "DB")
+}
+
+// WriteOcto disassembles program, loaded at 0x200, to w in Octo syntax.
+func WriteOcto(w io.Writer, program []byte) error {
+	return writeText(w, program, SyntaxOcto, "#", "")
+}
+
+func writeText(w io.Writer, program []byte, syntax Syntax, comment, db string) error {
+	bw := bufio.NewWriter(w)
+
+	for i := 0; i < len(program); i += 2 {
+		addr := 0x200 + i
+		if i+1 == len(program) {
+			text := strings.TrimSpace(fmt.Sprintf("%s 0x%02x", db, program[i]))
+			fmt.Fprintf(bw, "\t%-24s %s 0x%03x: %02x\n", text, comment, addr, program[i])
+			break
+		}
+
+		ins := Disassemble(NewOpCode([2]byte{program[i], program[i+1]}))
+		fmt.Fprintf(bw, "\t%-24s %s 0x%03x: %02x%02x\n", ins.Format(syntax, nil), comment, addr, program[i], program[i+1])
+	}
+
+	return bw.Flush()
+}
+
+type jsonInstruction struct {
+	Address     uint16    `json:"address"`
+	Opcode      string    `json:"opcode"`
+	Mnemonic    string    `json:"mnemonic"`
+	Operands    []Operand `json:"operands"`
+	Description string    `json:"description"`
+	Flow        Flow      `json:"flow"`
+	Target      *uint16   `json:"target,omitempty"`
+	Text        string    `json:"text"`
+}
+
+// WriteJSON disassembles program, loaded at 0x200, to w as a JSON array with
+// an object per instruction. A trailing odd byte is left out.
+func WriteJSON(w io.Writer, program []byte) error {
+	instructions := make([]jsonInstruction, 0, len(program)/2)
+	for i := 0; i+1 < len(program); i += 2 {
+		ins := Disassemble(NewOpCode([2]byte{program[i], program[i+1]}))
+
+		j := jsonInstruction{
+			Address:     uint16(0x200 + i),
+			Opcode:      fmt.Sprintf("%02x%02x", program[i], program[i+1]),
+			Mnemonic:    ins.Mnemonic,
+			Operands:    ins.Operands,
+			Description: ins.Description,
+			Flow:        ins.Flow,
+			Text:        ins.Format(SyntaxClassic, nil),
+		}
+		if j.Operands == nil {
+			j.Operands = []Operand{}
+		}
+		switch ins.Flow {
+		case FlowJump, FlowIndirectJump, FlowCall:
+			target := ins.Target
+			j.Target = &target
+		}
+
+		instructions = append(instructions, j)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(instructions)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/janezkenda/chip8/achievement"
+)
+
+func achievementsCommand(args []string) error {
+	fs := flag.NewFlagSet("achievements", flag.ExitOnError)
+	reset := fs.Bool("reset", false, "forget the achievements unlocked")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 achievements [-reset] rom.ch8|game.8o|game.asm|game.gif")
+	}
+	path := fs.Arg(0)
+
+	p, err := readProgram(path)
+	if err != nil {
+		return err
+	}
+	achievements, err := achievement.Load(achievement.Path(path))
+	if err != nil {
+		return err
+	}
+	if len(achievements) == 0 {
+		return fmt.Errorf("no achievements defined in %s", achievement.Path(path))
+	}
+
+	unlocksPath, err := achievement.UnlocksPath(p.rom)
+	if err != nil {
+		return err
+	}
+	if *reset {
+		if err := os.Remove(unlocksPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	unlocks, err := achievement.LoadUnlocks(unlocksPath)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	unlocked := 0
+	for _, a := range achievements {
+		status := "locked"
+		if t, ok := unlocks[a.ID]; ok {
+			status = "unlocked " + t.Local().Format("2006-01-02 15:04")
+			unlocked++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", a.ID, a.Title, status)
+	}
+	fmt.Fprintf(tw, "\n%d of %d unlocked\n", unlocked, len(achievements))
+
+	return tw.Flush()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/octo"
+	"github.com/janezkenda/chip8/sym"
+)
+
+func asmCommand(args []string) error {
+	fs := flag.NewFlagSet("asm", flag.ExitOnError)
+	out := fs.String("o", "", "write the program to `file`, by default the source file with a .ch8 extension")
+	symbols := fs.Bool("sym", true, "write the symbols of the program next to it, in a .sym file")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 asm [-o rom.ch8] [-sym=false] source.asm|game.8o")
+	}
+
+	// Octo sources are compiled, anything else is assembled
+	var rom []byte
+	var table *sym.Table
+	var err error
+	if strings.ToLower(filepath.Ext(fs.Arg(0))) == ".8o" {
+		var p *octo.Program
+		if p, err = octo.CompileFile(fs.Arg(0)); err == nil {
+			rom, table = p.ROM, sym.New(p.Labels, p.Lines)
+		}
+	} else {
+		var p *asm.Program
+		if p, err = asm.AssembleFile(fs.Arg(0)); err == nil {
+			rom, table = p.ROM, sym.New(p.Labels, p.Lines)
+		}
+	}
+	if errs, ok := err.(asm.ErrorList); ok {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		return fmt.Errorf("%d errors", len(errs))
+	}
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(fs.Arg(0), filepath.Ext(fs.Arg(0))) + ".ch8"
+	}
+
+	if err := ioutil.WriteFile(*out, rom, 0644); err != nil {
+		return err
+	}
+	if !*symbols {
+		return nil
+	}
+
+	return table.WriteFile(sym.Path(*out))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/janezkenda/chip8/cart"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/octo"
+)
+
+// quirkFlags are the names of the quirks accepted by cart -quirks.
+var quirkFlags = map[string]func(q *chip8.Quirks) *bool{
+	"shift":     func(q *chip8.Quirks) *bool { return &q.Shift },
+	"loadstore": func(q *chip8.Quirks) *bool { return &q.LoadStore },
+	"jump":      func(q *chip8.Quirks) *bool { return &q.Jump },
+	"logic":     func(q *chip8.Quirks) *bool { return &q.Logic },
+	"clip":      func(q *chip8.Quirks) *bool { return &q.Clip },
+	"vblank":    func(q *chip8.Quirks) *bool { return &q.VBlank },
+	"vforder":   func(q *chip8.Quirks) *bool { return &q.VFOrder },
+}
+
+// parseQuirks parses a comma separated list of quirk names.
+func parseQuirks(s string) (chip8.Quirks, error) {
+	var q chip8.Quirks
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		quirk, ok := quirkFlags[name]
+		if !ok {
+			return q, fmt.Errorf("unknown quirk %q", name)
+		}
+		*quirk(&q) = true
+	}
+
+	return q, nil
+}
+
+func cartCommand(args []string) error {
+	defaults := cart.DefaultOptions()
+
+	fs := flag.NewFlagSet("cart", flag.ExitOnError)
+	out := fs.String("o", "", "write the cartridge to `file`, by default the program with a .gif extension")
+	label := fs.String("label", "", "`text` on the label, by default the name of the program")
+	tickRate := fs.Int("tickrate", defaults.TickRate, "instructions executed per frame")
+	quirks := fs.String("quirks", "", "comma separated `list` of quirks: shift, loadstore, jump, logic, clip, vblank, vforder; by default those the program runs with here")
+	fg := fs.String("fg", defaults.FillColor, "`colour` of set pixels")
+	bg := fs.String("bg", defaults.BackgroundColor, "`colour` of unset pixels")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 cart [-o game.gif] [-label text] [-tickrate n] [-quirks list] [-fg colour] [-bg colour] rom.ch8|game.8o|game.asm")
+	}
+	path := fs.Arg(0)
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	c := &cart.Cartridge{Options: defaults}
+	c.Options.TickRate = *tickRate
+	c.Options.FillColor = *fg
+	c.Options.BackgroundColor = *bg
+
+	isOcto := strings.ToLower(filepath.Ext(path)) == ".8o"
+	q := chip8.DefaultQuirks
+	if isOcto {
+		q = octo.Quirks()
+	}
+	if *quirks != "" {
+		var err error
+		if q, err = parseQuirks(*quirks); err != nil {
+			return err
+		}
+	}
+	c.Options.SetQuirks(q)
+
+	// Octo sources are embedded as they are, anything else as the bytes of
+	// the program
+	if isOcto {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		c.Program = string(src)
+	} else {
+		p, err := readProgram(path)
+		if err != nil {
+			return err
+		}
+		c.Program = cart.ROMSource(p.rom)
+	}
+
+	if *label == "" {
+		*label = name
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".gif"
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+
+	if err := cart.Write(f, c, *label); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
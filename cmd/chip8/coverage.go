@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/coverage"
+)
+
+func coverageCommand(args []string) error {
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	lcov := fs.String("lcov", "", "write an lcov tracefile of the source lines to `file`")
+	html := fs.String("html", "", "write an HTML report of the annotated disassembly to `file`")
+	cycles := fs.Int("cycles", 10*chip8.ClockRate, "number of instructions to run")
+	seed := fs.Int64("seed", 1, "seed for the random number generator")
+	var presses keysFlag
+	fs.Var(&presses, "key", "hold the hex `key` down from instruction start up to end, given as key@start-end; may be repeated")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 coverage [-lcov file] [-html file] [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif")
+	}
+
+	c8, p, err := loadROM(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c8.SetRandom(chip8.NewSeededRandom(*seed))
+
+	r := coverage.NewRecorder(p.rom, p.symbols)
+	c8.AddHook(r)
+
+	runWithKeys(c8, *cycles, presses)
+
+	if *lcov != "" {
+		if err := writeFile(*lcov, r.WriteLcov); err != nil {
+			return err
+		}
+	}
+	if *html != "" {
+		title := filepath.Base(fs.Arg(0))
+		if err := writeFile(*html, func(w io.Writer) error { return r.WriteHTML(w, title) }); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(r.Summary())
+
+	return nil
+}
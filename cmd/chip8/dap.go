@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/dap"
+	"github.com/janezkenda/chip8/sym"
+)
+
+func dapCommand(args []string) error {
+	fs := flag.NewFlagSet("dap", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: chip8 dap")
+	}
+
+	s := dap.NewServer(os.Stdin, os.Stdout)
+	s.Load = func(path string) (*chip8.State, *sym.Table, error) {
+		c8, p, err := loadROM(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c8, p.symbols, nil
+	}
+
+	return s.Serve()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/crash"
+	"github.com/janezkenda/chip8/debugger"
+)
+
+func debugCommand(args []string) error {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	seed := fs.Int64("seed", 0, "seed for the random number generator, by default random bytes come from crypto/rand")
+	vip := fs.String("vip", "", "draw random bytes like the COSMAC VIP interpreter, whose image or 0x100 page is in `file`")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 debug [-seed n] [-vip file] rom.ch8|game.8o|game.asm|game.gif|game.crash")
+	}
+
+	var c8 *chip8.State
+	var p *program
+	var err error
+	if strings.ToLower(filepath.Ext(fs.Arg(0))) == ".crash" {
+		c8, p, err = loadCrashDump(fs.Arg(0))
+	} else {
+		c8, p, err = loadROM(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	random, err := randomSource(*seed, *vip)
+	if err != nil {
+		return err
+	}
+	c8.SetRandom(random)
+
+	d := debugger.New(c8, os.Stdout)
+	d.SetSymbols(p.symbols)
+	for _, addr := range sortedAddrs(p.breakpoints) {
+		d.Break(addr)
+	}
+	if len(p.rom) > 0 {
+		cheats, path, err := loadCheats(p.rom)
+		if err != nil {
+			return err
+		}
+		if err := d.SetCheats(cheats, path); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	// Ctrl-C stops a running program instead of exiting
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			d.Interrupt()
+		}
+	}()
+
+	return d.Run(os.Stdin)
+}
+
+// Instructions and key events of a crash dump shown when debugging it
+const crashHistory = 10
+
+// loadCrashDump restores the state of a crash dump for post-mortem
+// debugging. The symbols are those of the program the dump was taken of,
+// if it is still around and unchanged.
+func loadCrashDump(path string) (*chip8.State, *program, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	dump, err := crash.Read(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := dump.WriteSummary(os.Stdout, crashHistory); err != nil {
+		return nil, nil, err
+	}
+
+	c8 := chip8.Init(nil)
+	dump.Restore(&c8)
+
+	p, err := readProgram(dump.Program)
+	if err != nil || crash.Hash(p.rom) != dump.ROMHash {
+		p = &program{}
+	}
+
+	return &c8, p, nil
+}
+
+func sortedAddrs(m map[uint16]string) []uint16 {
+	addrs := make([]uint16, 0, len(m))
+	for addr := range m {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	return addrs
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+func disasmCommand(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	format := fs.String("format", "classic", "output `format`: classic, octo or json")
+	flow := fs.Bool("flow", false, "follow control flow to separate code from data, writing an annotated listing")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 disasm [-flow | -format classic|octo|json] rom.ch8")
+	}
+
+	p, err := readProgram(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	program := p.rom
+
+	// The listing uses the labels and source lines of the symbols, if any
+	if *flow {
+		a := chip8.Analyze(program)
+		if p.symbols != nil {
+			a.SetLabels(p.symbols.Names())
+			a.Annotate = func(addr uint16) string {
+				if pos, ok := p.symbols.Source(addr); ok {
+					return fmt.Sprintf("%s:%d", pos.File, pos.Line)
+				}
+				return ""
+			}
+		}
+		return a.WriteListing(os.Stdout)
+	}
+
+	switch *format {
+	case "classic":
+		return chip8.WriteClassic(os.Stdout, program)
+	case "octo":
+		return chip8.WriteOcto(os.Stdout, program)
+	case "json":
+		return chip8.WriteJSON(os.Stdout, program)
+	}
+
+	return fmt.Errorf("unknown format %q", *format)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/heatmap"
+)
+
+func heatmapCommand(args []string) error {
+	fs := flag.NewFlagSet("heatmap", flag.ExitOnError)
+	out := fs.String("o", "", "write the image to `file`, by default the program with a .heatmap.png extension")
+	scale := fs.Int("scale", 8, "size in pixels of the square drawn for every byte; regions are outlined and labelled from 4")
+	cycles := fs.Int("cycles", 10*chip8.ClockRate, "number of instructions to run")
+	seed := fs.Int64("seed", 1, "seed for the random number generator")
+	var presses keysFlag
+	fs.Var(&presses, "key", "hold the hex `key` down from instruction start up to end, given as key@start-end; may be repeated")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 heatmap [-o file] [-scale n] [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif")
+	}
+	path := fs.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".heatmap.png"
+	}
+
+	c8, p, err := loadROM(path)
+	if err != nil {
+		return err
+	}
+	c8.SetRandom(chip8.NewSeededRandom(*seed))
+
+	r := heatmap.NewRecorder(len(p.rom))
+	c8.AddHook(r)
+
+	runWithKeys(c8, *cycles, presses)
+
+	if err := writeFile(*out, func(w io.Writer) error { return r.WritePNG(w, *scale) }); err != nil {
+		return err
+	}
+
+	return r.WriteUsage(os.Stdout)
+}
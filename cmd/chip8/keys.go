@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// keyPress holds a key down from instruction start up to, and not
+// including, end.
+type keyPress struct {
+	key        byte
+	start, end int
+}
+
+// keysFlag collects scripted key presses given as key@start-end.
+type keysFlag []keyPress
+
+func (f *keysFlag) String() string {
+	var presses []string
+	for _, p := range *f {
+		presses = append(presses, fmt.Sprintf("%x@%d-%d", p.key, p.start, p.end))
+	}
+
+	return strings.Join(presses, ",")
+}
+
+func (f *keysFlag) Set(value string) error {
+	parts := strings.Split(value, "@")
+	if len(parts) != 2 {
+		return fmt.Errorf("key press %q is not key@start-end", value)
+	}
+
+	key, err := strconv.ParseUint(parts[0], 16, 4)
+	if err != nil {
+		return fmt.Errorf("invalid key %q", parts[0])
+	}
+
+	span := strings.Split(parts[1], "-")
+	if len(span) != 2 {
+		return fmt.Errorf("key press %q is not key@start-end", value)
+	}
+	start, err := strconv.Atoi(span[0])
+	if err != nil {
+		return fmt.Errorf("invalid start %q", span[0])
+	}
+	end, err := strconv.Atoi(span[1])
+	if err != nil || end <= start {
+		return fmt.Errorf("invalid end %q", span[1])
+	}
+
+	*f = append(*f, keyPress{byte(key), start, end})
+
+	return nil
+}
+
+// runWithKeys runs the program like runFor, pressing and releasing the keys
+// as scripted. A key is held while any of its presses is, so overlapping
+// presses of the same key do not release each other.
+func runWithKeys(c8 *chip8.State, cycles int, presses []keyPress) {
+	perTick := c8.ClockRate() / chip8.TimerRate
+	if perTick < 1 {
+		perTick = 1
+	}
+
+	var scripted [16]bool
+	for _, p := range presses {
+		scripted[p.key] = true
+	}
+
+	for i := 1; i <= cycles && !c8.Halted(); i++ {
+		var held [16]bool
+		for _, p := range presses {
+			if i >= p.start && i < p.end {
+				held[p.key] = true
+			}
+		}
+		for key, ok := range scripted {
+			if ok {
+				c8.SetKey(byte(key), held[key])
+			}
+		}
+
+		c8.Step()
+		if i%perTick == 0 {
+			c8.Tick()
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/janezkenda/chip8/lint"
+)
+
+func lintCommand(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	format := fs.String("format", "text", "output `format`: text or json")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 lint [-format text|json] rom.ch8")
+	}
+
+	p, err := readProgram(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	findings := lint.Check(p.rom)
+
+	switch *format {
+	case "text":
+		return lint.WriteText(os.Stdout, findings, p.symbols.Describe)
+	case "json":
+		return lint.WriteJSON(os.Stdout, findings)
+	}
+
+	return fmt.Errorf("unknown format %q", *format)
+}
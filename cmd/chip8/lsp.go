@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/janezkenda/chip8/lsp"
+)
+
+func lspCommand(args []string) error {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: chip8 lsp")
+	}
+
+	return lsp.NewServer(os.Stdin, os.Stdout).Serve()
+}
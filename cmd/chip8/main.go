@@ -0,0 +1,74 @@
+// Command chip8 is a collection of tools for working with CHIP-8 programs.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+var commands = map[string]struct {
+	usage string
+	run   func(args []string) error
+}{
+	"achievements": {"achievements [-reset] rom.ch8|game.8o|game.asm|game.gif", achievementsCommand},
+	"asm":          {"asm [-o rom.ch8] [-sym=false] source.asm|game.8o", asmCommand},
+	"cart":         {"cart [-o game.gif] [-label text] [-tickrate n] [-quirks list] [-fg colour] [-bg colour] rom.ch8|game.8o|game.asm", cartCommand},
+	"coverage":     {"coverage [-lcov file] [-html file] [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif", coverageCommand},
+	"dap":          {"dap", dapCommand},
+	"debug":        {"debug [-seed n] [-vip file] rom.ch8|game.8o|game.asm|game.gif|game.crash", debugCommand},
+	"disasm":       {"disasm [-flow | -format classic|octo|json] rom.ch8", disasmCommand},
+	"heatmap":      {"heatmap [-o file] [-scale n] [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif", heatmapCommand},
+	"lint":         {"lint [-format text|json] rom.ch8", lintCommand},
+	"lsp":          {"lsp", lspCommand},
+	"profile":      {"profile [-o file] [-cycles n] [-seed n] [-top n] rom.ch8|game.8o|game.asm|game.gif", profileCommand},
+	"run":          {"run [-dump file | -nodump] [-seed n] [-vip file] rom.ch8|game.8o|game.asm|game.gif", runCommand},
+	"selfmod":      {"selfmod [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif", selfmodCommand},
+	"timeline":     {"timeline [-o file] [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif", timelineCommand},
+	"trace":        {"trace [-o file] [-gzip] [-range start-end]... [-depth n] [-cycles n] [-seed n] rom.ch8|game.8o|game.asm|game.gif", traceCommand},
+	"tracediff":    {"tracediff [-context n] a.log b.log", traceDiffCommand},
+	"vcd":          {"vcd [-o file] [-signals list] [-realtime] [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif", vcdCommand},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chip8 <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  chip8 %s\n", commands[name].usage)
+	}
+	os.Exit(2)
+}
+
+// errExitStatus makes a command exit with status 1 without an error message,
+// when it printed its outcome already, as tracediff does for traces which
+// differ.
+var errExitStatus = errors.New("exit status 1")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+
+	err := cmd.run(os.Args[2:])
+	if err == errExitStatus {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "chip8 %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/profile"
+)
+
+func profileCommand(args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ExitOnError)
+	output := fs.String("o", "", "write a pprof profile to `file`, for go tool pprof")
+	cycles := fs.Int("cycles", 10*chip8.ClockRate, "number of instructions to run")
+	seed := fs.Int64("seed", 1, "seed for the random number generator")
+	top := fs.Int("top", 20, "number of instructions in the report; 0 shows all of them")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 profile [-o file] [-cycles n] [-seed n] [-top n] rom.ch8|game.8o|game.asm|game.gif")
+	}
+
+	c8, p, err := loadROM(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c8.SetRandom(chip8.NewSeededRandom(*seed))
+
+	prof := profile.NewProfiler(c8, p.symbols)
+	c8.AddHook(prof)
+
+	runFor(c8, *cycles)
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := prof.WritePprof(f, filepath.Base(fs.Arg(0))); err != nil {
+			return err
+		}
+	}
+
+	return prof.WriteReport(os.Stdout, *top)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/cart"
+	"github.com/janezkenda/chip8/cheat"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/octo"
+	"github.com/janezkenda/chip8/sym"
+)
+
+// program is a program to run, with its symbols when it was built from
+// source or has a symbol file, and the options it runs with when it was
+// written in Octo.
+type program struct {
+	rom         []byte
+	symbols     *sym.Table
+	breakpoints map[uint16]string
+	options     *cart.Options
+}
+
+// configure sets up c8 to run the program.
+func (p *program) configure(c8 *chip8.State) error {
+	if p.options == nil {
+		return nil
+	}
+
+	return p.options.Configure(c8)
+}
+
+// readProgram reads the program at path. Files with the .asm extension are
+// assembled, files with the .8o extension are compiled from Octo and files
+// with the .gif extension are read as Octo cartridges. The symbols of other
+// files are read from the symbol file next to them, if there is one.
+func readProgram(path string) (*program, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".asm":
+		p, err := asm.AssembleFile(path)
+		if err != nil {
+			return nil, sourceError(err)
+		}
+		return &program{rom: p.ROM, symbols: sym.New(p.Labels, p.Lines)}, nil
+	case ".8o":
+		p, err := octo.CompileFile(path)
+		if err != nil {
+			return nil, sourceError(err)
+		}
+		options := cart.DefaultOptions()
+		options.SetQuirks(octo.Quirks())
+		return &program{rom: p.ROM, symbols: sym.New(p.Labels, p.Lines), breakpoints: p.Breakpoints, options: &options}, nil
+	case ".gif":
+		return readCartridge(path)
+	}
+
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols, err := sym.ReadFile(sym.Path(path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return &program{rom: rom, symbols: symbols}, nil
+}
+
+// readCartridge reads and compiles the Octo cartridge at path. The source is
+// only in the image, so the program has labels but no source map.
+func readCartridge(path string) (*program, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := cart.Read(f)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := octo.Compile(path, []byte(c.Program))
+	if err != nil {
+		return nil, sourceError(err)
+	}
+
+	return &program{rom: p.ROM, symbols: sym.New(p.Labels, nil), breakpoints: p.Breakpoints, options: &c.Options}, nil
+}
+
+// sourceError returns an error listing every error in the source, a line
+// each.
+func sourceError(err error) error {
+	errs, ok := err.(asm.ErrorList)
+	if !ok || len(errs) < 2 {
+		return err
+	}
+
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Error()
+	}
+
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// loadROM reads the program at path into a new State.
+func loadROM(path string) (*chip8.State, *program, error) {
+	p, err := readProgram(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c8 := chip8.Init(nil)
+	if err := p.configure(&c8); err != nil {
+		return nil, nil, err
+	}
+	c8.LoadProgram(p.rom)
+
+	return &c8, p, nil
+}
+
+// loadCheats reads the cheats of a ROM from its cheat file, returning them
+// with the path of the file.
+func loadCheats(rom []byte) ([]cheat.Cheat, string, error) {
+	path, err := cheat.Path(rom)
+	if err != nil {
+		return nil, "", err
+	}
+
+	cheats, err := cheat.Load(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %s", path, err)
+	}
+
+	return cheats, path, nil
+}
+
+// randomSource returns the source of the random bytes of CXNN. Given vip,
+// the path of a COSMAC VIP interpreter image, it is the VIP's own routine,
+// seeded with seed. Otherwise it is seeded unless seed is 0, and crypto/rand
+// otherwise.
+func randomSource(seed int64, vip string) (chip8.Random, error) {
+	if vip != "" {
+		page, err := ioutil.ReadFile(vip)
+		if err != nil {
+			return nil, err
+		}
+		// A full interpreter image holds the page at 0x0100; anything
+		// shorter is taken to be the page itself.
+		if len(page) >= 0x200 {
+			page = page[0x100:0x200]
+		}
+		if len(page) == 0 {
+			return nil, fmt.Errorf("%s: empty VIP interpreter page", vip)
+		}
+
+		return chip8.NewVIPRandom(page, uint16(seed)), nil
+	}
+	if seed == 0 {
+		return chip8.CryptoRandom, nil
+	}
+
+	return chip8.NewSeededRandom(seed), nil
+}
+
+// runFor executes up to cycles instructions, stopping early when the program
+// halts. The timers tick as often as they would in real time.
+func runFor(c8 *chip8.State, cycles int) {
+	perTick := c8.ClockRate() / chip8.TimerRate
+	if perTick < 1 {
+		perTick = 1
+	}
+
+	for i := 1; i <= cycles && !c8.Halted(); i++ {
+		c8.Step()
+		if i%perTick == 0 {
+			c8.Tick()
+		}
+	}
+}
+
+// writeFile creates the file at path and writes it with write.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/shiny/driver"
+	"golang.org/x/exp/shiny/screen"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/mobile/event/key"
+	"golang.org/x/mobile/event/lifecycle"
+
+	"github.com/janezkenda/chip8/achievement"
+	"github.com/janezkenda/chip8/cheat"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/crash"
+)
+
+var keys = map[rune]byte{
+	'1': 0x0,
+	'2': 0x1,
+	'3': 0x2,
+	'4': 0x3,
+	'q': 0x4,
+	'w': 0x5,
+	'e': 0x6,
+	'r': 0x7,
+	'a': 0x8,
+	's': 0x9,
+	'd': 0xa,
+	'f': 0xb,
+	'y': 0xc,
+	'x': 0xd,
+	'c': 0xe,
+	'v': 0xf,
+}
+
+// pauser pauses the program at the breakpoints of an Octo program until
+// resumed.
+type pauser struct {
+	c8          *chip8.State
+	breakpoints map[uint16]string
+	resume      chan struct{}
+}
+
+func (p *pauser) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	name, ok := p.breakpoints[pc]
+	if !ok {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "breakpoint %s at 0x%03x, press space to continue\n", name, pc)
+	fmt.Fprintf(os.Stderr, "V: % x\nI: 0x%03x DT: %d ST: %d\n", p.c8.V, p.c8.I, p.c8.DelayTimer(), p.c8.SoundTimer())
+	<-p.resume
+}
+
+// How long an achievement unlocked is shown over the display
+const notificationTime = 4 * time.Second
+
+// notification is a message shown at the bottom of the window for a while.
+type notification struct {
+	mu    sync.Mutex
+	text  string
+	until time.Time
+}
+
+func (n *notification) show(text string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.text, n.until = text, time.Now().Add(notificationTime)
+}
+
+// draw draws the message, if it is still to be shown, on a bar at the bottom
+// of img.
+func (n *notification) draw(img *image.RGBA) {
+	n.mu.Lock()
+	text, until := n.text, n.until
+	n.mu.Unlock()
+	if text == "" || time.Now().After(until) {
+		return
+	}
+
+	b := img.Bounds()
+	bar := image.Rect(b.Min.X, b.Max.Y-24, b.Max.X, b.Max.Y)
+	draw.Draw(img, bar, image.NewUniform(color.RGBA{0x20, 0x20, 0x20, 0xff}), image.Point{}, draw.Src)
+
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.RGBA{0xff, 0xd7, 0x00, 0xff}),
+		Face: basicfont.Face7x13,
+	}
+	d.Dot = fixed.P(bar.Min.X+8, bar.Max.Y-7)
+	d.DrawString(text)
+}
+
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	dump := fs.String("dump", "", "write a crash dump to `file` when the program halts, by default the program with a .crash extension")
+	noDump := fs.Bool("nodump", false, "do not write a crash dump")
+	seed := fs.Int64("seed", 0, "seed for the random number generator, by default random bytes come from crypto/rand")
+	vip := fs.String("vip", "", "draw random bytes like the COSMAC VIP interpreter, whose image or 0x100 page is in `file`")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 run [-dump file | -nodump] [-seed n] [-vip file] rom.ch8|game.8o|game.asm|game.gif")
+	}
+	path := fs.Arg(0)
+	if *dump == "" {
+		*dump = strings.TrimSuffix(path, filepath.Ext(path)) + ".crash"
+	}
+
+	p, err := readProgram(path)
+	if err != nil {
+		return err
+	}
+	random, err := randomSource(*seed, *vip)
+	if err != nil {
+		return err
+	}
+	cheats, _, err := loadCheats(p.rom)
+	if err != nil {
+		return err
+	}
+	achievements, err := achievement.Load(achievement.Path(path))
+	if err != nil {
+		return err
+	}
+	unlocksPath, err := achievement.UnlocksPath(p.rom)
+	if err != nil {
+		return err
+	}
+	unlocks, err := achievement.LoadUnlocks(unlocksPath)
+	if err != nil {
+		return err
+	}
+
+	driver.Main(func(s screen.Screen) {
+		screenSize := image.Rect(0, 0, 640, 320)
+
+		w, err := s.NewWindow(&screen.NewWindowOptions{
+			Title:  "CHIP-8",
+			Width:  screenSize.Max.X,
+			Height: screenSize.Max.Y,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer w.Release()
+
+		c8 := chip8.Init(nil)
+		if err := p.configure(&c8); err != nil {
+			log.Fatal(err)
+		}
+		c8.SetRandom(random)
+		pause := &pauser{c8: &c8, breakpoints: p.breakpoints, resume: make(chan struct{})}
+		if len(p.breakpoints) > 0 {
+			c8.AddHook(pause)
+		}
+		if !*noDump {
+			c8.AddHook(crash.NewRecorder(&c8, path, p.rom, func(d *crash.Dump) {
+				if err := writeFile(*dump, d.Write); err != nil {
+					log.Printf("writing the crash dump: %s", err)
+					return
+				}
+				fmt.Fprintf(os.Stderr, "%s, crash dump written to %s\n", d.Reason, *dump)
+			}))
+		}
+		engine := cheat.NewEngine(&c8, cheats)
+		c8.AddHook(engine)
+		notify := &notification{}
+		if len(achievements) > 0 {
+			var achieved *achievement.Engine
+			achieved = achievement.NewEngine(&c8, achievements, unlocks, func(a achievement.Achievement, t time.Time) {
+				fmt.Fprintf(os.Stderr, "achievement unlocked: %s\n", a.Title)
+				notify.show("Achievement unlocked: " + a.Title)
+				if err := achievement.SaveUnlocks(unlocksPath, achieved.Unlocks()); err != nil {
+					log.Printf("saving achievements: %s", err)
+				}
+			})
+			c8.AddHook(achieved)
+		}
+		go c8.RunProgram(p.rom)
+
+		go func() {
+			for {
+				src := c8.GetFrame(screenSize.Max.X, screenSize.Max.Y)
+
+				b, err := s.NewBuffer(screenSize.Max)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				draw.Draw(b.RGBA(), b.RGBA().Bounds(), src, image.Point{}, draw.Src)
+				notify.draw(b.RGBA())
+
+				w.Upload(image.Point{}, b, b.Bounds())
+				w.Publish()
+				b.Release()
+			}
+		}()
+
+		for {
+			switch e := w.NextEvent().(type) {
+			case lifecycle.Event:
+				if e.To == lifecycle.StageDead {
+					return
+				}
+			case key.Event:
+				if k, ok := keys[e.Rune]; ok {
+					go c8.SendKey(k, e.Direction == key.DirPress)
+				}
+
+				if e.Code == key.CodeSpacebar && e.Direction == key.DirPress {
+					select {
+					case pause.resume <- struct{}{}:
+					default:
+					}
+				}
+				// F1 to F12 toggle the cheats
+				if e.Code >= key.CodeF1 && e.Code <= key.CodeF12 && e.Direction == key.DirPress {
+					if c, err := engine.Toggle(int(e.Code - key.CodeF1)); err == nil {
+						fmt.Fprintf(os.Stderr, "cheat %s\n", c)
+					}
+				}
+				if e.Code == key.CodeEscape {
+					return
+				}
+			case error:
+				log.Print(e)
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/selfmod"
+)
+
+func selfmodCommand(args []string) error {
+	fs := flag.NewFlagSet("selfmod", flag.ExitOnError)
+	cycles := fs.Int("cycles", 10*chip8.ClockRate, "number of instructions to run")
+	seed := fs.Int64("seed", 1, "seed for the random number generator")
+	var presses keysFlag
+	fs.Var(&presses, "key", "hold the hex `key` down from instruction start up to end, given as key@start-end; may be repeated")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 selfmod [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif")
+	}
+
+	c8, p, err := loadROM(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c8.SetRandom(chip8.NewSeededRandom(*seed))
+
+	d := selfmod.NewDetector(c8, p.symbols)
+	c8.AddHook(d)
+
+	runWithKeys(c8, *cycles, presses)
+
+	return d.WriteReport(os.Stdout)
+}
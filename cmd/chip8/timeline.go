@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/timeline"
+)
+
+func timelineCommand(args []string) error {
+	fs := flag.NewFlagSet("timeline", flag.ExitOnError)
+	output := fs.String("o", "", "write the timeline to `file` instead of stdout")
+	cycles := fs.Int("cycles", 5*chip8.ClockRate, "number of instructions to run")
+	seed := fs.Int64("seed", 1, "seed for the random number generator")
+	var presses keysFlag
+	fs.Var(&presses, "key", "hold the hex `key` down from instruction start up to end, given as key@start-end; may be repeated")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 timeline [-o file] [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif")
+	}
+
+	c8, p, err := loadROM(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c8.SetRandom(chip8.NewSeededRandom(*seed))
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	t := timeline.NewWriter(w, c8, p.symbols)
+	c8.AddHook(t)
+
+	runWithKeys(c8, *cycles, presses)
+
+	return t.Close()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/trace"
+)
+
+// rangesFlag collects address ranges given as start-end.
+type rangesFlag []trace.Range
+
+func (f *rangesFlag) String() string {
+	var ranges []string
+	for _, r := range *f {
+		ranges = append(ranges, fmt.Sprintf("0x%03x-0x%03x", r.Start, r.End))
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+func (f *rangesFlag) Set(value string) error {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return fmt.Errorf("range %q is not start-end", value)
+	}
+
+	start, err := strconv.ParseUint(parts[0], 0, 12)
+	if err != nil {
+		return fmt.Errorf("invalid range start %q", parts[0])
+	}
+	end, err := strconv.ParseUint(parts[1], 0, 12)
+	if err != nil {
+		return fmt.Errorf("invalid range end %q", parts[1])
+	}
+
+	*f = append(*f, trace.Range{Start: uint16(start), End: uint16(end)})
+
+	return nil
+}
+
+func traceCommand(args []string) error {
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	output := fs.String("o", "", "write the trace to `file` instead of stdout; compressed if it ends in .gz")
+	compress := fs.Bool("gzip", false, "compress the trace with gzip")
+	depth := fs.Int("depth", 0, "only trace up to this call depth, 1 being outside of subroutines")
+	cycles := fs.Int("cycles", 10*chip8.ClockRate, "number of instructions to run")
+	seed := fs.Int64("seed", 1, "seed for the random number generator")
+	var ranges rangesFlag
+	fs.Var(&ranges, "range", "only trace instructions in the address `range` start-end; may be repeated")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 trace [-o file] [-gzip] [-range start-end]... [-depth n] [-cycles n] [-seed n] rom.ch8|game.8o|game.asm|game.gif")
+	}
+
+	c8, p, err := loadROM(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c8.SetRandom(chip8.NewSeededRandom(*seed))
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+		*compress = *compress || strings.HasSuffix(*output, ".gz")
+	}
+
+	t := trace.NewWriter(w, c8, trace.Options{
+		Ranges:   ranges,
+		MaxDepth: *depth,
+		Gzip:     *compress,
+		Symbols:  p.symbols,
+	})
+	c8.AddHook(t)
+
+	runFor(c8, *cycles)
+
+	return t.Close()
+}
+
+func traceDiffCommand(args []string) error {
+	fs := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	context := fs.Int("context", 5, "number of lines to show around the divergence")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: chip8 tracediff [-context n] a.log b.log")
+	}
+
+	var traces [2]io.Reader
+	for i, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if traces[i], err = trace.NewReader(f); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	d, err := trace.Diff(traces[0], traces[1], *context)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		fmt.Println("traces are identical")
+		return nil
+	}
+
+	if err := d.Write(os.Stdout, fs.Arg(0), fs.Arg(1)); err != nil {
+		return err
+	}
+
+	return errExitStatus
+}
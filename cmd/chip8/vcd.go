@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/vcd"
+)
+
+func vcdCommand(args []string) error {
+	fs := flag.NewFlagSet("vcd", flag.ExitOnError)
+	output := fs.String("o", "", "write the dump to `file` instead of stdout")
+	signals := fs.String("signals", "", "comma separated `list` of the signals to dump, of "+strings.Join(vcd.Signals(), ",")+"; all of them by default")
+	realtime := fs.Bool("realtime", false, "count time in microseconds at the clock rate instead of in instructions")
+	cycles := fs.Int("cycles", 10*chip8.ClockRate, "number of instructions to run")
+	seed := fs.Int64("seed", 1, "seed for the random number generator")
+	var presses keysFlag
+	fs.Var(&presses, "key", "hold the hex `key` down from instruction start up to end, given as key@start-end; may be repeated")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: chip8 vcd [-o file] [-signals list] [-realtime] [-cycles n] [-seed n] [-key key@start-end]... rom.ch8|game.8o|game.asm|game.gif")
+	}
+
+	c8, _, err := loadROM(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c8.SetRandom(chip8.NewSeededRandom(*seed))
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	opts := vcd.Options{Realtime: *realtime}
+	if *signals != "" {
+		opts.Signals = strings.Split(*signals, ",")
+	}
+
+	v, err := vcd.NewWriter(w, c8, opts)
+	if err != nil {
+		return err
+	}
+	c8.AddHook(v)
+
+	runWithKeys(c8, *cycles, presses)
+
+	return v.Close()
+}
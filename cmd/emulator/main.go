@@ -16,8 +16,6 @@ import (
 	"image/draw"
 	"io/ioutil"
 	"log"
-	"math/rand"
-	"time"
 
 	"golang.org/x/exp/shiny/driver"
 	"golang.org/x/exp/shiny/screen"
@@ -47,7 +45,6 @@ var keys = map[rune]byte{
 }
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
 	driver.Main(func(s screen.Screen) {
 		pr, err := ioutil.ReadFile("roms/MAZE")
 		if err != nil {
@@ -0,0 +1,241 @@
+// Package coverage records which code of a CHIP-8 program runs and which
+// memory it reads and writes, and reports it as an lcov file, mapped to the
+// sources of the program through its symbols, or as an HTML page of the
+// annotated disassembly.
+//
+// The instructions of a program are those found by the control flow
+// analysis of the chip8 package, plus any executed instruction it missed,
+// such as the targets of computed jumps.
+package coverage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+)
+
+// Address programs are loaded at
+const programAddress = 0x200
+
+const memorySize = 0x1000
+
+// Recorder is a hook recording the coverage of a program. Attach it with
+// chip8.State.AddHook.
+type Recorder struct {
+	program  []byte
+	symbols  *sym.Table
+	analysis *chip8.Analysis
+
+	executed [memorySize]uint64
+	read     [memorySize]bool
+	written  [memorySize]bool
+}
+
+// NewRecorder returns a recorder for program, loaded at 0x200. The symbols,
+// which may be nil, map it to its sources.
+func NewRecorder(program []byte, symbols *sym.Table) *Recorder {
+	return &Recorder{
+		program:  program,
+		symbols:  symbols,
+		analysis: chip8.Analyze(program),
+	}
+}
+
+func (r *Recorder) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	r.executed[pc&0xfff]++
+}
+
+func (r *Recorder) OnMemoryRead(addr uint16, value byte) {
+	r.read[addr&0xfff] = true
+}
+
+func (r *Recorder) OnMemoryWrite(addr uint16, value byte) {
+	r.written[addr&0xfff] = true
+}
+
+// Executed returns the number of times the instruction at addr was
+// executed.
+func (r *Recorder) Executed(addr uint16) uint64 {
+	return r.executed[addr&0xfff]
+}
+
+// Read reports whether an instruction read the byte at addr.
+func (r *Recorder) Read(addr uint16) bool {
+	return r.read[addr&0xfff]
+}
+
+// Written reports whether an instruction wrote the byte at addr.
+func (r *Recorder) Written(addr uint16) bool {
+	return r.written[addr&0xfff]
+}
+
+func (r *Recorder) contains(addr uint16) bool {
+	return addr >= programAddress && int(addr-programAddress) < len(r.program)
+}
+
+// Instructions returns the addresses of the instructions of the program, in
+// ascending order.
+func (r *Recorder) Instructions() []uint16 {
+	addrs := r.analysis.Addresses()
+	for addr := uint16(programAddress); r.contains(addr); addr++ {
+		if _, ok := r.analysis.Instructions[addr]; !ok && r.executed[addr] > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	return addrs
+}
+
+// Summary is the coverage of a whole program.
+type Summary struct {
+	// Instructions of the program, and those executed
+	Instructions, Executed int
+	// Bytes of memory read and written, in and outside of the program
+	Read, Written int
+}
+
+func (s Summary) String() string {
+	percent := 0.0
+	if s.Instructions > 0 {
+		percent = 100 * float64(s.Executed) / float64(s.Instructions)
+	}
+
+	return fmt.Sprintf("%d of %d instructions executed (%.1f%%), %d bytes read, %d bytes written", s.Executed, s.Instructions, percent, s.Read, s.Written)
+}
+
+// Summary returns the coverage of the whole program.
+func (r *Recorder) Summary() Summary {
+	var s Summary
+	for _, addr := range r.Instructions() {
+		s.Instructions++
+		if r.executed[addr] > 0 {
+			s.Executed++
+		}
+	}
+	for addr := 0; addr < memorySize; addr++ {
+		if r.read[addr] {
+			s.Read++
+		}
+		if r.written[addr] {
+			s.Written++
+		}
+	}
+
+	return s
+}
+
+// ErrNoSymbols is returned by WriteLcov for programs without symbols, which
+// have no sources to map the coverage to.
+var ErrNoSymbols = errors.New("no symbols to map the coverage to sources")
+
+// sourceFile is the coverage of a source file.
+type sourceFile struct {
+	// Execution counts of the lines with instructions
+	lines map[int]uint64
+	// Subroutines defined in the file, by name
+	functions map[string]function
+}
+
+type function struct {
+	line  int
+	count uint64
+}
+
+// WriteLcov writes the coverage of the source lines in the lcov tracefile
+// format read by genhtml and editor coverage plugins. The count of a line is
+// that of its most executed instruction; lines without instructions, such
+// as those of data, are left out. Subroutines are the targets of calls with
+// a symbol.
+func (r *Recorder) WriteLcov(w io.Writer) error {
+	if r.symbols == nil {
+		return ErrNoSymbols
+	}
+
+	files := make(map[string]*sourceFile)
+	file := func(name string) *sourceFile {
+		f, ok := files[name]
+		if !ok {
+			f = &sourceFile{lines: make(map[int]uint64), functions: make(map[string]function)}
+			files[name] = f
+		}
+		return f
+	}
+
+	entries := map[uint16]bool{programAddress: true}
+	for _, addr := range r.Instructions() {
+		pos, ok := r.symbols.Source(addr)
+		if !ok {
+			continue
+		}
+
+		f := file(pos.File)
+		if n := r.executed[addr]; n >= f.lines[pos.Line] {
+			f.lines[pos.Line] = n
+		}
+
+		if ins, ok := r.analysis.Instructions[addr]; ok && ins.Flow == chip8.FlowCall {
+			entries[ins.Target] = true
+		}
+	}
+
+	for entry := range entries {
+		s, ok := r.symbols.Lookup(entry)
+		pos, hasPos := r.symbols.Source(entry)
+		if ok && s.Addr == entry && hasPos {
+			file(pos.File).functions[s.Name] = function{pos.Line, r.executed[entry]}
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := files[name]
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", name)
+
+		fns := make([]string, 0, len(f.functions))
+		for fn := range f.functions {
+			fns = append(fns, fn)
+		}
+		sort.Slice(fns, func(i, j int) bool { return f.functions[fns[i]].line < f.functions[fns[j]].line })
+
+		hit := 0
+		for _, fn := range fns {
+			fmt.Fprintf(bw, "FN:%d,%s\n", f.functions[fn].line, fn)
+		}
+		for _, fn := range fns {
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", f.functions[fn].count, fn)
+			if f.functions[fn].count > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(fns), hit)
+
+		lines := make([]int, 0, len(f.lines))
+		for line := range f.lines {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+
+		hit = 0
+		for _, line := range lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, f.lines[line])
+			if f.lines[line] > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit)
+	}
+
+	return bw.Flush()
+}
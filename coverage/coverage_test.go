@@ -0,0 +1,132 @@
+package coverage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var program = []byte{
+	0x60, 0x05, // 0x200: V0 = 0x05
+	0xe0, 0xa1, // 0x202: Skip next instruction if key V0 is not pressed
+	0x22, 0x0e, // 0x204: Call 0x20e
+	0xa2, 0x12, // 0x206: I = 0x212
+	0x61, 0x00, // 0x208: V1 = 0x00
+	0xd1, 0x12, // 0x20a: Draw 8x2 sprite at (V1, V1)
+	0x12, 0x02, // 0x20c: Jump to 0x202
+	0x62, 0x01, // 0x20e: V2 = 0x01
+	0x00, 0xee, // 0x210: Return
+	0xf0, 0x90, // 0x212: Sprite
+}
+
+// symbols are those of the program written in game.asm, one statement per
+// line, with the label of the subroutine on a line of its own.
+var symbols = sym.New(map[string]uint16{"start": 0x200, "loop": 0x202, "pressed": 0x20e, "sprite": 0x212}, asm.SourceMap{
+	{Addr: 0x200, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 1, Col: 9}},
+	{Addr: 0x202, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 2, Col: 9}},
+	{Addr: 0x204, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 3, Col: 9}},
+	{Addr: 0x206, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 4, Col: 9}},
+	{Addr: 0x208, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 5, Col: 9}},
+	{Addr: 0x20a, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 6, Col: 9}},
+	{Addr: 0x20c, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 7, Col: 9}},
+	{Addr: 0x20e, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 9, Col: 9}},
+	{Addr: 0x210, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 10, Col: 9}},
+	{Addr: 0x212, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 11, Col: 9}},
+})
+
+// record runs the program for a number of instructions, holding key 5 down
+// from the instruction at press on.
+func record(withSymbols bool, cycles, press int) *Recorder {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(program)
+
+	r := NewRecorder(program, nil)
+	if withSymbols {
+		r = NewRecorder(program, symbols)
+	}
+	c8.AddHook(r)
+
+	for i := 0; i < cycles; i++ {
+		if i == press {
+			c8.SetKey(5, true)
+		}
+		c8.Step()
+	}
+
+	return r
+}
+
+func TestRecorder(t *testing.T) {
+	r := record(true, 13, -1)
+
+	assert.Equal(t, []uint16{0x200, 0x202, 0x204, 0x206, 0x208, 0x20a, 0x20c, 0x20e, 0x210}, r.Instructions())
+	assert.Equal(t, uint64(1), r.Executed(0x200))
+	assert.Equal(t, uint64(3), r.Executed(0x202))
+	assert.Equal(t, uint64(0), r.Executed(0x204))
+
+	assert.True(t, r.Read(0x212))
+	assert.True(t, r.Read(0x213))
+	assert.False(t, r.Written(0x212))
+	assert.True(t, r.Read(0xf00), "drawing reads and writes the screen")
+	assert.True(t, r.Written(0xf00))
+
+	assert.Equal(t, Summary{Instructions: 9, Executed: 6, Read: 4, Written: 2}, r.Summary())
+	assert.Equal(t, "6 of 9 instructions executed (66.7%), 4 bytes read, 2 bytes written", r.Summary().String())
+}
+
+func TestRecorder_WriteLcov(t *testing.T) {
+	r := record(true, 15, 8)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, r.WriteLcov(out))
+	assert.Equal(t, `TN:
+SF:game.asm
+FN:1,start
+FN:9,pressed
+FNDA:1,start
+FNDA:1,pressed
+FNF:2
+FNH:2
+DA:1,1
+DA:2,3
+DA:3,1
+DA:4,2
+DA:5,2
+DA:6,2
+DA:7,2
+DA:9,1
+DA:10,1
+LF:9
+LH:9
+end_of_record
+`, out.String())
+
+	assert.Equal(t, ErrNoSymbols, record(false, 1, -1).WriteLcov(out))
+}
+
+func TestRecorder_WriteHTML(t *testing.T) {
+	r := record(false, 13, -1)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, r.WriteHTML(out, "<game>"))
+
+	html := out.String()
+	assert.Contains(t, html, "<title>&lt;game&gt; coverage</title>")
+	assert.Contains(t, html, "<p>6 of 9 instructions executed (66.7%), 4 bytes read, 2 bytes written.")
+	assert.Contains(t, html, `<tr class="hit"><td>0x202</td><td>e0 a1</td><td class="count">3</td><td></td><td>SKNP V0</td><td></td></tr>`)
+	assert.Contains(t, html, `<tr class="miss"><td>0x204</td><td>22 0e</td><td class="count">0</td><td></td><td>CALL sub_20e</td><td></td></tr>`)
+	assert.Contains(t, html, `<tr class="label"><td colspan="6">data_212:</td></tr>`)
+	assert.Contains(t, html, `<tr class="data accessed"><td>0x212</td><td>f0 90</td><td class="count"></td><td>R</td><td>DB</td><td></td></tr>`)
+
+	// An instruction starting on the last byte of an odd-sized program
+	r = NewRecorder([]byte{0x60, 0x01, 0x12, 0x04, 0x00}, nil)
+	r.OnBeforeInstruction(0x204, chip8.NewOpCode([2]byte{0x00, 0x00}))
+	out.Reset()
+	require.NoError(t, r.WriteHTML(out, "odd"))
+	assert.Contains(t, out.String(), `<tr class="data"><td>0x204</td><td>00</td><td class="count"></td><td></td><td>DB</td><td></td></tr>`)
+}
@@ -0,0 +1,153 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// Bytes of data shown on a line of the report
+const dataPerRow = 8
+
+// row is a line of the HTML report: an instruction or a run of data.
+type row struct {
+	Label  string
+	Addr   string
+	Bytes  string
+	Text   string
+	Count  string
+	Access string
+	Source string
+	Class  string
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; font-family: monospace; }
+td, th { padding: 0 0.75em; text-align: left; white-space: pre; }
+td.count { text-align: right; }
+tr.label td { padding-top: 0.5em; font-weight: bold; }
+tr.hit { background: #dfd; }
+tr.miss { background: #fcc; }
+tr.data { color: #666; }
+tr.accessed { color: #000; background: #eef; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Summary}}. Instructions never executed are highlighted in red; data the program read or wrote is marked R and W.</p>
+<table>
+<tr><th>address</th><th>bytes</th><th>count</th><th>access</th><th>instruction</th><th>source</th></tr>
+{{- range .Rows}}
+{{- if .Label}}
+<tr class="label"><td colspan="6">{{.Label}}:</td></tr>
+{{- end}}
+<tr class="{{.Class}}"><td>{{.Addr}}</td><td>{{.Bytes}}</td><td class="count">{{.Count}}</td><td>{{.Access}}</td><td>{{.Text}}</td><td>{{.Source}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// access returns the accesses to the bytes from addr up to end, as R and W.
+func (r *Recorder) access(addr, end uint16) string {
+	read, written := false, false
+	for ; addr < end; addr++ {
+		read = read || r.read[addr]
+		written = written || r.written[addr]
+	}
+
+	var s string
+	if read {
+		s += "R"
+	}
+	if written {
+		s += "W"
+	}
+
+	return s
+}
+
+// rows returns the lines of the report.
+func (r *Recorder) rows() []row {
+	labels := r.analysis.Labels
+	if r.symbols != nil {
+		labels = make(map[uint16]string)
+		for addr, name := range r.analysis.Labels {
+			labels[addr] = name
+		}
+		for addr, name := range r.symbols.Names() {
+			labels[addr] = name
+		}
+	}
+
+	instructions := make(map[uint16]bool)
+	for _, addr := range r.Instructions() {
+		instructions[addr] = true
+	}
+
+	source := func(addr uint16) string {
+		if pos, ok := r.symbols.Source(addr); ok {
+			return fmt.Sprintf("%s:%d", pos.File, pos.Line)
+		}
+		return ""
+	}
+
+	var rows []row
+	end := uint16(programAddress + len(r.program))
+	for addr := uint16(programAddress); addr < end; {
+		start := addr
+		ro := row{Label: labels[addr], Addr: fmt.Sprintf("0x%03x", addr), Source: source(addr)}
+
+		if instructions[addr] && addr+1 < end {
+			op := chip8.NewOpCode([2]byte{r.program[addr-programAddress], r.program[addr+1-programAddress]})
+			ro.Bytes = fmt.Sprintf("%02x %02x", op.B0, op.B1)
+			ro.Text = chip8.Disassemble(op).Format(chip8.SyntaxClassic, labels)
+			ro.Count = fmt.Sprint(r.executed[addr])
+			ro.Class = "miss"
+			if r.executed[addr] > 0 {
+				ro.Class = "hit"
+			}
+			addr += 2
+		} else {
+			// An instruction executed from the last byte of the program is
+			// shown as data, as its second byte is not part of it
+			var bytes []string
+			for ; addr < end && len(bytes) < dataPerRow && (addr == start || !instructions[addr]); addr++ {
+				if addr != start && labels[addr] != "" {
+					break
+				}
+				bytes = append(bytes, fmt.Sprintf("%02x", r.program[addr-programAddress]))
+			}
+			ro.Bytes = strings.Join(bytes, " ")
+			ro.Text = "DB"
+			ro.Class = "data"
+		}
+
+		if ro.Access = r.access(start, addr); ro.Access != "" && ro.Class == "data" {
+			ro.Class = "data accessed"
+		}
+		rows = append(rows, ro)
+	}
+
+	return rows
+}
+
+// WriteHTML writes an HTML page of the annotated disassembly of the program,
+// with the execution count of each instruction and the accesses to its
+// data. Title names the program.
+func (r *Recorder) WriteHTML(w io.Writer, title string) error {
+	return reportTemplate.Execute(w, struct {
+		Title   string
+		Summary Summary
+		Rows    []row
+	}{title, r.Summary(), r.rows()})
+}
@@ -0,0 +1,312 @@
+// Package crash writes crash dumps of CHIP-8 programs when they halt, and
+// reads them back for post-mortem debugging. A dump holds the whole state of
+// the machine, the last instructions executed, the call stack, the recent
+// key presses and the hash of the ROM, as a JSON document to attach to bug
+// reports.
+package crash
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// Version of the dump format
+const Version = 1
+
+// Number of instructions and key events kept in a dump
+const (
+	TraceLength = 256
+	InputLength = 64
+)
+
+const memorySize = 0x1000
+
+// Addr is an address, written as a hex string in dumps.
+type Addr uint16
+
+func (a Addr) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("0x%03x", uint16(a))), nil
+}
+
+func (a *Addr) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseUint(string(text), 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", text)
+	}
+	*a = Addr(v)
+
+	return nil
+}
+
+// Registers are the registers and timers of the machine.
+type Registers struct {
+	V  [16]byte `json:"v"`
+	I  Addr     `json:"i"`
+	SP Addr     `json:"sp"`
+	PC Addr     `json:"pc"`
+	DT byte     `json:"dt"`
+	ST byte     `json:"st"`
+}
+
+// Instruction is an instruction executed before the crash.
+type Instruction struct {
+	Cycle uint64 `json:"cycle"`
+	PC    Addr   `json:"pc"`
+	Op    string `json:"op"`
+	Text  string `json:"text"`
+}
+
+// KeyEvent is a key pressed or released before the instruction of a cycle.
+type KeyEvent struct {
+	Cycle   uint64 `json:"cycle"`
+	Key     byte   `json:"key"`
+	Pressed bool   `json:"pressed"`
+}
+
+// Dump is the state of a program when it halted.
+type Dump struct {
+	Version int `json:"version"`
+
+	// Name of the program, and the size and SHA-256 hash of its ROM
+	Program string `json:"program"`
+	ROMSize int    `json:"romSize"`
+	ROMHash string `json:"romSHA256"`
+
+	// Why the program halted, and after how many instructions
+	Reason string `json:"reason"`
+	Cycles uint64 `json:"cycles"`
+
+	Registers Registers    `json:"registers"`
+	Keys      []int        `json:"keys,omitempty"`
+	Quirks    chip8.Quirks `json:"quirks"`
+	ClockRate int          `json:"clockRate"`
+
+	// Addresses of the calls on the stack, the innermost first
+	CallStack []Addr `json:"callStack,omitempty"`
+
+	// Last instructions executed and key events, the oldest first
+	Trace []Instruction `json:"trace"`
+	Input []KeyEvent    `json:"input,omitempty"`
+
+	// The 4 KiB of memory, including the display buffer
+	Memory []byte `json:"memory"`
+}
+
+// Hash returns the hex SHA-256 hash of a ROM, as stored in dumps.
+func Hash(rom []byte) string {
+	sum := sha256.Sum256(rom)
+	return hex.EncodeToString(sum[:])
+}
+
+// Restore sets the machine in state to the state of the dump. The program
+// is not halted, so stepping it runs the instruction at PC again.
+func (d *Dump) Restore(state *chip8.State) {
+	for addr, b := range d.Memory {
+		state.Poke(uint16(addr), b)
+	}
+
+	r := d.Registers
+	state.V = r.V
+	state.I, state.SP, state.PC = uint16(r.I), uint16(r.SP), uint16(r.PC)
+	state.SetDelayTimer(r.DT)
+	state.SetSoundTimer(r.ST)
+
+	for k := byte(0); k < 16; k++ {
+		state.SetKey(k, false)
+	}
+	for _, k := range d.Keys {
+		state.SetKey(byte(k), true)
+	}
+
+	state.SetQuirks(d.Quirks)
+	if d.ClockRate > 0 {
+		state.SetClockRate(d.ClockRate)
+	}
+}
+
+// Write writes the dump as indented JSON.
+func (d *Dump) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(d)
+}
+
+// Read reads a dump written by Write.
+func Read(r io.Reader) (*Dump, error) {
+	var d Dump
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return nil, fmt.Errorf("not a crash dump: %s", err)
+	}
+	if d.Version != Version {
+		return nil, fmt.Errorf("unsupported crash dump version %d", d.Version)
+	}
+	if len(d.Memory) != memorySize {
+		return nil, fmt.Errorf("crash dump has %d bytes of memory instead of %d", len(d.Memory), memorySize)
+	}
+
+	return &d, nil
+}
+
+// Recorder is a hook keeping the recent history of a program, and handing a
+// dump to a function when the program halts. Attach it with
+// chip8.State.AddHook.
+type Recorder struct {
+	state   *chip8.State
+	program string
+	rom     []byte
+	crashed func(*Dump)
+
+	cycles uint64
+	keys   uint16
+
+	// Ring buffer of the last instructions, next written at traceNext
+	trace     [TraceLength]Instruction
+	traceNext int
+	input     []KeyEvent
+}
+
+// NewRecorder returns a recorder for the named program running in state,
+// calling crashed, when set, with a dump when it halts.
+func NewRecorder(state *chip8.State, program string, rom []byte, crashed func(*Dump)) *Recorder {
+	return &Recorder{state: state, program: program, rom: rom, crashed: crashed}
+}
+
+func (r *Recorder) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	r.cycles++
+
+	var keys uint16
+	for k := byte(0); k < 16; k++ {
+		if r.state.Key(k) {
+			keys |= 1 << k
+		}
+	}
+	for k := byte(0); k < 16; k++ {
+		if changed := (keys ^ r.keys) & (1 << k); changed != 0 {
+			r.input = append(r.input, KeyEvent{r.cycles, k, keys&changed != 0})
+		}
+	}
+	if len(r.input) > 2*InputLength {
+		r.input = append([]KeyEvent(nil), r.input[len(r.input)-InputLength:]...)
+	}
+	r.keys = keys
+
+	r.trace[r.traceNext] = Instruction{
+		Cycle: r.cycles,
+		PC:    Addr(pc),
+		Op:    fmt.Sprintf("%02x%02x", op.B0, op.B1),
+		Text:  chip8.Disassemble(op).Format(chip8.SyntaxClassic, nil),
+	}
+	r.traceNext = (r.traceNext + 1) % TraceLength
+}
+
+func (r *Recorder) OnHalt(pc uint16, reason chip8.HaltReason) {
+	if r.crashed != nil {
+		r.crashed(r.Dump(reason.String()))
+	}
+}
+
+// Dump returns a dump of the current state, halted for the given reason.
+func (r *Recorder) Dump(reason string) *Dump {
+	s := r.state
+	d := &Dump{
+		Version:   Version,
+		Program:   r.program,
+		ROMSize:   len(r.rom),
+		ROMHash:   Hash(r.rom),
+		Reason:    reason,
+		Cycles:    r.cycles,
+		Registers: Registers{s.V, Addr(s.I), Addr(s.SP), Addr(s.PC), s.DelayTimer(), s.SoundTimer()},
+		Quirks:    s.Quirks(),
+		ClockRate: s.ClockRate(),
+		Memory:    make([]byte, memorySize),
+	}
+
+	for k := byte(0); k < 16; k++ {
+		if s.Key(k) {
+			d.Keys = append(d.Keys, int(k))
+		}
+	}
+	for _, addr := range s.CallStack() {
+		d.CallStack = append(d.CallStack, Addr(addr))
+	}
+
+	for i := 0; i < TraceLength; i++ {
+		ins := r.trace[(r.traceNext+i)%TraceLength]
+		if ins.Cycle > 0 {
+			d.Trace = append(d.Trace, ins)
+		}
+	}
+	d.Input = r.input
+	if len(d.Input) > InputLength {
+		d.Input = d.Input[len(d.Input)-InputLength:]
+	}
+
+	for addr := range d.Memory {
+		d.Memory[addr] = s.Peek(uint16(addr))
+	}
+
+	return d
+}
+
+// WriteSummary writes why the program halted, its registers and call stack,
+// and the last instructions and key events of the dump, up to count each.
+func (d *Dump) WriteSummary(w io.Writer, count int) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "Crash dump of %s (%d bytes, sha256 %s)\n", d.Program, d.ROMSize, d.ROMHash)
+	fmt.Fprintf(bw, "Halted after %d instructions: %s\n", d.Cycles, d.Reason)
+
+	r := d.Registers
+	for i, v := range r.V {
+		sep := " "
+		if i%8 == 7 {
+			sep = "\n"
+		}
+		fmt.Fprintf(bw, "V%X=%02x%s", i, v, sep)
+	}
+	fmt.Fprintf(bw, "I=%03x SP=%03x PC=%03x DT=%02x ST=%02x\n", uint16(r.I), uint16(r.SP), uint16(r.PC), r.DT, r.ST)
+
+	if len(d.CallStack) > 0 {
+		fmt.Fprint(bw, "Call stack:")
+		for _, addr := range d.CallStack {
+			fmt.Fprintf(bw, " 0x%03x", uint16(addr))
+		}
+		fmt.Fprintln(bw)
+	}
+
+	trace := d.Trace
+	if len(trace) > count {
+		trace = trace[len(trace)-count:]
+	}
+	if len(trace) > 0 {
+		fmt.Fprintln(bw, "Last instructions:")
+	}
+	for _, ins := range trace {
+		fmt.Fprintf(bw, "%10d 0x%03x %s %s\n", ins.Cycle, uint16(ins.PC), ins.Op, ins.Text)
+	}
+
+	input := d.Input
+	if len(input) > count {
+		input = input[len(input)-count:]
+	}
+	if len(input) > 0 {
+		fmt.Fprintln(bw, "Last key events:")
+	}
+	for _, e := range input {
+		state := "up"
+		if e.Pressed {
+			state = "down"
+		}
+		fmt.Fprintf(bw, "%10d key %X %s\n", e.Cycle, e.Key, state)
+	}
+
+	return bw.Flush()
+}
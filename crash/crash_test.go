@@ -0,0 +1,135 @@
+package crash
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// program calls a subroutine running into an unknown opcode once key 5 is
+// pressed.
+var program = []byte{
+	0x60, 0x05, // 0x200: V0 = 0x05
+	0xe0, 0xa1, // 0x202: Skip next instruction if key V0 is not pressed
+	0x22, 0x0a, // 0x204: Call 0x20a
+	0x71, 0x01, // 0x206: V1 += 0x01
+	0x12, 0x02, // 0x208: Jump to 0x202
+	0x62, 0x07, // 0x20a: V2 = 0x07
+	0xff, 0xff, // 0x20c: Unknown opcode
+}
+
+func record(t *testing.T) *Dump {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(program)
+
+	var dump *Dump
+	c8.AddHook(NewRecorder(&c8, "game.ch8", program, func(d *Dump) { dump = d }))
+
+	for i := 0; !c8.Halted(); i++ {
+		if i == 4 {
+			c8.SetKey(5, true)
+		}
+		c8.Step()
+	}
+	require.NotNil(t, dump)
+
+	return dump
+}
+
+func TestRecorder(t *testing.T) {
+	d := record(t)
+
+	assert.Equal(t, Version, d.Version)
+	assert.Equal(t, "game.ch8", d.Program)
+	assert.Equal(t, len(program), d.ROMSize)
+	assert.Equal(t, Hash(program), d.ROMHash)
+	assert.Len(t, d.ROMHash, 64)
+	assert.Equal(t, "opcode not implemented", d.Reason)
+	assert.Equal(t, uint64(8), d.Cycles)
+
+	assert.Equal(t, Addr(0x20c), d.Registers.PC)
+	assert.Equal(t, Addr(0xefc), d.Registers.SP)
+	assert.Equal(t, byte(1), d.Registers.V[1])
+	assert.Equal(t, byte(7), d.Registers.V[2])
+	assert.Equal(t, []int{5}, d.Keys)
+	assert.Equal(t, []Addr{0x204}, d.CallStack)
+	assert.Equal(t, chip8.DefaultQuirks, d.Quirks)
+	assert.Equal(t, chip8.ClockRate, d.ClockRate)
+
+	require.Len(t, d.Trace, 8)
+	assert.Equal(t, Instruction{1, 0x200, "6005", "LD V0, 0x05"}, d.Trace[0])
+	assert.Equal(t, Instruction{8, 0x20c, "ffff", "DW 0xffff"}, d.Trace[7])
+	assert.Equal(t, []KeyEvent{{5, 5, true}}, d.Input)
+
+	require.Len(t, d.Memory, 0x1000)
+	assert.Equal(t, program, d.Memory[0x200:0x200+len(program)])
+}
+
+func TestRecorder_traceLength(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram([]byte{0x70, 0x01, 0x12, 0x00}) // V0 += 1, jump to 0x200
+
+	r := NewRecorder(&c8, "loop.ch8", nil, nil)
+	c8.AddHook(r)
+	for i := 0; i < 1000; i++ {
+		c8.Step()
+	}
+
+	d := r.Dump("interrupted")
+	require.Len(t, d.Trace, TraceLength)
+	assert.Equal(t, uint64(1000-TraceLength+1), d.Trace[0].Cycle)
+	assert.Equal(t, uint64(1000), d.Trace[TraceLength-1].Cycle)
+	assert.Empty(t, d.Input)
+}
+
+func TestDump_roundTrip(t *testing.T) {
+	d := record(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, d.Write(&buf))
+	assert.Contains(t, buf.String(), `"pc": "0x20c"`)
+
+	read, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, d, read)
+
+	c8 := chip8.Init(nil)
+	read.Restore(&c8)
+	assert.Equal(t, uint16(0x20c), c8.PC)
+	assert.Equal(t, uint16(0xefc), c8.SP)
+	assert.Equal(t, byte(7), c8.V[2])
+	assert.True(t, c8.Key(5))
+	assert.Equal(t, []uint16{0x204}, c8.CallStack())
+	assert.Equal(t, byte(0x60), c8.Peek(0x200))
+	assert.False(t, c8.Halted())
+
+	_, err = Read(strings.NewReader(`{"version": 2}`))
+	assert.EqualError(t, err, "unsupported crash dump version 2")
+	_, err = Read(strings.NewReader(`{"version": 1, "memory": "AAAA"}`))
+	assert.EqualError(t, err, "crash dump has 3 bytes of memory instead of 4096")
+	_, err = Read(strings.NewReader(`garbage`))
+	assert.Error(t, err)
+}
+
+func TestDump_WriteSummary(t *testing.T) {
+	d := record(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, d.WriteSummary(&buf, 2))
+	assert.Equal(t, `Crash dump of game.ch8 (14 bytes, sha256 `+d.ROMHash+`)
+Halted after 8 instructions: opcode not implemented
+V0=05 V1=01 V2=07 V3=00 V4=00 V5=00 V6=00 V7=00
+V8=00 V9=00 VA=00 VB=00 VC=00 VD=00 VE=00 VF=00
+I=000 SP=efc PC=20c DT=00 ST=00
+Call stack: 0x204
+Last instructions:
+         7 0x20a 6207 LD V2, 0x07
+         8 0x20c ffff DW 0xffff
+Last key events:
+         5 key 5 down
+`, buf.String())
+}
@@ -0,0 +1,697 @@
+// Package dap implements a Debug Adapter Protocol server, so CHIP-8 programs
+// can be debugged from editors such as VS Code. It launches a program,
+// stops at breakpoints set on source lines, through the symbols of the
+// program, or on addresses, steps over and out of subroutines, shows the
+// registers, timers and call stack as variables, and reads and disassembles
+// memory.
+//
+// The program runs in the background, so it can be paused, while requests
+// are served one at a time. There is a single thread, with ID 1.
+package dap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+)
+
+// ID of the only thread
+const threadID = 1
+
+// References of the variables of the scopes
+const (
+	registersReference = 1 + iota
+	timersReference
+	stackReference
+)
+
+// Number of instructions executed between checks for requests
+const batchSize = 1000
+
+// Server is a debug adapter.
+type Server struct {
+	// Load loads the program at path, returning the state to run it in and
+	// its symbols, which may be nil. When nil, the program is read as a ROM,
+	// with the symbols in the symbol file next to it.
+	Load func(path string) (*chip8.State, *sym.Table, error)
+
+	in *bufio.Reader
+
+	// Guards out and seq, as events are sent while the program runs
+	outMu sync.Mutex
+	out   io.Writer
+	seq   int
+
+	// Guards the state of the program below, which is only changed by
+	// requests and the running program
+	mu          sync.Mutex
+	path        string
+	state       *chip8.State
+	symbols     *sym.Table
+	halt        *haltRecorder
+	stopOnEntry bool
+	cycles      int
+	running     bool
+
+	// IDs of the breakpoints set on the lines of each source file and on
+	// instructions, by address. Each request replaces one of them only.
+	sourceBreakpoints      map[string]map[uint16]int
+	instructionBreakpoints map[uint16]int
+	nextID                 int
+
+	// Set to stop the running program
+	pausing int32
+	wg      sync.WaitGroup
+}
+
+// haltRecorder records why the program halted.
+type haltRecorder struct {
+	reason chip8.HaltReason
+}
+
+func (h *haltRecorder) OnHalt(pc uint16, reason chip8.HaltReason) {
+	h.reason = reason
+}
+
+// console writes the messages of the emulator to the client as output
+// events, as stdout carries the protocol.
+type console struct {
+	s *Server
+}
+
+func (c console) Write(p []byte) (int, error) {
+	if err := c.s.event("output", map[string]interface{}{"category": "console", "output": string(p)}); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// NewServer returns a server reading requests from in and writing responses
+// and events to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:                     bufio.NewReader(in),
+		out:                    out,
+		sourceBreakpoints:      make(map[string]map[uint16]int),
+		instructionBreakpoints: make(map[uint16]int),
+		nextID:                 1,
+	}
+}
+
+// Serve handles requests until the client disconnects or closes the input.
+func (s *Server) Serve() error {
+	defer s.pause()
+
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var r request
+		if err := json.Unmarshal(body, &r); err != nil {
+			return fmt.Errorf("invalid message: %s", err)
+		}
+		if r.Type != "request" {
+			continue
+		}
+
+		if err := s.handle(r); err != nil {
+			return err
+		}
+		if r.Command == "disconnect" || r.Command == "terminate" {
+			return nil
+		}
+	}
+}
+
+func (s *Server) send(v interface{}) error {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+
+	s.seq++
+	switch m := v.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+
+	return writeMessage(s.out, v)
+}
+
+func (s *Server) event(name string, body interface{}) error {
+	return s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// handle handles a request. Errors of the request are reported to the
+// client, only errors writing to it are returned.
+func (s *Server) handle(r request) error {
+	// decode decodes the arguments into v
+	decode := func(v interface{}) error {
+		if len(r.Arguments) == 0 {
+			return nil
+		}
+		return json.Unmarshal(r.Arguments, v)
+	}
+
+	// after runs once the response was sent, for requests resuming the
+	// program or sending events
+	var after func()
+
+	body, err := func() (interface{}, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if r.Command != "initialize" && r.Command != "launch" && r.Command != "disconnect" && r.Command != "terminate" && s.state == nil {
+			return nil, fmt.Errorf("no program launched")
+		}
+
+		switch r.Command {
+		case "initialize":
+			return map[string]interface{}{
+				"supportsConfigurationDoneRequest": true,
+				"supportsInstructionBreakpoints":   true,
+				"supportsReadMemoryRequest":        true,
+				"supportsDisassembleRequest":       true,
+				"supportsTerminateRequest":         true,
+			}, nil
+		case "launch":
+			var args launchArguments
+			if err := decode(&args); err != nil {
+				return nil, err
+			}
+			if err := s.launch(args); err != nil {
+				return nil, err
+			}
+			after = func() { s.event("initialized", nil) }
+			return nil, nil
+		case "setBreakpoints":
+			var args setBreakpointsArguments
+			if err := decode(&args); err != nil {
+				return nil, err
+			}
+			return s.setBreakpoints(args), nil
+		case "setInstructionBreakpoints":
+			var args setInstructionBreakpointsArguments
+			if err := decode(&args); err != nil {
+				return nil, err
+			}
+			return s.setInstructionBreakpoints(args)
+		case "setExceptionBreakpoints":
+			return map[string]interface{}{"breakpoints": []breakpoint{}}, nil
+		case "configurationDone":
+			if s.stopOnEntry {
+				after = func() { s.stopped("entry", nil) }
+			} else {
+				after = func() { s.resume("", nil) }
+			}
+			return nil, nil
+		case "threads":
+			return map[string]interface{}{
+				"threads": []map[string]interface{}{{"id": threadID, "name": "CHIP-8"}},
+			}, nil
+		case "stackTrace":
+			return s.stackTrace(), nil
+		case "scopes":
+			return map[string]interface{}{"scopes": []scope{
+				{"Registers", registersReference, false},
+				{"Timers", timersReference, false},
+				{"Stack", stackReference, false},
+			}}, nil
+		case "variables":
+			var args struct {
+				VariablesReference int `json:"variablesReference"`
+			}
+			if err := decode(&args); err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"variables": s.variables(args.VariablesReference)}, nil
+		case "readMemory":
+			var args readMemoryArguments
+			if err := decode(&args); err != nil {
+				return nil, err
+			}
+			return s.readMemory(args)
+		case "disassemble":
+			var args disassembleArguments
+			if err := decode(&args); err != nil {
+				return nil, err
+			}
+			return s.disassemble(args)
+		case "continue":
+			after = func() { s.resume("", nil) }
+			return map[string]interface{}{"allThreadsContinued": true}, nil
+		case "next":
+			after = s.next()
+			return nil, nil
+		case "stepIn":
+			after = func() { s.resume("step", func() bool { return true }) }
+			return nil, nil
+		case "stepOut":
+			after = s.stepOut()
+			return nil, nil
+		case "pause":
+			if !s.running {
+				after = func() { s.stopped("pause", nil) }
+			}
+			atomic.StoreInt32(&s.pausing, 1)
+			return nil, nil
+		case "disconnect", "terminate":
+			atomic.StoreInt32(&s.pausing, 1)
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unsupported request %q", r.Command)
+	}()
+
+	resp := &response{Type: "response", RequestSeq: r.Seq, Command: r.Command, Success: err == nil, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	if err := s.send(resp); err != nil {
+		return err
+	}
+
+	if after != nil {
+		after()
+	}
+
+	return nil
+}
+
+// launch loads the program.
+func (s *Server) launch(args launchArguments) error {
+	if args.Program == "" {
+		return fmt.Errorf("launch needs a program")
+	}
+
+	load := s.Load
+	if load == nil {
+		load = loadROM
+	}
+
+	state, symbols, err := load(args.Program)
+	if err != nil {
+		return err
+	}
+
+	s.path = args.Program
+	s.state = state
+	s.symbols = symbols
+	s.stopOnEntry = args.StopOnEntry
+	s.halt = &haltRecorder{}
+	s.state.AddHook(s.halt)
+	s.state.SetOutput(console{s})
+
+	return nil
+}
+
+// loadROM reads the ROM at path, and its symbol file if there is one.
+func loadROM(path string) (*chip8.State, *sym.Table, error) {
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	symbols, err := sym.ReadFile(sym.Path(path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(rom)
+
+	return &c8, symbols, nil
+}
+
+// sourcePath returns the absolute path of a source file of the symbols,
+// which are relative to the working directory as the symbol file is read.
+func (s *Server) sourcePath(file string) string {
+	if p, err := filepath.Abs(file); err == nil {
+		return p
+	}
+
+	return file
+}
+
+func (s *Server) setBreakpoints(args setBreakpointsArguments) interface{} {
+	path := s.sourcePath(args.Source.Path)
+	breakpoints := make(map[uint16]int)
+	s.sourceBreakpoints[path] = breakpoints
+
+	// The lines of the file which have code, in order
+	var lines []int
+	addrs := make(map[int]uint16)
+	if s.symbols != nil {
+		for _, l := range s.symbols.Lines {
+			if s.sourcePath(l.Pos.File) != path {
+				continue
+			}
+			if _, ok := addrs[l.Pos.Line]; !ok {
+				addrs[l.Pos.Line] = l.Addr
+				lines = append(lines, l.Pos.Line)
+			}
+		}
+	}
+	sort.Ints(lines)
+
+	result := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		bp := breakpoint{ID: s.nextID, Source: &source{Path: args.Source.Path}, Line: b.Line}
+		s.nextID++
+
+		// Breakpoints on lines without code move to the next line with code
+		i := sort.SearchInts(lines, b.Line)
+		if i == len(lines) {
+			bp.Message = fmt.Sprintf("no code at line %d", b.Line)
+			result = append(result, bp)
+			continue
+		}
+
+		addr := addrs[lines[i]]
+		bp.Verified = true
+		bp.Line = lines[i]
+		bp.InstructionReference = reference(addr)
+		breakpoints[addr] = bp.ID
+		result = append(result, bp)
+	}
+
+	return map[string]interface{}{"breakpoints": result}
+}
+
+func (s *Server) setInstructionBreakpoints(args setInstructionBreakpointsArguments) (interface{}, error) {
+	breakpoints := make(map[uint16]int)
+
+	result := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		addr, err := s.parseReference(b.InstructionReference)
+		if err != nil {
+			return nil, err
+		}
+		addr += uint16(b.Offset)
+
+		bp := breakpoint{ID: s.nextID, Verified: true, InstructionReference: reference(addr)}
+		s.nextID++
+		if pos, ok := s.symbols.Source(addr); ok {
+			bp.Source = &source{Path: s.sourcePath(pos.File)}
+			bp.Line = pos.Line
+		}
+
+		breakpoints[addr] = bp.ID
+		result = append(result, bp)
+	}
+	s.instructionBreakpoints = breakpoints
+
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+// breakpointsAt returns the IDs of the breakpoints at addr.
+func (s *Server) breakpointsAt(addr uint16) []int {
+	var ids []int
+	if id, ok := s.instructionBreakpoints[addr]; ok {
+		ids = append(ids, id)
+	}
+	for _, breakpoints := range s.sourceBreakpoints {
+		if id, ok := breakpoints[addr]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+// parseReference parses a memory or instruction reference, which is an
+// address or the name of a symbol.
+func (s *Server) parseReference(ref string) (uint16, error) {
+	if addr, ok := s.symbols.Addr(ref); ok {
+		return addr, nil
+	}
+
+	v, err := strconv.ParseUint(ref, 0, 16)
+	if err != nil || v > 0xfff {
+		return 0, fmt.Errorf("invalid address %q", ref)
+	}
+
+	return uint16(v), nil
+}
+
+func reference(addr uint16) string {
+	return fmt.Sprintf("0x%03x", addr)
+}
+
+func (s *Server) stackTrace() interface{} {
+	pcs := append([]uint16{s.state.PC}, s.state.CallStack()...)
+
+	frames := make([]stackFrame, len(pcs))
+	for i, pc := range pcs {
+		f := stackFrame{ID: i, Name: s.symbols.Label(pc), InstructionPointerReference: reference(pc)}
+		if f.Name == "" {
+			f.Name = reference(pc)
+		}
+		if pos, ok := s.symbols.Source(pc); ok {
+			path := s.sourcePath(pos.File)
+			f.Source = &source{Name: filepath.Base(path), Path: path}
+			f.Line, f.Column = pos.Line, pos.Col
+		}
+		frames[i] = f
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+func (s *Server) variables(ref int) []variable {
+	c8 := s.state
+	vars := []variable{}
+
+	switch ref {
+	case registersReference:
+		for i, v := range c8.V {
+			vars = append(vars, variable{Name: fmt.Sprintf("V%X", i), Value: fmt.Sprintf("0x%02x (%d)", v, v), Type: "byte"})
+		}
+		vars = append(vars,
+			variable{Name: "I", Value: s.address(c8.I), Type: "address", MemoryReference: reference(c8.I)},
+			variable{Name: "PC", Value: s.address(c8.PC), Type: "address", MemoryReference: reference(c8.PC)},
+			variable{Name: "SP", Value: reference(c8.SP), Type: "address", MemoryReference: reference(c8.SP)},
+		)
+	case timersReference:
+		vars = append(vars,
+			variable{Name: "DT", Value: fmt.Sprintf("%d", c8.DelayTimer()), Type: "byte"},
+			variable{Name: "ST", Value: fmt.Sprintf("%d", c8.SoundTimer()), Type: "byte"},
+		)
+	case stackReference:
+		for i, addr := range c8.CallStack() {
+			vars = append(vars, variable{Name: fmt.Sprintf("#%d", i+1), Value: s.address(addr), Type: "address", MemoryReference: reference(addr)})
+		}
+	}
+
+	return vars
+}
+
+// address formats an address with its label, as in 0x204 <main+4>.
+func (s *Server) address(addr uint16) string {
+	if label := s.symbols.Label(addr); label != "" {
+		return fmt.Sprintf("%s <%s>", reference(addr), label)
+	}
+
+	return reference(addr)
+}
+
+func (s *Server) readMemory(args readMemoryArguments) (interface{}, error) {
+	base, err := s.parseReference(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+
+	start := int(base) + args.Offset
+	if start < 0 || start > 0xfff {
+		return map[string]interface{}{"address": reference(uint16(base)), "unreadableBytes": args.Count}, nil
+	}
+
+	count := args.Count
+	if start+count > 0x1000 {
+		count = 0x1000 - start
+	}
+
+	data := make([]byte, count)
+	for i := range data {
+		data[i] = s.state.Peek(uint16(start + i))
+	}
+
+	return map[string]interface{}{
+		"address":         reference(uint16(start)),
+		"data":            base64.StdEncoding.EncodeToString(data),
+		"unreadableBytes": args.Count - count,
+	}, nil
+}
+
+func (s *Server) disassemble(args disassembleArguments) (interface{}, error) {
+	base, err := s.parseReference(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+
+	instructions := []disassembledInstruction{}
+	addr := int(base) + args.Offset + 2*args.InstructionOffset
+	for i := 0; i < args.InstructionCount; i, addr = i+1, addr+2 {
+		if addr < 0 || addr > 0xffe {
+			instructions = append(instructions, disassembledInstruction{Address: fmt.Sprintf("0x%03x", addr&0xffff), Instruction: "??"})
+			continue
+		}
+
+		pc := uint16(addr)
+		op := s.state.OpAt(pc)
+		ins := disassembledInstruction{
+			Address:          reference(pc),
+			InstructionBytes: fmt.Sprintf("%02x %02x", op.B0, op.B1),
+			Instruction:      chip8.Mnemonic(op),
+		}
+		if sym, ok := s.symbols.Lookup(pc); ok && sym.Addr == pc {
+			ins.Symbol = sym.Name
+		}
+		if pos, ok := s.symbols.Source(pc); ok {
+			ins.Location = &source{Path: s.sourcePath(pos.File)}
+			ins.Line = pos.Line
+		}
+		instructions = append(instructions, ins)
+	}
+
+	return map[string]interface{}{"instructions": instructions}, nil
+}
+
+// next returns a function stepping over the instruction at PC, running
+// subroutines it calls to their return.
+func (s *Server) next() func() {
+	pc, sp := s.state.PC, s.state.SP
+	if s.state.OpAt(pc).B00 != 0x2 {
+		return func() { s.resume("step", func() bool { return true }) }
+	}
+
+	return func() {
+		s.resume("step", func() bool { return s.state.PC == pc+2 && s.state.SP == sp })
+	}
+}
+
+// stepOut returns a function running the program until the current
+// subroutine returns, or stepping over an instruction outside of any.
+func (s *Server) stepOut() func() {
+	sp := s.state.SP
+	if len(s.state.CallStack()) == 0 {
+		return s.next()
+	}
+
+	return func() {
+		s.resume("step", func() bool { return s.state.SP > sp })
+	}
+}
+
+// resume runs the program in the background until stop returns true, a
+// breakpoint is hit, the program halts or it is paused, and sends the event
+// saying so. The instruction at PC always executes, even if there is a
+// breakpoint on it.
+func (s *Server) resume(reason string, stop func() bool) {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	atomic.StoreInt32(&s.pausing, 0)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		first := true
+		for {
+			s.mu.Lock()
+			done := s.runBatch(&first, reason, stop)
+			if done != nil {
+				s.running = false
+			}
+			s.mu.Unlock()
+
+			if done != nil {
+				done()
+				return
+			}
+		}
+	}()
+}
+
+// runBatch executes up to batchSize instructions, returning a function
+// sending the events once the program stopped.
+func (s *Server) runBatch(first *bool, reason string, stop func() bool) func() {
+	perTick := s.state.ClockRate() / chip8.TimerRate
+	if perTick < 1 {
+		perTick = 1
+	}
+
+	for i := 0; i < batchSize; i++ {
+		if s.state.Halted() {
+			msg := fmt.Sprintf("Program halted at %s: %s\n", s.address(s.state.PC), s.halt.reason)
+			return func() {
+				s.event("output", map[string]interface{}{"category": "console", "output": msg})
+				s.event("exited", map[string]interface{}{"exitCode": 0})
+				s.event("terminated", nil)
+			}
+		}
+
+		if ids := s.breakpointsAt(s.state.PC); len(ids) > 0 && !*first {
+			return func() { s.stopped("breakpoint", ids) }
+		}
+		if atomic.LoadInt32(&s.pausing) != 0 {
+			return func() { s.stopped("pause", nil) }
+		}
+		*first = false
+
+		s.state.Step()
+		s.cycles++
+		if s.cycles%perTick == 0 {
+			s.state.Tick()
+		}
+
+		if stop != nil && stop() {
+			return func() { s.stopped(reason, nil) }
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) stopped(reason string, ids []int) {
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	}
+	if len(ids) > 0 {
+		body["hitBreakpointIds"] = ids
+	}
+
+	s.event("stopped", body)
+}
+
+// pause stops the running program and waits for it.
+func (s *Server) pause() {
+	atomic.StoreInt32(&s.pausing, 1)
+	s.wg.Wait()
+}
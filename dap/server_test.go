@@ -0,0 +1,334 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const program = `start:  LD V0, 1
+loop:   CALL inc
+        JP loop
+
+inc:    ADD V0, 1
+        RET
+`
+
+// client is a scripted DAP client, talking to a server running in the
+// background.
+type client struct {
+	t      *testing.T
+	w      *io.PipeWriter
+	r      *bufio.Reader
+	seq    int
+	events []map[string]interface{}
+	done   chan error
+}
+
+// start runs a server for the program assembled from src, written to a
+// temporary directory, and returns a client talking to it and the path of
+// the program.
+func start(t *testing.T, src string) (*client, string) {
+	dir, err := ioutil.TempDir("", "dap")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "game.asm")
+	require.NoError(t, ioutil.WriteFile(path, []byte(src), 0644))
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	s := NewServer(inR, outW)
+	s.Load = func(path string) (*chip8.State, *sym.Table, error) {
+		p, err := asm.AssembleFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		c8 := chip8.Init(nil)
+		c8.LoadProgram(p.ROM)
+
+		return &c8, sym.New(p.Labels, p.Lines), nil
+	}
+
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		err := s.Serve()
+		outW.Close()
+		c.done <- err
+	}()
+
+	return c, path
+}
+
+func (c *client) read() map[string]interface{} {
+	body, err := readMessage(c.r)
+	require.NoError(c.t, err)
+
+	var m map[string]interface{}
+	require.NoError(c.t, json.Unmarshal(body, &m))
+
+	return m
+}
+
+// call sends a request and returns the body of the response, failing the
+// test if it was unsuccessful. Events sent meanwhile are queued.
+func (c *client) call(command string, args interface{}) map[string]interface{} {
+	m := c.request(command, args)
+	require.Equal(c.t, true, m["success"], "%s: %v", command, m["message"])
+
+	body, _ := m["body"].(map[string]interface{})
+	return body
+}
+
+// request sends a request and returns its response.
+func (c *client) request(command string, args interface{}) map[string]interface{} {
+	c.seq++
+	require.NoError(c.t, writeMessage(c.w, map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": args,
+	}))
+
+	for {
+		m := c.read()
+		if m["type"] == "event" {
+			c.events = append(c.events, m)
+			continue
+		}
+		require.Equal(c.t, float64(c.seq), m["request_seq"])
+		return m
+	}
+}
+
+// event waits for the next event, which must be called name, and returns
+// its body.
+func (c *client) event(name string) map[string]interface{} {
+	var m map[string]interface{}
+	if len(c.events) > 0 {
+		m, c.events = c.events[0], c.events[1:]
+	} else {
+		m = c.read()
+	}
+	require.Equal(c.t, "event", m["type"])
+	require.Equal(c.t, name, m["event"], "%v", m["body"])
+
+	body, _ := m["body"].(map[string]interface{})
+	return body
+}
+
+// stopped waits for a stopped event and returns its reason.
+func (c *client) stopped() string {
+	return c.event("stopped")["reason"].(string)
+}
+
+// frames returns the names and lines of the frames of the stack.
+func (c *client) frames() []string {
+	var frames []string
+	for _, f := range c.call("stackTrace", map[string]int{"threadId": threadID})["stackFrames"].([]interface{}) {
+		f := f.(map[string]interface{})
+		frames = append(frames, f["name"].(string)+" "+f["instructionPointerReference"].(string))
+	}
+
+	return frames
+}
+
+// variables returns the values of the variables of a scope by name.
+func (c *client) variables(ref int) map[string]string {
+	vars := map[string]string{}
+	for _, v := range c.call("variables", map[string]int{"variablesReference": ref})["variables"].([]interface{}) {
+		v := v.(map[string]interface{})
+		vars[v["name"].(string)] = v["value"].(string)
+	}
+
+	return vars
+}
+
+func (c *client) disconnect() {
+	c.call("disconnect", nil)
+	require.NoError(c.t, <-c.done)
+}
+
+func TestServer(t *testing.T) {
+	c, path := start(t, program)
+
+	capabilities := c.call("initialize", map[string]string{"adapterID": "chip8"})
+	assert.Equal(t, true, capabilities["supportsReadMemoryRequest"])
+
+	c.call("launch", map[string]interface{}{"program": path, "stopOnEntry": true})
+	c.event("initialized")
+
+	breakpoints := c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 4}, {"line": 9}},
+	})["breakpoints"].([]interface{})
+	require.Len(t, breakpoints, 2)
+	bp := breakpoints[0].(map[string]interface{})
+	assert.Equal(t, true, bp["verified"])
+	// The empty line moves to the next line with code
+	assert.Equal(t, float64(5), bp["line"])
+	assert.Equal(t, "0x206", bp["instructionReference"])
+	assert.Equal(t, false, breakpoints[1].(map[string]interface{})["verified"])
+
+	c.call("configurationDone", nil)
+	assert.Equal(t, "entry", c.stopped())
+	assert.Equal(t, []string{"start 0x200"}, c.frames())
+
+	c.call("continue", map[string]int{"threadId": threadID})
+	stopped := c.event("stopped")
+	assert.Equal(t, "breakpoint", stopped["reason"])
+	assert.Equal(t, []interface{}{bp["id"]}, stopped["hitBreakpointIds"])
+
+	frames := c.call("stackTrace", map[string]int{"threadId": threadID})["stackFrames"].([]interface{})
+	require.Len(t, frames, 2)
+	frame := frames[0].(map[string]interface{})
+	assert.Equal(t, "inc", frame["name"])
+	assert.Equal(t, float64(5), frame["line"])
+	assert.Equal(t, path, frame["source"].(map[string]interface{})["path"])
+	frame = frames[1].(map[string]interface{})
+	assert.Equal(t, "loop", frame["name"])
+	assert.Equal(t, float64(2), frame["line"])
+
+	scopes := c.call("scopes", map[string]int{"frameId": 0})["scopes"].([]interface{})
+	assert.Len(t, scopes, 3)
+
+	registers := c.variables(registersReference)
+	assert.Equal(t, "0x01 (1)", registers["V0"])
+	assert.Equal(t, "0x206 <inc>", registers["PC"])
+	assert.Equal(t, map[string]string{"DT": "0", "ST": "0"}, c.variables(timersReference))
+	assert.Equal(t, map[string]string{"#1": "0x202 <loop>"}, c.variables(stackReference))
+
+	// The breakpoint does not stop the program stepping from it
+	c.call("stepIn", map[string]int{"threadId": threadID})
+	assert.Equal(t, "step", c.stopped())
+	assert.Equal(t, []string{"inc+2 0x208", "loop 0x202"}, c.frames())
+
+	c.call("stepOut", map[string]int{"threadId": threadID})
+	assert.Equal(t, "step", c.stopped())
+	assert.Equal(t, []string{"loop+2 0x204"}, c.frames())
+
+	c.call("next", map[string]int{"threadId": threadID})
+	assert.Equal(t, "step", c.stopped())
+	assert.Equal(t, []string{"loop 0x202"}, c.frames())
+
+	// Stepping over a call stops at breakpoints in the subroutine
+	c.call("next", map[string]int{"threadId": threadID})
+	assert.Equal(t, "breakpoint", c.stopped())
+
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{},
+	})
+	c.call("stepOut", map[string]int{"threadId": threadID})
+	assert.Equal(t, "step", c.stopped())
+	c.call("next", map[string]int{"threadId": threadID})
+	assert.Equal(t, "step", c.stopped())
+	c.call("next", map[string]int{"threadId": threadID})
+	assert.Equal(t, "step", c.stopped())
+	assert.Equal(t, []string{"loop+2 0x204"}, c.frames())
+	assert.Equal(t, "0x04 (4)", c.variables(registersReference)["V0"])
+
+	memory := c.call("readMemory", map[string]interface{}{"memoryReference": "start", "count": 4})
+	assert.Equal(t, map[string]interface{}{"address": "0x200", "data": "YAEiBg==", "unreadableBytes": float64(0)}, memory)
+	memory = c.call("readMemory", map[string]interface{}{"memoryReference": "0xffe", "count": 4})
+	assert.Equal(t, float64(2), memory["unreadableBytes"])
+
+	instructions := c.call("disassemble", map[string]interface{}{"memoryReference": "0x204", "instructionCount": 2})["instructions"].([]interface{})
+	require.Len(t, instructions, 2)
+	assert.Equal(t, map[string]interface{}{
+		"address": "0x206", "instructionBytes": "70 01", "instruction": "ADD V0, 0x01", "symbol": "inc",
+		"location": map[string]interface{}{"path": path}, "line": float64(5),
+	}, instructions[1])
+
+	breakpoints = c.call("setInstructionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]interface{}{{"instructionReference": "inc", "offset": 2}},
+	})["breakpoints"].([]interface{})
+	assert.Equal(t, float64(6), breakpoints[0].(map[string]interface{})["line"])
+	c.call("continue", map[string]int{"threadId": threadID})
+	assert.Equal(t, "breakpoint", c.stopped())
+	assert.Equal(t, []string{"inc+2 0x208", "loop 0x202"}, c.frames())
+
+	// Source and instruction breakpoints at the same address are replaced
+	// independently
+	breakpoints = c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 6}},
+	})["breakpoints"].([]interface{})
+	c.call("setInstructionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]interface{}{},
+	})
+	c.call("continue", map[string]int{"threadId": threadID})
+	stopped = c.event("stopped")
+	assert.Equal(t, "breakpoint", stopped["reason"])
+	assert.Equal(t, []interface{}{breakpoints[0].(map[string]interface{})["id"]}, stopped["hitBreakpointIds"])
+	assert.Equal(t, []string{"inc+2 0x208", "loop 0x202"}, c.frames())
+
+	c.disconnect()
+}
+
+func TestServer_pause(t *testing.T) {
+	c, path := start(t, "loop: ADD V0, 1\n      JP loop\n")
+
+	c.call("initialize", nil)
+	c.call("launch", map[string]interface{}{"program": path})
+	c.event("initialized")
+	c.call("configurationDone", nil)
+
+	c.call("pause", map[string]int{"threadId": threadID})
+	assert.Equal(t, "pause", c.stopped())
+
+	c.call("continue", map[string]int{"threadId": threadID})
+	c.call("pause", map[string]int{"threadId": threadID})
+	assert.Equal(t, "pause", c.stopped())
+
+	c.disconnect()
+}
+
+func TestServer_halt(t *testing.T) {
+	c, path := start(t, "start: LD V0, 1\nend:   JP end\n")
+
+	c.call("initialize", nil)
+	c.call("launch", map[string]interface{}{"program": path})
+	c.event("initialized")
+	c.call("configurationDone", nil)
+
+	// The messages of the emulator are output events, as stdout carries the
+	// protocol
+	assert.Equal(t, "Infinite loop found, exiting.\n", c.event("output")["output"])
+	assert.Equal(t, "Program halted at 0x202 <end>: infinite loop\n", c.event("output")["output"])
+	c.event("exited")
+	c.event("terminated")
+
+	c.disconnect()
+}
+
+func TestServer_errors(t *testing.T) {
+	c, path := start(t, program)
+
+	resp := c.request("stackTrace", nil)
+	assert.Equal(t, false, resp["success"])
+	assert.Equal(t, "no program launched", resp["message"])
+
+	resp = c.request("launch", map[string]string{"program": filepath.Join(filepath.Dir(path), "missing.asm")})
+	assert.Equal(t, false, resp["success"])
+
+	c.call("launch", map[string]string{"program": path})
+	c.event("initialized")
+
+	resp = c.request("evaluate", nil)
+	assert.Equal(t, `unsupported request "evaluate"`, resp["message"])
+
+	resp = c.request("readMemory", map[string]interface{}{"memoryReference": "nowhere", "count": 1})
+	assert.Equal(t, `invalid address "nowhere"`, resp["message"])
+
+	c.disconnect()
+}
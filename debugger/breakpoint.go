@@ -0,0 +1,263 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/expr"
+)
+
+type breakpointKind int
+
+const (
+	kindBreak breakpointKind = iota
+	kindLog
+	kindWatchRead
+	kindWatchWrite
+	kindWatchChange
+)
+
+// A breakpoint stops execution, or prints a message for log points. Break
+// and log points at an address trigger before the instruction there
+// executes. On an opcode pattern they trigger after a matching instruction
+// executed, so that their condition sees its result, as VF after DXYN.
+// Without a location they trigger when their condition becomes true.
+// Watchpoints trigger after an instruction read, wrote or changed what they
+// watch.
+type breakpoint struct {
+	id   int
+	kind breakpointKind
+
+	// Location of break and log points; either an address or an opcode
+	// pattern is set
+	addr    int
+	pattern string
+
+	// Label of the address, if it has one
+	label string
+
+	cond    *expr.Expr
+	message logMessage
+
+	// Watched address of read and write watchpoints, and watched expression
+	// of change watchpoints with its last value
+	watchAddr uint16
+	watch     *expr.Expr
+	value     int
+
+	// Value of the condition when last checked, for points without location
+	active bool
+
+	hits   int
+	ignore int
+}
+
+// newOpBreakpoint creates a breakpoint on every opcode matching pattern. A
+// pattern has four nibbles; hex digits must match exactly, while X, Y, N, K,
+// ? and _ match any value, as in DXYN or 8XY6.
+func newOpBreakpoint(id int, pattern string) (*breakpoint, error) {
+	pattern = strings.ToUpper(pattern)
+	if len(pattern) != 4 {
+		return nil, fmt.Errorf("opcode pattern %q must have 4 nibbles", pattern)
+	}
+
+	for _, r := range pattern {
+		if !isHex(r) && !strings.ContainsRune("XYNK?_", r) {
+			return nil, fmt.Errorf("invalid nibble %q in opcode pattern %q", r, pattern)
+		}
+	}
+
+	return &breakpoint{id: id, addr: -1, pattern: pattern}, nil
+}
+
+func (b *breakpoint) hasLocation() bool {
+	return b.addr >= 0 || b.pattern != ""
+}
+
+func (b *breakpoint) matches(pc uint16, op chip8.OpCode) bool {
+	if b.pattern == "" {
+		return int(pc) == b.addr
+	}
+
+	nibbles := [4]byte{op.B00, op.B01, op.B10, op.B11}
+	for i, r := range b.pattern {
+		if isHex(r) && hexValue(r) != nibbles[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// afterInstruction reports whether a break or log point triggers after the
+// instruction it matches executed rather than before.
+func (b *breakpoint) afterInstruction() bool {
+	return b.pattern != ""
+}
+
+// triggered reports whether a break or log point triggers for the
+// instruction op at pc, before it is executed or, for points triggering
+// after the instruction, after.
+func (b *breakpoint) triggered(s *chip8.State, pc uint16, op chip8.OpCode) bool {
+	if !b.hasLocation() {
+		active := b.cond.Eval(s) != 0
+		edge := active && !b.active
+		b.active = active
+
+		return edge
+	}
+
+	if !b.matches(pc, op) {
+		return false
+	}
+
+	return b.cond == nil || b.cond.Eval(s) != 0
+}
+
+// hit counts a trigger and reports whether it is past the ignore count.
+func (b *breakpoint) hit() bool {
+	b.hits++
+	return b.hits > b.ignore
+}
+
+func (b *breakpoint) location() string {
+	switch {
+	case b.pattern != "":
+		return fmt.Sprintf("opcode %s", b.pattern)
+	case b.addr >= 0 && b.label != "":
+		return fmt.Sprintf("address 0x%03x <%s>", b.addr, b.label)
+	case b.addr >= 0:
+		return fmt.Sprintf("address 0x%03x", b.addr)
+	}
+
+	return fmt.Sprintf("when %s", b.cond)
+}
+
+func (b *breakpoint) String() string {
+	var s string
+	switch b.kind {
+	case kindBreak:
+		s = b.location()
+	case kindLog:
+		s = fmt.Sprintf("log %q at %s", b.message.src, b.location())
+	case kindWatchRead:
+		s = fmt.Sprintf("read watch 0x%03x", b.watchAddr)
+	case kindWatchWrite:
+		s = fmt.Sprintf("write watch 0x%03x", b.watchAddr)
+	case kindWatchChange:
+		s = fmt.Sprintf("change watch %s", b.watch)
+	}
+
+	if b.cond != nil && (b.kind != kindBreak && b.kind != kindLog || b.hasLocation()) {
+		s += fmt.Sprintf(" if %s", b.cond)
+	}
+
+	return s
+}
+
+// A logMessage is a message with embedded expressions, such as
+// "V3={V3} I={I:03x}". The text after the colon is a fmt verb; it defaults to
+// d. Literal braces are written as {{ and }}.
+type logMessage struct {
+	src   string
+	parts []logPart
+}
+
+type logPart struct {
+	text string
+	expr *expr.Expr
+	verb string
+}
+
+func parseLogMessage(src string) (logMessage, error) {
+	m := logMessage{src: src}
+
+	var text strings.Builder
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case (c == '{' || c == '}') && i+1 < len(src) && src[i+1] == c:
+			text.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return m, fmt.Errorf("column %d: unterminated '{'", i+1)
+			}
+
+			inner := src[i+1 : i+end]
+			verb := "d"
+			if colon := strings.IndexByte(inner, ':'); colon >= 0 {
+				inner, verb = inner[:colon], inner[colon+1:]
+			}
+
+			e, err := expr.Parse(inner)
+			if err != nil {
+				return m, fmt.Errorf("in {%s}: %s", inner, err)
+			}
+
+			m.parts = append(m.parts, logPart{text: text.String()}, logPart{expr: e, verb: "%" + verb})
+			text.Reset()
+			i += end
+		case c == '}':
+			return m, fmt.Errorf("column %d: unexpected '}'", i+1)
+		default:
+			text.WriteByte(c)
+		}
+	}
+	m.parts = append(m.parts, logPart{text: text.String()})
+
+	return m, nil
+}
+
+func (m logMessage) format(s *chip8.State) string {
+	var sb strings.Builder
+	for _, p := range m.parts {
+		if p.expr == nil {
+			sb.WriteString(p.text)
+			continue
+		}
+		fmt.Fprintf(&sb, p.verb, p.expr.Eval(s))
+	}
+
+	return sb.String()
+}
+
+// watcher reports memory accesses to the read and write watchpoints.
+type watcher struct {
+	d *Debugger
+}
+
+type watchHit struct {
+	bp       *breakpoint
+	old, new byte
+}
+
+func (w watcher) OnMemoryRead(addr uint16, value byte) {
+	for _, bp := range w.d.breakpoints {
+		if bp.kind == kindWatchRead && bp.watchAddr == addr {
+			w.d.watchHits = append(w.d.watchHits, watchHit{bp, value, value})
+		}
+	}
+}
+
+func (w watcher) OnMemoryWrite(addr uint16, value byte) {
+	for _, bp := range w.d.breakpoints {
+		if bp.kind == kindWatchWrite && bp.watchAddr == addr {
+			w.d.watchHits = append(w.d.watchHits, watchHit{bp, w.d.state.Peek(addr), value})
+		}
+	}
+}
+
+func isHex(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'A' && r <= 'F'
+}
+
+func hexValue(r rune) byte {
+	if r <= '9' {
+		return byte(r - '0')
+	}
+
+	return byte(r-'A') + 10
+}
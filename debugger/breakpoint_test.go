@@ -0,0 +1,26 @@
+package debugger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+func TestParseLogMessage(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.V[0x3] = 10
+	c8.I = 0x2a
+
+	m, err := parseLogMessage("V3={V3} I={I:03x} {{literal}}")
+	require.NoError(t, err)
+	assert.Equal(t, "V3=10 I=02a {literal}", m.format(&c8))
+
+	_, err = parseLogMessage("V3={V3")
+	assert.Error(t, err)
+
+	_, err = parseLogMessage("{V3 +}")
+	assert.Error(t, err)
+}
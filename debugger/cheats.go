@@ -0,0 +1,134 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/janezkenda/chip8/cheat"
+)
+
+// Number of search candidates listed
+const maxCandidates = 16
+
+// SetCheats sets the cheats of the program, applied from the next
+// instruction, and the file the cheat save command writes them to.
+func (d *Debugger) SetCheats(cheats []cheat.Cheat, path string) error {
+	for _, c := range cheats {
+		if err := d.cheats.Add(c); err != nil {
+			return err
+		}
+	}
+	d.cheatPath = path
+
+	return nil
+}
+
+func (d *Debugger) cmdSearch(args []string) error {
+	if len(args) == 0 {
+		d.search = cheat.NewSearch(d.state)
+		fmt.Fprintf(d.out, "%d candidates\n", len(d.search.Candidates()))
+		return nil
+	}
+	if d.search == nil {
+		return errors.New("no search started (try search)")
+	}
+
+	c, err := cheat.ParseComparison(args[0])
+	if err != nil {
+		return err
+	}
+
+	var value int
+	if c == cheat.Equal {
+		if len(args) != 2 {
+			return fmt.Errorf("usage: search eq value")
+		}
+		if value, err = parseNumber(args[1]); err != nil {
+			return err
+		}
+		if value < 0 || value > 0xff {
+			return fmt.Errorf("value %d is not a byte", value)
+		}
+	} else if len(args) != 1 {
+		return fmt.Errorf("usage: search %s", args[0])
+	}
+
+	n := d.search.Filter(c, byte(value))
+	fmt.Fprintf(d.out, "%d candidates\n", n)
+	if n <= maxCandidates {
+		for _, addr := range d.search.Candidates() {
+			fmt.Fprintf(d.out, "%s = 0x%02x\n", d.addr(addr), d.state.Peek(addr))
+		}
+	}
+
+	return nil
+}
+
+func (d *Debugger) cmdCheat(args []string) error {
+	if len(args) == 0 {
+		cheats := d.cheats.Cheats()
+		if len(cheats) == 0 {
+			fmt.Fprintln(d.out, "No cheats")
+		}
+		for i, c := range cheats {
+			fmt.Fprintf(d.out, "%d: %s\n", i+1, c)
+		}
+		return nil
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		if len(args) != 4 {
+			return fmt.Errorf("usage: cheat add name addr|register value")
+		}
+		c := cheat.Cheat{Name: args[1], Enabled: true}
+		if c.Target, err = cheat.ParseTarget(args[2]); err != nil {
+			if addr, symErr := d.parseAddress(args[2]); symErr == nil {
+				c.Target, err = cheat.Target{Register: -1, Addr: addr}, nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+		value, err := parseNumber(args[3])
+		if err != nil {
+			return err
+		}
+		if value < 0 || value > 0xff {
+			return fmt.Errorf("value %d is not a byte", value)
+		}
+		c.Value = byte(value)
+		if err := d.cheats.Add(c); err != nil {
+			return err
+		}
+	case "on", "off":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: cheat %s name", args[0])
+		}
+		err = d.cheats.Enable(args[1], args[0] == "on")
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: cheat delete name")
+		}
+		err = d.cheats.Remove(args[1])
+	case "save":
+		if d.cheatPath == "" {
+			return errors.New("no cheat file for this program")
+		}
+		if err := cheat.Save(d.cheatPath, d.cheats.Cheats()); err != nil {
+			return err
+		}
+		fmt.Fprintf(d.out, "Cheats saved to %s\n", d.cheatPath)
+		return nil
+	default:
+		return fmt.Errorf("unknown cheat command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	d.journal.amend(d.cheats.Apply)
+
+	return nil
+}
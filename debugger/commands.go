@@ -0,0 +1,760 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/expr"
+	"github.com/janezkenda/chip8/provenance"
+)
+
+type command struct {
+	names []string
+	usage string
+	help  string
+	run   func(d *Debugger, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{[]string{"step", "s"}, "step [n]", "Execute n instructions", (*Debugger).cmdStep},
+		{[]string{"next", "n"}, "next", "Execute one instruction, stepping over subroutine calls", (*Debugger).cmdNext},
+		{[]string{"finish", "fin"}, "finish", "Run until the current subroutine returns", (*Debugger).cmdFinish},
+		{[]string{"continue", "c"}, "continue", "Run until a breakpoint is hit or the program halts", (*Debugger).cmdContinue},
+		{[]string{"reverse-step", "rs"}, "reverse-step [n]", "Undo n instructions", (*Debugger).cmdReverseStep},
+		{[]string{"reverse-next", "rn"}, "reverse-next", "Undo one instruction, stepping back over subroutine calls", (*Debugger).cmdReverseNext},
+		{[]string{"reverse-continue", "rc"}, "reverse-continue", "Undo instructions until a breakpoint, write watchpoint or change watchpoint is hit", (*Debugger).cmdReverseContinue},
+		{[]string{"break", "b"}, "break [addr | op pattern] [if expr]", "Set a breakpoint at an address or label, on an opcode pattern such as DXYN, stopping after the matching instruction so that expr sees its result, or for when expr becomes true; list breakpoints without arguments", (*Debugger).cmdBreak},
+		{[]string{"watch", "w"}, "watch [read | write] addr [if expr]", "Stop when the byte at addr is read or written", (*Debugger).cmdWatch},
+		{[]string{"watch", "w"}, "watch expr [if expr]", "Stop when the value of expr, such as mem[0x3a0] or V3, changes", nil},
+		{[]string{"log"}, "log [addr | op pattern] \"message\" [if expr]", "Print message, with {expr} or {expr:x} expanded, without stopping", (*Debugger).cmdLog},
+		{[]string{"condition"}, "condition id [expr]", "Set or remove the condition of a breakpoint", (*Debugger).cmdCondition},
+		{[]string{"ignore"}, "ignore id n", "Ignore the next n hits of a breakpoint", (*Debugger).cmdIgnore},
+		{[]string{"delete", "d"}, "delete [id...]", "Delete the given breakpoints, or all of them", (*Debugger).cmdDelete},
+		{[]string{"print", "p"}, "print [register | stack]", "Print registers, timers and the call stack", (*Debugger).cmdPrint},
+		{[]string{"stack", "bt"}, "stack", "Print the call stack", (*Debugger).cmdStack},
+		{[]string{"x"}, "x[/s] addr [n]", "Examine n bytes of memory as hex, or n rows as a sprite bitmap with /s", (*Debugger).cmdExamine},
+		{[]string{"who-wrote"}, "who-wrote addr", "Show the instruction which last wrote the byte at addr", (*Debugger).cmdWhoWrote},
+		{[]string{"who-drew"}, "who-drew x,y", "Show the instruction which last turned the pixel at x,y on or off", (*Debugger).cmdWhoDrew},
+		{[]string{"set"}, "set register|addr value...", "Set a register, a timer or bytes of memory", (*Debugger).cmdSet},
+		{[]string{"disas", "l"}, "disas [addr] [n]", "Disassemble n instructions from addr, or around PC", (*Debugger).cmdDisas},
+		{[]string{"search"}, "search [eq value | changed | unchanged | inc | dec]", "Start a search of memory for a variable, or keep the bytes equal to value or compared to the last search; run the program between searches", (*Debugger).cmdSearch},
+		{[]string{"cheat"}, "cheat [add name addr|register value]", "List the cheats, or add one freezing a byte of memory or a V register every frame", (*Debugger).cmdCheat},
+		{[]string{"cheat"}, "cheat on|off|delete name | save", "Enable, disable or delete a cheat, or save the cheats of the program", nil},
+		{[]string{"key", "k"}, "key [key [up]]", "Press or release a key, or list pressed keys", (*Debugger).cmdKey},
+		{[]string{"screen"}, "screen [on | off]", "Render the screen, or toggle rendering it when execution stops", (*Debugger).cmdScreen},
+		{[]string{"help", "h", "?"}, "help", "Show this help", (*Debugger).cmdHelp},
+		{[]string{"quit", "q"}, "quit", "Exit the debugger", (*Debugger).cmdQuit},
+	}
+}
+
+func lookup(name string) *command {
+	for i, cmd := range commands {
+		for _, n := range cmd.names {
+			if n == name && cmd.run != nil {
+				return &commands[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *Debugger) cmdStep(args []string) error {
+	n := 1
+	if len(args) > 0 {
+		v, err := parseNumber(args[0])
+		if err != nil {
+			return err
+		}
+		if v < 1 {
+			return fmt.Errorf("cannot step %d instructions", v)
+		}
+		n = v
+	}
+	if d.state.Halted() {
+		return errHalted
+	}
+
+	d.run(func() bool {
+		n--
+		return n <= 0
+	})
+
+	return nil
+}
+
+func (d *Debugger) cmdNext(args []string) error {
+	if d.state.Halted() {
+		return errHalted
+	}
+
+	op := d.state.OpAt(d.state.PC)
+	if op.B00 != 0x2 {
+		d.run(func() bool { return true })
+		return nil
+	}
+
+	ret, sp := d.state.PC+2, d.state.SP
+	d.run(func() bool {
+		return d.state.PC == ret && d.state.SP == sp
+	})
+
+	return nil
+}
+
+func (d *Debugger) cmdFinish(args []string) error {
+	if d.state.Halted() {
+		return errHalted
+	}
+
+	sp := d.state.SP
+	if sp >= stackBase {
+		return fmt.Errorf("not in a subroutine")
+	}
+
+	d.run(func() bool {
+		return d.state.SP > sp
+	})
+
+	return nil
+}
+
+func (d *Debugger) cmdContinue(args []string) error {
+	if d.state.Halted() {
+		return errHalted
+	}
+
+	d.run(nil)
+
+	return nil
+}
+
+func (d *Debugger) cmdBreak(args []string) error {
+	if len(args) == 0 {
+		if len(d.breakpoints) == 0 {
+			fmt.Fprintln(d.out, "No breakpoints")
+		}
+		for _, bp := range d.breakpoints {
+			fmt.Fprintf(d.out, "%d: %s", bp.id, bp)
+			if bp.hits > 0 || bp.ignore > 0 {
+				fmt.Fprintf(d.out, " (%d hits, ignoring %d)", bp.hits, bp.ignore)
+			}
+			fmt.Fprintln(d.out)
+		}
+		return nil
+	}
+
+	bp, rest, err := d.parseLocation(args)
+	if err != nil {
+		return err
+	}
+
+	if bp.cond, err = parseCondition(rest); err != nil {
+		return err
+	}
+	if !bp.hasLocation() && bp.cond == nil {
+		return fmt.Errorf("usage: break [addr | op pattern] [if expr]")
+	}
+
+	bp.kind = kindBreak
+	d.addBreakpoint(bp)
+
+	return nil
+}
+
+func (d *Debugger) cmdWatch(args []string) error {
+	bp := &breakpoint{addr: -1, kind: kindWatchChange}
+	if len(args) > 0 {
+		switch args[0] {
+		case "read":
+			bp.kind = kindWatchRead
+			args = args[1:]
+		case "write":
+			bp.kind = kindWatchWrite
+			args = args[1:]
+		}
+	}
+
+	target := args
+	for i, arg := range args {
+		if arg == "if" {
+			target = args[:i]
+			break
+		}
+	}
+	if len(target) == 0 {
+		return fmt.Errorf("usage: watch [read | write] addr [if expr]")
+	}
+
+	var err error
+	if bp.cond, err = parseCondition(args[len(target):]); err != nil {
+		return err
+	}
+
+	addr, err := d.parseAddress(strings.Join(target, ""))
+	if bp.kind != kindWatchChange {
+		if err != nil {
+			return err
+		}
+		bp.watchAddr = addr
+	} else {
+		src := strings.Join(target, " ")
+		if err == nil {
+			src = fmt.Sprintf("mem[0x%03x]", addr)
+		}
+
+		if bp.watch, err = expr.Parse(src); err != nil {
+			return err
+		}
+		bp.value = bp.watch.Eval(d.state)
+	}
+
+	d.addBreakpoint(bp)
+
+	return nil
+}
+
+func (d *Debugger) cmdLog(args []string) error {
+	bp, _, err := d.parseLocation(args)
+	if err != nil {
+		return err
+	}
+
+	// The message is quoted, so it is taken from the raw arguments to keep
+	// its spacing
+	raw := d.rawArgs
+	start := strings.IndexByte(raw, '"')
+	end := strings.LastIndexByte(raw, '"')
+	if start < 0 || end == start {
+		return fmt.Errorf("usage: log [addr | op pattern] \"message\" [if expr]")
+	}
+
+	if bp.message, err = parseLogMessage(raw[start+1 : end]); err != nil {
+		return err
+	}
+	if bp.cond, err = parseCondition(strings.Fields(raw[end+1:])); err != nil {
+		return err
+	}
+	if !bp.hasLocation() && bp.cond == nil {
+		return fmt.Errorf("a log point needs a location or a condition")
+	}
+
+	bp.kind = kindLog
+	d.addBreakpoint(bp)
+
+	return nil
+}
+
+func (d *Debugger) cmdCondition(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: condition id [expr]")
+	}
+
+	bp, err := d.breakpoint(args[0])
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		if !bp.hasLocation() && (bp.kind == kindBreak || bp.kind == kindLog) {
+			return fmt.Errorf("breakpoint %d has no location, its condition cannot be removed", bp.id)
+		}
+		bp.cond = nil
+		return nil
+	}
+
+	cond, err := expr.Parse(strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+	bp.cond = cond
+	bp.active = false
+
+	return nil
+}
+
+func (d *Debugger) cmdIgnore(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: ignore id n")
+	}
+
+	bp, err := d.breakpoint(args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := parseNumber(args[1])
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("cannot ignore %d hits", n)
+	}
+	bp.ignore = bp.hits + n
+
+	return nil
+}
+
+func (d *Debugger) cmdDelete(args []string) error {
+	defer d.updateWatcher()
+
+	if len(args) == 0 {
+		d.breakpoints = nil
+		return nil
+	}
+
+	for _, arg := range args {
+		bp, err := d.breakpoint(arg)
+		if err != nil {
+			return err
+		}
+
+		for i := range d.breakpoints {
+			if d.breakpoints[i] == bp {
+				d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *Debugger) breakpoint(arg string) (*breakpoint, error) {
+	id, err := parseNumber(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bp := range d.breakpoints {
+		if bp.id == id {
+			return bp, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no breakpoint %d", id)
+}
+
+// parseLocation parses an optional address or opcode pattern at the start of
+// args, returning a breakpoint at that location and the remaining arguments.
+func (d *Debugger) parseLocation(args []string) (*breakpoint, []string, error) {
+	if len(args) == 0 {
+		return &breakpoint{addr: -1}, args, nil
+	}
+
+	if args[0] == "op" {
+		if len(args) < 2 {
+			return nil, nil, fmt.Errorf("missing opcode pattern")
+		}
+
+		bp, err := newOpBreakpoint(0, args[1])
+		return bp, args[2:], err
+	}
+
+	if args[0] == "if" || strings.HasPrefix(args[0], `"`) {
+		return &breakpoint{addr: -1}, args, nil
+	}
+
+	addr, err := d.parseAddress(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &breakpoint{addr: int(addr)}, args[1:], nil
+}
+
+// parseCondition parses an optional "if expr" clause.
+func parseCondition(args []string) (*expr.Expr, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if args[0] != "if" || len(args) == 1 {
+		return nil, fmt.Errorf("expected if expr, got %q", strings.Join(args, " "))
+	}
+
+	return expr.Parse(strings.Join(args[1:], " "))
+}
+
+func (d *Debugger) cmdPrint(args []string) error {
+	if len(args) == 0 {
+		d.printRegisters()
+		return d.cmdStack(nil)
+	}
+
+	for _, arg := range args {
+		if arg == "stack" {
+			if err := d.cmdStack(nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		reg, err := d.register(arg)
+		if err != nil {
+			return err
+		}
+		v := reg.get()
+		fmt.Fprintf(d.out, "%s = 0x%0*x (%d)\n", reg.name, reg.width, v, v)
+	}
+
+	return nil
+}
+
+func (d *Debugger) printRegisters() {
+	s := d.state
+	for i, v := range s.V {
+		sep := " "
+		if i%8 == 7 {
+			sep = "\n"
+		}
+		fmt.Fprintf(d.out, "V%X=%02x%s", i, v, sep)
+	}
+	fmt.Fprintf(d.out, "I=%03x SP=%03x PC=%03x DT=%02x ST=%02x\n", s.I, s.SP, s.PC, s.DelayTimer(), s.SoundTimer())
+}
+
+func (d *Debugger) cmdStack(args []string) error {
+	fmt.Fprintf(d.out, "#0 %s%s\n", d.addr(d.state.PC), d.sourcePos(d.state.PC))
+	for i, addr := range d.state.CallStack() {
+		fmt.Fprintf(d.out, "#%d %s %s%s\n", i+1, d.addr(addr), chip8.DescribeOp(d.state.OpAt(addr)), d.sourcePos(addr))
+	}
+
+	return nil
+}
+
+// sourcePos returns the source position of addr as " at file:line", or an
+// empty string when it is unknown.
+func (d *Debugger) sourcePos(addr uint16) string {
+	pos, ok := d.symbols.Source(addr)
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf(" at %s:%d", pos.File, pos.Line)
+}
+
+func (d *Debugger) cmdExamine(args []string) error {
+	sprite := false
+	if len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		switch args[0] {
+		case "/x":
+		case "/s":
+			sprite = true
+		default:
+			return fmt.Errorf("unknown format %q", args[0])
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: x[/s] addr [n]")
+	}
+
+	addr, err := d.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+
+	n := 16
+	if sprite {
+		n = 15
+	}
+	if len(args) > 1 {
+		if n, err = parseNumber(args[1]); err != nil {
+			return err
+		}
+	}
+
+	if sprite {
+		for i := 0; i < n; i++ {
+			a := addr + uint16(i)
+			row := d.state.Peek(a)
+			fmt.Fprintf(d.out, "0x%03x: %08b %s\n", a&0xfff, row, renderSprite(row))
+		}
+		return nil
+	}
+
+	for i := 0; i < n; i++ {
+		a := addr + uint16(i)
+		if i%16 == 0 {
+			if i > 0 {
+				fmt.Fprintln(d.out)
+			}
+			fmt.Fprintf(d.out, "0x%03x:", a&0xfff)
+		}
+		fmt.Fprintf(d.out, " %02x", d.state.Peek(a))
+	}
+	fmt.Fprintln(d.out)
+
+	return nil
+}
+
+func (d *Debugger) cmdSet(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: set register|addr value...")
+	}
+
+	if reg, err := d.register(args[0]); err == nil {
+		if len(args) != 2 {
+			return fmt.Errorf("usage: set %s value", reg.name)
+		}
+
+		v, err := parseNumber(args[1])
+		if err != nil {
+			return err
+		}
+		if v < 0 || v >= 1<<(4*reg.width) {
+			return fmt.Errorf("value 0x%x does not fit in %s", v, reg.name)
+		}
+		reg.set(v)
+
+		return nil
+	}
+
+	addr, err := d.parseAddress(args[0])
+	if err != nil {
+		return fmt.Errorf("%q is neither a register nor an address", args[0])
+	}
+
+	for i, arg := range args[1:] {
+		v, err := parseNumber(arg)
+		if err != nil {
+			return err
+		}
+		if v < 0 || v > 0xff {
+			return fmt.Errorf("value 0x%x does not fit in a byte", v)
+		}
+		d.state.Poke(addr+uint16(i), byte(v))
+	}
+
+	return nil
+}
+
+func (d *Debugger) cmdDisas(args []string) error {
+	pc := d.state.PC
+	addr, n := pc, 9
+	if pc >= 8 {
+		addr = pc - 8
+	}
+
+	if len(args) > 0 {
+		a, err := d.parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		addr = a
+	}
+	if len(args) > 1 {
+		v, err := parseNumber(args[1])
+		if err != nil {
+			return err
+		}
+		n = v
+	}
+
+	for i := 0; i < n && addr < 0xfff; i++ {
+		marker := "  "
+		if addr == pc {
+			marker = "=>"
+		}
+		if d.breakpointAt(addr) != nil {
+			marker = marker[:1] + "*"
+		}
+
+		if s, ok := d.symbols.Lookup(addr); ok && s.Addr == addr {
+			fmt.Fprintf(d.out, "%s:\n", s.Name)
+		}
+
+		op := d.state.OpAt(addr)
+		fmt.Fprintf(d.out, "%s 0x%03x %s %s\n", marker, addr, op, chip8.DescribeOp(op))
+		addr += 2
+	}
+
+	return nil
+}
+
+// describeWrite formats when a write happened, and the instruction which
+// did it with the line of source it was built from.
+func (d *Debugger) describeWrite(w provenance.Write) string {
+	return fmt.Sprintf("in cycle %d (frame %d) by %s %s %s%s", w.Cycle, w.Frame, d.addr(w.PC), w.Op, chip8.DescribeOp(w.Op), d.sourcePos(w.PC))
+}
+
+func (d *Debugger) cmdWhoWrote(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: who-wrote addr")
+	}
+
+	addr, err := d.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+
+	w, ok := d.provenance.WhoWrote(addr)
+	if !ok {
+		fmt.Fprintf(d.out, "0x%03x = 0x%02x has not been written\n", addr, d.state.Peek(addr))
+		return nil
+	}
+	fmt.Fprintf(d.out, "0x%03x = 0x%02x, written %s\n", addr, d.state.Peek(addr), d.describeWrite(w))
+
+	return nil
+}
+
+func (d *Debugger) cmdWhoDrew(args []string) error {
+	coords := strings.FieldsFunc(strings.Join(args, ","), func(r rune) bool { return r == ',' })
+	if len(coords) != 2 {
+		return fmt.Errorf("usage: who-drew x,y")
+	}
+
+	x, err := parseNumber(coords[0])
+	if err != nil {
+		return err
+	}
+	y, err := parseNumber(coords[1])
+	if err != nil {
+		return err
+	}
+	if x < 0 || x >= screenWidth || y < 0 || y >= screenHeight {
+		return fmt.Errorf("pixel %d,%d is off the screen", x, y)
+	}
+
+	lit := "off"
+	if d.state.Pixel(x, y) {
+		lit = "on"
+	}
+
+	w, ok := d.provenance.WhoDrew(x, y)
+	if !ok {
+		fmt.Fprintf(d.out, "Pixel %d,%d is %s and has not been drawn\n", x, y, lit)
+		return nil
+	}
+	fmt.Fprintf(d.out, "Pixel %d,%d is %s, turned %s %s\n", x, y, lit, lit, d.describeWrite(w))
+
+	return nil
+}
+
+func (d *Debugger) cmdKey(args []string) error {
+	if len(args) == 0 {
+		var pressed []string
+		for k := byte(0); k < 16; k++ {
+			if d.state.Key(k) {
+				pressed = append(pressed, fmt.Sprintf("%X", k))
+			}
+		}
+		fmt.Fprintf(d.out, "Pressed keys: %s\n", strings.Join(pressed, " "))
+		return nil
+	}
+
+	k, err := strconv.ParseUint(args[0], 16, 4)
+	if err != nil {
+		return fmt.Errorf("invalid key %q", args[0])
+	}
+
+	pressed := true
+	if len(args) > 1 {
+		switch args[1] {
+		case "down":
+		case "up":
+			pressed = false
+		default:
+			return fmt.Errorf("usage: key [key [up]]")
+		}
+	}
+	d.state.SetKey(byte(k), pressed)
+
+	return nil
+}
+
+func (d *Debugger) cmdScreen(args []string) error {
+	if len(args) == 0 {
+		renderScreen(d.out, d.state)
+		return nil
+	}
+
+	switch args[0] {
+	case "on":
+		d.screen = true
+	case "off":
+		d.screen = false
+	default:
+		return fmt.Errorf("usage: screen [on | off]")
+	}
+
+	return nil
+}
+
+func (d *Debugger) cmdHelp(args []string) error {
+	for _, cmd := range commands {
+		fmt.Fprintf(d.out, "%-44s %s\n", cmd.usage, cmd.help)
+	}
+
+	fmt.Fprintln(d.out)
+	fmt.Fprintln(d.out, "Expressions use C operators over V0-VF, I, SP, PC, DT, ST, K0-KF (1 while the key")
+	fmt.Fprintln(d.out, "is pressed) and mem[addr], for example: break if V3 == 5 && I > 0x300")
+
+	return nil
+}
+
+func (d *Debugger) cmdQuit(args []string) error {
+	d.quit = true
+	return nil
+}
+
+type register struct {
+	name  string
+	width int // in hex digits
+	get   func() int
+	set   func(int)
+}
+
+func (d *Debugger) register(name string) (*register, error) {
+	s := d.state
+	upper := strings.ToUpper(name)
+
+	if len(upper) == 2 && upper[0] == 'V' && isHex(rune(upper[1])) {
+		i := hexValue(rune(upper[1]))
+		return &register{upper, 2, func() int { return int(s.V[i]) }, func(v int) { s.V[i] = byte(v) }}, nil
+	}
+
+	switch upper {
+	case "I":
+		return &register{upper, 3, func() int { return int(s.I) }, func(v int) { s.I = uint16(v) }}, nil
+	case "SP":
+		return &register{upper, 3, func() int { return int(s.SP) }, func(v int) { s.SP = uint16(v) }}, nil
+	case "PC":
+		return &register{upper, 3, func() int { return int(s.PC) }, func(v int) { s.PC = uint16(v) }}, nil
+	case "DT":
+		return &register{upper, 2, func() int { return int(s.DelayTimer()) }, func(v int) { s.SetDelayTimer(byte(v)) }}, nil
+	case "ST":
+		return &register{upper, 2, func() int { return int(s.SoundTimer()) }, func(v int) { s.SetSoundTimer(byte(v)) }}, nil
+	}
+
+	return nil, fmt.Errorf("unknown register %q", name)
+}
+
+func parseNumber(s string) (int, error) {
+	v, err := strconv.ParseInt(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	return int(v), nil
+}
+
+// parseAddress parses an address, given as a number or as the name of a
+// symbol.
+func (d *Debugger) parseAddress(s string) (uint16, error) {
+	if addr, ok := d.symbols.Addr(s); ok {
+		return addr, nil
+	}
+
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil || v > 0xfff {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+
+	return uint16(v), nil
+}
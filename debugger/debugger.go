@@ -0,0 +1,418 @@
+// Package debugger implements an interactive monitor for CHIP-8 programs.
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/cheat"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/provenance"
+	"github.com/janezkenda/chip8/sym"
+)
+
+// Bottom of the stack, as set up by chip8.Init
+const stackBase = 0xefe
+
+type Debugger struct {
+	state *chip8.State
+	out   io.Writer
+
+	breakpoints []*breakpoint
+	nextID      int
+
+	// Memory accesses seen by the read and write watchpoints during the
+	// current instruction
+	watchHits []watchHit
+	watcher   *watcher
+
+	// Last writes to memory and the display, for who-wrote and who-drew
+	provenance *provenance.Tracker
+
+	// Changes made by the instructions executed, to reverse them
+	journal *journal
+
+	// Cheats freezing memory and registers, the file they are saved to,
+	// and the memory search in progress
+	cheats    *cheat.Engine
+	cheatPath string
+	search    *cheat.Search
+
+	// Number of executed instructions, used to drive the timers
+	cycles int
+
+	// Render the screen whenever execution stops
+	screen bool
+
+	interrupted int32
+	last        string
+	quit        bool
+
+	// Arguments of the current command, before they were split into fields
+	rawArgs string
+
+	// Symbols and source of the program, and the lines of the source files
+	// read so far
+	symbols *sym.Table
+	files   map[string][]string
+}
+
+func New(state *chip8.State, out io.Writer) *Debugger {
+	d := &Debugger{
+		state:      state,
+		out:        out,
+		nextID:     1,
+		screen:     true,
+		provenance: provenance.NewTracker(state),
+		cheats:     cheat.NewEngine(state, nil),
+	}
+	d.journal = &journal{state: state, tracker: d.provenance}
+	d.cheats.SetPoke(d.journal.poke)
+
+	// The journal saves what the tracker recorded before it records a write
+	state.AddHook(d.journal)
+	state.AddHook(d.provenance)
+	state.AddHook(d.cheats)
+
+	return d
+}
+
+// SetSymbols sets the symbols of the program, so the debugger shows
+// addresses as labels and source lines along with instructions, and accepts
+// labels as addresses.
+func (d *Debugger) SetSymbols(t *sym.Table) {
+	d.symbols = t
+}
+
+// SetSourceMap sets the source the program was built from, so the debugger
+// shows source lines along with instructions. It replaces the symbols.
+func (d *Debugger) SetSourceMap(m asm.SourceMap) {
+	d.symbols = sym.New(nil, m)
+}
+
+// Break sets a breakpoint at addr.
+func (d *Debugger) Break(addr uint16) {
+	d.addBreakpoint(&breakpoint{addr: int(addr), kind: kindBreak})
+}
+
+// Run reads commands from in until it is exhausted or the quit command is
+// given. An empty line repeats the previous command.
+func (d *Debugger) Run(in io.Reader) error {
+	d.where()
+
+	scanner := bufio.NewScanner(in)
+	for !d.quit {
+		fmt.Fprint(d.out, "(chip8) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(d.out)
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = d.last
+		}
+		if line == "" {
+			continue
+		}
+		d.last = line
+
+		if err := d.Exec(line); err != nil {
+			fmt.Fprintf(d.out, "error: %s\n", err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Exec runs a single debugger command.
+func (d *Debugger) Exec(line string) error {
+	line = strings.TrimSpace(line)
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+	d.rawArgs = strings.TrimSpace(line[len(args[0]):])
+
+	name := args[0]
+	// Allow gdb style formats, as in x/s
+	if i := strings.IndexByte(name, '/'); i > 0 {
+		args = append([]string{name[:i], name[i:]}, args[1:]...)
+		name = name[:i]
+	}
+
+	cmd := lookup(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q (try help)", name)
+	}
+
+	return cmd.run(d, args[1:])
+}
+
+// Interrupt stops a running continue, next or finish command. It is safe to
+// call from another goroutine.
+func (d *Debugger) Interrupt() {
+	atomic.StoreInt32(&d.interrupted, 1)
+}
+
+// stepOne executes a single instruction and advances the timers as often as
+// the clock rate of the program asks for, recording what they changed in the
+// journal.
+func (d *Debugger) stepOne() {
+	d.journal.begin()
+	d.state.Step()
+
+	perTick := d.state.ClockRate() / chip8.TimerRate
+	if perTick < 1 {
+		perTick = 1
+	}
+	d.cycles++
+	if d.cycles%perTick == 0 {
+		d.state.Tick()
+	}
+	d.journal.end()
+}
+
+// run executes instructions until stop returns true, a breakpoint is hit, the
+// program halts or the debugger is interrupted. The instruction at PC is
+// always executed, even if there is a breakpoint on it.
+func (d *Debugger) run(stop func() bool) {
+	atomic.StoreInt32(&d.interrupted, 0)
+
+	// Values may have been changed by hand since the last run
+	for _, bp := range d.breakpoints {
+		if bp.kind == kindWatchChange {
+			bp.value = bp.watch.Eval(d.state)
+		}
+	}
+
+	for first := true; ; first = false {
+		if d.state.Halted() {
+			fmt.Fprintln(d.out, "Program halted")
+			break
+		}
+
+		pc := d.state.PC
+		op := d.state.OpAt(pc)
+		if d.checkBreakpoints(pc, op, false, first) {
+			break
+		}
+
+		if atomic.LoadInt32(&d.interrupted) != 0 {
+			fmt.Fprintln(d.out, "Interrupted")
+			break
+		}
+
+		d.stepOne()
+		hit := d.checkWatchpoints(pc)
+		if d.checkBreakpoints(pc, op, true, false) {
+			hit = true
+		}
+		if hit {
+			break
+		}
+
+		if stop != nil && stop() {
+			break
+		}
+	}
+
+	d.stopped()
+}
+
+// checkBreakpoints evaluates the break and log points for the instruction op
+// at pc, and reports whether execution should stop. Before the instruction
+// executes, executed is false and the points triggering before it are
+// evaluated; after, executed is true and the points triggering after it are.
+// Right after execution resumes only the conditions are evaluated, so that
+// execution can move on from a breakpoint.
+func (d *Debugger) checkBreakpoints(pc uint16, op chip8.OpCode, executed, resumed bool) bool {
+	stop := false
+	for _, bp := range d.breakpoints {
+		if bp.kind != kindBreak && bp.kind != kindLog {
+			continue
+		}
+		if bp.afterInstruction() != executed {
+			continue
+		}
+		if !bp.triggered(d.state, pc, op) || resumed || !bp.hit() {
+			continue
+		}
+
+		if bp.kind == kindLog {
+			fmt.Fprintln(d.out, bp.message.format(d.state))
+			continue
+		}
+
+		if !stop {
+			if executed {
+				fmt.Fprintf(d.out, "Breakpoint %d, %s at %s\n", bp.id, bp, d.addr(pc))
+			} else {
+				fmt.Fprintf(d.out, "Breakpoint %d, %s\n", bp.id, bp)
+			}
+			stop = true
+		}
+	}
+
+	return stop
+}
+
+// checkWatchpoints evaluates the watchpoints after the instruction at pc was
+// executed, and reports whether execution should stop.
+func (d *Debugger) checkWatchpoints(pc uint16) bool {
+	stop := false
+	for _, hit := range d.watchHits {
+		bp := hit.bp
+		if bp.cond != nil && bp.cond.Eval(d.state) == 0 || !bp.hit() {
+			continue
+		}
+
+		if bp.kind == kindWatchRead {
+			fmt.Fprintf(d.out, "Watchpoint %d: 0x%03x read 0x%02x at %s\n", bp.id, bp.watchAddr, hit.new, d.addr(pc))
+		} else {
+			fmt.Fprintf(d.out, "Watchpoint %d: 0x%03x written 0x%02x -> 0x%02x at %s\n", bp.id, bp.watchAddr, hit.old, hit.new, d.addr(pc))
+		}
+		stop = true
+	}
+	d.watchHits = d.watchHits[:0]
+
+	for _, bp := range d.breakpoints {
+		if bp.kind != kindWatchChange {
+			continue
+		}
+
+		old, value := bp.value, bp.watch.Eval(d.state)
+		if value == old {
+			continue
+		}
+		bp.value = value
+
+		if bp.cond != nil && bp.cond.Eval(d.state) == 0 || !bp.hit() {
+			continue
+		}
+
+		fmt.Fprintf(d.out, "Watchpoint %d: %s changed 0x%x -> 0x%x at %s\n", bp.id, bp.watch, old, value, d.addr(pc))
+		stop = true
+	}
+
+	return stop
+}
+
+// addBreakpoint registers bp, attaching the memory watcher when needed.
+func (d *Debugger) addBreakpoint(bp *breakpoint) {
+	if bp.addr >= 0 {
+		bp.label = d.symbols.Label(uint16(bp.addr))
+	}
+	bp.id = d.nextID
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	d.updateWatcher()
+
+	fmt.Fprintf(d.out, "%s %d: %s\n", kindNames[bp.kind], bp.id, bp)
+}
+
+var kindNames = map[breakpointKind]string{
+	kindBreak:       "Breakpoint",
+	kindLog:         "Logpoint",
+	kindWatchRead:   "Watchpoint",
+	kindWatchWrite:  "Watchpoint",
+	kindWatchChange: "Watchpoint",
+}
+
+// updateWatcher attaches the memory watcher to the state only while there
+// are read or write watchpoints, so memory accesses are not slowed down
+// otherwise.
+func (d *Debugger) updateWatcher() {
+	needed := false
+	for _, bp := range d.breakpoints {
+		if bp.kind == kindWatchRead || bp.kind == kindWatchWrite {
+			needed = true
+		}
+	}
+
+	switch {
+	case needed && d.watcher == nil:
+		d.watcher = &watcher{d}
+		d.state.AddHook(d.watcher)
+	case !needed && d.watcher != nil:
+		d.state.RemoveHook(d.watcher)
+		d.watcher = nil
+	}
+}
+
+// stopped reports the state after execution stopped.
+func (d *Debugger) stopped() {
+	if d.screen {
+		renderScreen(d.out, d.state)
+	}
+	d.where()
+}
+
+// addr formats an address with its label, as in 0x204 <main+4>.
+func (d *Debugger) addr(addr uint16) string {
+	if label := d.symbols.Label(addr); label != "" {
+		return fmt.Sprintf("0x%03x <%s>", addr, label)
+	}
+
+	return fmt.Sprintf("0x%03x", addr)
+}
+
+// where prints the instruction at PC, and the line of source it was built
+// from.
+func (d *Debugger) where() {
+	pc := d.state.PC
+	op := d.state.OpAt(pc)
+	fmt.Fprintf(d.out, "%s %s %s\n", d.addr(pc), op, chip8.DescribeOp(op))
+
+	pos, ok := d.symbols.Source(pc)
+	if !ok {
+		return
+	}
+
+	if text, ok := d.sourceLine(pos.File, pos.Line); ok {
+		fmt.Fprintf(d.out, "%s:%d: %s\n", pos.File, pos.Line, text)
+	} else {
+		fmt.Fprintf(d.out, "%s:%d\n", pos.File, pos.Line)
+	}
+}
+
+// sourceLine returns a line of a source file, reading the file on first use.
+func (d *Debugger) sourceLine(file string, n int) (string, bool) {
+	if d.files == nil {
+		d.files = make(map[string][]string)
+	}
+
+	lines, ok := d.files[file]
+	if !ok {
+		src, err := ioutil.ReadFile(file)
+		if err == nil {
+			lines = strings.Split(string(src), "\n")
+		}
+		d.files[file] = lines
+	}
+
+	if n < 1 || n > len(lines) {
+		return "", false
+	}
+
+	return strings.TrimSpace(lines[n-1]), true
+}
+
+func (d *Debugger) breakpointAt(pc uint16) *breakpoint {
+	op := d.state.OpAt(pc)
+	for _, bp := range d.breakpoints {
+		if bp.kind == kindBreak && bp.hasLocation() && bp.matches(pc, op) {
+			return bp
+		}
+	}
+
+	return nil
+}
+
+var errHalted = errors.New("the program has halted")
@@ -0,0 +1,541 @@
+package debugger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/cart"
+	"github.com/janezkenda/chip8/cheat"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/octo"
+	"github.com/janezkenda/chip8/sym"
+)
+
+func newTestDebugger(program []byte) (*Debugger, *chip8.State, *bytes.Buffer) {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(program)
+
+	out := &bytes.Buffer{}
+	d := New(&c8, out)
+	d.screen = false
+
+	return d, &c8, out
+}
+
+var subroutineProgram = []byte{
+	0x60, 0x01, // 0x200: V0 = 0x01
+	0x23, 0x00, // 0x202: Call 0x300
+	0x61, 0x02, // 0x204: V1 = 0x02
+	0x12, 0x06, // 0x206: Jump to 0x206
+}
+
+func withSubroutine(program []byte) []byte {
+	p := make([]byte, 0x100+4)
+	copy(p, program)
+	copy(p[0x100:], []byte{
+		0x70, 0x01, // 0x300: V0 += 0x01
+		0x00, 0xee, // 0x302: Return
+	})
+
+	return p
+}
+
+func TestDebugger_Step(t *testing.T) {
+	d, c8, _ := newTestDebugger(withSubroutine(subroutineProgram))
+
+	require.NoError(t, d.Exec("step"))
+	assert.Equal(t, uint16(0x202), c8.PC)
+
+	require.NoError(t, d.Exec("step 2"))
+	assert.Equal(t, uint16(0x302), c8.PC)
+	assert.Equal(t, byte(0x02), c8.V[0x0])
+
+	assert.Error(t, d.Exec("step 0"))
+}
+
+func TestDebugger_Next(t *testing.T) {
+	d, c8, _ := newTestDebugger(withSubroutine(subroutineProgram))
+
+	require.NoError(t, d.Exec("next"))
+	require.NoError(t, d.Exec("next"))
+
+	assert.Equal(t, uint16(0x204), c8.PC, "expected next to step over the call")
+	assert.Equal(t, byte(0x02), c8.V[0x0])
+}
+
+func TestDebugger_Finish(t *testing.T) {
+	d, c8, _ := newTestDebugger(withSubroutine(subroutineProgram))
+
+	assert.Error(t, d.Exec("finish"), "expected an error outside of a subroutine")
+
+	require.NoError(t, d.Exec("step 2"))
+	require.NoError(t, d.Exec("finish"))
+
+	assert.Equal(t, uint16(0x204), c8.PC)
+	assert.Equal(t, uint16(stackBase), c8.SP)
+}
+
+func TestDebugger_Breakpoints(t *testing.T) {
+	d, c8, out := newTestDebugger(withSubroutine(subroutineProgram))
+
+	require.NoError(t, d.Exec("break 0x206"))
+	require.NoError(t, d.Exec("break op 00EE"))
+	assert.Error(t, d.Exec("break op 0G00"))
+
+	// Opcode breakpoints stop after the instruction
+	require.NoError(t, d.Exec("continue"))
+	assert.Equal(t, uint16(0x204), c8.PC)
+	assert.Contains(t, out.String(), "Breakpoint 2, opcode 00EE at 0x302\n")
+
+	require.NoError(t, d.Exec("continue"))
+	assert.Equal(t, uint16(0x206), c8.PC)
+	assert.Contains(t, out.String(), "Breakpoint 1, address 0x206\n")
+
+	require.NoError(t, d.Exec("delete"))
+	require.NoError(t, d.Exec("continue"))
+	assert.True(t, c8.Halted(), "expected the infinite loop to halt the program")
+	assert.Error(t, d.Exec("step"))
+}
+
+func TestDebugger_Set(t *testing.T) {
+	d, c8, out := newTestDebugger(subroutineProgram)
+
+	require.NoError(t, d.Exec("set V3 0x05"))
+	require.NoError(t, d.Exec("set i 0x300"))
+	require.NoError(t, d.Exec("set DT 10"))
+	require.NoError(t, d.Exec("set 0x300 0xf0 0x90"))
+	assert.Error(t, d.Exec("set V3 0x100"))
+	assert.Error(t, d.Exec("set VG 1"))
+
+	assert.Equal(t, byte(0x05), c8.V[0x3])
+	assert.Equal(t, uint16(0x300), c8.I)
+	assert.Equal(t, byte(10), c8.DelayTimer())
+	assert.Equal(t, byte(0xf0), c8.Peek(0x300))
+	assert.Equal(t, byte(0x90), c8.Peek(0x301))
+
+	out.Reset()
+	require.NoError(t, d.Exec("x 0x300 2"))
+	assert.Equal(t, "0x300: f0 90\n", out.String())
+
+	out.Reset()
+	require.NoError(t, d.Exec("x/s 0x300 2"))
+	assert.Equal(t, "0x300: 11110000 ████····\n0x301: 10010000 █··█····\n", out.String())
+
+	out.Reset()
+	require.NoError(t, d.Exec("print V3"))
+	assert.Equal(t, "V3 = 0x05 (5)\n", out.String())
+}
+
+func TestDebugger_Run(t *testing.T) {
+	d, c8, out := newTestDebugger(subroutineProgram)
+
+	in := strings.NewReader("step\n\nbogus\nquit\nstep\n")
+	require.NoError(t, d.Run(in))
+
+	assert.Equal(t, uint16(0x300), c8.PC, "expected an empty line to repeat the step")
+	assert.Contains(t, out.String(), `error: unknown command "bogus"`)
+}
+
+func TestDebugger_TickRate(t *testing.T) {
+	program := []byte{
+		0x60, 0x05, // 0x200: V0 = 0x05
+		0xf0, 0x15, // 0x202: Set delay timer to V0
+		0x71, 0x01, // 0x204: V1 += 0x01
+		0x12, 0x04, // 0x206: Jump to 0x204
+	}
+
+	// A cartridge running 2 instructions per frame
+	c := &cart.Cartridge{Options: cart.DefaultOptions(), Program: cart.ROMSource(program)}
+	c.Options.TickRate = 2
+	var buf bytes.Buffer
+	require.NoError(t, cart.Write(&buf, c, "tickrate"))
+	c, err := cart.Read(&buf)
+	require.NoError(t, err)
+	p, err := octo.Compile("game.8o", []byte(c.Program))
+	require.NoError(t, err)
+
+	d, c8, _ := newTestDebugger(p.ROM)
+	require.NoError(t, c.Options.Configure(c8))
+
+	// The timer ticks after the second instruction, then every other one
+	require.NoError(t, d.Exec("step 2"))
+	assert.Equal(t, byte(4), c8.DelayTimer())
+	require.NoError(t, d.Exec("step 4"))
+	assert.Equal(t, byte(2), c8.DelayTimer())
+}
+
+func TestRenderScreen(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.Poke(0xf00, 0x80) // (0, 0)
+	c8.Poke(0xf08, 0xc0) // (0, 1) and (1, 1)
+
+	out := &bytes.Buffer{}
+	renderScreen(out, &c8)
+
+	lines := strings.Split(out.String(), "\n")
+	assert.True(t, strings.HasPrefix(lines[1], "|█▄ "))
+}
+
+var counterProgram = []byte{
+	0xa3, 0xa0, // 0x200: I = 0x3a0
+	0x70, 0x01, // 0x202: V0 += 0x01
+	0xf0, 0x55, // 0x204: Store V0 at I
+	0x12, 0x02, // 0x206: Jump to 0x202
+}
+
+func TestDebugger_ConditionalBreakpoints(t *testing.T) {
+	d, c8, out := newTestDebugger(counterProgram)
+
+	require.NoError(t, d.Exec("break 0x204 if V0 == 3"))
+	require.NoError(t, d.Exec("continue"))
+	assert.Equal(t, uint16(0x204), c8.PC)
+	assert.Equal(t, byte(3), c8.V[0x0])
+	assert.Contains(t, out.String(), "Breakpoint 1, address 0x204 if V0 == 3")
+
+	require.NoError(t, d.Exec("condition 1 V0 % 2 == 0"))
+	require.NoError(t, d.Exec("ignore 1 2"))
+	require.NoError(t, d.Exec("continue"))
+	assert.Equal(t, byte(8), c8.V[0x0], "expected the hits at V0 == 4 and V0 == 6 to be ignored")
+
+	require.NoError(t, d.Exec("delete 1"))
+	require.NoError(t, d.Exec("break if V0 > 10 && mem[0x3a0] == 10"))
+	require.NoError(t, d.Exec("continue"))
+	assert.Equal(t, byte(11), c8.V[0x0])
+	assert.Equal(t, uint16(0x204), c8.PC, "expected to stop once V0 > 10 and mem[0x3a0] == 10")
+
+	assert.Error(t, d.Exec("break 0x202 if V0 =="))
+	assert.Error(t, d.Exec("break 0x202 V0"))
+}
+
+func TestDebugger_OpBreakpointConditions(t *testing.T) {
+	d, c8, out := newTestDebugger([]byte{
+		0xa2, 0x08, // 0x200: I = 0x208
+		0xd0, 0x01, // 0x202: Draw 1 row at V0, V0
+		0x70, 0x00, // 0x204: V0 += 0
+		0x12, 0x02, // 0x206: Jump to 0x202
+		0x80, 0x00, // 0x208: Sprite
+	})
+
+	// The condition sees VF as set by the draw, which collides every other
+	// time
+	require.NoError(t, d.Exec("break op DXYN if VF == 1"))
+	require.NoError(t, d.Exec("log op DXYN \"VF={VF}\""))
+	require.NoError(t, d.Exec("ignore 1 1"))
+	require.NoError(t, d.Exec("continue"))
+	assert.Equal(t, uint16(0x204), c8.PC)
+	assert.Equal(t, byte(1), c8.V[0xf])
+	assert.Contains(t, out.String(), "VF=0\nVF=1\nVF=0\nBreakpoint 1, opcode DXYN if VF == 1 at 0x202\nVF=1\n")
+
+	// Running backwards stops after the matching instruction too
+	require.NoError(t, d.Exec("delete 2"))
+	out.Reset()
+	require.NoError(t, d.Exec("reverse-continue"))
+	assert.Equal(t, uint16(0x204), c8.PC)
+	assert.Equal(t, byte(1), c8.V[0xf])
+	assert.EqualValues(t, 5, d.cycles, "expected to stop after the second draw")
+}
+
+func TestDebugger_Watchpoints(t *testing.T) {
+	d, c8, out := newTestDebugger(counterProgram)
+
+	require.NoError(t, d.Exec("watch write 0x3a0 if V0 == 2"))
+	require.NoError(t, d.Exec("continue"))
+	assert.Equal(t, uint16(0x206), c8.PC)
+	assert.Contains(t, out.String(), "Watchpoint 1: 0x3a0 written 0x01 -> 0x02 at 0x204")
+
+	require.NoError(t, d.Exec("delete"))
+	assert.Nil(t, d.watcher, "expected the watcher to be detached")
+
+	require.NoError(t, d.Exec("watch V0"))
+	require.NoError(t, d.Exec("continue"))
+	assert.Equal(t, uint16(0x204), c8.PC)
+	assert.Contains(t, out.String(), "Watchpoint 2: V0 changed 0x2 -> 0x3 at 0x202")
+
+	require.NoError(t, d.Exec("delete"))
+	require.NoError(t, d.Exec("watch 0x3a0"))
+	require.NoError(t, d.Exec("continue"))
+	assert.Contains(t, out.String(), "Watchpoint 3: mem[0x3a0] changed 0x2 -> 0x3 at 0x204")
+
+	require.NoError(t, d.Exec("delete"))
+	require.NoError(t, d.Exec("watch read 0x3a0"))
+	assert.Error(t, d.Exec("watch read V0"))
+
+	// Nothing reads 0x3a0; set up an FX65 to read it
+	require.NoError(t, d.Exec("set 0x208 0xf0 0x65"))
+	require.NoError(t, d.Exec("set PC 0x208"))
+	require.NoError(t, d.Exec("step"))
+	assert.Contains(t, out.String(), "Watchpoint 4: 0x3a0 read 0x03 at 0x208")
+}
+
+func TestDebugger_Logpoints(t *testing.T) {
+	d, c8, out := newTestDebugger(counterProgram)
+
+	require.NoError(t, d.Exec(`log 0x204 "V0 is {V0:02x},  mem is {mem[I]}" if V0 < 3`))
+	require.NoError(t, d.Exec("break 0x204 if V0 == 4"))
+	require.NoError(t, d.Exec("continue"))
+
+	assert.Equal(t, byte(4), c8.V[0x0])
+	assert.Contains(t, out.String(), "V0 is 01,  mem is 0\nV0 is 02,  mem is 1\n")
+	assert.NotContains(t, out.String(), "V0 is 03")
+
+	assert.Error(t, d.Exec(`log "message"`))
+}
+
+func TestDebugger_SourceMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debugger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "counter.8o")
+	require.NoError(t, ioutil.WriteFile(file, []byte(": main\n\ti := 0x3a0\n\tv0 += 1\n"), 0644))
+
+	d, _, out := newTestDebugger(counterProgram)
+	d.SetSourceMap(asm.SourceMap{
+		{Addr: 0x200, Size: 2, Pos: asm.Pos{File: file, Line: 2, Col: 2}},
+		{Addr: 0x202, Size: 2, Pos: asm.Pos{File: file, Line: 3, Col: 2}},
+	})
+
+	d.Break(0x202)
+	require.NoError(t, d.Exec("continue"))
+	assert.Contains(t, out.String(), "Breakpoint 1: address 0x202\n")
+	assert.Contains(t, out.String(), file+":3: v0 += 1\n")
+}
+
+func TestDebugger_Symbols(t *testing.T) {
+	d, c8, out := newTestDebugger(withSubroutine(subroutineProgram))
+	d.SetSymbols(sym.New(map[string]uint16{"main": 0x200, "inc": 0x300}, asm.SourceMap{
+		{Addr: 0x202, Size: 2, Pos: asm.Pos{File: "game.8o", Line: 4, Col: 2}},
+	}))
+
+	require.NoError(t, d.Exec("break inc"))
+	assert.Contains(t, out.String(), "Breakpoint 1: address 0x300 <inc>\n")
+
+	require.NoError(t, d.Exec("continue"))
+	assert.Equal(t, uint16(0x300), c8.PC)
+	assert.Contains(t, out.String(), "0x300 <inc> 0x7001")
+
+	out.Reset()
+	require.NoError(t, d.Exec("stack"))
+	assert.Equal(t, "#0 0x300 <inc>\n#1 0x202 <main+2> Call subroutine at 0x300 at game.8o:4\n", out.String())
+
+	out.Reset()
+	require.NoError(t, d.Exec("disas inc 1"))
+	assert.Equal(t, "inc:\n=* 0x300 0x7001 V0 += 0x01\n", out.String())
+}
+
+func TestDebugger_WhoWrote(t *testing.T) {
+	d, _, out := newTestDebugger([]byte{
+		0x60, 0x12, // 0x200: V0 = 0x12
+		0xa3, 0x00, // 0x202: I = 0x300
+		0xf0, 0x55, // 0x204: Store V0 at I
+		0xa2, 0x0e, // 0x206: I = 0x20e
+		0xd0, 0x01, // 0x208: Draw 1 row at V0, V0
+		0x12, 0x0a, // 0x20a: Jump to 0x20a
+		0x00, 0x00,
+		0x80, 0x00, // 0x20e: Sprite
+	})
+	d.SetSymbols(sym.New(map[string]uint16{"main": 0x200}, nil))
+
+	require.NoError(t, d.Exec("step 5"))
+	out.Reset()
+
+	require.NoError(t, d.Exec("who-wrote 0x300"))
+	assert.Equal(t, "0x300 = 0x12, written in cycle 3 (frame 0) by 0x204 <main+4> 0xf055 Stores V0 to (including) V0 in memory starting at address I. I is left unmodified with the LoadStore quirk, and set to the address after the last byte written otherwise\n", out.String())
+
+	out.Reset()
+	require.NoError(t, d.Exec("who-wrote 0x301"))
+	assert.Equal(t, "0x301 = 0x00 has not been written\n", out.String())
+
+	out.Reset()
+	require.NoError(t, d.Exec("who-drew 18,18"))
+	assert.Equal(t, "Pixel 18,18 is on, turned on in cycle 5 (frame 0) by 0x208 <main+8> 0xd001 Draw 8x1 sprite at (V0, V0)\n", out.String())
+
+	out.Reset()
+	require.NoError(t, d.Exec("who-drew 19, 18"))
+	assert.Equal(t, "Pixel 19,18 is off and has not been drawn\n", out.String())
+
+	assert.Error(t, d.Exec("who-drew 64,0"))
+	assert.Error(t, d.Exec("who-drew 1"))
+	assert.Error(t, d.Exec("who-wrote"))
+
+	// Undone writes are forgotten, and written again in the same cycle
+	require.NoError(t, d.Exec("reverse-step 3"))
+	out.Reset()
+	require.NoError(t, d.Exec("who-drew 18,18"))
+	require.NoError(t, d.Exec("who-wrote 0x300"))
+	assert.Equal(t, "Pixel 18,18 is off and has not been drawn\n0x300 = 0x00 has not been written\n", out.String())
+
+	require.NoError(t, d.Exec("step"))
+	out.Reset()
+	require.NoError(t, d.Exec("who-wrote 0x300"))
+	assert.Contains(t, out.String(), "0x300 = 0x12, written in cycle 3 (frame 0) by 0x204")
+}
+
+func TestDebugger_ReverseStep(t *testing.T) {
+	d, c8, out := newTestDebugger(withSubroutine(subroutineProgram))
+
+	require.NoError(t, d.Exec("step 6"))
+	assert.True(t, c8.Halted())
+
+	// Stepping back from the halt lets the program run again
+	require.NoError(t, d.Exec("reverse-step"))
+	assert.False(t, c8.Halted())
+	assert.Equal(t, uint16(0x206), c8.PC)
+	assert.Equal(t, byte(0x02), c8.V[0x1])
+
+	require.NoError(t, d.Exec("rs 2"))
+	assert.Equal(t, uint16(0x302), c8.PC)
+	assert.Equal(t, byte(0x00), c8.V[0x1])
+	assert.Equal(t, uint16(0xefc), c8.SP)
+
+	require.NoError(t, d.Exec("rs 2"))
+	assert.Equal(t, uint16(0x202), c8.PC)
+	assert.Equal(t, byte(0x01), c8.V[0x0])
+	assert.Equal(t, uint16(0xefe), c8.SP)
+	assert.Equal(t, byte(0x00), c8.Peek(0xefc))
+	assert.Equal(t, byte(0x00), c8.Peek(0xefd))
+
+	require.NoError(t, d.Exec("rs 2"))
+	assert.Equal(t, uint16(0x200), c8.PC)
+	assert.Equal(t, byte(0x00), c8.V[0x0])
+	assert.Contains(t, out.String(), "No more reverse execution history\n")
+
+	// Running forward again retraces the same steps
+	require.NoError(t, d.Exec("continue"))
+	assert.True(t, c8.Halted())
+	assert.Equal(t, byte(0x02), c8.V[0x0])
+
+	assert.Error(t, d.Exec("reverse-step 0"))
+}
+
+func TestDebugger_ReverseNext(t *testing.T) {
+	d, c8, _ := newTestDebugger(withSubroutine(subroutineProgram))
+
+	require.NoError(t, d.Exec("step 5"))
+	assert.Equal(t, uint16(0x206), c8.PC)
+
+	require.NoError(t, d.Exec("reverse-next"))
+	assert.Equal(t, uint16(0x204), c8.PC)
+
+	// The subroutine is undone as a whole
+	require.NoError(t, d.Exec("reverse-next"))
+	assert.Equal(t, uint16(0x202), c8.PC)
+	assert.Equal(t, uint16(0xefe), c8.SP)
+	assert.Equal(t, byte(0x01), c8.V[0x0])
+}
+
+func TestDebugger_ReverseContinue(t *testing.T) {
+	d, c8, out := newTestDebugger(counterProgram)
+
+	require.NoError(t, d.Exec("step 12"))
+	assert.Equal(t, byte(0x04), c8.V[0x0])
+
+	// Back to the last store of 3
+	require.NoError(t, d.Exec("watch write 0x3a0 if V0 == 3"))
+	out.Reset()
+	require.NoError(t, d.Exec("reverse-continue"))
+	assert.Contains(t, out.String(), "Watchpoint 1: 0x3a0 written 0x02 -> 0x03 at 0x204\n")
+	assert.Equal(t, uint16(0x204), c8.PC)
+	assert.Equal(t, byte(0x02), c8.Peek(0x3a0))
+	assert.Equal(t, byte(0x03), c8.V[0x0])
+
+	require.NoError(t, d.Exec("delete"))
+	require.NoError(t, d.Exec("break 0x202"))
+	out.Reset()
+	require.NoError(t, d.Exec("rc"))
+	assert.Contains(t, out.String(), "Breakpoint 2, address 0x202\n")
+	assert.Equal(t, uint16(0x202), c8.PC)
+	assert.Equal(t, byte(0x02), c8.V[0x0])
+
+	require.NoError(t, d.Exec("delete"))
+	require.NoError(t, d.Exec("watch V0"))
+	out.Reset()
+	require.NoError(t, d.Exec("rc"))
+	assert.Contains(t, out.String(), "Watchpoint 3: V0 changed 0x1 -> 0x2 at 0x202\n")
+	assert.Equal(t, byte(0x01), c8.V[0x0])
+}
+
+func TestDebugger_ReverseCheats(t *testing.T) {
+	d, c8, _ := newTestDebugger([]byte{
+		0x60, 0x03, // 0x200: V0 = 0x03
+		0xa3, 0xa0, // 0x202: I = 0x3a0
+		0xf0, 0x55, // 0x204: Store V0 at I
+		0x70, 0xff, // 0x206: V0 -= 1
+		0x12, 0x04, // 0x208: Jump to 0x204
+	})
+
+	// Cheats applied between instructions are undone with the last one
+	require.NoError(t, d.Exec("step 3"))
+	require.NoError(t, d.Exec("cheat add lives 0x3a0 9"))
+	require.NoError(t, d.Exec("cheat add level V1 0x05"))
+	assert.Equal(t, byte(0x09), c8.Peek(0x3a0))
+	require.NoError(t, d.Exec("reverse-step"))
+	assert.Equal(t, byte(0x00), c8.Peek(0x3a0))
+	assert.Equal(t, byte(0x00), c8.V[0x1])
+
+	// and applied before an instruction, with it
+	require.NoError(t, d.Exec("step"))
+	assert.Equal(t, byte(0x03), c8.Peek(0x3a0))
+	assert.Equal(t, byte(0x05), c8.V[0x1])
+	require.NoError(t, d.Exec("reverse-step"))
+	assert.Equal(t, byte(0x00), c8.Peek(0x3a0))
+	assert.Equal(t, byte(0x00), c8.V[0x1])
+}
+
+func TestDebugger_Cheats(t *testing.T) {
+	d, c8, out := newTestDebugger([]byte{
+		0x60, 0x03, // 0x200: V0 = 0x03
+		0xa3, 0xa0, // 0x202: I = 0x3a0
+		0xf0, 0x55, // 0x204: Store V0 at I
+		0x70, 0xff, // 0x206: V0 -= 1
+		0x12, 0x04, // 0x208: Jump to 0x204
+	})
+	path := filepath.Join(t.TempDir(), "game.cheats")
+	require.NoError(t, d.SetCheats(nil, path))
+
+	require.NoError(t, d.Exec("search"))
+	assert.Equal(t, "4096 candidates\n", out.String())
+	require.NoError(t, d.Exec("step 3"))
+	require.NoError(t, d.Exec("search eq 3"))
+	require.NoError(t, d.Exec("step 3"))
+	out.Reset()
+	require.NoError(t, d.Exec("search dec"))
+	assert.Equal(t, "1 candidates\n0x3a0 = 0x02\n", out.String())
+	assert.Error(t, d.Exec("search eq"))
+	assert.Error(t, d.Exec("search more"))
+
+	require.NoError(t, d.Exec("cheat add lives 0x3a0 9"))
+	require.NoError(t, d.Exec("cheat add level V1 0x05"))
+	assert.Equal(t, byte(0x09), c8.Peek(0x3a0))
+	assert.Equal(t, byte(0x05), c8.V[0x1])
+	require.NoError(t, d.Exec("cheat off level"))
+	out.Reset()
+	require.NoError(t, d.Exec("cheat"))
+	assert.Equal(t, "1: on lives 0x3a0 = 0x09\n2: off level V1 = 0x05\n", out.String())
+
+	// The frozen byte is written back at the start of the next frame
+	require.NoError(t, d.Exec("step 3"))
+	assert.Equal(t, byte(0x01), c8.Peek(0x3a0))
+	require.NoError(t, d.Exec(fmt.Sprintf("step %d", c8.ClockRate()/chip8.TimerRate)))
+	assert.Equal(t, byte(0x09), c8.Peek(0x3a0))
+
+	require.NoError(t, d.Exec("cheat save"))
+	cheats, err := cheat.Load(path)
+	require.NoError(t, err)
+	assert.Len(t, cheats, 2)
+
+	require.NoError(t, d.Exec("cheat delete lives"))
+	assert.Error(t, d.Exec("cheat delete lives"))
+	assert.Error(t, d.Exec("cheat add level V2 1"))
+	assert.Error(t, d.Exec("cheat add bad 0x3a0 0x100"))
+	assert.Error(t, d.Exec("cheat frobnicate"))
+}
@@ -0,0 +1,382 @@
+package debugger
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/provenance"
+)
+
+// Number of instructions the journal keeps at least, about nine minutes at
+// the clock rate. It grows to twice as many before the oldest are dropped;
+// older instructions cannot be reversed.
+const journalSize = 1 << 18
+
+// Registers and timers as indexes into a snapshot, after V0-VF
+const (
+	regI = 16 + iota
+	regSP
+	regPC
+	regDT
+	regST
+	regCount
+)
+
+type snapshot [regCount]uint16
+
+func takeSnapshot(s *chip8.State) snapshot {
+	var r snapshot
+	for i, v := range s.V {
+		r[i] = uint16(v)
+	}
+	r[regI], r[regSP], r[regPC] = s.I, s.SP, s.PC
+	r[regDT], r[regST] = uint16(s.DelayTimer()), uint16(s.SoundTimer())
+
+	return r
+}
+
+type registerDelta struct {
+	reg int
+	old uint16
+}
+
+type memoryDelta struct {
+	addr     uint16
+	old, new byte
+
+	// Set for values stored by cheats, which the tracker does not see;
+	// saved is what the tracker recorded otherwise
+	poke  bool
+	saved provenance.Saved
+}
+
+// delta holds what an instruction changed: the old values of the registers,
+// timers and memory, including the display buffer, it wrote, and of the
+// tracker of who wrote them.
+type delta struct {
+	// The instruction and its address, and whether it halted the program
+	pc     uint16
+	op     chip8.OpCode
+	halted bool
+
+	registers []registerDelta
+	memory    []memoryDelta
+	tracker   provenance.Mark
+}
+
+// journal records the delta of every instruction the debugger executes, so
+// that they can be undone. Undoing restores the state, but executing again
+// may not retrace the same steps when the program reads keys or random
+// numbers.
+type journal struct {
+	state   *chip8.State
+	tracker *provenance.Tracker
+	entries []delta
+
+	// Delta of the instruction being executed, and the registers and whether
+	// the program was halted before it
+	current   *delta
+	registers snapshot
+	halted    bool
+}
+
+// OnMemoryWrite records the old value of a byte written by the instruction
+// being executed, and what the tracker recorded for it. It must be attached
+// before the tracker.
+func (j *journal) OnMemoryWrite(addr uint16, value byte) {
+	if j.current != nil {
+		j.current.memory = append(j.current.memory, memoryDelta{
+			addr: addr, old: j.state.Peek(addr), new: value,
+			saved: j.tracker.Save(addr, value),
+		})
+	}
+}
+
+// poke stores a value of a cheat, recording its old value with the
+// instruction being executed or amended.
+func (j *journal) poke(addr uint16, value byte) {
+	if j.current != nil {
+		j.current.memory = append(j.current.memory, memoryDelta{addr: addr, old: j.state.Peek(addr), new: value, poke: true})
+	}
+	j.state.Poke(addr, value)
+}
+
+// begin starts recording an instruction.
+func (j *journal) begin() {
+	j.current = &delta{pc: j.state.PC, op: j.state.OpAt(j.state.PC), tracker: j.tracker.Mark()}
+	j.registers = takeSnapshot(j.state)
+	j.halted = j.state.Halted()
+}
+
+// end finishes recording an instruction, keeping the registers it changed.
+func (j *journal) end() {
+	j.current.halted = !j.halted && j.state.Halted()
+	j.record(j.current)
+
+	j.entries = append(j.entries, *j.current)
+	j.current = nil
+
+	// Drop the oldest entries, copying only once in a while
+	if len(j.entries) >= 2*journalSize {
+		j.entries = append([]delta(nil), j.entries[len(j.entries)-journalSize:]...)
+	}
+}
+
+// record adds the registers changed since the snapshot to e, unless e
+// already holds an older value.
+func (j *journal) record(e *delta) {
+	after := takeSnapshot(j.state)
+	for reg, old := range j.registers {
+		if after[reg] == old {
+			continue
+		}
+
+		recorded := false
+		for _, r := range e.registers {
+			recorded = recorded || r.reg == reg
+		}
+		if !recorded {
+			e.registers = append(e.registers, registerDelta{reg, old})
+		}
+	}
+}
+
+// amend calls f, which changes the state between instructions, as when
+// cheats are applied, and adds what it changed to the last instruction, so
+// that undoing the instruction undoes both.
+func (j *journal) amend(f func()) {
+	if len(j.entries) == 0 {
+		f()
+		return
+	}
+
+	j.current = &j.entries[len(j.entries)-1]
+	j.registers = takeSnapshot(j.state)
+	f()
+	j.record(j.current)
+	j.current = nil
+}
+
+// len returns the number of instructions which can be undone.
+func (j *journal) len() int {
+	return len(j.entries)
+}
+
+// last returns the delta of the last instruction, and false if the journal
+// is empty.
+func (j *journal) last() (delta, bool) {
+	if j.len() == 0 {
+		return delta{}, false
+	}
+
+	return j.entries[len(j.entries)-1], true
+}
+
+// undo reverts the last instruction, returning its delta, and false if the
+// journal is empty.
+func (j *journal) undo() (delta, bool) {
+	if j.len() == 0 {
+		return delta{}, false
+	}
+
+	e := j.entries[len(j.entries)-1]
+	j.entries = j.entries[:len(j.entries)-1]
+
+	for i := len(e.memory) - 1; i >= 0; i-- {
+		m := e.memory[i]
+		j.state.Poke(m.addr, m.old)
+		if !m.poke {
+			j.tracker.Restore(m.addr, m.saved)
+		}
+	}
+	j.tracker.Rewind(e.tracker)
+
+	s := j.state
+	for _, r := range e.registers {
+		switch r.reg {
+		case regI:
+			s.I = r.old
+		case regSP:
+			s.SP = r.old
+		case regPC:
+			s.PC = r.old
+		case regDT:
+			s.SetDelayTimer(byte(r.old))
+		case regST:
+			s.SetSoundTimer(byte(r.old))
+		default:
+			s.V[r.reg] = byte(r.old)
+		}
+	}
+	if e.halted {
+		s.Resume()
+	}
+
+	return e, true
+}
+
+// undoOne reverts the last instruction executed, returning its delta.
+func (d *Debugger) undoOne() (delta, bool) {
+	e, ok := d.journal.undo()
+	if ok {
+		d.cycles--
+	}
+
+	return e, ok
+}
+
+// reverse undoes instructions until stop returns true, a breakpoint or
+// watchpoint is hit, the journal runs out or the debugger is interrupted. At
+// least one instruction is undone.
+func (d *Debugger) reverse(stop func() bool) {
+	atomic.StoreInt32(&d.interrupted, 0)
+
+	for _, bp := range d.breakpoints {
+		if bp.kind == kindWatchChange {
+			bp.value = bp.watch.Eval(d.state)
+		}
+	}
+
+	for first := true; ; first = false {
+		if atomic.LoadInt32(&d.interrupted) != 0 {
+			fmt.Fprintln(d.out, "Interrupted")
+			break
+		}
+
+		if !first && d.checkReversedExecuted() {
+			break
+		}
+
+		e, ok := d.undoOne()
+		if !ok {
+			fmt.Fprintln(d.out, "No more reverse execution history")
+			break
+		}
+
+		if d.checkReversed(e) {
+			break
+		}
+
+		if stop != nil && stop() {
+			break
+		}
+	}
+
+	d.stopped()
+}
+
+// checkReversed evaluates the breakpoints and watchpoints after the
+// instruction at PC was undone, and reports whether to stop. Break points
+// at an address stop before the instruction as when running forward;
+// write and change watchpoints stop before the instruction which wrote or
+// changed what they watch. Read watchpoints and break points without a
+// location only trigger running forward.
+func (d *Debugger) checkReversed(e delta) bool {
+	pc := d.state.PC
+	op := d.state.OpAt(pc)
+
+	stop := false
+	for _, bp := range d.breakpoints {
+		if bp.cond != nil && bp.cond.Eval(d.state) == 0 {
+			if bp.kind == kindWatchChange {
+				bp.value = bp.watch.Eval(d.state)
+			}
+			continue
+		}
+
+		switch bp.kind {
+		case kindBreak:
+			if bp.hasLocation() && !bp.afterInstruction() && bp.matches(pc, op) {
+				fmt.Fprintf(d.out, "Breakpoint %d, %s\n", bp.id, bp)
+				stop = true
+			}
+		case kindWatchWrite:
+			old, new, written := 0, 0, false
+			for _, m := range e.memory {
+				if m.addr != bp.watchAddr || m.poke {
+					continue
+				}
+				if !written {
+					old = int(m.old)
+				}
+				new, written = int(m.new), true
+			}
+			if written {
+				fmt.Fprintf(d.out, "Watchpoint %d: 0x%03x written 0x%02x -> 0x%02x at %s\n", bp.id, bp.watchAddr, old, new, d.addr(pc))
+				stop = true
+			}
+		case kindWatchChange:
+			value := bp.watch.Eval(d.state)
+			if value != bp.value {
+				fmt.Fprintf(d.out, "Watchpoint %d: %s changed 0x%x -> 0x%x at %s\n", bp.id, bp.watch, value, bp.value, d.addr(pc))
+				bp.value = value
+				stop = true
+			}
+		}
+	}
+
+	return stop
+}
+
+// checkReversedExecuted evaluates the break points triggering after the
+// instruction they match before the last instruction is undone, and reports
+// whether to stop, so that they stop after the instruction as when running
+// forward.
+func (d *Debugger) checkReversedExecuted() bool {
+	e, ok := d.journal.last()
+	if !ok {
+		return false
+	}
+
+	for _, bp := range d.breakpoints {
+		if bp.kind != kindBreak || !bp.afterInstruction() || !bp.matches(e.pc, e.op) {
+			continue
+		}
+		if bp.cond != nil && bp.cond.Eval(d.state) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(d.out, "Breakpoint %d, %s at %s\n", bp.id, bp, d.addr(e.pc))
+		return true
+	}
+
+	return false
+}
+
+func (d *Debugger) cmdReverseStep(args []string) error {
+	n := 1
+	if len(args) > 0 {
+		v, err := parseNumber(args[0])
+		if err != nil {
+			return err
+		}
+		if v < 1 {
+			return fmt.Errorf("cannot step back %d instructions", v)
+		}
+		n = v
+	}
+
+	d.reverse(func() bool {
+		n--
+		return n <= 0
+	})
+
+	return nil
+}
+
+func (d *Debugger) cmdReverseNext(args []string) error {
+	// Undoing a return enters the subroutine; keep undoing until the call
+	sp := d.state.SP
+	d.reverse(func() bool {
+		return d.state.SP >= sp
+	})
+
+	return nil
+}
+
+func (d *Debugger) cmdReverseContinue(args []string) error {
+	d.reverse(nil)
+
+	return nil
+}
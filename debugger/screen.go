@@ -0,0 +1,57 @@
+package debugger
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+const (
+	screenWidth  = 64
+	screenHeight = 32
+)
+
+// renderScreen draws the framebuffer with half block characters, so each
+// line of text shows two rows of pixels.
+func renderScreen(w io.Writer, s *chip8.State) {
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+
+	border := "+" + strings.Repeat("-", screenWidth) + "+\n"
+
+	b.WriteString(border)
+	for y := 0; y < screenHeight; y += 2 {
+		b.WriteByte('|')
+		for x := 0; x < screenWidth; x++ {
+			top, bottom := s.Pixel(x, y), s.Pixel(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteRune('█')
+			case top:
+				b.WriteRune('▀')
+			case bottom:
+				b.WriteRune('▄')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString("|\n")
+	}
+	b.WriteString(border)
+}
+
+// renderSprite returns a row of sprite data as a bitmap.
+func renderSprite(row byte) string {
+	var sb strings.Builder
+	for i := 7; i >= 0; i-- {
+		if row>>i&1 == 1 {
+			sb.WriteRune('█')
+		} else {
+			sb.WriteRune('·')
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,310 @@
+// Package expr compiles expressions over the state of a CHIP-8 machine, as
+// used by conditional breakpoints and watchpoints, such as
+// mem[0x3a0] >= 10 && V5 == 2.
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// An Expr is a compiled expression over the machine state. Expressions use C
+// syntax and operate on integers; comparisons and logical operators yield 0
+// or 1. The operands are:
+//
+//	V0-VF, I, SP, PC       registers
+//	DT, ST                 delay and sound timers
+//	K0-KF                  1 when the key is pressed, 0 otherwise
+//	mem[addr]              the byte at addr, memory[addr] is an alias
+//	numbers                decimal, 0x hexadecimal or 0b binary
+type Expr struct {
+	src  string
+	eval evalFunc
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression on the machine state s.
+func (e *Expr) Eval(s *chip8.State) int {
+	return e.eval(s)
+}
+
+// Parse compiles src.
+func Parse(src string) (*Expr, error) {
+	p := &exprParser{src: src}
+	p.advance()
+
+	eval, err := p.parse(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %q", p.tok)
+	}
+
+	return &Expr{src: strings.TrimSpace(src), eval: eval}, nil
+}
+
+type evalFunc func(s *chip8.State) int
+
+// Binary operators, from the lowest precedence to the highest
+var binaryOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+type exprParser struct {
+	src string
+	pos int
+
+	// Current token and the column it starts at
+	tok string
+	col int
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("column %d: %s", p.col, fmt.Sprintf(format, args...))
+}
+
+// advance reads the next token into p.tok, which is empty at the end.
+func (p *exprParser) advance() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	p.col = p.pos + 1
+
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+
+	start := p.pos
+	if isWordChar(p.src[p.pos]) {
+		for p.pos < len(p.src) && isWordChar(p.src[p.pos]) {
+			p.pos++
+		}
+	} else {
+		p.pos++
+		if p.pos < len(p.src) {
+			switch p.src[start : p.pos+1] {
+			case "||", "&&", "==", "!=", "<=", ">=", "<<", ">>":
+				p.pos++
+			}
+		}
+	}
+
+	p.tok = p.src[start:p.pos]
+}
+
+func isWordChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// parse parses binary operators of at least the given precedence level.
+func (p *exprParser) parse(level int) (evalFunc, error) {
+	if level == len(binaryOperators) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parse(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for contains(binaryOperators[level], p.tok) {
+		op := p.tok
+		p.advance()
+
+		right, err := p.parse(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = binary(op, left, right)
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (evalFunc, error) {
+	switch op := p.tok; op {
+	case "!", "-", "~":
+		p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "!":
+			return func(s *chip8.State) int { return boolToInt(operand(s) == 0) }, nil
+		case "-":
+			return func(s *chip8.State) int { return -operand(s) }, nil
+		default:
+			return func(s *chip8.State) int { return ^operand(s) }, nil
+		}
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (evalFunc, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, p.errorf("unexpected end of expression")
+	case tok == "(":
+		p.advance()
+		inner, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("expected ')'")
+		}
+		p.advance()
+		return inner, nil
+	case tok[0] >= '0' && tok[0] <= '9':
+		v, err := strconv.ParseInt(tok, 0, 32)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok)
+		}
+		p.advance()
+		return func(*chip8.State) int { return int(v) }, nil
+	case isWordChar(tok[0]):
+		return p.parseIdentifier()
+	}
+
+	return nil, p.errorf("unexpected %q", tok)
+}
+
+func (p *exprParser) parseIdentifier() (evalFunc, error) {
+	name := strings.ToUpper(p.tok)
+	col := p.col
+	p.advance()
+
+	if name == "MEM" || name == "MEMORY" {
+		if p.tok != "[" {
+			return nil, p.errorf("expected '['")
+		}
+		p.advance()
+
+		addr, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != "]" {
+			return nil, p.errorf("expected ']'")
+		}
+		p.advance()
+
+		return func(s *chip8.State) int { return int(s.Peek(uint16(addr(s)))) }, nil
+	}
+
+	if i, err := strconv.ParseUint(name[1:], 16, 4); len(name) == 2 && err == nil {
+		i := byte(i)
+		switch name[0] {
+		case 'V':
+			return func(s *chip8.State) int { return int(s.V[i]) }, nil
+		case 'K':
+			return func(s *chip8.State) int { return boolToInt(s.Key(i)) }, nil
+		}
+	}
+
+	switch name {
+	case "I":
+		return func(s *chip8.State) int { return int(s.I) }, nil
+	case "SP":
+		return func(s *chip8.State) int { return int(s.SP) }, nil
+	case "PC":
+		return func(s *chip8.State) int { return int(s.PC) }, nil
+	case "DT":
+		return func(s *chip8.State) int { return int(s.DelayTimer()) }, nil
+	case "ST":
+		return func(s *chip8.State) int { return int(s.SoundTimer()) }, nil
+	}
+
+	return nil, fmt.Errorf("column %d: unknown identifier %q", col, name)
+}
+
+func binary(op string, left, right evalFunc) evalFunc {
+	switch op {
+	case "||":
+		return func(s *chip8.State) int { return boolToInt(left(s) != 0 || right(s) != 0) }
+	case "&&":
+		return func(s *chip8.State) int { return boolToInt(left(s) != 0 && right(s) != 0) }
+	case "|":
+		return func(s *chip8.State) int { return left(s) | right(s) }
+	case "^":
+		return func(s *chip8.State) int { return left(s) ^ right(s) }
+	case "&":
+		return func(s *chip8.State) int { return left(s) & right(s) }
+	case "==":
+		return func(s *chip8.State) int { return boolToInt(left(s) == right(s)) }
+	case "!=":
+		return func(s *chip8.State) int { return boolToInt(left(s) != right(s)) }
+	case "<":
+		return func(s *chip8.State) int { return boolToInt(left(s) < right(s)) }
+	case "<=":
+		return func(s *chip8.State) int { return boolToInt(left(s) <= right(s)) }
+	case ">":
+		return func(s *chip8.State) int { return boolToInt(left(s) > right(s)) }
+	case ">=":
+		return func(s *chip8.State) int { return boolToInt(left(s) >= right(s)) }
+	case "<<":
+		return func(s *chip8.State) int { return left(s) << uint(right(s)&31) }
+	case ">>":
+		return func(s *chip8.State) int { return left(s) >> uint(right(s)&31) }
+	case "+":
+		return func(s *chip8.State) int { return left(s) + right(s) }
+	case "-":
+		return func(s *chip8.State) int { return left(s) - right(s) }
+	case "*":
+		return func(s *chip8.State) int { return left(s) * right(s) }
+	case "/":
+		return func(s *chip8.State) int {
+			if r := right(s); r != 0 {
+				return left(s) / r
+			}
+			return 0
+		}
+	default:
+		return func(s *chip8.State) int {
+			if r := right(s); r != 0 {
+				return left(s) % r
+			}
+			return 0
+		}
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
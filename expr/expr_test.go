@@ -0,0 +1,67 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+func TestParse(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.V[0x3] = 5
+	c8.I = 0x301
+	c8.Poke(0x3a0, 0x42)
+	c8.SetKey(0xa, true)
+
+	tests := []struct {
+		src  string
+		want int
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"V3 == 5 && I > 0x300", 1},
+		{"V3 == 5 && I > 0x301", 0},
+		{"v3 != 5 || !0", 1},
+		{"mem[0x3a0]", 0x42},
+		{"memory[0x3a0 + V3 - 5] & 0x0f", 0x02},
+		{"KA + KB", 1},
+		{"-V3 + 6", 1},
+		{"~0 & 0xff", 0xff},
+		{"1 << 4 | 1", 17},
+		{"0b101 ^ 1", 4},
+		{"SP - PC", 0xefe - 0x200},
+		{"7 / 0", 0},
+		{"10 % 4 >= 2", 1},
+	}
+
+	for _, tt := range tests {
+		e, err := Parse(tt.src)
+		require.NoError(t, err, tt.src)
+		assert.Equal(t, tt.want, e.Eval(&c8), tt.src)
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"V3 ==", "column 6: unexpected end of expression"},
+		{"VG == 1", `column 1: unknown identifier "VG"`},
+		{"(1 + 2", "column 7: expected ')'"},
+		{"mem 0x300", "column 5: expected '['"},
+		{"1 2", `column 3: unexpected "2"`},
+		{"0xzz", `column 1: invalid number "0xzz"`},
+		{"V1 $ 2", `column 4: unexpected "$"`},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		if assert.Error(t, err, tt.src) {
+			assert.Equal(t, tt.err, err.Error(), tt.src)
+		}
+	}
+}
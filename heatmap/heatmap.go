@@ -0,0 +1,147 @@
+// Package heatmap records how a running CHIP-8 program reads, writes and
+// executes its 4 KiB of memory, and renders it as a 64x64 image: one cell
+// per byte, 64 bytes to a row, over the regions of the memory map.
+//
+// Reads are shown in green, writes in red and executes in blue, brighter
+// the more often they happen, so that bytes used in several ways blend:
+// self-modifying code is magenta, and writes past the end of a buffer stand
+// out as red in regions the program should not touch.
+package heatmap
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"text/tabwriter"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// Address programs are loaded at
+const programAddress = 0x200
+
+const memorySize = 0x1000
+
+// Region is a range of memory with a role in the memory map.
+type Region struct {
+	Name string
+	// First and last address of the region
+	Start, End uint16
+	Color      color.RGBA
+}
+
+// Contains reports whether addr lies in the region.
+func (r Region) Contains(addr uint16) bool {
+	return addr >= r.Start && addr <= r.End
+}
+
+// Regions returns the memory map for a program of the given size, in
+// address order: the font, the area the original interpreter lived in, the
+// program, free memory, the stack, which grows down from SP 0xefe in the
+// space the COSMAC VIP reserved for it and its variables, and the display
+// buffer at 0xf00.
+func Regions(programSize int) []Region {
+	end := uint16(programAddress + programSize - 1)
+	if programSize <= 0 {
+		end = programAddress
+	}
+	if end > 0xe9f {
+		end = 0xe9f
+	}
+
+	regions := []Region{
+		{"font", 0x000, 0x04f, color.RGBA{0xff, 0xd7, 0x00, 0xff}},
+		{"interpreter", 0x050, 0x1ff, color.RGBA{0x80, 0x80, 0x80, 0xff}},
+		{"program", programAddress, end, color.RGBA{0x00, 0xbf, 0xff, 0xff}},
+	}
+	if end < 0xe9f {
+		regions = append(regions, Region{"free", end + 1, 0xe9f, color.RGBA{0x40, 0x40, 0x40, 0xff}})
+	}
+
+	return append(regions,
+		Region{"stack", 0xea0, 0xeff, color.RGBA{0xff, 0x8c, 0x00, 0xff}},
+		Region{"display", 0xf00, 0xfff, color.RGBA{0xee, 0x82, 0xee, 0xff}},
+	)
+}
+
+// Recorder is a hook counting the accesses to every byte of memory. Attach
+// it with chip8.State.AddHook.
+type Recorder struct {
+	regions []Region
+
+	reads, writes, executes [memorySize]uint64
+}
+
+// NewRecorder returns a recorder for a program of the given size, loaded at
+// 0x200.
+func NewRecorder(programSize int) *Recorder {
+	return &Recorder{regions: Regions(programSize)}
+}
+
+// OnBeforeInstruction counts both bytes of the instruction as executed.
+func (r *Recorder) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	r.executes[pc&0xfff]++
+	r.executes[(pc+1)&0xfff]++
+}
+
+func (r *Recorder) OnMemoryRead(addr uint16, value byte) {
+	r.reads[addr&0xfff]++
+}
+
+func (r *Recorder) OnMemoryWrite(addr uint16, value byte) {
+	r.writes[addr&0xfff]++
+}
+
+// Reads returns the number of times an instruction read the byte at addr.
+func (r *Recorder) Reads(addr uint16) uint64 {
+	return r.reads[addr&0xfff]
+}
+
+// Writes returns the number of times an instruction wrote the byte at addr.
+func (r *Recorder) Writes(addr uint16) uint64 {
+	return r.writes[addr&0xfff]
+}
+
+// Executes returns the number of times the byte at addr was executed as
+// part of an instruction.
+func (r *Recorder) Executes(addr uint16) uint64 {
+	return r.executes[addr&0xfff]
+}
+
+// Usage is the number of bytes of a region read, written and executed.
+type Usage struct {
+	Region
+	Read, Written, Executed int
+}
+
+// Usage returns the bytes of each region of the memory map accessed.
+func (r *Recorder) Usage() []Usage {
+	usage := make([]Usage, len(r.regions))
+	for i, region := range r.regions {
+		usage[i].Region = region
+		for addr := int(region.Start); addr <= int(region.End); addr++ {
+			if r.reads[addr] > 0 {
+				usage[i].Read++
+			}
+			if r.writes[addr] > 0 {
+				usage[i].Written++
+			}
+			if r.executes[addr] > 0 {
+				usage[i].Executed++
+			}
+		}
+	}
+
+	return usage
+}
+
+// WriteUsage writes a table of the bytes of each region accessed.
+func (r *Recorder) WriteUsage(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "region\trange\tsize\tread\twritten\texecuted\t")
+	for _, u := range r.Usage() {
+		fmt.Fprintf(tw, "%s\t0x%03x-0x%03x\t%d\t%d\t%d\t%d\t\n", u.Name, u.Start, u.End, int(u.End-u.Start)+1, u.Read, u.Written, u.Executed)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,121 @@
+package heatmap
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// program stores a byte to a buffer, calls a subroutine and patches its own
+// code, writing back the byte already there.
+var program = []byte{
+	0xa2, 0x12, // 0x200: I = 0x212
+	0x60, 0x61, // 0x202: V0 = 0x61
+	0xf0, 0x55, // 0x204: Store V0 at I
+	0x22, 0x10, // 0x206: Call 0x210
+	0xa2, 0x0c, // 0x208: I = 0x20c
+	0xf0, 0x55, // 0x20a: Store V0 at I
+	0x61, 0x01, // 0x20c: V1 = 0x01
+	0x12, 0x0e, // 0x20e: Jump to 0x20e
+	0x00, 0xee, // 0x210: Return
+	0x00, // 0x212: buffer
+}
+
+func record() *Recorder {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(program)
+
+	r := NewRecorder(len(program))
+	c8.AddHook(r)
+
+	for !c8.Halted() {
+		c8.Step()
+	}
+
+	return r
+}
+
+func TestRegions(t *testing.T) {
+	regions := Regions(0x13)
+
+	var names []string
+	for _, r := range regions {
+		names = append(names, r.Name)
+	}
+	assert.Equal(t, []string{"font", "interpreter", "program", "free", "stack", "display"}, names)
+	assert.Equal(t, uint16(0x212), regions[2].End)
+	assert.Equal(t, uint16(0x213), regions[3].Start)
+	assert.True(t, regions[4].Contains(0xefc))
+	assert.False(t, regions[4].Contains(0xf00))
+
+	// Programs filling the memory up to the stack leave no free memory
+	regions = Regions(0xe00)
+	assert.Len(t, regions, 5)
+	assert.Equal(t, uint16(0xe9f), regions[2].End)
+}
+
+func TestRecorder(t *testing.T) {
+	r := record()
+
+	assert.Equal(t, uint64(1), r.Executes(0x20c))
+	assert.Equal(t, uint64(1), r.Executes(0x20d))
+	assert.Equal(t, uint64(1), r.Writes(0x20c))
+	assert.Equal(t, uint64(1), r.Writes(0x212))
+	assert.Equal(t, uint64(0), r.Executes(0x212))
+	assert.Equal(t, uint64(1), r.Writes(0xefc))
+	assert.Equal(t, uint64(1), r.Reads(0xefd))
+
+	usage := r.Usage()
+	require.Len(t, usage, 6)
+	assert.Equal(t, "program", usage[2].Name)
+	assert.Equal(t, 0, usage[2].Read)
+	assert.Equal(t, 2, usage[2].Written)
+	assert.Equal(t, 18, usage[2].Executed)
+	assert.Equal(t, "stack", usage[4].Name)
+	assert.Equal(t, 2, usage[4].Read)
+	assert.Equal(t, 2, usage[4].Written)
+	assert.Equal(t, Usage{Region: usage[0].Region}, usage[0])
+
+	var out bytes.Buffer
+	require.NoError(t, r.WriteUsage(&out))
+	assert.Contains(t, out.String(), "program  0x200-0x212    19     0        2        18")
+}
+
+func TestRecorder_Image(t *testing.T) {
+	r := record()
+
+	img := r.Image(1)
+	assert.Equal(t, 64, img.Bounds().Dx())
+	assert.Equal(t, 64, img.Bounds().Dy())
+
+	// The patched byte is written and executed, the buffer only written and
+	// the font never accessed
+	assert.Equal(t, color.RGBA{0xff, 0x00, 0xff, 0xff}, img.RGBAAt(0x20c%64, 0x20c/64))
+	assert.Equal(t, color.RGBA{0xff, 0x00, 0x00, 0xff}, img.RGBAAt(0x212%64, 0x212/64))
+	assert.Equal(t, color.RGBA{0x33, 0x2b, 0x00, 0xff}, img.RGBAAt(1, 0))
+
+	// Larger scales outline the regions and add a legend
+	img = r.Image(4)
+	assert.Equal(t, 64*4+legendWidth, img.Bounds().Dx())
+	assert.Equal(t, 64*4, img.Bounds().Dy())
+	assert.Equal(t, Regions(0)[0].Color, img.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{0xff, 0x00, 0xff, 0xff}, img.RGBAAt(0x20c%64*4+2, 0x20c/64*4+2))
+
+	var out bytes.Buffer
+	require.NoError(t, r.WritePNG(&out, 2))
+	decoded, err := png.Decode(&out)
+	require.NoError(t, err)
+	assert.Equal(t, 128, decoded.Bounds().Dx())
+}
+
+func TestLevel(t *testing.T) {
+	assert.Equal(t, uint8(0), level(0, 10))
+	assert.Equal(t, uint8(255), level(10, 10))
+	assert.True(t, level(1, 1000) >= 64)
+	assert.True(t, level(1, 1000) < level(100, 1000))
+}
@@ -0,0 +1,179 @@
+package heatmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Bytes of memory on a row of the map
+const rowSize = 64
+
+// Smallest scale the region borders and the legend are drawn at
+const annotateScale = 4
+
+// Width of the legend and height of its lines, in pixels
+const (
+	legendWidth = 168
+	lineHeight  = 16
+)
+
+var (
+	readColor    = color.RGBA{0x00, 0xff, 0x00, 0xff}
+	writeColor   = color.RGBA{0xff, 0x00, 0x00, 0xff}
+	executeColor = color.RGBA{0x00, 0x00, 0xff, 0xff}
+	textColor    = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// level returns the brightness of n accesses, out of most: 0 for none and
+// 64 to 255 on a log scale otherwise, so that rare accesses remain visible
+// next to busy loops.
+func level(n, most uint64) uint8 {
+	if n == 0 {
+		return 0
+	}
+
+	return uint8(64 + 191*math.Log1p(float64(n))/math.Log1p(float64(most)))
+}
+
+// region returns the index of the region holding addr.
+func (r *Recorder) region(addr uint16) int {
+	for i, region := range r.regions {
+		if region.Contains(addr) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// cell returns the colour of the byte at addr.
+func (r *Recorder) cell(addr uint16, mostRead, mostWritten, mostExecuted uint64) color.RGBA {
+	c := color.RGBA{
+		R: level(r.writes[addr], mostWritten),
+		G: level(r.reads[addr], mostRead),
+		B: level(r.executes[addr], mostExecuted),
+		A: 0xff,
+	}
+	if c.R != 0 || c.G != 0 || c.B != 0 {
+		return c
+	}
+
+	// Bytes never accessed show the region they belong to, dimmed
+	if i := r.region(addr); i >= 0 {
+		rc := r.regions[i].Color
+		return color.RGBA{rc.R / 5, rc.G / 5, rc.B / 5, 0xff}
+	}
+
+	return color.RGBA{A: 0xff}
+}
+
+// Image renders the heatmap with every byte as a square of scale pixels.
+// From a scale of 4 the regions are outlined in their colour and a legend
+// is drawn to the right of the map.
+func (r *Recorder) Image(scale int) *image.RGBA {
+	if scale < 1 {
+		scale = 1
+	}
+
+	var mostRead, mostWritten, mostExecuted uint64
+	for addr := 0; addr < memorySize; addr++ {
+		if r.reads[addr] > mostRead {
+			mostRead = r.reads[addr]
+		}
+		if r.writes[addr] > mostWritten {
+			mostWritten = r.writes[addr]
+		}
+		if r.executes[addr] > mostExecuted {
+			mostExecuted = r.executes[addr]
+		}
+	}
+
+	size := rowSize * scale
+	width := size
+	annotate := scale >= annotateScale
+	if annotate {
+		width += legendWidth
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{A: 0xff}), image.Point{}, draw.Src)
+
+	for addr := uint16(0); addr < memorySize; addr++ {
+		x, y := int(addr%rowSize)*scale, int(addr/rowSize)*scale
+		c := r.cell(addr, mostRead, mostWritten, mostExecuted)
+		draw.Draw(img, image.Rect(x, y, x+scale, y+scale), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	if annotate {
+		r.drawBorders(img, scale)
+		r.drawLegend(img, size+8)
+	}
+
+	return img
+}
+
+// drawBorders outlines the regions, drawing the edges of the cells next to
+// a cell of another region.
+func (r *Recorder) drawBorders(img *image.RGBA, scale int) {
+	rows := memorySize / rowSize
+	for addr := 0; addr < memorySize; addr++ {
+		i := r.region(uint16(addr))
+		if i < 0 {
+			continue
+		}
+		c := r.regions[i].Color
+		col, row := addr%rowSize, addr/rowSize
+		x, y := col*scale, row*scale
+
+		if row == 0 || r.region(uint16(addr-rowSize)) != i {
+			draw.Draw(img, image.Rect(x, y, x+scale, y+1), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+		if row == rows-1 || r.region(uint16(addr+rowSize)) != i {
+			draw.Draw(img, image.Rect(x, y+scale-1, x+scale, y+scale), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+		if col == 0 || r.region(uint16(addr-1)) != i {
+			draw.Draw(img, image.Rect(x, y, x+1, y+scale), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+		if col == rowSize-1 || r.region(uint16(addr+1)) != i {
+			draw.Draw(img, image.Rect(x+scale-1, y, x+scale, y+scale), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+	}
+}
+
+// drawLegend lists the regions and the colours of the accesses, from x.
+func (r *Recorder) drawLegend(img *image.RGBA, x int) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(textColor),
+		Face: basicfont.Face7x13,
+	}
+
+	y := 4
+	line := func(c color.RGBA, text string) {
+		draw.Draw(img, image.Rect(x, y+2, x+10, y+12), image.NewUniform(c), image.Point{}, draw.Src)
+		d.Dot = fixed.P(x+16, y+11)
+		d.DrawString(text)
+		y += lineHeight
+	}
+
+	for _, region := range r.regions {
+		line(region.Color, fmt.Sprintf("%-11s %03x-%03x", region.Name, region.Start, region.End))
+	}
+	y += lineHeight
+	line(readColor, "read")
+	line(writeColor, "written")
+	line(executeColor, "executed")
+}
+
+// WritePNG writes the heatmap as a PNG image, at the given scale.
+func (r *Recorder) WritePNG(w io.Writer, scale int) error {
+	return png.Encode(w, r.Image(scale))
+}
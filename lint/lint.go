@@ -0,0 +1,324 @@
+// Package lint finds code in CHIP-8 programs which behaves differently
+// across interpreters, or looks like a mistake. It works on the control flow
+// analysis of the chip8 package, so it only looks at code reachable from the
+// entry point.
+//
+// Checks depending on a quirk name it as the -quirks flags do: shift,
+// loadstore, jump and clip. The checks are heuristics: a program without
+// findings is less likely to depend on those quirks, but may still reach such
+// code in ways the analysis does not follow.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// Names of the checks
+const (
+	CheckShift        = "shift"
+	CheckLoadStore    = "loadstore"
+	CheckJump         = "jump"
+	CheckClip         = "clip"
+	CheckMachineCode  = "machine-code"
+	CheckUnreachable  = "unreachable"
+	CheckJumpIntoData = "jump-into-data"
+	CheckEmptySprite  = "empty-sprite"
+)
+
+// Address programs are loaded at
+const programAddress = 0x200
+
+// Screen size; sprites crossing its edges are clipped or wrap around
+const (
+	screenWidth  = 64
+	screenHeight = 32
+)
+
+// Number of instructions after FX55 and FX65 searched for uses of I
+const loadStoreWindow = 16
+
+// Finding is a problem found in a program.
+type Finding struct {
+	Address uint16 `json:"address"`
+	Check   string `json:"check"`
+	// Quirk the code depends on, if any
+	Quirk   string `json:"quirk,omitempty"`
+	Message string `json:"message"`
+}
+
+// Check lints program, loaded at 0x200, returning the findings in address
+// order.
+func Check(program []byte) []Finding {
+	l := &linter{a: chip8.Analyze(program), end: programAddress + len(program)}
+
+	l.instructions()
+	l.loadStore()
+	l.sprites()
+	l.unreachable()
+
+	sort.SliceStable(l.findings, func(i, j int) bool { return l.findings[i].Address < l.findings[j].Address })
+
+	return l.findings
+}
+
+type linter struct {
+	a        *chip8.Analysis
+	end      int
+	findings []Finding
+}
+
+func (l *linter) report(addr uint16, check, quirk, format string, args ...interface{}) {
+	l.findings = append(l.findings, Finding{addr, check, quirk, fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) contains(addr uint16) bool {
+	return int(addr) >= programAddress && int(addr) < l.end
+}
+
+func format(ins chip8.Instruction) string {
+	return ins.Format(chip8.SyntaxClassic, nil)
+}
+
+// instructions checks instructions on their own.
+func (l *linter) instructions() {
+	for _, addr := range l.a.Addresses() {
+		ins := l.a.Instructions[addr]
+		op := ins.Op
+
+		switch {
+		case op.B00 == 0x8 && (op.B11 == 0x6 || op.B11 == 0xe) && op.B01 != op.B10:
+			l.report(addr, CheckShift, "shift", "%s shifts V%X in place with the shift quirk, and stores V%X shifted into V%X without it", format(ins), op.B01, op.B10, op.B01)
+		case op.B00 == 0xb && op.B01 != 0:
+			l.report(addr, CheckJump, "jump", "%s jumps to 0x%03x plus V0, or plus V%X with the jump quirk", format(ins), ins.Target, op.B01)
+		case ins.Mnemonic == "SYS":
+			l.report(addr, CheckMachineCode, "", "%s calls machine code, which only the COSMAC VIP runs", format(ins))
+		case op.B00 == 0xd && op.B11 == 0:
+			l.report(addr, CheckEmptySprite, "", "%s draws nothing here, and a 16x16 sprite in SUPER-CHIP", format(ins))
+		}
+
+		if ins.Flow == chip8.FlowJump || ins.Flow == chip8.FlowCall {
+			l.target(addr, ins)
+		}
+	}
+}
+
+// target checks the target of a jump or call.
+func (l *linter) target(addr uint16, ins chip8.Instruction) {
+	t := ins.Target
+	_, overlaps := l.a.Instructions[t-1]
+
+	switch {
+	case !l.contains(t):
+		l.report(addr, CheckJumpIntoData, "", "%s jumps outside of the program", format(ins))
+	case l.a.IsDataRef(t):
+		l.report(addr, CheckJumpIntoData, "", "%s jumps into data loaded into I", format(ins))
+	case overlaps:
+		l.report(addr, CheckJumpIntoData, "", "%s jumps into the middle of the instruction at 0x%03x", format(ins), t-1)
+	}
+}
+
+// readsI reports whether ins uses the value of I.
+func readsI(ins chip8.Instruction) bool {
+	op := ins.Op
+	if op.B00 == 0xd {
+		return true
+	}
+
+	return op.B00 == 0xf && (op.B1 == 0x1e || op.B1 == 0x33 || op.B1 == 0x55 || op.B1 == 0x65)
+}
+
+// setsI reports whether ins sets I without using its value.
+func setsI(ins chip8.Instruction) bool {
+	return ins.Op.B00 == 0xa || ins.Op.B00 == 0xf && ins.Op.B1 == 0x29
+}
+
+// loadStore finds FX55 and FX65 followed by code using I before setting it,
+// which sees I incremented past the registers without the load/store quirk.
+func (l *linter) loadStore() {
+	for _, addr := range l.a.Addresses() {
+		ins := l.a.Instructions[addr]
+		if ins.Op.B00 != 0xf || ins.Op.B1 != 0x55 && ins.Op.B1 != 0x65 {
+			continue
+		}
+
+		next := addr + 2
+		for i := 0; i < loadStoreWindow; i, next = i+1, next+2 {
+			use, ok := l.a.Instructions[next]
+			if !ok || setsI(use) {
+				break
+			}
+			if readsI(use) {
+				l.report(addr, CheckLoadStore, "loadstore", "%s is followed by %s at 0x%03x, which uses I as left by the load/store quirk", format(ins), format(use), next)
+				break
+			}
+			if use.Flow != chip8.FlowNext && use.Flow != chip8.FlowSkip {
+				break
+			}
+		}
+	}
+}
+
+// registers tracks the values of the registers known from the instructions
+// of a basic block.
+type registers struct {
+	known [16]bool
+	v     [16]byte
+}
+
+func (r *registers) set(x byte, v byte) {
+	r.known[x], r.v[x] = true, v
+}
+
+func (r *registers) forget(x byte) {
+	r.known[x] = false
+}
+
+// execute updates the registers for ins. When the instruction may be
+// skipped, the registers it writes are forgotten instead.
+func (r *registers) execute(ins chip8.Instruction, maybeSkipped bool) {
+	op := ins.Op
+	x, y := op.B01, op.B10
+
+	// VF holds a flag after most arithmetic and drawing
+	switch {
+	case op.B00 == 0x6 && !maybeSkipped:
+		r.set(x, op.B1)
+	case op.B00 == 0x7 && !maybeSkipped && r.known[x]:
+		r.set(x, r.v[x]+op.B1)
+	case op.B00 == 0x8 && op.B11 == 0x0 && !maybeSkipped && r.known[y]:
+		r.set(x, r.v[y])
+	case op.B00 == 0x6, op.B00 == 0x7, op.B00 == 0xc:
+		r.forget(x)
+	case op.B00 == 0x8:
+		r.forget(x)
+		r.forget(0xf)
+	case op.B00 == 0xd:
+		r.forget(0xf)
+	case op.B00 == 0xf && (op.B1 == 0x07 || op.B1 == 0x0a):
+		r.forget(x)
+	case op.B00 == 0xf && op.B1 == 0x1e:
+		r.forget(0xf)
+	case op.B00 == 0xf && op.B1 == 0x65:
+		for i := byte(0); i <= x; i++ {
+			r.forget(i)
+		}
+	}
+}
+
+// sprites finds sprites drawn across the edges of the screen, which are
+// clipped with the clip quirk and wrap around without it. Only coordinates
+// set by constants in the same basic block are known.
+func (l *linter) sprites() {
+	targets := make(map[uint16]bool)
+	for _, ins := range l.a.Instructions {
+		if ins.Flow == chip8.FlowJump || ins.Flow == chip8.FlowIndirectJump || ins.Flow == chip8.FlowCall {
+			targets[ins.Target] = true
+		}
+	}
+
+	var r registers
+	var prev *chip8.Instruction
+	var prevAddr uint16
+	for _, addr := range l.a.Addresses() {
+		ins := l.a.Instructions[addr]
+
+		// A basic block starts at targets and after instructions not
+		// continuing to the next one, including calls, after which the
+		// registers are unknown
+		if prev == nil || targets[addr] || prevAddr+2 != addr || prev.Flow != chip8.FlowNext && prev.Flow != chip8.FlowSkip {
+			r = registers{}
+		}
+		maybeSkipped := prev != nil && prevAddr+2 == addr && prev.Flow == chip8.FlowSkip
+
+		op := ins.Op
+		if op.B00 == 0xd && r.known[op.B01] && r.known[op.B10] {
+			// DXY0 draws no rows, which is reported on its own
+			x, y, n := int(r.v[op.B01])%screenWidth, int(r.v[op.B10])%screenHeight, int(op.B11)
+			if n > 0 && (x+8 > screenWidth || y+n > screenHeight) {
+				l.report(addr, CheckClip, "clip", "%s draws a sprite at (%d, %d) across the edge of the screen, which is clipped with the clip quirk and wraps around without it", format(ins), x, y)
+			}
+		}
+
+		r.execute(ins, maybeSkipped)
+		prev, prevAddr = &ins, addr
+	}
+}
+
+// unreachable finds bytes no control flow reaches, which are not loaded into
+// I and decode as instructions. Data loaded into I is taken to run up to the
+// next code.
+func (l *linter) unreachable() {
+	for addr := uint16(programAddress); l.contains(addr); {
+		switch {
+		case l.a.IsCode(addr):
+			addr++
+			continue
+		case l.a.IsDataRef(addr):
+			for ; l.contains(addr) && !l.a.IsCode(addr); addr++ {
+			}
+			continue
+		}
+
+		start := addr
+		for ; l.contains(addr) && !l.a.IsCode(addr) && !l.a.IsDataRef(addr); addr++ {
+		}
+
+		if n := l.instructionCount(start, addr); n >= 2 {
+			l.report(start, CheckUnreachable, "", "%d bytes up to 0x%03x decode as %d instructions, but are never reached", addr-start, addr-1, n)
+		}
+	}
+}
+
+// instructionCount returns the number of instructions the bytes from start
+// up to end decode as, or 0 if some of them are not instructions.
+func (l *linter) instructionCount(start, end uint16) int {
+	n := 0
+	for addr := start; addr+1 < end; addr += 2 {
+		ins := chip8.Disassemble(chip8.NewOpCode([2]byte{l.byteAt(addr), l.byteAt(addr + 1)}))
+		if ins.Flow == chip8.FlowHalt {
+			return 0
+		}
+		n++
+	}
+
+	return n
+}
+
+func (l *linter) byteAt(addr uint16) byte {
+	return l.a.Program[addr-programAddress]
+}
+
+// WriteText writes the findings a line each. Describe, when set, describes
+// an address, such as by its label and source line.
+func WriteText(w io.Writer, findings []Finding, describe func(addr uint16) string) error {
+	for _, f := range findings {
+		loc := fmt.Sprintf("0x%03x", f.Address)
+		if describe != nil {
+			if d := describe(f.Address); d != "" {
+				loc += " (" + d + ")"
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s: %s\n", loc, f.Check, f.Message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteJSON writes the findings as a JSON array.
+func WriteJSON(w io.Writer, findings []Finding) error {
+	if findings == nil {
+		findings = []Finding{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(findings)
+}
@@ -0,0 +1,131 @@
+package lint
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const quirky = `start:  LD V0, 60
+        LD V1, 10
+        LD I, sprite
+        DRW V0, V1, 5
+        SHR V2, V3
+        LD [I], V3
+        DRW V1, V1, 5
+        SYS 0x123
+dead:   CLS
+        JP dead
+sprite: DB 0xf0, 0x90, 0xf0, 0x90, 0xf0
+`
+
+func assemble(t *testing.T, src string) []byte {
+	p, err := asm.Assemble("test.asm", []byte(src))
+	require.NoError(t, err)
+
+	return p.ROM
+}
+
+func checks(findings []Finding) []string {
+	var checks []string
+	for _, f := range findings {
+		checks = append(checks, f.Check)
+	}
+
+	return checks
+}
+
+func TestCheck(t *testing.T) {
+	findings := Check(assemble(t, quirky))
+
+	assert.Equal(t, []Finding{
+		{0x206, CheckClip, "clip", "DRW V0, V1, 5 draws a sprite at (60, 10) across the edge of the screen, which is clipped with the clip quirk and wraps around without it"},
+		{0x208, CheckShift, "shift", "SHR V2, V3 shifts V2 in place with the shift quirk, and stores V3 shifted into V2 without it"},
+		{0x20a, CheckLoadStore, "loadstore", "LD [I], V3 is followed by DRW V1, V1, 5 at 0x20c, which uses I as left by the load/store quirk"},
+		{0x20e, CheckMachineCode, "", "SYS 0x123 calls machine code, which only the COSMAC VIP runs"},
+		{0x210, CheckUnreachable, "", "4 bytes up to 0x213 decode as 2 instructions, but are never reached"},
+	}, findings)
+}
+
+func TestCheck_jumps(t *testing.T) {
+	findings := Check([]byte{
+		0xa2, 0x08, // 0x200: LD I, 0x208
+		0x23, 0x00, // 0x202: CALL 0x300, outside of the program
+		0x22, 0x07, // 0x204: CALL 0x207, inside the next instruction
+		0x12, 0x08, // 0x206: JP 0x208, which is data
+		0x00, 0xee, // 0x208
+		0xb3, 0x00, // 0x20a: JP V0, 0x300, not reached
+	})
+
+	assert.Equal(t, []Finding{
+		{0x202, CheckJumpIntoData, "", "CALL 0x300 jumps outside of the program"},
+		{0x204, CheckJumpIntoData, "", "CALL 0x207 jumps into the middle of the instruction at 0x206"},
+		{0x206, CheckJumpIntoData, "", "JP 0x208 jumps into data loaded into I"},
+		// The instruction the misaligned call reaches
+		{0x207, CheckMachineCode, "", "SYS 0x800 calls machine code, which only the COSMAC VIP runs"},
+	}, findings)
+
+	assert.Equal(t, []string{CheckJump}, checks(Check([]byte{0xb3, 0x00})))
+}
+
+func TestCheck_registers(t *testing.T) {
+	// Coordinates are only known within a basic block, and not after
+	// instructions which may be skipped
+	assert.Empty(t, Check(assemble(t, `
+        LD V0, 60
+loop:   DRW V0, V0, 1
+        LD V1, 60
+        SE V2, 0
+        LD V1, 0
+        DRW V1, V1, 1
+        CALL sub
+        DRW V1, V1, 1
+        JP loop
+sub:    RET
+`)))
+
+	assert.Equal(t, []string{CheckClip}, checks(Check(assemble(t, `
+        LD V0, 20
+        ADD V0, 10
+        LD V1, V0
+        DRW V0, V1, 3
+end:    JP end
+`))))
+
+	// DXY0 draws no rows in this emulator, so it never crosses the edges
+	findings := Check(assemble(t, `
+        LD V0, 28
+        DRW V0, V0, 0
+end:    JP end
+`))
+	assert.Equal(t, []Finding{
+		{0x202, CheckEmptySprite, "", "DRW V0, V0, 0 draws nothing here, and a 16x16 sprite in SUPER-CHIP"},
+	}, findings)
+}
+
+func TestWriteText(t *testing.T) {
+	findings := []Finding{
+		{0x200, CheckShift, "shift", "shifts"},
+		{0x202, CheckMachineCode, "", "calls"},
+	}
+
+	out := &bytes.Buffer{}
+	require.NoError(t, WriteText(out, findings, func(addr uint16) string {
+		if addr == 0x200 {
+			return "main test.asm:1"
+		}
+		return ""
+	}))
+	assert.Equal(t, "0x200 (main test.asm:1): shift: shifts\n0x202: machine-code: calls\n", out.String())
+
+	out.Reset()
+	require.NoError(t, WriteJSON(out, nil))
+	assert.Equal(t, "[]\n", out.String())
+
+	out.Reset()
+	require.NoError(t, WriteJSON(out, findings[1:]))
+	assert.JSONEq(t, `[{"address": 514, "check": "machine-code", "message": "calls"}]`, out.String())
+}
@@ -0,0 +1,640 @@
+// Package lsp implements a Language Server Protocol server for programs
+// written in the classic assembler syntax of the asm package. It reports the
+// errors of the assembler, describes instructions on hover, finds the
+// definitions and references of labels and constants, completes mnemonics,
+// registers and labels, and shows the bytes of each line as an inlay hint.
+//
+// The server speaks JSON-RPC over a pair of streams, usually stdin and
+// stdout, and keeps documents in sync by full content changes. Positions
+// count characters in UTF-16 code units, as the protocol requires.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// Address programs are loaded at
+const programAddress = 0x200
+
+// Most bytes shown by an inlay hint
+const maxHintBytes = 8
+
+// Server is a language server.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// Open documents, by path
+	docs map[string]*document
+
+	shutdown bool
+}
+
+// document is an open source file.
+type document struct {
+	path  string
+	lines []string
+
+	// Program last assembled from the document without errors, nil if it
+	// never was. While the document has errors the program is stale: its
+	// symbols still serve navigation, but its lines may not match the text.
+	program *asm.Program
+	stale   bool
+
+	// Files diagnostics were last published for
+	published []string
+}
+
+// NewServer returns a server reading requests from in and writing responses
+// to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+}
+
+// Serve handles requests until the client sends exit or closes the input.
+func (s *Server) Serve() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var m message
+		if err := json.Unmarshal(body, &m); err != nil {
+			if err := s.reply(nil, nil, &rpcError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if m.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+
+		result, err := s.handle(m)
+		if m.ID == nil {
+			// Notifications have no response, not even for errors
+			continue
+		}
+		if err := s.reply(m.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, err error) error {
+	if err == nil {
+		return writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Result: result})
+	}
+
+	e, ok := err.(*rpcError)
+	if !ok {
+		e = &rpcError{codeInvalidRequest, err.Error()}
+	}
+
+	return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: e})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return writeMessage(s.out, struct {
+		JSONRPC string      `json:"jsonrpc"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params"`
+	}{"2.0", method, params})
+}
+
+// handle runs the handler of a request or notification.
+func (s *Server) handle(m message) (interface{}, error) {
+	// decode decodes the parameters into v
+	decode := func(v interface{}) error {
+		if err := json.Unmarshal(m.Params, v); err != nil {
+			return &rpcError{codeInvalidParams, err.Error()}
+		}
+		return nil
+	}
+
+	switch m.Method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			return nil, s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p didCloseParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return nil, s.close(p.TextDocument.URI)
+	case "textDocument/hover":
+		var p textDocumentPositionParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.hover(p), nil
+	case "textDocument/definition":
+		var p textDocumentPositionParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.definition(p), nil
+	case "textDocument/references":
+		var p referenceParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.references(p), nil
+	case "textDocument/completion":
+		var p textDocumentPositionParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.completion(p), nil
+	case "textDocument/inlayHint":
+		var p inlayHintParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.inlayHints(p), nil
+	}
+
+	return nil, &rpcError{codeMethodNotFound, fmt.Sprintf("method %q not found", m.Method)}
+}
+
+func (s *Server) initialize() interface{} {
+	type capabilities struct {
+		TextDocumentSync   int                    `json:"textDocumentSync"`
+		HoverProvider      bool                   `json:"hoverProvider"`
+		DefinitionProvider bool                   `json:"definitionProvider"`
+		ReferencesProvider bool                   `json:"referencesProvider"`
+		CompletionProvider map[string]interface{} `json:"completionProvider"`
+		InlayHintProvider  bool                   `json:"inlayHintProvider"`
+	}
+
+	return map[string]interface{}{
+		"capabilities": capabilities{
+			// Full content changes
+			TextDocumentSync:   1,
+			HoverProvider:      true,
+			DefinitionProvider: true,
+			ReferencesProvider: true,
+			CompletionProvider: map[string]interface{}{},
+			InlayHintProvider:  true,
+		},
+		"serverInfo": map[string]string{"name": "chip8"},
+	}
+}
+
+// update assembles a document after it was opened or changed, and publishes
+// its errors. A document with errors keeps its last program.
+func (s *Server) update(uri, text string) error {
+	path, err := uriPath(uri)
+	if err != nil {
+		return &rpcError{codeInvalidParams, err.Error()}
+	}
+
+	doc, ok := s.docs[path]
+	if !ok {
+		doc = &document{path: path}
+		s.docs[path] = doc
+	}
+	doc.lines = strings.Split(text, "\n")
+
+	as := &asm.Assembler{ReadFile: s.readFile}
+	p, err := as.Assemble(path, []byte(text))
+	doc.stale = err != nil
+	if err == nil {
+		doc.program = p
+	}
+
+	return s.publish(doc, err)
+}
+
+// readFile reads included files, preferring the text of open documents to
+// the file on disk.
+func (s *Server) readFile(path string) ([]byte, error) {
+	if doc, ok := s.docs[path]; ok {
+		return []byte(strings.Join(doc.lines, "\n")), nil
+	}
+
+	return ioutil.ReadFile(path)
+}
+
+// publish publishes the errors of assembling doc, by file, clearing those of
+// files which no longer have errors.
+func (s *Server) publish(doc *document, err error) error {
+	diagnostics := map[string][]diagnostic{doc.path: {}}
+	for _, file := range doc.published {
+		diagnostics[file] = []diagnostic{}
+	}
+
+	var errs asm.ErrorList
+	switch e := err.(type) {
+	case nil:
+	case asm.ErrorList:
+		errs = e
+	default:
+		errs = asm.ErrorList{&asm.Error{Pos: asm.Pos{File: doc.path, Line: 1, Col: 1}, Msg: err.Error()}}
+	}
+
+	for _, e := range errs {
+		file := e.Pos.File
+		diagnostics[file] = append(diagnostics[file], diagnostic{
+			Range:    s.wordRange(file, e.Pos),
+			Severity: severityError,
+			Source:   "chip8",
+			Message:  e.Msg,
+		})
+	}
+
+	doc.published = doc.published[:0]
+	files := make([]string, 0, len(diagnostics))
+	for file := range diagnostics {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		if len(diagnostics[file]) > 0 {
+			doc.published = append(doc.published, file)
+		}
+		if err := s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         pathURI(file),
+			Diagnostics: diagnostics[file],
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) close(uri string) error {
+	path, err := uriPath(uri)
+	if err != nil {
+		return &rpcError{codeInvalidParams, err.Error()}
+	}
+
+	doc, ok := s.docs[path]
+	if !ok {
+		return nil
+	}
+	delete(s.docs, path)
+
+	// Errors of closed documents are no longer shown
+	for _, file := range doc.published {
+		if err := s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         pathURI(file),
+			Diagnostics: []diagnostic{},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the document, word and range of word at a position.
+func (s *Server) lookup(p textDocumentPositionParams) (*document, string, lspRange, bool) {
+	path, err := uriPath(p.TextDocument.URI)
+	if err != nil {
+		return nil, "", lspRange{}, false
+	}
+
+	doc, ok := s.docs[path]
+	if !ok || p.Position.Line < 0 || p.Position.Line >= len(doc.lines) {
+		return nil, "", lspRange{}, false
+	}
+
+	line := doc.lines[p.Position.Line]
+	start := byteOffset(line, p.Position.Character)
+	if start < 0 {
+		return nil, "", lspRange{}, false
+	}
+	end := start
+	for start > 0 && isWordByte(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWordByte(line[end]) {
+		end++
+	}
+	if start == end {
+		return doc, "", lspRange{}, false
+	}
+
+	return doc, line[start:end], lspRange{
+		Start: position{p.Position.Line, utf16Len(line[:start])},
+		End:   position{p.Position.Line, utf16Len(line[:end])},
+	}, true
+}
+
+func (s *Server) hover(p textDocumentPositionParams) interface{} {
+	doc, word, r, ok := s.lookup(p)
+	if !ok || doc.program == nil {
+		return nil
+	}
+	prog := doc.program
+
+	if addr, ok := prog.Labels[word]; ok {
+		return markdown(fmt.Sprintf("```\n%s: 0x%03x\n```\nLabel defined at %s", word, addr, prog.Definitions[word]), r)
+	}
+	if v, ok := prog.Constants[word]; ok {
+		return markdown(fmt.Sprintf("```\n%s = %d (0x%x)\n```\nConstant defined at %s", word, v, v, prog.Definitions[word]), r)
+	}
+
+	if !isMnemonic(word) || doc.stale {
+		return nil
+	}
+	line, ok := statementAt(prog, doc.path, p.Position.Line+1)
+	if !ok || line.Size != 2 {
+		return nil
+	}
+
+	b := prog.ROM[line.Addr-programAddress:]
+	op := chip8.NewOpCode([2]byte{b[0], b[1]})
+	return markdown(fmt.Sprintf("```\n0x%03x: %02x%02x %s\n```\n%s", line.Addr, b[0], b[1], chip8.Mnemonic(op), chip8.DescribeOp(op)), r)
+}
+
+func markdown(text string, r lspRange) *hover {
+	return &hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: r}
+}
+
+func (s *Server) definition(p textDocumentPositionParams) interface{} {
+	doc, word, _, ok := s.lookup(p)
+	if !ok || doc.program == nil {
+		return nil
+	}
+
+	pos, ok := doc.program.Definitions[word]
+	if !ok {
+		return nil
+	}
+
+	return []location{s.location(pos, word)}
+}
+
+func (s *Server) references(p referenceParams) interface{} {
+	doc, word, _, ok := s.lookup(p.textDocumentPositionParams)
+	if !ok || doc.program == nil {
+		return nil
+	}
+
+	locations := []location{}
+	if pos, ok := doc.program.Definitions[word]; ok && p.Context.IncludeDeclaration {
+		locations = append(locations, s.location(pos, word))
+	}
+	for _, ref := range doc.program.References {
+		if ref.Name == word {
+			locations = append(locations, s.location(ref.Pos, word))
+		}
+	}
+
+	return locations
+}
+
+func (s *Server) location(pos asm.Pos, word string) location {
+	start := s.position(pos)
+	return location{
+		URI:   pathURI(pos.File),
+		Range: lspRange{start, position{start.Line, start.Character + utf16Len(word)}},
+	}
+}
+
+func (s *Server) completion(p textDocumentPositionParams) interface{} {
+	var items []completionItem
+	for _, m := range asm.Mnemonics() {
+		items = append(items, completionItem{Label: m, Kind: completionKeyword, Detail: "instruction"})
+	}
+	for _, d := range asm.Directives() {
+		items = append(items, completionItem{Label: d, Kind: completionKeyword, Detail: "directive"})
+	}
+	for i := 0; i < 16; i++ {
+		items = append(items, completionItem{Label: fmt.Sprintf("V%X", i), Kind: completionVariable, Detail: "register"})
+	}
+	for _, k := range []string{"I", "[I]", "DT", "ST", "K", "F", "B"} {
+		items = append(items, completionItem{Label: k, Kind: completionVariable, Detail: "register"})
+	}
+
+	path, err := uriPath(p.TextDocument.URI)
+	if doc, ok := s.docs[path]; err == nil && ok && doc.program != nil {
+		var names []string
+		for name := range doc.program.Definitions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if addr, ok := doc.program.Labels[name]; ok {
+				items = append(items, completionItem{Label: name, Kind: completionConstant, Detail: fmt.Sprintf("label 0x%03x", addr)})
+			} else {
+				items = append(items, completionItem{Label: name, Kind: completionConstant, Detail: fmt.Sprintf("constant %d", doc.program.Constants[name])})
+			}
+		}
+	}
+
+	return items
+}
+
+// inlayHints shows the bytes assembled from each line at its end.
+func (s *Server) inlayHints(p inlayHintParams) interface{} {
+	hints := []inlayHint{}
+
+	path, err := uriPath(p.TextDocument.URI)
+	doc, ok := s.docs[path]
+	if err != nil || !ok || doc.program == nil || doc.stale {
+		return hints
+	}
+
+	prog := doc.program
+	seen := make(map[int]bool)
+	for _, line := range prog.Lines {
+		n := line.Pos.Line - 1
+		if line.Pos.File != doc.path || n < p.Range.Start.Line || n > p.Range.End.Line || n >= len(doc.lines) || seen[n] {
+			continue
+		}
+		// Macro bodies assemble once per expansion, show the first
+		seen[n] = true
+
+		b := prog.ROM[line.Addr-programAddress:]
+		b = b[:line.Size]
+		label := fmt.Sprintf("% x", b)
+		if len(b) > maxHintBytes {
+			label = fmt.Sprintf("% x ...", b[:maxHintBytes])
+		}
+
+		hints = append(hints, inlayHint{
+			Position:    position{n, utf16Len(strings.TrimRight(doc.lines[n], " \t\r"))},
+			Label:       label,
+			PaddingLeft: true,
+		})
+	}
+
+	sort.SliceStable(hints, func(i, j int) bool { return hints[i].Position.Line < hints[j].Position.Line })
+
+	return hints
+}
+
+// statementAt returns the first statement assembled from a line of a file.
+func statementAt(p *asm.Program, file string, n int) (asm.Line, bool) {
+	for _, line := range p.Lines {
+		if line.Pos.File == file && line.Pos.Line == n {
+			return line, true
+		}
+	}
+
+	return asm.Line{}, false
+}
+
+// wordRange returns the range of the word at pos, or of the character at pos
+// when there is no word there.
+func (s *Server) wordRange(file string, pos asm.Pos) lspRange {
+	start := s.position(pos)
+	end := position{start.Line, start.Character + 1}
+
+	line, ok := s.line(file, pos.Line-1)
+	if col := pos.Col - 1; ok && col < len(line) {
+		i := col
+		for i < len(line) && isWordByte(line[i]) {
+			i++
+		}
+		if i > col {
+			end.Character = start.Character + utf16Len(line[col:i])
+		}
+	}
+
+	return lspRange{start, end}
+}
+
+// position returns the LSP position of pos, whose column counts bytes.
+func (s *Server) position(pos asm.Pos) position {
+	col := pos.Col - 1
+	if line, ok := s.line(pos.File, pos.Line-1); ok && col <= len(line) {
+		col = utf16Len(line[:col])
+	}
+
+	return position{pos.Line - 1, col}
+}
+
+// line returns line n of a file, counting from 0.
+func (s *Server) line(file string, n int) (string, bool) {
+	src, err := s.readFile(file)
+	if err != nil {
+		return "", false
+	}
+
+	lines := strings.Split(string(src), "\n")
+	if n < 0 || n >= len(lines) {
+		return "", false
+	}
+
+	return lines[n], true
+}
+
+// utf16Len returns the length of s in UTF-16 code units.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			// A surrogate pair
+			n++
+		}
+	}
+
+	return n
+}
+
+// byteOffset returns the offset in line of the character at col, counted in
+// UTF-16 code units, or -1 when col is outside the line.
+func byteOffset(line string, col int) int {
+	if col < 0 {
+		return -1
+	}
+
+	n := 0
+	for i, r := range line {
+		if n >= col {
+			return i
+		}
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	if n >= col {
+		return len(line)
+	}
+
+	return -1
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isMnemonic(word string) bool {
+	word = strings.ToUpper(word)
+	for _, m := range asm.Mnemonics() {
+		if m == word {
+			return true
+		}
+	}
+
+	return false
+}
+
+// uriPath returns the path of a file URI.
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI %q, expected a file", uri)
+	}
+
+	return filepath.FromSlash(u.Path), nil
+}
+
+// pathURI returns the file URI of a path.
+func pathURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,297 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const source = `SPEED equ 2
+
+start:  CLS
+        LD V0, SPEED
+loop:   ADD V0, SPEED
+        CALL draw
+        JP loop
+
+draw:   DRW V0, V1, 5
+        RET
+        DB 1, 2, 3, 4, 5, 6, 7, 8, 9
+`
+
+// session runs the server over a script of requests and notifications,
+// returning the messages it wrote. Requests are messages with an ID.
+func session(t *testing.T, script ...map[string]interface{}) []map[string]interface{} {
+	in := &bytes.Buffer{}
+	for _, m := range script {
+		m["jsonrpc"] = "2.0"
+		require.NoError(t, writeMessage(in, m))
+	}
+
+	out := &bytes.Buffer{}
+	require.NoError(t, NewServer(in, out).Serve())
+
+	var messages []map[string]interface{}
+	r := bufio.NewReader(out)
+	for {
+		body, err := readMessage(r)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &m))
+		messages = append(messages, m)
+	}
+
+	return messages
+}
+
+func request(id int, method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"id": id, "method": method, "params": params}
+}
+
+func notification(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"method": method, "params": params}
+}
+
+func open(uri, text string) map[string]interface{} {
+	return notification("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "chip8", "version": 1, "text": text},
+	})
+}
+
+func at(id int, method, uri string, line, char int) map[string]interface{} {
+	return request(id, method, map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": char},
+		"context":      map[string]bool{"includeDeclaration": true},
+	})
+}
+
+// result returns the result of the response to the request with id.
+func result(t *testing.T, messages []map[string]interface{}, id int) interface{} {
+	for _, m := range messages {
+		if v, ok := m["id"].(float64); ok && int(v) == id {
+			require.Nil(t, m["error"], "request %d", id)
+			return m["result"]
+		}
+	}
+
+	t.Fatalf("no response to request %d", id)
+	return nil
+}
+
+// toJSON normalises v to what it reads as after a JSON round trip.
+func toJSON(t *testing.T, v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	var out interface{}
+	require.NoError(t, json.Unmarshal(b, &out))
+
+	return out
+}
+
+func TestServer(t *testing.T) {
+	uri := "file:///src/game.asm"
+	messages := session(t,
+		request(1, "initialize", map[string]interface{}{}),
+		notification("initialized", map[string]interface{}{}),
+		open(uri, source),
+		at(2, "textDocument/hover", uri, 2, 9),
+		at(3, "textDocument/hover", uri, 5, 14),
+		at(4, "textDocument/hover", uri, 0, 2),
+		at(5, "textDocument/definition", uri, 6, 12),
+		at(6, "textDocument/references", uri, 0, 1),
+		at(7, "textDocument/completion", uri, 3, 8),
+		request(8, "textDocument/inlayHint", map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"range":        map[string]interface{}{"start": map[string]int{"line": 0}, "end": map[string]int{"line": 20}},
+		}),
+		at(9, "textDocument/hover", uri, 1, 0),
+		request(10, "textDocument/formatting", map[string]interface{}{}),
+		at(12, "textDocument/hover", uri, -1, 0),
+		at(13, "textDocument/hover", uri, 2, -1),
+		request(11, "shutdown", nil),
+		notification("exit", nil),
+	)
+
+	capabilities := result(t, messages, 1).(map[string]interface{})["capabilities"].(map[string]interface{})
+	assert.Equal(t, true, capabilities["hoverProvider"])
+	assert.Equal(t, true, capabilities["inlayHintProvider"])
+
+	// A program without errors clears the diagnostics
+	assert.Equal(t, toJSON(t, notification("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri": uri, "diagnostics": []interface{}{},
+	})), toJSON(t, map[string]interface{}{"method": messages[1]["method"], "params": messages[1]["params"]}))
+
+	assert.Equal(t, map[string]interface{}{
+		"contents": map[string]interface{}{"kind": "markdown", "value": "```\n0x200: 00e0 CLS\n```\nClear the screen"},
+		"range":    toJSON(t, lspRange{position{2, 8}, position{2, 11}}),
+	}, result(t, messages, 2))
+
+	hover := result(t, messages, 3).(map[string]interface{})["contents"].(map[string]interface{})
+	assert.Equal(t, "```\ndraw: 0x20a\n```\nLabel defined at /src/game.asm:9:1", hover["value"])
+
+	hover = result(t, messages, 4).(map[string]interface{})["contents"].(map[string]interface{})
+	assert.Equal(t, "```\nSPEED = 2 (0x2)\n```\nConstant defined at /src/game.asm:1:1", hover["value"])
+
+	assert.Equal(t, toJSON(t, []location{{uri, lspRange{position{4, 0}, position{4, 4}}}}), result(t, messages, 5))
+
+	assert.Equal(t, toJSON(t, []location{
+		{uri, lspRange{position{0, 0}, position{0, 5}}},
+		{uri, lspRange{position{3, 15}, position{3, 20}}},
+		{uri, lspRange{position{4, 16}, position{4, 21}}},
+	}), result(t, messages, 6))
+
+	labels := map[string]bool{}
+	for _, item := range result(t, messages, 7).([]interface{}) {
+		labels[item.(map[string]interface{})["label"].(string)] = true
+	}
+	for _, label := range []string{"CLS", "DRW", "db", "VF", "DT", "[I]", "loop", "SPEED"} {
+		assert.True(t, labels[label], label)
+	}
+
+	assert.Equal(t, toJSON(t, []inlayHint{
+		{position{2, 11}, "00 e0", true},
+		{position{3, 20}, "60 02", true},
+		{position{4, 21}, "70 02", true},
+		{position{5, 17}, "22 0a", true},
+		{position{6, 15}, "12 04", true},
+		{position{8, 21}, "d0 15", true},
+		{position{9, 11}, "00 ee", true},
+		{position{10, 36}, "01 02 03 04 05 06 07 08 ...", true},
+	}), result(t, messages, 8))
+
+	assert.Nil(t, result(t, messages, 9))
+	assert.Nil(t, result(t, messages, 12))
+	assert.Nil(t, result(t, messages, 13))
+
+	for _, m := range messages {
+		if m["id"] == float64(10) {
+			assert.Equal(t, float64(codeMethodNotFound), m["error"].(map[string]interface{})["code"])
+		}
+	}
+}
+
+func TestServer_diagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lib := filepath.Join(dir, "lib.asm")
+	require.NoError(t, ioutil.WriteFile(lib, []byte("draw: DRW V0, V1, 16\n"), 0644))
+
+	main := filepath.Join(dir, "main.asm")
+	uri := pathURI(main)
+	messages := session(t,
+		open(uri, "start: JP nowhere\ninclude \"lib.asm\"\n"),
+		notification("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]string{"uri": uri},
+			"contentChanges": []map[string]string{{"text": "start: JP start\n"}},
+		}),
+		request(1, "shutdown", nil),
+		notification("exit", nil),
+	)
+
+	var published []interface{}
+	for _, m := range messages {
+		if m["method"] == "textDocument/publishDiagnostics" {
+			published = append(published, m["params"])
+		}
+	}
+
+	assert.Equal(t, toJSON(t, []publishDiagnosticsParams{
+		{pathURI(lib), []diagnostic{
+			{lspRange{position{0, 18}, position{0, 20}}, severityError, "chip8", "nibble out of range: 16 (0x10)"},
+		}},
+		{uri, []diagnostic{
+			{lspRange{position{0, 10}, position{0, 17}}, severityError, "chip8", `undefined symbol "nowhere"`},
+		}},
+		// Fixing the errors clears them in both files
+		{pathURI(lib), []diagnostic{}},
+		{uri, []diagnostic{}},
+	}), toJSON(t, published))
+}
+
+func TestServer_exitWithoutShutdown(t *testing.T) {
+	in := &bytes.Buffer{}
+	require.NoError(t, writeMessage(in, map[string]string{"jsonrpc": "2.0", "method": "exit"}))
+
+	assert.EqualError(t, NewServer(in, ioutil.Discard).Serve(), "exit before shutdown")
+}
+
+func TestServer_errorsKeepProgram(t *testing.T) {
+	uri := "file:///src/game.asm"
+	messages := session(t,
+		open(uri, source),
+		notification("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]string{"uri": uri},
+			"contentChanges": []map[string]string{{"text": "\n\n\n\n\n        CALL draw\n        JP nowhere\n"}},
+		}),
+		at(1, "textDocument/hover", uri, 5, 14),
+		at(2, "textDocument/definition", uri, 5, 14),
+		at(3, "textDocument/hover", uri, 5, 9),
+		request(4, "textDocument/inlayHint", map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"range":        map[string]interface{}{"start": map[string]int{"line": 0}, "end": map[string]int{"line": 20}},
+		}),
+		request(5, "shutdown", nil),
+		notification("exit", nil),
+	)
+
+	var diagnostics []interface{}
+	for _, m := range messages {
+		if m["method"] == "textDocument/publishDiagnostics" {
+			diagnostics = m["params"].(map[string]interface{})["diagnostics"].([]interface{})
+		}
+	}
+	assert.Len(t, diagnostics, 2, "expected the errors to be published")
+
+	// Labels of the last program without errors still resolve
+	hover := result(t, messages, 1).(map[string]interface{})["contents"].(map[string]interface{})
+	assert.Equal(t, "```\ndraw: 0x20a\n```\nLabel defined at /src/game.asm:9:1", hover["value"])
+	assert.Len(t, result(t, messages, 2), 1)
+
+	// Its lines no longer match the text
+	assert.Nil(t, result(t, messages, 3))
+	assert.Empty(t, result(t, messages, 4))
+}
+
+func TestServer_utf16(t *testing.T) {
+	uri := "file:///src/game.asm"
+	// SPEED starts at byte 21 of the last line, but character 18 in UTF-16:
+	// é is 2 bytes and 1 code unit, 🎮 4 bytes and 2 code units
+	messages := session(t,
+		open(uri, "SPEED equ 2\nmsg:    DB \"é🎮\", SPEED ; ok\n"),
+		at(1, "textDocument/hover", uri, 1, 19),
+		at(2, "textDocument/references", uri, 0, 1),
+		request(3, "textDocument/inlayHint", map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"range":        map[string]interface{}{"start": map[string]int{"line": 0}, "end": map[string]int{"line": 1}},
+		}),
+		at(4, "textDocument/hover", uri, 1, 31),
+		request(5, "shutdown", nil),
+		notification("exit", nil),
+	)
+
+	assert.Equal(t, toJSON(t, lspRange{position{1, 18}, position{1, 23}}), result(t, messages, 1).(map[string]interface{})["range"])
+	assert.Equal(t, toJSON(t, []location{
+		{uri, lspRange{position{0, 0}, position{0, 5}}},
+		{uri, lspRange{position{1, 18}, position{1, 23}}},
+	}), result(t, messages, 2))
+	assert.Equal(t, toJSON(t, []inlayHint{
+		{position{1, 28}, "c3 a9 f0 9f 8e ae 02", true},
+	}), result(t, messages, 3))
+	assert.Nil(t, result(t, messages, 4), "expected a position past the end of the line to be rejected")
+}
@@ -0,0 +1,121 @@
+package octo
+
+import (
+	"math"
+)
+
+// Unary operators of :calc expressions
+var unaryOperators = map[string]func(float64) float64{
+	"-":     func(x float64) float64 { return -x },
+	"~":     func(x float64) float64 { return float64(^int64(x)) },
+	"!":     func(x float64) float64 { return truth(x == 0) },
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"sign": func(x float64) float64 {
+		switch {
+		case x > 0:
+			return 1
+		case x < 0:
+			return -1
+		}
+		return 0
+	},
+}
+
+// Binary operators of :calc expressions
+var binaryOperators = map[string]func(x, y float64) float64{
+	"+":   func(x, y float64) float64 { return x + y },
+	"-":   func(x, y float64) float64 { return x - y },
+	"*":   func(x, y float64) float64 { return x * y },
+	"/":   func(x, y float64) float64 { return x / y },
+	"%":   math.Mod,
+	"&":   func(x, y float64) float64 { return float64(int64(x) & int64(y)) },
+	"|":   func(x, y float64) float64 { return float64(int64(x) | int64(y)) },
+	"^":   func(x, y float64) float64 { return float64(int64(x) ^ int64(y)) },
+	"<<":  func(x, y float64) float64 { return float64(int64(x) << uint(int64(y)&63)) },
+	">>":  func(x, y float64) float64 { return float64(int64(x) >> uint(int64(y)&63)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"<":   func(x, y float64) float64 { return truth(x < y) },
+	"<=":  func(x, y float64) float64 { return truth(x <= y) },
+	"==":  func(x, y float64) float64 { return truth(x == y) },
+	"!=":  func(x, y float64) float64 { return truth(x != y) },
+	">=":  func(x, y float64) float64 { return truth(x >= y) },
+	">":   func(x, y float64) float64 { return truth(x > y) },
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// calc evaluates the expression between braces following the current token.
+// As in Octo, binary operators have no precedence and group to the right, so
+// 2 * 3 + 4 is 14.
+func (c *compiler) calc() float64 {
+	c.expect("{")
+	v := c.calcExpr()
+	c.expect("}")
+
+	return v
+}
+
+func (c *compiler) calcExpr() float64 {
+	x := c.calcTerm()
+
+	if op, ok := binaryOperators[c.peek().text]; ok {
+		c.next()
+		return op(x, c.calcExpr())
+	}
+
+	return x
+}
+
+func (c *compiler) calcTerm() float64 {
+	t := c.next()
+
+	if t.text == "(" {
+		v := c.calcExpr()
+		c.expect(")")
+		return v
+	}
+	if op, ok := unaryOperators[t.text]; ok {
+		return op(c.calcTerm())
+	}
+
+	switch t.text {
+	case "@":
+		addr := int(c.calcTerm())
+		if addr < 0 || addr >= len(c.memory) {
+			c.errorf(t.pos, "address out of range: %d", addr)
+		}
+		return float64(c.memory[addr])
+	case "HERE":
+		return float64(c.here)
+	case "PI":
+		return math.Pi
+	case "E":
+		return math.E
+	}
+
+	if v, ok := c.constant(t); ok {
+		return v
+	}
+	if addr, ok := c.labels[t.text]; ok {
+		return float64(addr)
+	}
+
+	c.errorf(t.pos, "undefined name %q in expression", t.text)
+	return 0
+}
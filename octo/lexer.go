@@ -0,0 +1,42 @@
+package octo
+
+import (
+	"strings"
+
+	"github.com/janezkenda/chip8/asm"
+)
+
+type token struct {
+	text string
+	pos  asm.Pos
+}
+
+// lex splits src into tokens, which Octo separates by whitespace. Comments
+// run from # to the end of the line.
+func lex(name string, src string) []token {
+	var tokens []token
+	for i, line := range strings.Split(src, "\n") {
+		if c := strings.IndexByte(line, '#'); c >= 0 {
+			line = line[:c]
+		}
+
+		for col := 0; col < len(line); {
+			if isSpace(line[col]) {
+				col++
+				continue
+			}
+
+			start := col
+			for col < len(line) && !isSpace(line[col]) {
+				col++
+			}
+			tokens = append(tokens, token{line[start:col], asm.Pos{File: name, Line: i + 1, Col: start + 1}})
+		}
+	}
+
+	return tokens
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
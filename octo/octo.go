@@ -0,0 +1,915 @@
+// Package octo compiles programs written in Octo, the high-level assembly
+// language most CHIP-8 programs are written in nowadays.
+//
+// The compiler supports labels, :alias, :const, :calc, :macro, :unpack,
+// :next, :org, :byte, :pointer, :call, :breakpoint, the loop, while and again
+// loops, the if-then and if-begin-else-end conditionals, including the
+// <, >, <= and >= comparisons, and the SUPER-CHIP and XO-CHIP instructions.
+// The emulator only runs CHIP-8 instructions, so programs using the
+// extensions compile but halt when they reach one.
+//
+// Execution starts at the label main. Unless main is the first thing in the
+// program, the compiler puts a jump to it at 0x200. Compiled programs must
+// run with Quirks.
+package octo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// Address programs are loaded at
+const programAddress = 0x200
+
+// Most macro expansions in a program, to catch recursive macros
+const maxExpansions = 10000
+
+// Quirks returns the quirks compiled programs run with, those of Octo by
+// default: none. 8XY6 and 8XYE shift VY into VX, FX55 and FX65 advance I,
+// sprites wrap around the screen, DXYN does not wait for the next frame, and
+// 8XY4 to 8XYE write VF after the result, which the <, >, <= and >=
+// comparisons rely on. Cartridges and the cart command may select others.
+func Quirks() chip8.Quirks {
+	return chip8.Quirks{}
+}
+
+// Program is a compiled program.
+type Program struct {
+	// Bytes of the program, loaded at 0x200
+	ROM []byte
+
+	// Addresses of the labels, by name
+	Labels map[string]uint16
+
+	// Values of the constants defined with :const and :calc, by name
+	Constants map[string]float64
+
+	// Names of the :breakpoint statements, by address
+	Breakpoints map[uint16]string
+
+	// Source of the bytes of the program
+	Lines asm.SourceMap
+}
+
+// CompileFile compiles the file at path.
+func CompileFile(path string) (*Program, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Compile(path, src)
+}
+
+// Compile compiles src, read from the file name. Compilation stops at the
+// first error, which is returned as an asm.ErrorList.
+func Compile(name string, src []byte) (p *Program, err error) {
+	c := &compiler{
+		tokens:      lex(name, string(src)),
+		eof:         asm.Pos{File: name, Line: 1, Col: 1},
+		labels:      make(map[string]int),
+		constants:   make(map[string]float64),
+		aliases:     make(map[string]int),
+		macros:      make(map[string]*macro),
+		breakpoints: make(map[uint16]string),
+		here:        programAddress,
+	}
+
+	// Errors at the end of the file are reported after the last token
+	if n := len(c.tokens); n > 0 {
+		c.eof = c.tokens[n-1].pos
+		c.eof.Col += len(c.tokens[n-1].text)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*asm.Error)
+			if !ok {
+				panic(r)
+			}
+			p, err = nil, asm.ErrorList{e}
+		}
+	}()
+
+	// Reserve room for a jump to main
+	c.emit(0, 0)
+	c.mainJump = true
+
+	for c.i < len(c.tokens) {
+		c.statement()
+	}
+
+	return c.finish(), nil
+}
+
+type fixupKind int
+
+const (
+	// The low 12 bits of an instruction
+	fixup12 fixupKind = iota
+	// A 16-bit address
+	fixup16
+	// The high nibble of an address in the low nibble of a byte
+	fixupHigh
+	// The low byte of an address
+	fixupLow
+	// The high byte of an address
+	fixupHighByte
+)
+
+// fixup is a reference to a label which was not defined yet.
+type fixup struct {
+	addr int
+	kind fixupKind
+	name string
+	pos  asm.Pos
+}
+
+type macro struct {
+	params []string
+	body   []token
+	calls  int
+}
+
+// flow is an open loop or if-begin block.
+type flow struct {
+	pos asm.Pos
+
+	// Address of the first instruction of a loop
+	start int
+
+	// Addresses of the jumps to patch when the block ends
+	jumps []int
+
+	loop bool
+	els  bool
+}
+
+type compiler struct {
+	tokens []token
+	i      int
+	eof    asm.Pos
+
+	memory  [0x10000]byte
+	written [0x10000]bool
+	here    int
+
+	labels      map[string]int
+	constants   map[string]float64
+	aliases     map[string]int
+	macros      map[string]*macro
+	breakpoints map[uint16]string
+	fixups      []fixup
+	flows       []*flow
+	lines       asm.SourceMap
+	expansions  int
+
+	// Whether 0x200 holds a jump to main
+	mainJump bool
+}
+
+func (c *compiler) errorf(pos asm.Pos, format string, args ...interface{}) {
+	panic(&asm.Error{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *compiler) peek() token {
+	if c.i < len(c.tokens) {
+		return c.tokens[c.i]
+	}
+
+	return token{pos: c.eof}
+}
+
+func (c *compiler) next() token {
+	t := c.peek()
+	if c.i == len(c.tokens) {
+		c.errorf(t.pos, "unexpected end of file")
+	}
+	c.i++
+
+	return t
+}
+
+func (c *compiler) expect(text string) token {
+	t := c.next()
+	if t.text != text {
+		c.errorf(t.pos, "expected %q, found %q", text, t.text)
+	}
+
+	return t
+}
+
+// emit writes bytes at the current address.
+func (c *compiler) emit(b ...byte) {
+	for _, v := range b {
+		if c.here >= len(c.memory) {
+			c.errorf(c.peek().pos, "program does not fit in memory")
+		}
+		if c.written[c.here] {
+			c.errorf(c.tokens[c.i-1].pos, "0x%04x is written twice", c.here)
+		}
+
+		c.memory[c.here] = v
+		c.written[c.here] = true
+		c.here++
+	}
+}
+
+func (c *compiler) inst(op uint16) {
+	c.emit(byte(op>>8), byte(op))
+}
+
+func (c *compiler) statement() {
+	start, pos := c.here, c.peek().pos
+	c.compile()
+
+	if c.here > start {
+		c.lines = append(c.lines, asm.Line{Addr: uint16(start), Size: c.here - start, Pos: pos})
+	}
+}
+
+func (c *compiler) compile() {
+	t := c.next()
+
+	switch t.text {
+	case ":":
+		c.defineLabel(c.next())
+	case ":alias":
+		// Aliases may be redefined
+		name := c.next()
+		delete(c.aliases, name.text)
+		c.checkName(name)
+		c.aliases[name.text] = c.register(c.next())
+	case ":const":
+		name := c.next()
+		c.checkName(name)
+		c.constants[name.text] = c.number(c.next())
+	case ":calc":
+		name := c.next()
+		c.checkName(name)
+		c.constants[name.text] = c.calc()
+	case ":unpack":
+		c.unpack()
+	case ":next":
+		name := c.next()
+		c.checkName(name)
+		c.labels[name.text] = c.here + 1
+	case ":org":
+		addr := c.next()
+		v := int(c.number(addr))
+		if v < programAddress || v >= len(c.memory) {
+			c.errorf(addr.pos, "address out of range: 0x%x", v)
+		}
+		c.here = v
+	case ":macro":
+		c.defineMacro()
+	case ":byte":
+		if c.peek().text == "{" {
+			c.emit(c.byteValue(c.peek().pos, c.calc()))
+		} else {
+			c.emit(c.immediate(c.next()))
+		}
+	case ":pointer":
+		c.emit(0, 0)
+		c.address(c.next(), c.here-2, fixup16)
+	case ":breakpoint":
+		c.breakpoints[uint16(c.here)] = c.next().text
+	case ":call":
+		c.jump(0x2000)
+	case ";", "return":
+		c.inst(0x00ee)
+	case "clear":
+		c.inst(0x00e0)
+	case "bcd":
+		c.inst(0xf033 | c.regX())
+	case "save", "load":
+		c.saveLoad(t.text)
+	case "sprite":
+		x, y := c.regX(), c.regY()
+		n := c.next()
+		v := int(c.number(n))
+		if v < 0 || v > 0xf {
+			c.errorf(n.pos, "sprite height out of range: %d", v)
+		}
+		c.inst(0xd000 | x | y | uint16(v))
+	case "jump":
+		c.jump(0x1000)
+	case "jump0":
+		c.jump(0xb000)
+	case "native":
+		c.jump(0x0000)
+	case "delay", "buzzer", "pitch":
+		c.expect(":=")
+		c.inst(map[string]uint16{"delay": 0xf015, "buzzer": 0xf018, "pitch": 0xf03a}[t.text] | c.regX())
+	case "i":
+		c.assignI()
+	case "if":
+		c.conditional()
+	case "else":
+		c.elseBlock(t)
+	case "end":
+		f := c.popFlow(t, false)
+		c.patch(f.jumps)
+	case "loop":
+		c.flows = append(c.flows, &flow{pos: t.pos, start: c.here, loop: true})
+	case "while":
+		f := c.innermostLoop(t)
+		c.condition(true)
+		f.jumps = append(f.jumps, c.here)
+		c.inst(0x1000)
+	case "again":
+		f := c.popFlow(t, true)
+		c.inst(0x1000 | c.addr12(t.pos, f.start))
+		c.patch(f.jumps)
+	case "hires":
+		c.inst(0x00ff)
+	case "lores":
+		c.inst(0x00fe)
+	case "scroll-down":
+		c.inst(0x00c0 | c.nibble(c.next()))
+	case "scroll-up":
+		c.inst(0x00d0 | c.nibble(c.next()))
+	case "scroll-left":
+		c.inst(0x00fc)
+	case "scroll-right":
+		c.inst(0x00fb)
+	case "exit":
+		c.inst(0x00fd)
+	case "saveflags":
+		c.inst(0xf075 | c.regX())
+	case "loadflags":
+		c.inst(0xf085 | c.regX())
+	case "plane":
+		c.inst(0xf001 | c.nibble(c.next())<<8)
+	case "audio":
+		c.inst(0xf002)
+	default:
+		c.other(t)
+	}
+}
+
+// other compiles register assignments, macro invocations, bytes and calls.
+func (c *compiler) other(t token) {
+	if r, ok := c.isRegister(t); ok {
+		c.assign(uint16(r))
+		return
+	}
+	if m, ok := c.macros[t.text]; ok {
+		c.expand(t, m)
+		return
+	}
+	if _, ok := c.constant(t); ok {
+		c.emit(c.immediate(t))
+		return
+	}
+	if strings.HasPrefix(t.text, ":") || strings.HasPrefix(t.text, "{") {
+		c.errorf(t.pos, "unknown statement %q", t.text)
+	}
+
+	// A bare label calls it
+	c.inst(0x2000)
+	c.address(t, c.here-2, fixup12)
+}
+
+func (c *compiler) checkName(t token) {
+	if _, ok := c.isRegister(t); ok || t.text == "i" {
+		c.errorf(t.pos, "%q is a register and cannot be redefined", t.text)
+	}
+	if _, err := strconv.ParseFloat(t.text, 64); err == nil || strings.HasPrefix(t.text, "0x") || strings.HasPrefix(t.text, "0b") {
+		c.errorf(t.pos, "%q is a number and cannot be used as a name", t.text)
+	}
+	if _, ok := c.labels[t.text]; ok {
+		c.errorf(t.pos, "%q is already defined", t.text)
+	}
+	if _, ok := c.constants[t.text]; ok {
+		c.errorf(t.pos, "%q is already defined", t.text)
+	}
+}
+
+func (c *compiler) defineLabel(name token) {
+	c.checkName(name)
+
+	// A main at the start of the program needs no jump to reach it
+	if name.text == "main" && c.mainJump && c.here == programAddress+2 && len(c.labels) == 0 {
+		c.mainJump = false
+		c.written[programAddress] = false
+		c.written[programAddress+1] = false
+		c.here = programAddress
+	}
+
+	c.labels[name.text] = c.here
+}
+
+func (c *compiler) defineMacro() {
+	name := c.next()
+	c.checkName(name)
+
+	m := &macro{}
+	for c.peek().text != "{" {
+		m.params = append(m.params, c.next().text)
+	}
+	c.next()
+
+	for depth := 1; ; {
+		t := c.next()
+		switch t.text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+		m.body = append(m.body, t)
+	}
+
+	c.macros[name.text] = m
+}
+
+// expand replaces a macro invocation by the body of the macro, with its
+// parameters replaced by the arguments. CALLS in the body is replaced by the
+// number of earlier invocations.
+func (c *compiler) expand(name token, m *macro) {
+	c.expansions++
+	if c.expansions > maxExpansions {
+		c.errorf(name.pos, "too many macro expansions, is macro %q recursive?", name.text)
+	}
+
+	args := make(map[string]string)
+	for _, param := range m.params {
+		args[param] = c.next().text
+	}
+	args["CALLS"] = strconv.Itoa(m.calls)
+	m.calls++
+
+	body := make([]token, 0, len(m.body)+len(c.tokens)-c.i)
+	for _, t := range m.body {
+		if arg, ok := args[t.text]; ok {
+			t.text = arg
+		}
+		body = append(body, t)
+	}
+
+	c.tokens = append(body, c.tokens[c.i:]...)
+	c.i = 0
+}
+
+func (c *compiler) isRegister(t token) (int, bool) {
+	if r, ok := c.aliases[t.text]; ok {
+		return r, true
+	}
+	if len(t.text) == 2 && (t.text[0] == 'v' || t.text[0] == 'V') {
+		if r, err := strconv.ParseUint(t.text[1:], 16, 4); err == nil {
+			return int(r), true
+		}
+	}
+
+	return 0, false
+}
+
+func (c *compiler) register(t token) int {
+	r, ok := c.isRegister(t)
+	if !ok {
+		c.errorf(t.pos, "expected a register, found %q", t.text)
+	}
+
+	return r
+}
+
+// regX parses a register for the X nibble of an instruction.
+func (c *compiler) regX() uint16 {
+	return uint16(c.register(c.next())) << 8
+}
+
+// regY parses a register for the Y nibble of an instruction.
+func (c *compiler) regY() uint16 {
+	return uint16(c.register(c.next())) << 4
+}
+
+// constant returns the value of a number or constant.
+func (c *compiler) constant(t token) (float64, bool) {
+	if v, ok := c.constants[t.text]; ok {
+		return v, true
+	}
+
+	if v, err := strconv.ParseInt(t.text, 0, 64); err == nil {
+		return float64(v), true
+	}
+
+	return 0, false
+}
+
+// number returns the value of a number, constant or defined label.
+func (c *compiler) number(t token) float64 {
+	if v, ok := c.constant(t); ok {
+		return v
+	}
+	if v, ok := c.labels[t.text]; ok {
+		return float64(v)
+	}
+
+	c.errorf(t.pos, "expected a number, found %q", t.text)
+	return 0
+}
+
+func (c *compiler) byteValue(pos asm.Pos, v float64) byte {
+	n := int(math.Floor(v))
+	if n < -128 || n > 255 {
+		c.errorf(pos, "value out of range for a byte: %d", n)
+	}
+
+	return byte(n)
+}
+
+func (c *compiler) immediate(t token) byte {
+	return c.byteValue(t.pos, c.number(t))
+}
+
+func (c *compiler) nibble(t token) uint16 {
+	v := int(c.number(t))
+	if v < 0 || v > 0xf {
+		c.errorf(t.pos, "value out of range for a nibble: %d", v)
+	}
+
+	return uint16(v)
+}
+
+func (c *compiler) addr12(pos asm.Pos, addr int) uint16 {
+	if addr < 0 || addr > 0xfff {
+		c.errorf(pos, "address 0x%x does not fit in 12 bits", addr)
+	}
+
+	return uint16(addr)
+}
+
+// address stores the address named by t at addr, resolving labels which are
+// not defined yet once the whole program is compiled.
+func (c *compiler) address(t token, addr int, kind fixupKind) {
+	f := fixup{addr: addr, kind: kind, name: t.text, pos: t.pos}
+
+	if v, ok := c.constant(t); ok {
+		c.resolve(f, int(v))
+		return
+	}
+	if v, ok := c.labels[t.text]; ok {
+		c.resolve(f, v)
+		return
+	}
+
+	if _, ok := c.isRegister(t); ok {
+		c.errorf(t.pos, "expected an address, found register %q", t.text)
+	}
+	c.fixups = append(c.fixups, f)
+}
+
+func (c *compiler) resolve(f fixup, v int) {
+	switch f.kind {
+	case fixup12:
+		v := c.addr12(f.pos, v)
+		c.memory[f.addr] |= byte(v >> 8)
+		c.memory[f.addr+1] = byte(v)
+	case fixup16:
+		if v < 0 || v > 0xffff {
+			c.errorf(f.pos, "address 0x%x does not fit in 16 bits", v)
+		}
+		c.memory[f.addr] = byte(v >> 8)
+		c.memory[f.addr+1] = byte(v)
+	case fixupHigh:
+		c.memory[f.addr] |= byte(c.addr12(f.pos, v) >> 8)
+	case fixupLow:
+		c.memory[f.addr] = byte(v)
+	case fixupHighByte:
+		c.memory[f.addr] = byte(v >> 8)
+	}
+}
+
+func (c *compiler) jump(opcode uint16) {
+	c.inst(opcode)
+	c.address(c.next(), c.here-2, fixup12)
+}
+
+func (c *compiler) unpack() {
+	t := c.next()
+	if t.text == "long" {
+		label := c.next()
+		c.inst(0x6000)
+		c.address(label, c.here-1, fixupHighByte)
+		c.inst(0x6100)
+		c.address(label, c.here-1, fixupLow)
+		return
+	}
+
+	nibble := c.nibble(t)
+	label := c.next()
+	c.inst(0x6000 | nibble<<4)
+	c.address(label, c.here-1, fixupHigh)
+	c.inst(0x6100)
+	c.address(label, c.here-1, fixupLow)
+}
+
+func (c *compiler) saveLoad(name string) {
+	x := c.regX()
+
+	opcode := map[string]uint16{"save": 0xf055, "load": 0xf065}[name]
+	if c.peek().text == "-" {
+		// XO-CHIP saves and loads a range of registers
+		c.next()
+		opcode = map[string]uint16{"save": 0x5002, "load": 0x5003}[name] | c.regY()
+	}
+
+	c.inst(opcode | x)
+}
+
+func (c *compiler) assignI() {
+	switch op := c.next(); op.text {
+	case ":=":
+		switch t := c.next(); t.text {
+		case "hex":
+			c.inst(0xf029 | c.regX())
+		case "bighex":
+			c.inst(0xf030 | c.regX())
+		case "long":
+			c.inst(0xf000)
+			c.emit(0, 0)
+			c.address(c.next(), c.here-2, fixup16)
+		default:
+			c.inst(0xa000)
+			c.address(t, c.here-2, fixup12)
+		}
+	case "+=":
+		c.inst(0xf01e | c.regX())
+	default:
+		c.errorf(op.pos, "expected := or += after i, found %q", op.text)
+	}
+}
+
+// Register to register operations, by operator
+var aluOperators = map[string]uint16{
+	":=":  0x8000,
+	"|=":  0x8001,
+	"&=":  0x8002,
+	"^=":  0x8003,
+	"+=":  0x8004,
+	"-=":  0x8005,
+	">>=": 0x8006,
+	"=-":  0x8007,
+	"<<=": 0x800e,
+}
+
+func (c *compiler) assign(r uint16) {
+	x := r << 8
+	op := c.next()
+	t := c.next()
+
+	if y, ok := c.isRegister(t); ok {
+		opcode, ok := aluOperators[op.text]
+		if !ok {
+			c.errorf(op.pos, "unknown operator %q", op.text)
+		}
+		c.inst(opcode | x | uint16(y)<<4)
+		return
+	}
+
+	switch op.text {
+	case ":=":
+		switch t.text {
+		case "random":
+			c.inst(0xc000 | x | uint16(c.immediate(c.next())))
+		case "key":
+			c.inst(0xf00a | x)
+		case "delay":
+			c.inst(0xf007 | x)
+		default:
+			c.inst(0x6000 | x | uint16(c.immediate(t)))
+		}
+	case "+=":
+		c.inst(0x7000 | x | uint16(c.immediate(t)))
+	case "-=":
+		c.inst(0x7000 | x | uint16(-c.immediate(t)))
+	default:
+		if _, ok := aluOperators[op.text]; !ok {
+			c.errorf(op.pos, "unknown operator %q", op.text)
+		}
+		c.errorf(t.pos, "operator %s needs a register, found %q", op.text, t.text)
+	}
+}
+
+// Comparisons with their negation
+var negations = map[string]string{
+	"==":   "!=",
+	"!=":   "==",
+	"<":    ">=",
+	">=":   "<",
+	">":    "<=",
+	"<=":   ">",
+	"key":  "-key",
+	"-key": "key",
+}
+
+// condition compiles a comparison into instructions which skip the next
+// instruction when it is false, or when it is true if negate is set. The
+// <, >, <= and >= comparisons use vf.
+func (c *compiler) condition(negate bool) {
+	x := c.regX()
+	op := c.next()
+
+	cmp, ok := negations[op.text]
+	if !ok {
+		c.errorf(op.pos, "unknown comparison %q", op.text)
+	}
+	if !negate {
+		cmp = op.text
+	}
+
+	switch cmp {
+	case "key":
+		c.inst(0xe0a1 | x)
+		return
+	case "-key":
+		c.inst(0xe09e | x)
+		return
+	}
+
+	t := c.next()
+	y, isReg := c.isRegister(t)
+	regY := uint16(y) << 4
+
+	switch cmp {
+	case "==", "!=":
+		if isReg {
+			c.inst(map[string]uint16{"==": 0x9000, "!=": 0x5000}[cmp] | x | regY)
+		} else {
+			c.inst(map[string]uint16{"==": 0x4000, "!=": 0x3000}[cmp] | x | uint16(c.immediate(t)))
+		}
+		return
+	}
+
+	// vf is set to 1 when x >= y by vf -= y after vf := x, and when y >= x by
+	// vf =- y; with an immediate, vf := n and x and y swap roles
+	var sub uint16
+	switch cmp {
+	case ">", "<=":
+		sub = 0x8f07
+	case "<", ">=":
+		sub = 0x8f05
+	}
+	if isReg {
+		c.inst(0x8f00 | x>>4)
+		c.inst(sub | regY)
+	} else {
+		c.inst(0x6f00 | uint16(c.immediate(t)))
+		c.inst(sub ^ 0x0002 | x>>4)
+	}
+
+	// Skip when vf is 1, or when it is 0
+	switch cmp {
+	case ">", "<":
+		c.inst(0x3f01)
+	default:
+		c.inst(0x4f01)
+	}
+}
+
+// conditional compiles if-then, which skips the next statement when the
+// comparison is false, and if-begin, which jumps past the block instead.
+func (c *compiler) conditional() {
+	// key and -key take no operand
+	n := 3
+	if t := c.peekAt(1).text; t == "key" || t == "-key" {
+		n = 2
+	}
+
+	switch t := c.peekAt(n); t.text {
+	case "then":
+		c.condition(false)
+		c.next()
+	case "begin":
+		c.condition(true)
+		c.next()
+		c.flows = append(c.flows, &flow{pos: t.pos, jumps: []int{c.here}})
+		c.inst(0x1000)
+	default:
+		c.errorf(t.pos, "expected then or begin, found %q", t.text)
+	}
+}
+
+func (c *compiler) elseBlock(t token) {
+	f := c.popFlow(t, false)
+	if f.els {
+		c.errorf(t.pos, "else after else")
+	}
+	f.els = true
+	c.flows = append(c.flows, f)
+
+	// Jump from the end of the if block past the else block, which starts
+	// where the comparison jumps to
+	jumps := f.jumps
+	f.jumps = []int{c.here}
+	c.inst(0x1000)
+	c.patch(jumps)
+}
+
+// innermostLoop returns the loop a while statement belongs to.
+func (c *compiler) innermostLoop(t token) *flow {
+	for i := len(c.flows) - 1; i >= 0; i-- {
+		if c.flows[i].loop {
+			return c.flows[i]
+		}
+	}
+
+	c.errorf(t.pos, "while outside of a loop")
+	return nil
+}
+
+// popFlow ends the innermost block, which must be a loop or an if-begin
+// block.
+func (c *compiler) popFlow(t token, loop bool) *flow {
+	if len(c.flows) == 0 || c.flows[len(c.flows)-1].loop != loop {
+		if loop {
+			c.errorf(t.pos, "again without loop")
+		}
+		c.errorf(t.pos, "%s without if-begin", t.text)
+	}
+
+	f := c.flows[len(c.flows)-1]
+	c.flows = c.flows[:len(c.flows)-1]
+
+	return f
+}
+
+// patch points the jumps at addrs to the current address.
+func (c *compiler) patch(addrs []int) {
+	for _, addr := range addrs {
+		c.memory[addr] |= byte(c.here >> 8)
+		c.memory[addr+1] = byte(c.here)
+		if c.here > 0xfff {
+			c.errorf(c.tokens[c.i-1].pos, "address 0x%x does not fit in 12 bits", c.here)
+		}
+	}
+}
+
+func (c *compiler) peekAt(n int) token {
+	if c.i+n < len(c.tokens) {
+		return c.tokens[c.i+n]
+	}
+
+	return token{pos: c.eof}
+}
+
+// finish resolves the references to labels and builds the program.
+func (c *compiler) finish() *Program {
+	if len(c.flows) > 0 {
+		f := c.flows[len(c.flows)-1]
+		if f.loop {
+			c.errorf(f.pos, "loop without again")
+		}
+		c.errorf(f.pos, "begin without end")
+	}
+
+	for _, f := range c.fixups {
+		v, ok := c.labels[f.name]
+		if !ok {
+			c.errorf(f.pos, "undefined label %q", f.name)
+		}
+		c.resolve(f, v)
+	}
+
+	main, ok := c.labels["main"]
+	if !ok {
+		c.errorf(c.eof, "the program has no main label")
+	}
+	if c.mainJump {
+		v := 0x1000 | c.addr12(c.eof, main)
+		c.memory[programAddress] = byte(v >> 8)
+		c.memory[programAddress+1] = byte(v)
+	}
+
+	end := programAddress
+	for addr := range c.written {
+		if c.written[addr] {
+			end = addr + 1
+		}
+	}
+
+	p := &Program{
+		ROM:         append([]byte(nil), c.memory[programAddress:end]...),
+		Labels:      make(map[string]uint16),
+		Constants:   c.constants,
+		Breakpoints: c.breakpoints,
+		Lines:       c.lines,
+	}
+	for name, addr := range c.labels {
+		p.Labels[name] = uint16(addr)
+	}
+	sort.SliceStable(p.Lines, func(i, j int) bool { return p.Lines[i].Addr < p.Lines[j].Addr })
+
+	return p
+}
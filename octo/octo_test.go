@@ -0,0 +1,222 @@
+package octo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	p, err := Compile("game.8o", []byte(`
+:alias x v1
+:const SPEED 2
+
+: main
+	clear
+	x := 0
+	i := ball       # defined below
+	loop
+		sprite x x 1
+		x += SPEED
+		if x == 10 then x := 0
+	again
+
+: ball
+	0x80
+:calc double { SPEED * 2 + 1 }
+:byte double
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{
+		0x00, 0xe0, // 0x200: clear
+		0x61, 0x00, // 0x202: v1 := 0
+		0xa2, 0x10, // 0x204: i := ball
+		0xd1, 0x11, // 0x206: sprite v1 v1 1
+		0x71, 0x02, // 0x208: v1 += 2
+		0x41, 0x0a, // 0x20a: if v1 == 10 then
+		0x61, 0x00, // 0x20c: v1 := 0
+		0x12, 0x06, // 0x20e: again
+		0x80, // 0x210: ball
+		0x06, // 0x211: double is 2 * (2 + 1), right to left
+	}, p.ROM)
+
+	assert.Equal(t, map[string]uint16{"main": 0x200, "ball": 0x210}, p.Labels)
+	assert.Equal(t, map[string]float64{"SPEED": 2, "double": 6}, p.Constants)
+
+	line, ok := p.Lines.Lookup(0x20b)
+	require.True(t, ok)
+	assert.Equal(t, asm.Pos{File: "game.8o", Line: 12, Col: 3}, line.Pos)
+}
+
+func TestCompile_mainJump(t *testing.T) {
+	p, err := Compile("jump.8o", []byte(`
+: draw
+	sprite v0 v0 5
+	;
+: main
+	draw
+	jump main
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{
+		0x12, 0x06, // 0x200: jump main
+		0xd0, 0x05, // 0x202: draw
+		0x00, 0xee, // 0x204
+		0x22, 0x02, // 0x206: main
+		0x12, 0x06, // 0x208
+	}, p.ROM)
+}
+
+func TestCompile_statements(t *testing.T) {
+	p, err := Compile("statements.8o", []byte(`
+:macro swap a b { vf := a a := b b := vf }
+:macro counter { :byte CALLS }
+: main
+	swap v1 v2
+	counter counter
+	:unpack 0xa data
+	:next target v3 := 5
+	:breakpoint here
+	delay := v0 buzzer := v1
+	v4 := random 0x0f v5 := key v6 := delay
+	v7 -= 1 v7 -= v8 v7 =- v8 v7 >>= v7 v7 <<= v7 v7 |= v8 v7 &= v8 v7 ^= v8
+	i := hex v9 i += v9 bcd v9 save v9 load v9
+	if va key then jump0 target
+	if va -key then native 0x123
+: data
+	hires lores scroll-down 3 scroll-left scroll-right exit
+	i := bighex v1 saveflags v2 loadflags v3
+	scroll-up 4 plane 3 audio pitch := v5 save v1 - v3 load v2 - v4
+	i := long data
+	:pointer data
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{
+		0x8f, 0x10, 0x81, 0x20, 0x82, 0xf0, // swap
+		0x00, 0x01, // counter counter
+		0x60, 0xa2, 0x61, 0x3a, // :unpack
+		0x63, 0x05, // :next target
+		0xf0, 0x15, 0xf1, 0x18,
+		0xc4, 0x0f, 0xf5, 0x0a, 0xf6, 0x07,
+		0x77, 0xff, 0x87, 0x85, 0x87, 0x87, 0x87, 0x76, 0x87, 0x7e, 0x87, 0x81, 0x87, 0x82, 0x87, 0x83,
+		0xf9, 0x29, 0xf9, 0x1e, 0xf9, 0x33, 0xf9, 0x55, 0xf9, 0x65,
+		0xea, 0xa1, 0xb2, 0x0d,
+		0xea, 0x9e, 0x01, 0x23,
+		0x00, 0xff, 0x00, 0xfe, 0x00, 0xc3, 0x00, 0xfc, 0x00, 0xfb, 0x00, 0xfd, // data
+		0xf1, 0x30, 0xf2, 0x75, 0xf3, 0x85,
+		0x00, 0xd4, 0xf3, 0x01, 0xf0, 0x02, 0xf5, 0x3a, 0x51, 0x32, 0x52, 0x43,
+		0xf0, 0x00, 0x02, 0x3a,
+		0x02, 0x3a,
+	}, p.ROM)
+
+	assert.Equal(t, uint16(0x20d), p.Labels["target"])
+	assert.Equal(t, map[uint16]string{0x20e: "here"}, p.Breakpoints)
+}
+
+// Comparisons are checked by running them, as <, >, <= and >= expand to
+// several instructions
+func TestCompile_comparisons(t *testing.T) {
+	compare := map[string]func(a, b int) bool{
+		"==": func(a, b int) bool { return a == b },
+		"!=": func(a, b int) bool { return a != b },
+		"<":  func(a, b int) bool { return a < b },
+		">":  func(a, b int) bool { return a > b },
+		"<=": func(a, b int) bool { return a <= b },
+		">=": func(a, b int) bool { return a >= b },
+	}
+
+	programs := map[string]string{
+		"then register":  ": main v2 := 0 if v0 OP v1 then v2 := 1 loop again",
+		"then immediate": ": main v2 := 0 if v0 OP B then v2 := 1 loop again",
+		"begin":          ": main if v0 OP v1 begin v2 := 1 else v2 := 0 end loop again",
+		"while":          ": main v2 := 1 loop while v0 OP v1 v2 := 0 loop again again loop again",
+	}
+
+	for name, program := range programs {
+		for op, f := range compare {
+			for _, values := range [][2]int{{1, 2}, {2, 2}, {3, 2}, {0, 255}, {255, 0}} {
+				src := strings.NewReplacer("OP", op, "B", fmt.Sprint(values[1])).Replace(program)
+				p, err := Compile("cmp.8o", []byte(src))
+				require.NoError(t, err, src)
+
+				c8 := chip8.Init(nil)
+				c8.SetQuirks(Quirks())
+				c8.LoadProgram(p.ROM)
+				c8.V[0], c8.V[1] = byte(values[0]), byte(values[1])
+				for i := 0; i < 20; i++ {
+					c8.Step()
+				}
+
+				// v2 is set when the comparison holds, except for while,
+				// which clears it when it enters the loop
+				expected := byte(0)
+				if f(values[0], values[1]) != (name == "while") {
+					expected = 1
+				}
+				assert.Equal(t, expected, c8.V[2], "%s: %d %s %d", name, values[0], op, values[1])
+			}
+		}
+	}
+}
+
+func TestQuirks(t *testing.T) {
+	p, err := Compile("quirks.8o", []byte(": main v1 := 0x05 v0 >>= v1 i := 0x300 save v1 load v1 loop again"))
+	require.NoError(t, err)
+
+	c8 := chip8.Init(nil)
+	c8.SetQuirks(Quirks())
+	c8.LoadProgram(p.ROM)
+	for i := 0; i < 5; i++ {
+		c8.Step()
+	}
+
+	// >>= shifts v1 into v0, and save and load advance i past the registers
+	assert.Equal(t, byte(0x02), c8.Peek(0x300))
+	assert.Equal(t, byte(0x05), c8.Peek(0x301))
+	assert.Equal(t, byte(1), c8.V[0xf], "expected VF to hold the bit shifted out of v1")
+	assert.Equal(t, uint16(0x304), c8.I)
+}
+
+func TestCompile_errors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{": start clear", "test.8o:1:14: the program has no main label"},
+		{": main jump nowhere", `test.8o:1:13: undefined label "nowhere"`},
+		{": main v0 := 256", "test.8o:1:14: value out of range for a byte: 256"},
+		{": main v0 @= v1", `test.8o:1:11: unknown operator "@="`},
+		{": main v0 += random", `test.8o:1:14: expected a number, found "random"`},
+		{": main v0 >>= 1", `test.8o:1:15: operator >>= needs a register, found "1"`},
+		{": main sprite v0 v1 16", "test.8o:1:21: sprite height out of range: 16"},
+		{": main if v0 ~ 1 then", `test.8o:1:14: unknown comparison "~"`},
+		{": main if v0 == 1 clear", `test.8o:1:19: expected then or begin, found "clear"`},
+		{": main loop", "test.8o:1:8: loop without again"},
+		{": main if v0 == 1 begin", "test.8o:1:19: begin without end"},
+		{": main end", "test.8o:1:8: end without if-begin"},
+		{": main loop end", "test.8o:1:13: end without if-begin"},
+		{": main again", "test.8o:1:8: again without loop"},
+		{": main while v0 == 1", "test.8o:1:8: while outside of a loop"},
+		{": main if v0 == 1 begin else else end", "test.8o:1:30: else after else"},
+		{": main : main", `test.8o:1:10: "main" is already defined`},
+		{":const v0 1 : main", `test.8o:1:8: "v0" is a register and cannot be redefined`},
+		{": main :calc x { 1 + }", `test.8o:1:22: undefined name "}" in expression`},
+		{": main :calc x { 1 + 2", "test.8o:1:23: unexpected end of file"},
+		{":macro m { m } : main m", `test.8o:1:12: too many macro expansions, is macro "m" recursive?`},
+		{": main :org 0x1000 : far : main2 jump far", "test.8o:1:39: address 0x1000 does not fit in 12 bits"},
+		{": main clear :org 0x200 clear", "test.8o:1:25: 0x0200 is written twice"},
+	}
+
+	for _, test := range tests {
+		_, err := Compile("test.8o", []byte(test.src))
+		assert.EqualError(t, err, test.err, test.src)
+	}
+}
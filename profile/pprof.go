@@ -0,0 +1,233 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// Field numbers of the messages of profile.proto, from
+// github.com/google/pprof/proto/profile.proto
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileMapping       = 3
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profilePeriodType    = 11
+	profilePeriod        = 12
+	valueTypeType        = 1
+	valueTypeUnit        = 2
+	sampleLocationID     = 1
+	sampleValue          = 2
+	mappingID            = 1
+	mappingMemoryStart   = 2
+	mappingMemoryLimit   = 3
+	mappingFilename      = 5
+	mappingHasFunctions  = 7
+	mappingHasFilenames  = 8
+	mappingHasLineNumber = 9
+	locationID           = 1
+	locationMappingID    = 2
+	locationAddress      = 3
+	locationLine         = 4
+	lineFunctionID       = 1
+	lineLine             = 2
+	functionID           = 1
+	functionName         = 2
+	functionSystemName   = 3
+	functionFilename     = 4
+	functionStartLine    = 5
+)
+
+// protoBuffer encodes protocol buffer messages. Fields with zero values are
+// left out, as proto3 does.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.data = append(b.data, byte(v)|0x80)
+		v >>= 7
+	}
+	b.data = append(b.data, byte(v))
+}
+
+// Wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protoBuffer) tag(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	if v != 0 {
+		b.tag(field, wireVarint)
+		b.varint(v)
+	}
+}
+
+func (b *protoBuffer) int64(field int, v int64) {
+	b.uint64(field, uint64(v))
+}
+
+func (b *protoBuffer) bool(field int, v bool) {
+	if v {
+		b.uint64(field, 1)
+	}
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) message(field int, m *protoBuffer) {
+	b.bytes(field, m.data)
+}
+
+func (b *protoBuffer) packed(field int, vs []uint64) {
+	var p protoBuffer
+	for _, v := range vs {
+		p.varint(v)
+	}
+	b.bytes(field, p.data)
+}
+
+// stringTable is the string table of a profile, which starts with the empty
+// string.
+type stringTable struct {
+	list  []string
+	index map[string]int64
+}
+
+func (s *stringTable) add(str string) int64 {
+	if s.index == nil {
+		s.list, s.index = []string{""}, map[string]int64{"": 0}
+	}
+
+	i, ok := s.index[str]
+	if !ok {
+		i = int64(len(s.list))
+		s.list = append(s.list, str)
+		s.index[str] = i
+	}
+
+	return i
+}
+
+// WritePprof writes the profile in the gzip compressed protocol buffer
+// format of pprof. Each subroutine is a function, named by its symbol, and
+// each sample is a calling context: the address of an instruction and the
+// calls leading to it. Program names the mapping, which go tool pprof shows
+// as the binary.
+func (p *Profiler) WritePprof(w io.Writer, program string) error {
+	var out protoBuffer
+	var strs stringTable
+
+	valueType := func(field int, typ, unit string) {
+		var m protoBuffer
+		m.int64(valueTypeType, strs.add(typ))
+		m.int64(valueTypeUnit, strs.add(unit))
+		out.message(field, &m)
+	}
+	valueType(profileSampleType, "instructions", "count")
+	valueType(profilePeriodType, "instructions", "count")
+	out.int64(profilePeriod, 1)
+
+	var mapping protoBuffer
+	mapping.uint64(mappingID, 1)
+	mapping.uint64(mappingMemoryStart, programAddress)
+	mapping.uint64(mappingMemoryLimit, 0x1000)
+	mapping.int64(mappingFilename, strs.add(program))
+	mapping.bool(mappingHasFunctions, true)
+	mapping.bool(mappingHasFilenames, p.symbols != nil)
+	mapping.bool(mappingHasLineNumber, p.symbols != nil)
+	out.message(profileMapping, &mapping)
+
+	// Functions by the address of the subroutine
+	functions := make(map[uint16]uint64)
+	function := func(entry uint16) uint64 {
+		if id, ok := functions[entry]; ok {
+			return id
+		}
+		id := uint64(len(functions) + 1)
+		functions[entry] = id
+
+		var m protoBuffer
+		m.uint64(functionID, id)
+		m.int64(functionName, strs.add(p.name(entry)))
+		m.int64(functionSystemName, strs.add(p.name(entry)))
+		if pos, ok := p.symbols.Source(entry); ok {
+			m.int64(functionFilename, strs.add(pos.File))
+			m.int64(functionStartLine, int64(pos.Line))
+		}
+		out.message(profileFunction, &m)
+
+		return id
+	}
+
+	// Locations by subroutine and address, as shared code has an address
+	// in several subroutines
+	locations := make(map[uint32]uint64)
+	location := func(entry, addr uint16) uint64 {
+		key := uint32(entry)<<16 | uint32(addr)
+		if id, ok := locations[key]; ok {
+			return id
+		}
+		id := uint64(len(locations) + 1)
+		locations[key] = id
+
+		var line protoBuffer
+		line.uint64(lineFunctionID, function(entry))
+		if pos, ok := p.symbols.Source(addr); ok {
+			line.int64(lineLine, int64(pos.Line))
+		}
+
+		var m protoBuffer
+		m.uint64(locationID, id)
+		m.uint64(locationMappingID, 1)
+		m.uint64(locationAddress, uint64(addr))
+		m.message(locationLine, &line)
+		out.message(profileLocation, &m)
+
+		return id
+	}
+
+	p.root.walk(func(c *context) {
+		// The call sites leading to the context, innermost first
+		var stack []uint64
+		for a := c; a.parent != nil; a = a.parent {
+			stack = append(stack, location(a.parent.entry, a.site))
+		}
+
+		addrs := make([]uint16, 0, len(c.counts))
+		for addr := range c.counts {
+			addrs = append(addrs, addr)
+		}
+		sortAddresses(addrs)
+
+		for _, addr := range addrs {
+			var sample protoBuffer
+			sample.packed(sampleLocationID, append([]uint64{location(c.entry, addr)}, stack...))
+			sample.packed(sampleValue, []uint64{c.counts[addr]})
+			out.message(profileSample, &sample)
+		}
+	})
+
+	for _, s := range strs.list {
+		out.bytes(profileStringTable, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(out.data); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
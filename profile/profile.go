@@ -0,0 +1,298 @@
+// Package profile counts where a CHIP-8 program spends its instructions. A
+// Profiler hook counts the instructions executed at each address, within the
+// subroutines called on the way there, and writes them as a text report or
+// as a pprof profile for go tool pprof.
+//
+// The call stack is followed through 2NNN and 00EE, so programs managing
+// their own stack are attributed to the subroutine they were last called in.
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+)
+
+// Address programs are loaded at, and enter their top-level code at
+const programAddress = 0x200
+
+// context is a node of the calling context tree: the code running after a
+// chain of calls, counting the instructions executed at each address.
+type context struct {
+	parent *context
+	// Address of the call into the context, and of the subroutine called
+	site, entry uint16
+
+	children map[uint32]*context
+	counts   map[uint16]uint64
+}
+
+func newContext(parent *context, site, entry uint16) *context {
+	return &context{
+		parent:   parent,
+		site:     site,
+		entry:    entry,
+		children: make(map[uint32]*context),
+		counts:   make(map[uint16]uint64),
+	}
+}
+
+// call returns the context of a call from site to entry.
+func (c *context) call(site, entry uint16) *context {
+	key := uint32(site)<<16 | uint32(entry)
+	child, ok := c.children[key]
+	if !ok {
+		child = newContext(c, site, entry)
+		c.children[key] = child
+	}
+
+	return child
+}
+
+// walk calls f for c and the contexts below it, in address order.
+func (c *context) walk(f func(*context)) {
+	f(c)
+
+	keys := make([]uint32, 0, len(c.children))
+	for key := range c.children {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, key := range keys {
+		c.children[key].walk(f)
+	}
+}
+
+// Profiler is a hook counting the instructions a program executes. Attach it
+// with chip8.State.AddHook.
+//
+// A frame is the instructions between two ticks of the timers, which the
+// profiler sees through OnTick. An instruction is busy unless it
+// waits: for a key with FX0A, or for the delay timer, from an FX07 reading a
+// nonzero timer up to the FX07 reading zero.
+type Profiler struct {
+	state   *chip8.State
+	symbols *sym.Table
+
+	root    *context
+	current *context
+	calls   map[uint16]uint64
+
+	instructions uint64
+	waiting      bool
+
+	// Frames completed and their busy instructions, and the instructions
+	// of the current frame
+	frames        uint64
+	busy, maxBusy uint64
+	frameBusy     uint64
+}
+
+// NewProfiler returns a profiler for the program running in state. The
+// symbols, which may be nil, name the addresses in reports and profiles.
+func NewProfiler(state *chip8.State, symbols *sym.Table) *Profiler {
+	root := newContext(nil, 0, programAddress)
+
+	return &Profiler{
+		state:   state,
+		symbols: symbols,
+		root:    root,
+		current: root,
+		calls:   make(map[uint16]uint64),
+	}
+}
+
+func (p *Profiler) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	p.instructions++
+	p.current.counts[pc]++
+
+	waiting := p.waiting
+	switch {
+	case op.B00 == 0xf && op.B1 == 0x07:
+		p.waiting = p.state.DelayTimer() != 0
+		waiting = p.waiting
+	case op.B00 == 0xf && op.B1 == 0x0a:
+		waiting = true
+	}
+	if !waiting {
+		p.frameBusy++
+	}
+
+	switch {
+	case op.B00 == 0x2:
+		p.calls[op.Addr()]++
+		p.current = p.current.call(pc, op.Addr())
+	case op.B0 == 0x00 && op.B1 == 0xee && p.current.parent != nil:
+		p.current = p.current.parent
+	}
+}
+
+// OnTick closes the current frame.
+func (p *Profiler) OnTick() {
+	p.frames++
+	p.busy += p.frameBusy
+	if p.frameBusy > p.maxBusy {
+		p.maxBusy = p.frameBusy
+	}
+	p.frameBusy = 0
+}
+
+// Instructions returns the number of instructions executed.
+func (p *Profiler) Instructions() uint64 {
+	return p.instructions
+}
+
+// Counts returns the number of times the instruction at each address was
+// executed.
+func (p *Profiler) Counts() map[uint16]uint64 {
+	counts := make(map[uint16]uint64)
+	p.root.walk(func(c *context) {
+		for pc, n := range c.counts {
+			counts[pc] += n
+		}
+	})
+
+	return counts
+}
+
+// Subroutine is the profile of a subroutine, or of the top-level code at
+// 0x200.
+type Subroutine struct {
+	Entry uint16
+	// Number of calls
+	Calls uint64
+	// Instructions executed in the subroutine itself, and including the
+	// subroutines it calls
+	Flat, Cumulative uint64
+}
+
+// Subroutines returns the profile of the subroutines called, busiest first.
+func (p *Profiler) Subroutines() []Subroutine {
+	subs := make(map[uint16]*Subroutine)
+	get := func(entry uint16) *Subroutine {
+		s, ok := subs[entry]
+		if !ok {
+			s = &Subroutine{Entry: entry, Calls: p.calls[entry]}
+			subs[entry] = s
+		}
+		return s
+	}
+
+	p.root.walk(func(c *context) {
+		var n uint64
+		for _, count := range c.counts {
+			n += count
+		}
+		get(c.entry).Flat += n
+
+		// Recursive calls count once towards the cumulative count
+		seen := make(map[uint16]bool)
+		for a := c; a != nil; a = a.parent {
+			if !seen[a.entry] {
+				seen[a.entry] = true
+				get(a.entry).Cumulative += n
+			}
+		}
+	})
+
+	result := make([]Subroutine, 0, len(subs))
+	for _, s := range subs {
+		if s.Cumulative > 0 || s.Calls > 0 {
+			result = append(result, *s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Cumulative != b.Cumulative {
+			return a.Cumulative > b.Cumulative
+		}
+		return a.Entry < b.Entry
+	})
+
+	return result
+}
+
+// Frames returns the number of frames completed, and the average and
+// largest number of busy instructions in them.
+func (p *Profiler) Frames() (frames uint64, averageBusy float64, maxBusy uint64) {
+	if p.frames == 0 {
+		return 0, 0, 0
+	}
+
+	return p.frames, float64(p.busy) / float64(p.frames), p.maxBusy
+}
+
+// name returns the name of the subroutine at entry.
+func (p *Profiler) name(entry uint16) string {
+	if s, ok := p.symbols.Lookup(entry); ok && s.Addr == entry {
+		return s.Name
+	}
+	if entry == programAddress {
+		return "main"
+	}
+
+	return fmt.Sprintf("sub_%03x", entry)
+}
+
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return 100 * float64(n) / float64(total)
+}
+
+// WriteReport writes a text report of the busy instructions per frame, the
+// top instructions by execution count and the subroutines.
+func (p *Profiler) WriteReport(w io.Writer, top int) error {
+	bw := bufio.NewWriter(w)
+
+	frames, average, most := p.Frames()
+	fmt.Fprintf(bw, "%d instructions", p.instructions)
+	if frames > 0 {
+		fmt.Fprintf(bw, " in %d frames, %.1f busy instructions per frame on average and at most %d", frames, average, most)
+	}
+	fmt.Fprintln(bw)
+
+	counts := p.Counts()
+	addrs := make([]uint16, 0, len(counts))
+	for addr := range counts {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		a, b := counts[addrs[i]], counts[addrs[j]]
+		if a != b {
+			return a > b
+		}
+		return addrs[i] < addrs[j]
+	})
+	if top > 0 && len(addrs) > top {
+		addrs = addrs[:top]
+	}
+
+	fmt.Fprintf(bw, "\n%10s %7s  %-5s  %s\n", "count", "%", "addr", "instruction")
+	for _, addr := range addrs {
+		ins := chip8.Disassemble(p.state.OpAt(addr))
+		line := fmt.Sprintf("%10d %6.1f%%  0x%03x  %s", counts[addr], percent(counts[addr], p.instructions), addr, ins.Format(chip8.SyntaxClassic, p.symbols.Names()))
+		if where := p.symbols.Describe(addr); where != "" {
+			line = fmt.Sprintf("%-50s ; %s", line, where)
+		}
+		fmt.Fprintln(bw, line)
+	}
+
+	fmt.Fprintf(bw, "\n%10s %10s %7s %10s %7s  %s\n", "calls", "flat", "%", "cum", "%", "subroutine")
+	for _, s := range p.Subroutines() {
+		fmt.Fprintf(bw, "%10d %10d %6.1f%% %10d %6.1f%%  0x%03x %s\n", s.Calls, s.Flat, percent(s.Flat, p.instructions), s.Cumulative, percent(s.Cumulative, p.instructions), s.Entry, p.name(s.Entry))
+	}
+
+	return bw.Flush()
+}
+
+func sortAddresses(addrs []uint16) {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+}
@@ -0,0 +1,193 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var program = []byte{
+	0x60, 0x00, // 0x200: V0 = 0x00
+	0x22, 0x10, // 0x202: Call 0x210
+	0x61, 0x03, // 0x204: V1 = 0x03
+	0xf1, 0x15, // 0x206: Set delay timer to V1
+	0xf1, 0x07, // 0x208: V1 = delay timer
+	0x31, 0x00, // 0x20a: Skip next instruction if V1 == 0x00
+	0x12, 0x08, // 0x20c: Jump to 0x208
+	0x12, 0x02, // 0x20e: Jump to 0x202
+	0x70, 0x01, // 0x210: V0 += 0x01
+	0x22, 0x16, // 0x212: Call 0x216
+	0x00, 0xee, // 0x214: Return
+	0x82, 0x00, // 0x216: V2 = V0
+	0x72, 0x01, // 0x218: V2 += 0x01
+	0x00, 0xee, // 0x21a: Return
+}
+
+// symbols are those of the program written in game.asm, one statement per
+// line.
+var symbols = sym.New(map[string]uint16{"start": 0x200, "loop": 0x202, "wait": 0x208, "work": 0x210, "inner": 0x216}, asm.SourceMap{
+	{Addr: 0x200, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 1, Col: 9}},
+	{Addr: 0x202, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 2, Col: 9}},
+	{Addr: 0x204, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 3, Col: 9}},
+	{Addr: 0x206, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 4, Col: 9}},
+	{Addr: 0x208, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 5, Col: 9}},
+	{Addr: 0x20a, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 6, Col: 9}},
+	{Addr: 0x20c, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 7, Col: 9}},
+	{Addr: 0x20e, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 8, Col: 9}},
+	{Addr: 0x210, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 9, Col: 9}},
+	{Addr: 0x212, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 10, Col: 9}},
+	{Addr: 0x214, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 11, Col: 9}},
+	{Addr: 0x216, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 12, Col: 9}},
+	{Addr: 0x218, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 13, Col: 9}},
+	{Addr: 0x21a, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 14, Col: 9}},
+})
+
+// run profiles the program for a number of instructions, ticking the timers
+// every frame.
+func run(cycles int) *Profiler {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(program)
+
+	prof := NewProfiler(&c8, symbols)
+	c8.AddHook(prof)
+
+	perFrame := c8.ClockRate() / chip8.TimerRate
+	for i := 1; i <= cycles; i++ {
+		c8.Step()
+		if i%perFrame == 0 {
+			c8.Tick()
+		}
+	}
+
+	return prof
+}
+
+func TestProfiler(t *testing.T) {
+	prof := run(100)
+
+	assert.Equal(t, uint64(100), prof.Instructions())
+
+	counts := prof.Counts()
+	assert.Equal(t, uint64(1), counts[0x200])
+	assert.Equal(t, counts[0x202], counts[0x216], "every call of work calls inner")
+
+	subs := prof.Subroutines()
+	require.Len(t, subs, 3)
+	assert.Equal(t, Subroutine{Entry: 0x200, Calls: 0, Flat: 100 - subs[1].Cumulative, Cumulative: 100}, subs[0])
+	assert.Equal(t, uint16(0x210), subs[1].Entry)
+	assert.Equal(t, counts[0x202], subs[1].Calls)
+	assert.Equal(t, subs[1].Flat+subs[2].Cumulative, subs[1].Cumulative)
+	assert.Equal(t, Subroutine{Entry: 0x216, Calls: counts[0x212], Flat: subs[2].Cumulative, Cumulative: 3 * counts[0x216]}, subs[2])
+
+	frames, average, most := prof.Frames()
+	assert.Equal(t, uint64(100/(chip8.ClockRate/chip8.TimerRate)), frames)
+	assert.True(t, average > 0 && average < float64(chip8.ClockRate/chip8.TimerRate), "the program waits on the delay timer: %f", average)
+	assert.Equal(t, uint64(chip8.ClockRate/chip8.TimerRate), most, "the first frame is busy")
+}
+
+func TestProfiler_WriteReport(t *testing.T) {
+	prof := run(100)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, prof.WriteReport(out, 2))
+
+	lines := strings.Split(out.String(), "\n")
+	assert.Regexp(t, `^100 instructions in 12 frames, [0-9.]+ busy instructions per frame on average and at most 8$`, lines[0])
+	assert.Equal(t, "     count       %  addr   instruction", lines[2])
+	assert.Regexp(t, `^ +\d+ +\d+\.\d% +0x208  LD V1, DT +; wait game.asm:5$`, lines[3])
+	assert.Regexp(t, `0x20a  SE V1, 0x00 +; wait\+2 game.asm:6$`, lines[4])
+	assert.Equal(t, "     calls       flat       %        cum       %  subroutine", lines[6])
+	assert.Regexp(t, `100  100\.0%  0x200 start$`, lines[7])
+	assert.Regexp(t, `0x210 work$`, lines[8])
+	assert.Regexp(t, `0x216 inner$`, lines[9])
+}
+
+// protoFields decodes the fields of a protocol buffer message, by field
+// number. Varints are returned as uint64, other fields as bytes.
+func protoFields(t *testing.T, data []byte) map[int][]interface{} {
+	varint := func() uint64 {
+		var v uint64
+		for shift := uint(0); ; shift += 7 {
+			require.NotEmpty(t, data)
+			b := data[0]
+			data = data[1:]
+			v |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return v
+			}
+		}
+	}
+
+	fields := make(map[int][]interface{})
+	for len(data) > 0 {
+		tag := varint()
+		switch tag & 7 {
+		case wireVarint:
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], varint())
+		case wireBytes:
+			n := varint()
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], data[:n])
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+
+	return fields
+}
+
+func TestProfiler_WritePprof(t *testing.T) {
+	prof := run(100)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, prof.WritePprof(out, "game.ch8"))
+
+	gz, err := gzip.NewReader(out)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+
+	fields := protoFields(t, data)
+
+	var strs []string
+	for _, s := range fields[profileStringTable] {
+		strs = append(strs, string(s.([]byte)))
+	}
+	assert.Equal(t, "", strs[0])
+	for _, s := range []string{"instructions", "count", "game.ch8", "start", "work", "inner", "game.asm"} {
+		assert.Contains(t, strs, s)
+	}
+
+	assert.Len(t, fields[profileFunction], 3)
+	assert.Len(t, fields[profileMapping], 1)
+
+	// The samples add up to the instructions executed, and the deepest
+	// ones go through both calls
+	var total uint64
+	depth := 0
+	for _, s := range fields[profileSample] {
+		sample := protoFields(t, s.([]byte))
+		values := protoFields(t, append([]byte{wireVarint}, sample[sampleValue][0].([]byte)...))
+		total += values[0][0].(uint64)
+
+		locations := 0
+		for _, b := range sample[sampleLocationID][0].([]byte) {
+			if b < 0x80 {
+				locations++
+			}
+		}
+		if locations > depth {
+			depth = locations
+		}
+	}
+	assert.Equal(t, uint64(100), total)
+	assert.Equal(t, 3, depth)
+}
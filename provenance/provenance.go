@@ -0,0 +1,151 @@
+// Package provenance tracks which instruction last wrote every byte of
+// memory and last changed every pixel of the display, answering "who drew
+// this?" when a pixel is wrong.
+//
+// Tracking costs a store per byte written, so it can be left on while
+// debugging.
+package provenance
+
+import (
+	"github.com/janezkenda/chip8/chip8"
+)
+
+const memorySize = 0x1000
+
+// Display size, and the address of the display buffer
+const (
+	screenWidth   = 64
+	screenHeight  = 32
+	screenAddress = 0xf00
+)
+
+// Write is the instruction which last wrote a byte or changed a pixel.
+type Write struct {
+	PC uint16
+	Op chip8.OpCode
+	// Number of the instruction, counting from 1 since the tracker was
+	// attached, and of the frame it ran in, counting from 0
+	Cycle, Frame uint64
+}
+
+// Tracker is a hook recording the last write to every byte and pixel.
+// Attach it with chip8.State.AddHook. Writes are stamped with the number of
+// times the timers ticked before them, their frame.
+type Tracker struct {
+	state *chip8.State
+
+	// The current instruction, and the frame of the next one
+	current Write
+	frame   uint64
+
+	memory [memorySize]Write
+	pixels [screenWidth * screenHeight]Write
+}
+
+// NewTracker returns a tracker for the program running in state.
+func NewTracker(state *chip8.State) *Tracker {
+	return &Tracker{state: state}
+}
+
+func (t *Tracker) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	t.current.PC, t.current.Op, t.current.Frame = pc, op, t.frame
+	t.current.Cycle++
+}
+
+func (t *Tracker) OnTick() {
+	t.frame++
+}
+
+// OnMemoryWrite records the write, and for the display buffer, which pixels
+// it changes.
+func (t *Tracker) OnMemoryWrite(addr uint16, value byte) {
+	addr &= 0xfff
+	t.memory[addr] = t.current
+
+	if addr < screenAddress {
+		return
+	}
+	changed := t.state.Peek(addr) ^ value
+	offset := int(addr - screenAddress)
+	for bit := 0; bit < 8; bit++ {
+		if changed&(0x80>>uint(bit)) != 0 {
+			t.pixels[offset*8+bit] = t.current
+		}
+	}
+}
+
+// Saved is what a tracker recorded for a byte and for the pixels a write to
+// it changes, kept to undo the write.
+type Saved struct {
+	memory  Write
+	changed byte
+	pixels  []Write
+}
+
+// Save returns what the tracker recorded for the byte at addr and for the
+// pixels writing value to it changes. Call it before the tracker sees the
+// write.
+func (t *Tracker) Save(addr uint16, value byte) Saved {
+	addr &= 0xfff
+	s := Saved{memory: t.memory[addr]}
+	if addr < screenAddress {
+		return s
+	}
+
+	s.changed = t.state.Peek(addr) ^ value
+	offset := int(addr - screenAddress)
+	for bit := 0; bit < 8; bit++ {
+		if s.changed&(0x80>>uint(bit)) != 0 {
+			s.pixels = append(s.pixels, t.pixels[offset*8+bit])
+		}
+	}
+
+	return s
+}
+
+// Restore puts back what Save returned for the byte at addr, when the write
+// is undone.
+func (t *Tracker) Restore(addr uint16, s Saved) {
+	addr &= 0xfff
+	t.memory[addr] = s.memory
+
+	offset := int(addr-screenAddress) * 8
+	for bit := 0; bit < 8; bit++ {
+		if s.changed&(0x80>>uint(bit)) != 0 {
+			t.pixels[offset+bit], s.pixels = s.pixels[0], s.pixels[1:]
+		}
+	}
+}
+
+// Mark is the position of a tracker in the program, the instruction and
+// frame it counted last.
+type Mark struct {
+	current Write
+	frame   uint64
+}
+
+// Mark returns the position of the tracker, to rewind it to when
+// instructions are undone.
+func (t *Tracker) Mark() Mark {
+	return Mark{t.current, t.frame}
+}
+
+// Rewind moves the tracker back to a position returned by Mark.
+func (t *Tracker) Rewind(m Mark) {
+	t.current, t.frame = m.current, m.frame
+}
+
+// WhoWrote returns the instruction which last wrote the byte at addr, and
+// false if none did.
+func (t *Tracker) WhoWrote(addr uint16) (Write, bool) {
+	w := t.memory[addr&0xfff]
+	return w, w.Cycle > 0
+}
+
+// WhoDrew returns the instruction which last turned the pixel at (x, y) on
+// or off, and false if none did. Coordinates wrap around the display.
+func (t *Tracker) WhoDrew(x, y int) (Write, bool) {
+	x, y = (x%screenWidth+screenWidth)%screenWidth, (y%screenHeight+screenHeight)%screenHeight
+	w := t.pixels[y*screenWidth+x]
+	return w, w.Cycle > 0
+}
@@ -0,0 +1,71 @@
+package provenance
+
+import (
+	"testing"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/stretchr/testify/assert"
+)
+
+// program stores a byte, then draws a sprite and erases it in the next
+// frame.
+var program = []byte{
+	0x60, 0x12, // 0x200: V0 = 0x12
+	0xa3, 0x00, // 0x202: I = 0x300
+	0xf0, 0x55, // 0x204: Store V0 at I
+	0xa2, 0x14, // 0x206: I = 0x214
+	0x61, 0x08, // 0x208: V1 = 0x08
+	0x62, 0x02, // 0x20a: V2 = 0x02
+	0xd1, 0x21, // 0x20c: Draw 8x1 sprite at (V1, V2)
+	0x70, 0x01, // 0x20e: V0 += 0x01
+	0xd1, 0x21, // 0x210: Draw 8x1 sprite at (V1, V2)
+	0x12, 0x12, // 0x212: Jump to 0x212
+	0xc0, // 0x214: Sprite
+}
+
+func TestTracker(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(program)
+
+	tr := NewTracker(&c8)
+	c8.AddHook(tr)
+
+	for i := 0; i < 7; i++ {
+		c8.Step()
+	}
+
+	w, ok := tr.WhoWrote(0x300)
+	assert.True(t, ok)
+	assert.Equal(t, Write{0x204, chip8.NewOpCode([2]byte{0xf0, 0x55}), 3, 0}, w)
+
+	_, ok = tr.WhoWrote(0x301)
+	assert.False(t, ok)
+
+	drw := chip8.NewOpCode([2]byte{0xd1, 0x21})
+	w, ok = tr.WhoDrew(8, 2)
+	assert.True(t, ok)
+	assert.Equal(t, Write{0x20c, drw, 7, 0}, w)
+	w, ok = tr.WhoDrew(9, 2)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(7), w.Cycle)
+	_, ok = tr.WhoDrew(10, 2)
+	assert.False(t, ok)
+
+	// Erasing the sprite changes the pixels again, in the next frame
+	c8.Step()
+	c8.Tick()
+	c8.Step()
+	w, ok = tr.WhoDrew(8, 2)
+	assert.True(t, ok)
+	assert.Equal(t, Write{0x210, drw, 9, 1}, w)
+	assert.False(t, c8.Pixel(8, 2))
+
+	w, ok = tr.WhoWrote(0xf11)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(9), w.Cycle)
+
+	// Coordinates wrap around
+	w, ok = tr.WhoDrew(8+64, 2-32)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(9), w.Cycle)
+}
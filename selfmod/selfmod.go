@@ -0,0 +1,234 @@
+// Package selfmod detects CHIP-8 programs modifying their own code as they
+// run. Some programs patch their instructions with FX55 or FX33, which
+// interpreters caching decoded instructions get wrong.
+//
+// A write is reported when it hits an instruction executed before it, or
+// when the byte written is executed after it. Writes of the value already
+// stored are reported too, as a cache would still have to check them.
+package selfmod
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+)
+
+const memorySize = 0x1000
+
+// Kind tells when a modified instruction was executed.
+type Kind int
+
+const (
+	// WriteAfterExecute is a write to an instruction executed before.
+	WriteAfterExecute Kind = iota
+	// ExecuteAfterWrite is a write to a byte executed afterwards.
+	ExecuteAfterWrite
+)
+
+func (k Kind) String() string {
+	switch k {
+	case WriteAfterExecute:
+		return "write after execute"
+	case ExecuteAfterWrite:
+		return "execute after write"
+	}
+
+	return "unknown"
+}
+
+// Report is a write of a program to its own code.
+type Report struct {
+	Kind Kind
+	// Address of the writing instruction, and of the byte written
+	PC, Target uint16
+	// Address of the modified instruction, which starts at the target or at
+	// the byte before
+	Instruction uint16
+	// Instruction before the write and after it; for ExecuteAfterWrite, as
+	// it was loaded and as it was executed
+	Old, New chip8.OpCode
+	// Number of instructions executed when the write was found
+	Cycle uint64
+}
+
+// write is a byte written by the current instruction.
+type write struct {
+	addr uint16
+	old  byte
+}
+
+// pending is a write to a byte not executed yet.
+type pending struct {
+	pc  uint16
+	old byte
+}
+
+// Detector is a hook reporting self-modifying code. Attach it with
+// chip8.State.AddHook.
+type Detector struct {
+	state   *chip8.State
+	symbols *sym.Table
+
+	cycles uint64
+	writes []write
+
+	// Addresses instructions were executed at, and the bytes they cover
+	started, covered [memorySize]bool
+	pending          map[uint16]pending
+
+	reports []Report
+
+	halted bool
+	haltPC uint16
+	reason chip8.HaltReason
+}
+
+// NewDetector returns a detector for the program running in state. The
+// symbols, which may be nil, describe the addresses in reports.
+func NewDetector(state *chip8.State, symbols *sym.Table) *Detector {
+	return &Detector{state: state, symbols: symbols, pending: make(map[uint16]pending)}
+}
+
+// patch returns the instruction at addr with the bytes of writes restored
+// to their old values, the first write of a byte winning.
+func patch(op chip8.OpCode, addr uint16, old func(a uint16) (byte, bool)) chip8.OpCode {
+	b := [2]byte{op.B0, op.B1}
+	for i := range b {
+		if v, ok := old((addr + uint16(i)) & 0xfff); ok {
+			b[i] = v
+		}
+	}
+
+	return chip8.NewOpCode(b)
+}
+
+func (d *Detector) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	d.cycles++
+	d.writes = d.writes[:0]
+
+	pc &= 0xfff
+	old := func(a uint16) (byte, bool) {
+		p, ok := d.pending[a]
+		return p.old, ok
+	}
+	before := patch(op, pc, old)
+	for _, a := range []uint16{pc, (pc + 1) & 0xfff} {
+		if p, ok := d.pending[a]; ok {
+			d.reports = append(d.reports, Report{ExecuteAfterWrite, p.pc, a, pc, before, op, d.cycles})
+		}
+	}
+	delete(d.pending, pc)
+	delete(d.pending, (pc+1)&0xfff)
+
+	d.started[pc] = true
+	d.covered[pc] = true
+	d.covered[(pc+1)&0xfff] = true
+}
+
+func (d *Detector) OnMemoryWrite(addr uint16, value byte) {
+	d.writes = append(d.writes, write{addr & 0xfff, d.state.Peek(addr)})
+}
+
+func (d *Detector) OnAfterInstruction(pc uint16, op chip8.OpCode) {
+	old := func(a uint16) (byte, bool) {
+		for _, w := range d.writes {
+			if w.addr == a {
+				return w.old, true
+			}
+		}
+		return 0, false
+	}
+
+	for _, w := range d.writes {
+		if !d.covered[w.addr] {
+			if p, ok := d.pending[w.addr]; ok {
+				d.pending[w.addr] = pending{pc, p.old}
+			} else {
+				d.pending[w.addr] = pending{pc, w.old}
+			}
+			continue
+		}
+
+		ins := w.addr
+		if !d.started[ins] {
+			ins = (ins - 1) & 0xfff
+		}
+		after := d.state.OpAt(ins)
+		d.reports = append(d.reports, Report{WriteAfterExecute, pc, w.addr, ins, patch(after, ins, old), after, d.cycles})
+	}
+}
+
+func (d *Detector) OnHalt(pc uint16, reason chip8.HaltReason) {
+	d.halted, d.haltPC, d.reason = true, pc, reason
+}
+
+// Reports returns the writes to code found, in the order they were found.
+func (d *Detector) Reports() []Report {
+	return d.reports
+}
+
+// describe returns addr, followed by its symbol and source line if known.
+func (d *Detector) describe(addr uint16) string {
+	if where := d.symbols.Describe(addr); where != "" {
+		return fmt.Sprintf("0x%03x (%s)", addr, where)
+	}
+
+	return fmt.Sprintf("0x%03x", addr)
+}
+
+func format(op chip8.OpCode) string {
+	return fmt.Sprintf("%s %s", op, chip8.Disassemble(op).Format(chip8.SyntaxClassic, nil))
+}
+
+// WriteReport writes the reports a line each, repeated reports once with
+// their count, followed by a summary.
+func (d *Detector) WriteReport(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	type key struct {
+		kind        Kind
+		pc, target  uint16
+		instruction uint16
+		old, new    chip8.OpCode
+	}
+	counts := make(map[key]int)
+	var order []key
+	writers := make(map[uint16]bool)
+	modified := make(map[uint16]bool)
+	for _, r := range d.reports {
+		k := key{r.Kind, r.PC, r.Target, r.Instruction, r.Old, r.New}
+		if counts[k] == 0 {
+			order = append(order, k)
+		}
+		counts[k]++
+		writers[r.PC] = true
+		modified[r.Instruction] = true
+	}
+
+	for _, k := range order {
+		fmt.Fprintf(bw, "%s: %s: %s wrote %s", d.describe(k.pc), k.kind, chip8.Disassemble(d.state.OpAt(k.pc)).Format(chip8.SyntaxClassic, d.symbols.Names()), d.describe(k.target))
+		if k.instruction != k.target {
+			fmt.Fprintf(bw, " in the instruction at 0x%03x", k.instruction)
+		}
+		fmt.Fprintf(bw, ", changing it from %s to %s", format(k.old), format(k.new))
+		if n := counts[k]; n > 1 {
+			fmt.Fprintf(bw, " (%d times)", n)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	if len(d.reports) > 0 {
+		fmt.Fprintln(bw)
+	}
+	fmt.Fprintf(bw, "%d writes to code by %d instructions, modifying %d instructions", len(d.reports), len(writers), len(modified))
+	if d.halted {
+		fmt.Fprintf(bw, "; halted at 0x%03x after %d instructions: %s\n", d.haltPC, d.cycles, d.reason)
+	} else {
+		fmt.Fprintf(bw, "; still running after %d instructions\n", d.cycles)
+	}
+
+	return bw.Flush()
+}
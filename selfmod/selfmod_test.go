@@ -0,0 +1,111 @@
+package selfmod
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// program patches an instruction it has executed and one it is about to.
+var program = []byte{
+	0xa2, 0x0e, // 0x200: I = 0x20e
+	0x60, 0x61, // 0x202: V0 = 0x61
+	0x61, 0x05, // 0x204: V1 = 0x05
+	0xf1, 0x55, // 0x206: Store V0:V1 at I
+	0x22, 0x12, // 0x208: Call 0x212
+	0xa2, 0x00, // 0x20a: I = 0x200
+	0xf0, 0x55, // 0x20c: Store V0 at I
+	0x61, 0x00, // 0x20e: V1 = 0x00
+	0x12, 0x10, // 0x210: Jump to 0x210
+	0x00, 0xee, // 0x212: Return
+}
+
+// symbols are those of the program written in game.asm, one statement per
+// line.
+var symbols = sym.New(map[string]uint16{"start": 0x200, "later": 0x20e, "end": 0x210, "sub": 0x212}, asm.SourceMap{
+	{Addr: 0x200, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 1, Col: 9}},
+	{Addr: 0x202, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 2, Col: 9}},
+	{Addr: 0x204, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 3, Col: 9}},
+	{Addr: 0x206, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 4, Col: 9}},
+	{Addr: 0x208, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 5, Col: 9}},
+	{Addr: 0x20a, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 6, Col: 9}},
+	{Addr: 0x20c, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 7, Col: 9}},
+	{Addr: 0x20e, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 8, Col: 9}},
+	{Addr: 0x210, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 9, Col: 9}},
+	{Addr: 0x212, Size: 2, Pos: asm.Pos{File: "game.asm", Line: 10, Col: 9}},
+})
+
+func run(program []byte, symbols *sym.Table, cycles int) (*Detector, *chip8.State) {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(program)
+
+	d := NewDetector(&c8, symbols)
+	c8.AddHook(d)
+
+	for i := 0; i < cycles && !c8.Halted(); i++ {
+		c8.Step()
+	}
+
+	return d, &c8
+}
+
+func op(b0, b1 byte) chip8.OpCode {
+	return chip8.NewOpCode([2]byte{b0, b1})
+}
+
+func TestDetector(t *testing.T) {
+	d, _ := run(program, symbols, 100)
+
+	assert.Equal(t, []Report{
+		{WriteAfterExecute, 0x20c, 0x200, 0x200, op(0xa2, 0x0e), op(0x61, 0x0e), 8},
+		{ExecuteAfterWrite, 0x206, 0x20e, 0x20e, op(0x61, 0x00), op(0x61, 0x05), 9},
+		{ExecuteAfterWrite, 0x206, 0x20f, 0x20e, op(0x61, 0x00), op(0x61, 0x05), 9},
+	}, d.Reports())
+
+	var out bytes.Buffer
+	require.NoError(t, d.WriteReport(&out))
+	assert.Equal(t, `0x20c (start+12 game.asm:7): write after execute: LD [I], V0 wrote 0x200 (start game.asm:1), changing it from 0xa20e LD I, 0x20e to 0x610e LD V1, 0x0e
+0x206 (start+6 game.asm:4): execute after write: LD [I], V1 wrote 0x20e (later game.asm:8), changing it from 0x6100 LD V1, 0x00 to 0x6105 LD V1, 0x05
+0x206 (start+6 game.asm:4): execute after write: LD [I], V1 wrote 0x20f (later+1 game.asm:8) in the instruction at 0x20e, changing it from 0x6100 LD V1, 0x00 to 0x6105 LD V1, 0x05
+
+3 writes to code by 2 instructions, modifying 2 instructions; halted at 0x210 after 10 instructions: infinite loop
+`, out.String())
+}
+
+func TestDetector_repeated(t *testing.T) {
+	// The loop writes the first byte of its first instruction unchanged
+	d, _ := run([]byte{
+		0x60, 0x60, // 0x200: V0 = 0x60
+		0xa2, 0x00, // 0x202: I = 0x200
+		0xf0, 0x55, // 0x204: Store V0 at I
+		0x12, 0x00, // 0x206: Jump to 0x200
+	}, nil, 12)
+
+	require.Len(t, d.Reports(), 3)
+	assert.Equal(t, op(0x60, 0x60), d.Reports()[0].Old)
+	assert.Equal(t, op(0x60, 0x60), d.Reports()[0].New)
+
+	var out bytes.Buffer
+	require.NoError(t, d.WriteReport(&out))
+	assert.Contains(t, out.String(), "changing it from 0x6060 LD V0, 0x60 to 0x6060 LD V0, 0x60 (3 times)\n")
+	assert.Contains(t, out.String(), "3 writes to code by 1 instructions, modifying 1 instructions; still running after 12 instructions\n")
+}
+
+func TestDetector_data(t *testing.T) {
+	// Writes to data and the stack are not code
+	d, _ := run([]byte{
+		0xa2, 0x0a, // 0x200: I = 0x20a
+		0xf0, 0x55, // 0x202: Store V0 at I
+		0x22, 0x08, // 0x204: Call 0x208
+		0x12, 0x06, // 0x206: Jump to 0x206
+		0x00, 0xee, // 0x208: Return
+		0x00, // 0x20a: buffer
+	}, nil, 100)
+
+	assert.Empty(t, d.Reports())
+}
@@ -0,0 +1,308 @@
+// Package sym reads and writes symbol files, which name the addresses of a
+// program and map its bytes to the source they were built from. The tools
+// load the symbols of rom.ch8 from rom.sym, next to it.
+//
+// A symbol file is text, starting with a header line and followed by a line
+// per label and per line of source, with tab separated fields:
+//
+//	chip8 symbols 1
+//	label	0x200	main
+//	line	0x200	2	game.8o	5	2
+//
+// A label line holds the address and name of a label. A source line holds
+// the address and size of a range of bytes, and the file, line and column
+// they were built from. WriteFile stores the files relative to the directory
+// of the symbol file, and ReadFile resolves them against it again, so the
+// symbols work from any directory.
+package sym
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janezkenda/chip8/asm"
+)
+
+// Header is the first line of a symbol file.
+const Header = "chip8 symbols 1"
+
+// Symbol is a named address.
+type Symbol struct {
+	Name string
+	Addr uint16
+}
+
+// Table holds the symbols and source map of a program. The methods of a nil
+// Table find nothing, so programs without symbols need no special casing.
+type Table struct {
+	// Symbols in address order, and by name for symbols at the same address
+	Symbols []Symbol
+
+	// Source of the bytes of the program
+	Lines asm.SourceMap
+}
+
+// New returns the table of a program with the labels and source map given.
+func New(labels map[string]uint16, lines asm.SourceMap) *Table {
+	t := &Table{Lines: lines}
+	for name, addr := range labels {
+		t.Symbols = append(t.Symbols, Symbol{name, addr})
+	}
+	t.sort()
+
+	return t
+}
+
+func (t *Table) sort() {
+	sort.Slice(t.Symbols, func(i, j int) bool {
+		a, b := t.Symbols[i], t.Symbols[j]
+		if a.Addr != b.Addr {
+			return a.Addr < b.Addr
+		}
+		return a.Name < b.Name
+	})
+}
+
+// Lookup returns the closest symbol at or before addr.
+func (t *Table) Lookup(addr uint16) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+
+	i := sort.Search(len(t.Symbols), func(i int) bool { return t.Symbols[i].Addr > addr })
+	if i == 0 {
+		return Symbol{}, false
+	}
+
+	// The first of the symbols at the same address
+	s := t.Symbols[i-1]
+	for i > 1 && t.Symbols[i-2].Addr == s.Addr {
+		i--
+		s = t.Symbols[i-1]
+	}
+
+	return s, true
+}
+
+// Addr returns the address of the symbol with the given name.
+func (t *Table) Addr(name string) (uint16, bool) {
+	if t == nil {
+		return 0, false
+	}
+
+	for _, s := range t.Symbols {
+		if s.Name == name {
+			return s.Addr, true
+		}
+	}
+
+	return 0, false
+}
+
+// Names returns the name of the first symbol at each address.
+func (t *Table) Names() map[uint16]string {
+	names := make(map[uint16]string)
+	if t == nil {
+		return names
+	}
+
+	for _, s := range t.Symbols {
+		if _, ok := names[s.Addr]; !ok {
+			names[s.Addr] = s.Name
+		}
+	}
+
+	return names
+}
+
+// Label returns addr as the closest symbol at or before it plus an offset,
+// as in main+4, or an empty string when there is no such symbol.
+func (t *Table) Label(addr uint16) string {
+	s, ok := t.Lookup(addr)
+	switch {
+	case !ok:
+		return ""
+	case s.Addr == addr:
+		return s.Name
+	}
+
+	return fmt.Sprintf("%s+%d", s.Name, addr-s.Addr)
+}
+
+// Source returns the position in the source the byte at addr was built
+// from.
+func (t *Table) Source(addr uint16) (asm.Pos, bool) {
+	if t == nil {
+		return asm.Pos{}, false
+	}
+
+	line, ok := t.Lines.Lookup(addr)
+	return line.Pos, ok
+}
+
+// Describe returns the label and source line of addr, as in
+// "main+4 game.8o:12", or an empty string when both are unknown.
+func (t *Table) Describe(addr uint16) string {
+	var parts []string
+	if label := t.Label(addr); label != "" {
+		parts = append(parts, label)
+	}
+	if pos, ok := t.Source(addr); ok {
+		parts = append(parts, fmt.Sprintf("%s:%d", pos.File, pos.Line))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Write writes the table in the symbol file format.
+func (t *Table) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, Header)
+	for _, s := range t.Symbols {
+		fmt.Fprintf(bw, "label\t0x%03x\t%s\n", s.Addr, s.Name)
+	}
+	for _, l := range t.Lines {
+		fmt.Fprintf(bw, "line\t0x%03x\t%d\t%s\t%d\t%d\n", l.Addr, l.Size, l.Pos.File, l.Pos.Line, l.Pos.Col)
+	}
+
+	return bw.Flush()
+}
+
+// Read reads a table in the symbol file format.
+func Read(r io.Reader) (*Table, error) {
+	t := &Table{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 1 {
+			if line != Header {
+				return nil, fmt.Errorf("line 1: not a symbol file")
+			}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if err := t.parse(strings.Split(line, "\t")); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	t.sort()
+	sort.SliceStable(t.Lines, func(i, j int) bool { return t.Lines[i].Addr < t.Lines[j].Addr })
+
+	return t, nil
+}
+
+func (t *Table) parse(fields []string) error {
+	switch {
+	case fields[0] == "label" && len(fields) == 3:
+		addr, err := parseAddr(fields[1])
+		if err != nil {
+			return err
+		}
+		t.Symbols = append(t.Symbols, Symbol{fields[2], addr})
+	case fields[0] == "line" && len(fields) == 6:
+		addr, err := parseAddr(fields[1])
+		if err != nil {
+			return err
+		}
+
+		var numbers [3]int
+		for i, f := range []string{fields[2], fields[4], fields[5]} {
+			if numbers[i], err = strconv.Atoi(f); err != nil {
+				return fmt.Errorf("invalid number %q", f)
+			}
+		}
+
+		t.Lines = append(t.Lines, asm.Line{
+			Addr: addr,
+			Size: numbers[0],
+			Pos:  asm.Pos{File: fields[3], Line: numbers[1], Col: numbers[2]},
+		})
+	default:
+		return fmt.Errorf("invalid entry %q", strings.Join(fields, " "))
+	}
+
+	return nil
+}
+
+func parseAddr(s string) (uint16, error) {
+	addr, err := strconv.ParseUint(s, 0, 12)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+
+	return uint16(addr), nil
+}
+
+// Path returns the path of the symbol file of the ROM at path.
+func Path(rom string) string {
+	return strings.TrimSuffix(rom, filepath.Ext(rom)) + ".sym"
+}
+
+// ReadFile reads the symbol file at path.
+func ReadFile(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for i, l := range t.Lines {
+		if !filepath.IsAbs(l.Pos.File) {
+			t.Lines[i].Pos.File = filepath.Join(dir, filepath.FromSlash(l.Pos.File))
+		}
+	}
+
+	return t, nil
+}
+
+// WriteFile writes the table to the symbol file at path, with the source
+// files relative to its directory.
+func (t *Table) WriteFile(path string) error {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	rel := &Table{Symbols: t.Symbols, Lines: make(asm.SourceMap, len(t.Lines))}
+	for i, l := range t.Lines {
+		if file, err := filepath.Abs(l.Pos.File); err == nil {
+			if file, err = filepath.Rel(dir, file); err == nil {
+				l.Pos.File = filepath.ToSlash(file)
+			}
+		}
+		rel.Lines[i] = l
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := rel.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,102 @@
+package sym
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/janezkenda/chip8/asm"
+)
+
+func testTable() *Table {
+	return New(map[string]uint16{"main": 0x200, "start": 0x200, "draw": 0x210}, asm.SourceMap{
+		{Addr: 0x200, Size: 2, Pos: asm.Pos{File: "game.8o", Line: 3, Col: 2}},
+		{Addr: 0x210, Size: 4, Pos: asm.Pos{File: "lib/draw.8o", Line: 10, Col: 1}},
+	})
+}
+
+func TestTable_Label(t *testing.T) {
+	table := testTable()
+
+	assert.Equal(t, "main", table.Label(0x200))
+	assert.Equal(t, "main+14", table.Label(0x20e))
+	assert.Equal(t, "draw+2", table.Label(0x212))
+	assert.Equal(t, "", table.Label(0x1ff))
+
+	addr, ok := table.Addr("start")
+	assert.True(t, ok)
+	assert.Equal(t, uint16(0x200), addr)
+	assert.Equal(t, map[uint16]string{0x200: "main", 0x210: "draw"}, table.Names())
+
+	assert.Equal(t, "draw+3 lib/draw.8o:10", table.Describe(0x213))
+	assert.Equal(t, "main+4", table.Describe(0x204))
+
+	var empty *Table
+	assert.Equal(t, "", empty.Describe(0x200))
+	_, ok = empty.Source(0x200)
+	assert.False(t, ok)
+}
+
+func TestTable_Write(t *testing.T) {
+	table := testTable()
+
+	var buf bytes.Buffer
+	require.NoError(t, table.Write(&buf))
+	assert.Equal(t, `chip8 symbols 1
+label	0x200	main
+label	0x200	start
+label	0x210	draw
+line	0x200	2	game.8o	3	2
+line	0x210	4	lib/draw.8o	10	1
+`, buf.String())
+
+	read, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, table, read)
+}
+
+func TestTable_WriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sym")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Sources are relative to the working directory when assembled
+	table := New(nil, asm.SourceMap{
+		{Addr: 0x200, Size: 2, Pos: asm.Pos{File: filepath.Join(dir, "src", "game.8o"), Line: 3, Col: 2}},
+	})
+	path := filepath.Join(dir, "build", "game.sym")
+	require.NoError(t, os.Mkdir(filepath.Dir(path), 0755))
+	require.NoError(t, table.WriteFile(path))
+
+	// and relative to the symbol file in it
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "line\t0x200\t2\t../src/game.8o\t3\t2\n")
+
+	read, err := ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, table, read)
+}
+
+func TestRead_errors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"symbols\n", "line 1: not a symbol file"},
+		{Header + "\nlabel\t0x1000\tfar\n", `line 2: invalid address "0x1000"`},
+		{Header + "\n\nline\t0x200\ttwo\tgame.8o\t1\t1\n", `line 3: invalid number "two"`},
+		{Header + "\nconst\tx\t1\n", `line 2: invalid entry "const x 1"`},
+	}
+
+	for _, test := range tests {
+		_, err := Read(strings.NewReader(test.src))
+		assert.EqualError(t, err, test.err, test.src)
+	}
+}
@@ -0,0 +1,203 @@
+// Package timeline writes a timeline of a running CHIP-8 program in the
+// Chrome trace event format, which chrome://tracing and Perfetto open. It
+// shows when subroutines run relative to the frames, as spans from 2NNN to
+// 00EE, with instant events for sprites drawn, collisions, keys and beeps,
+// and counter tracks for the timers.
+//
+// Time is that of the instructions executed at the clock rate of the
+// program. Each tick of the timers ends a frame, and the vblank event of the
+// next one is written with its first instruction.
+package timeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+)
+
+// IDs of the process and thread the events belong to
+const (
+	pid = 1
+	tid = 1
+)
+
+// event is a trace event, as described in the Trace Event Format document.
+type event struct {
+	Name  string                 `json:"name,omitempty"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	Time  float64                `json:"ts"`
+	PID   int                    `json:"pid"`
+	TID   int                    `json:"tid"`
+	Scope string                 `json:"s,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// Writer is a hook writing the timeline of a program as it runs. Attach it
+// with chip8.State.AddHook and Close it when the program is done.
+type Writer struct {
+	state   *chip8.State
+	symbols *sym.Table
+
+	buf   *bufio.Writer
+	err   error
+	first bool
+
+	// Time of the current instruction in microseconds, and the number of
+	// instructions executed
+	now    float64
+	cycles uint64
+
+	// Number of the current frame, and whether its vblank is still to be
+	// written
+	frame  uint64
+	vblank bool
+
+	// Subroutine calls in progress
+	depth int
+
+	// Timers and keys as last written
+	started bool
+	dt, st  byte
+	keys    uint16
+}
+
+// NewWriter returns a timeline writer for the program running in state.
+// The symbols, which may be nil, name the subroutines.
+func NewWriter(w io.Writer, state *chip8.State, symbols *sym.Table) *Writer {
+	t := &Writer{state: state, symbols: symbols, buf: bufio.NewWriter(w), first: true, vblank: true}
+
+	_, t.err = t.buf.WriteString(`{"displayTimeUnit":"ms","traceEvents":[`)
+	t.emit(event{Name: "process_name", Phase: "M", Args: map[string]interface{}{"name": "chip8"}})
+	t.emit(event{Name: "thread_name", Phase: "M", Args: map[string]interface{}{"name": "CPU"}})
+
+	return t
+}
+
+func (t *Writer) emit(e event) {
+	if t.err != nil {
+		return
+	}
+
+	e.PID, e.TID = pid, tid
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.err = err
+		return
+	}
+
+	if !t.first {
+		t.buf.WriteByte(',')
+	}
+	t.first = false
+	t.buf.WriteByte('\n')
+	_, t.err = t.buf.Write(b)
+}
+
+func (t *Writer) instant(name, cat string, args map[string]interface{}) {
+	t.emit(event{Name: name, Cat: cat, Phase: "i", Time: t.now, Scope: "t", Args: args})
+}
+
+// name returns the name of the subroutine at entry.
+func (t *Writer) name(entry uint16) string {
+	if s, ok := t.symbols.Lookup(entry); ok && s.Addr == entry {
+		return s.Name
+	}
+
+	return fmt.Sprintf("sub_%03x", entry)
+}
+
+func (t *Writer) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	if t.cycles > 0 {
+		t.now += 1e6 / float64(t.state.ClockRate())
+	}
+
+	if t.vblank {
+		t.emit(event{Name: "vblank", Cat: "frame", Phase: "i", Time: t.now, Scope: "g", Args: map[string]interface{}{"frame": t.frame}})
+		t.vblank = false
+	}
+	t.cycles++
+
+	t.timers()
+	t.keyboard()
+
+	switch {
+	case op.B00 == 0x2:
+		t.depth++
+		t.emit(event{Name: t.name(op.Addr()), Cat: "call", Phase: "B", Time: t.now, Args: map[string]interface{}{"from": fmt.Sprintf("0x%03x", pc)}})
+	case op.B0 == 0x00 && op.B1 == 0xee && t.depth > 0:
+		t.depth--
+		t.emit(event{Phase: "E", Time: t.now})
+	}
+}
+
+func (t *Writer) OnTick() {
+	t.frame++
+	t.vblank = true
+}
+
+// timers writes the counters of the timers when they change, and a beep when
+// the sound timer starts.
+func (t *Writer) timers() {
+	dt, st := t.state.DelayTimer(), t.state.SoundTimer()
+	if !t.started || dt != t.dt {
+		t.emit(event{Name: "DT", Phase: "C", Time: t.now, Args: map[string]interface{}{"DT": dt}})
+	}
+	if !t.started || st != t.st {
+		t.emit(event{Name: "ST", Phase: "C", Time: t.now, Args: map[string]interface{}{"ST": st}})
+		if st > 0 && (t.st == 0 || !t.started) {
+			t.instant("beep", "sound", map[string]interface{}{"duration": st})
+		}
+	}
+
+	t.started, t.dt, t.st = true, dt, st
+}
+
+// keyboard writes the keys pressed and released since the last instruction.
+func (t *Writer) keyboard() {
+	var keys uint16
+	for k := byte(0); k < 16; k++ {
+		if t.state.Key(k) {
+			keys |= 1 << k
+		}
+	}
+
+	for k := uint(0); k < 16; k++ {
+		switch {
+		case keys&^t.keys&(1<<k) != 0:
+			t.instant(fmt.Sprintf("key %X down", k), "key", map[string]interface{}{"key": k})
+		case t.keys&^keys&(1<<k) != 0:
+			t.instant(fmt.Sprintf("key %X up", k), "key", map[string]interface{}{"key": k})
+		}
+	}
+
+	t.keys = keys
+}
+
+func (t *Writer) OnDraw(x, y, height byte, collision bool) {
+	t.instant("draw", "display", map[string]interface{}{"x": x, "y": y, "height": height, "collision": collision})
+	if collision {
+		t.instant("collision", "display", map[string]interface{}{"x": x, "y": y})
+	}
+}
+
+// Close ends the subroutine calls still in progress and finishes the JSON
+// document. It does not close the underlying writer.
+func (t *Writer) Close() error {
+	for ; t.depth > 0; t.depth-- {
+		t.emit(event{Phase: "E", Time: t.now})
+	}
+
+	if t.err != nil {
+		return t.err
+	}
+	if _, err := t.buf.WriteString("\n]}\n"); err != nil {
+		return err
+	}
+
+	return t.buf.Flush()
+}
@@ -0,0 +1,88 @@
+package timeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var program = []byte{
+	0x60, 0x02, // 0x200: V0 = 0x02
+	0xf0, 0x18, // 0x202: Set sound timer to V0
+	0x22, 0x0c, // 0x204: Call 0x20c
+	0x22, 0x0c, // 0x206: Call 0x20c
+	0x72, 0x01, // 0x208: V2 += 0x01
+	0x12, 0x08, // 0x20a: Jump to 0x208
+	0xa2, 0x12, // 0x20c: I = 0x212
+	0xd1, 0x11, // 0x20e: Draw 8x1 sprite at (V1, V1)
+	0x00, 0xee, // 0x210: Return
+	0x80, // 0x212: Sprite
+}
+
+func TestWriter(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(program)
+
+	out := &bytes.Buffer{}
+	w := NewWriter(out, &c8, sym.New(map[string]uint16{"start": 0x200, "loop": 0x208, "draw": 0x20c}, nil))
+	c8.AddHook(w)
+
+	// Run two frames, pressing a key in the second, and stop in the middle
+	// of the third call
+	perFrame := c8.ClockRate() / chip8.TimerRate
+	for i := 1; i <= 2*perFrame; i++ {
+		if i == perFrame+2 {
+			c8.SetKey(0xa, true)
+		}
+		c8.Step()
+		if i%perFrame == 0 {
+			c8.Tick()
+		}
+	}
+	c8.PC = 0x204
+	c8.Step()
+	require.NoError(t, w.Close())
+
+	var doc struct {
+		DisplayTimeUnit string
+		TraceEvents     []event
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &doc))
+	assert.Equal(t, "ms", doc.DisplayTimeUnit)
+
+	// Instructions take 2ms at 500Hz
+	var events []string
+	for _, e := range doc.TraceEvents {
+		require.Equal(t, pid, e.PID)
+		events = append(events, fmt.Sprintf("%s %s %g %v", e.Phase, e.Name, e.Time/1000, e.Args))
+	}
+	assert.Equal(t, []string{
+		"M process_name 0 map[name:chip8]",
+		"M thread_name 0 map[name:CPU]",
+		"i vblank 0 map[frame:0]",
+		"C DT 0 map[DT:0]",
+		"C ST 0 map[ST:0]",
+		"C ST 4 map[ST:2]",
+		"i beep 4 map[duration:2]",
+		"B draw 4 map[from:0x204]",
+		"i draw 8 map[collision:false height:1 x:0 y:0]",
+		"E  10 map[]",
+		"B draw 12 map[from:0x206]",
+		"i vblank 16 map[frame:1]",
+		"C ST 16 map[ST:1]",
+		"i draw 16 map[collision:true height:1 x:0 y:0]",
+		"i collision 16 map[x:0 y:0]",
+		"i key A down 18 map[key:10]",
+		"E  18 map[]",
+		"i vblank 32 map[frame:2]",
+		"C ST 32 map[ST:0]",
+		"B draw 32 map[from:0x204]",
+		"E  32 map[]",
+	}, events)
+}
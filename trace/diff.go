@@ -0,0 +1,134 @@
+package trace
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// NewReader returns a reader for a trace, decompressing it if it was written
+// with gzip.
+func NewReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+
+	return br, nil
+}
+
+// Divergence is the first difference between two traces.
+type Divergence struct {
+	// Line number of the first differing line, starting at 1
+	Line int
+
+	// Lines leading up to the divergence, which are the same in both traces
+	Context []string
+
+	// The differing line and the lines after it in each trace. A trace that
+	// ended early has fewer lines.
+	A, B []string
+}
+
+// Diff compares the traces read from a and b and returns their first
+// divergence, with up to context lines around it. It returns nil when the
+// traces are equal.
+func Diff(a, b io.Reader, context int) (*Divergence, error) {
+	sa, sb := bufio.NewScanner(a), bufio.NewScanner(b)
+
+	var before []string
+	for line := 1; ; line++ {
+		okA, okB := sa.Scan(), sb.Scan()
+		if err := firstError(sa.Err(), sb.Err()); err != nil {
+			return nil, err
+		}
+
+		if !okA && !okB {
+			return nil, nil
+		}
+
+		if okA && okB && sa.Text() == sb.Text() {
+			before = append(before, sa.Text())
+			if len(before) > context {
+				before = before[1:]
+			}
+			continue
+		}
+
+		d := &Divergence{Line: line, Context: before}
+		d.A = collect(sa, okA, context)
+		d.B = collect(sb, okB, context)
+
+		return d, firstError(sa.Err(), sb.Err())
+	}
+}
+
+// collect returns the current line of s, if there is one, and up to n more.
+func collect(s *bufio.Scanner, ok bool, n int) []string {
+	var lines []string
+	for i := 0; ok && i <= n; i++ {
+		lines = append(lines, s.Text())
+		ok = s.Scan()
+	}
+
+	return lines
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write describes the divergence, naming the traces a and b.
+func (d *Divergence) Write(w io.Writer, a, b string) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "traces diverge at line %d\n", d.Line)
+	for _, line := range d.Context {
+		fmt.Fprintf(bw, "  %s\n", line)
+	}
+
+	switch {
+	case len(d.A) == 0:
+		fmt.Fprintf(bw, "- %s ends here\n", a)
+	case len(d.B) == 0:
+		fmt.Fprintf(bw, "+ %s ends here\n", b)
+	default:
+		fmt.Fprintf(bw, "- %s\n", d.A[0])
+		fmt.Fprintf(bw, "+ %s\n", d.B[0])
+		fmt.Fprintf(bw, "  %s^\n", strings.Repeat(" ", diffColumn(d.A[0], d.B[0])))
+	}
+
+	for _, l := range []struct {
+		name  string
+		lines []string
+	}{{a, d.A}, {b, d.B}} {
+		if len(l.lines) > 1 {
+			fmt.Fprintf(bw, "then in %s:\n", l.name)
+			for _, line := range l.lines[1:] {
+				fmt.Fprintf(bw, "  %s\n", line)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// diffColumn returns the index of the first byte at which a and b differ.
+func diffColumn(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+
+	return i
+}
@@ -0,0 +1,116 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/janezkenda/chip8/asm"
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+)
+
+var program = []byte{
+	0x60, 0x22, // 0x200: V0 = 0x22
+	0x23, 0x00, // 0x202: Call 0x300
+	0x61, 0x01, // 0x204: V1 = 0x01
+}
+
+func runTrace(t *testing.T, opts Options) string {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram(program)
+	c8.Poke(0x300, 0x72) // 0x300: V2 += 0x05
+	c8.Poke(0x301, 0x05)
+	c8.Poke(0x302, 0x00) // 0x302: Return
+	c8.Poke(0x303, 0xee)
+
+	out := &bytes.Buffer{}
+	w := NewWriter(out, &c8, opts)
+	c8.AddHook(w)
+
+	for i := 0; i < 5; i++ {
+		c8.Step()
+	}
+	require.NoError(t, w.Close())
+
+	if !opts.Gzip {
+		return out.String()
+	}
+
+	r, err := NewReader(out)
+	require.NoError(t, err)
+
+	b := &bytes.Buffer{}
+	_, err = b.ReadFrom(r)
+	require.NoError(t, err)
+
+	return b.String()
+}
+
+func TestWriter(t *testing.T) {
+	lines := strings.Split(runTrace(t, Options{}), "\n")
+
+	require.Len(t, lines, 6)
+	assert.Equal(t, "000000001 200 6022 LD V0, 0x22      00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 I:000 SP:EFE DT:00 ST:00", lines[0])
+	assert.Equal(t, "000000003 300 7205 ADD V2, 0x05     22 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 I:000 SP:EFC DT:00 ST:00", lines[2])
+	assert.Equal(t, "", lines[5])
+}
+
+func TestWriter_symbols(t *testing.T) {
+	symbols := sym.New(map[string]uint16{"main": 0x200, "add": 0x300}, asm.SourceMap{
+		{Addr: 0x200, Size: 4, Pos: asm.Pos{File: "game.8o", Line: 3, Col: 2}},
+	})
+	lines := strings.Split(runTrace(t, Options{Symbols: symbols}), "\n")
+
+	require.Len(t, lines, 6)
+	assert.True(t, strings.HasSuffix(lines[1], "ST:00 ; main+2 game.8o:3"), lines[1])
+	assert.True(t, strings.HasSuffix(lines[3], "ST:00 ; add+2"), lines[3])
+}
+
+func TestWriter_filters(t *testing.T) {
+	lines := strings.Fields(runTrace(t, Options{Ranges: []Range{{0x300, 0x3ff}}}))
+	assert.Equal(t, []string{"000000003", "000000004"}, []string{lines[0], lines[26]})
+
+	lines = strings.Split(strings.TrimSpace(runTrace(t, Options{MaxDepth: 1})), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[2], "000000005 204"))
+}
+
+func TestWriter_gzip(t *testing.T) {
+	assert.Equal(t, runTrace(t, Options{}), runTrace(t, Options{Gzip: true}))
+}
+
+func TestDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n"
+	b := "1\n2\n3\n4\nx\n6\n"
+
+	d, err := Diff(strings.NewReader(a), strings.NewReader(b), 2)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+
+	assert.Equal(t, 5, d.Line)
+	assert.Equal(t, []string{"3", "4"}, d.Context)
+	assert.Equal(t, []string{"5", "6"}, d.A)
+	assert.Equal(t, []string{"x", "6"}, d.B)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, d.Write(out, "a.log", "b.log"))
+	assert.Equal(t, "traces diverge at line 5\n  3\n  4\n- 5\n+ x\n  ^\nthen in a.log:\n  6\nthen in b.log:\n  6\n", out.String())
+}
+
+func TestDiff_shorter(t *testing.T) {
+	d, err := Diff(strings.NewReader("1\n2\n"), strings.NewReader("1\n"), 3)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+
+	assert.Equal(t, 2, d.Line)
+	assert.Equal(t, []string{"2"}, d.A)
+	assert.Empty(t, d.B)
+
+	d, err = Diff(strings.NewReader("1\n2\n"), strings.NewReader("1\n2\n"), 3)
+	require.NoError(t, err)
+	assert.Nil(t, d)
+}
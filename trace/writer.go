@@ -0,0 +1,128 @@
+// Package trace writes and compares execution traces. A trace has one line
+// per executed instruction with the machine state before it executed, in a
+// fixed width format that diffs well against traces of other emulators.
+package trace
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/janezkenda/chip8/sym"
+)
+
+// Bottom of the stack, as set up by chip8.Init
+const stackBase = 0xefe
+
+// Range is an inclusive range of addresses.
+type Range struct {
+	Start, End uint16
+}
+
+func (r Range) contains(addr uint16) bool {
+	return addr >= r.Start && addr <= r.End
+}
+
+type Options struct {
+	// Only trace instructions within these ranges; trace all of them when
+	// empty.
+	Ranges []Range
+
+	// Only trace instructions up to this call depth. Code outside of any
+	// subroutine is at depth 1. Zero traces all depths.
+	MaxDepth int
+
+	// Compress the trace with gzip.
+	Gzip bool
+
+	// Symbols of the program. When set, each line ends with the label and
+	// source line of the instruction.
+	Symbols *sym.Table
+}
+
+// Writer is a hook writing a line for every executed instruction. Attach it
+// with chip8.State.AddHook and Close it when the program is done.
+type Writer struct {
+	state *chip8.State
+	opts  Options
+
+	buf  *bufio.Writer
+	gz   *gzip.Writer
+	err  error
+	line []byte
+
+	cycle uint64
+}
+
+func NewWriter(w io.Writer, state *chip8.State, opts Options) *Writer {
+	t := &Writer{state: state, opts: opts}
+	if opts.Gzip {
+		t.gz = gzip.NewWriter(w)
+		w = t.gz
+	}
+	t.buf = bufio.NewWriter(w)
+
+	return t
+}
+
+func (t *Writer) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	t.cycle++
+	if t.err != nil || !t.traced(pc) {
+		return
+	}
+
+	s := t.state
+	t.line = t.line[:0]
+	t.line = append(t.line, fmt.Sprintf("%09d %03X %04X %-16s", t.cycle, pc, uint16(op.B0)<<8|uint16(op.B1), chip8.Mnemonic(op))...)
+	for _, v := range s.V {
+		t.line = append(t.line, fmt.Sprintf(" %02X", v)...)
+	}
+	t.line = append(t.line, fmt.Sprintf(" I:%03X SP:%03X DT:%02X ST:%02X", s.I, s.SP, s.DelayTimer(), s.SoundTimer())...)
+	if where := t.opts.Symbols.Describe(pc); where != "" {
+		t.line = append(t.line, " ; "...)
+		t.line = append(t.line, where...)
+	}
+	t.line = append(t.line, '\n')
+
+	_, t.err = t.buf.Write(t.line)
+}
+
+func (t *Writer) traced(pc uint16) bool {
+	if t.opts.MaxDepth > 0 && Depth(t.state) > t.opts.MaxDepth {
+		return false
+	}
+	if len(t.opts.Ranges) == 0 {
+		return true
+	}
+
+	for _, r := range t.opts.Ranges {
+		if r.contains(pc) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close flushes the trace. It does not close the underlying writer.
+func (t *Writer) Close() error {
+	if t.err != nil {
+		return t.err
+	}
+	if err := t.buf.Flush(); err != nil {
+		return err
+	}
+	if t.gz != nil {
+		return t.gz.Close()
+	}
+
+	return nil
+}
+
+// Depth returns the call depth of the program, which is 1 outside of any
+// subroutine.
+func Depth(s *chip8.State) int {
+	return (stackBase-int(s.SP))/2 + 1
+}
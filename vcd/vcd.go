@@ -0,0 +1,191 @@
+// Package vcd writes the registers, timers and keypad of a running CHIP-8
+// program as a Value Change Dump, the waveform format of Verilog simulators,
+// which waveform viewers such as GTKWave open.
+//
+// A sample is taken before each instruction, and one after the last when
+// the writer is closed. By default time counts instructions; it can also
+// count microseconds at the clock rate of the program.
+package vcd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/janezkenda/chip8/chip8"
+)
+
+// signal is a value of the machine dumped as a wire.
+type signal struct {
+	name  string
+	width int
+	value func(s *chip8.State) uint64
+}
+
+func register(x int) signal {
+	return signal{fmt.Sprintf("V%X", x), 8, func(s *chip8.State) uint64 { return uint64(s.V[x]) }}
+}
+
+var signals = []signal{
+	register(0x0), register(0x1), register(0x2), register(0x3),
+	register(0x4), register(0x5), register(0x6), register(0x7),
+	register(0x8), register(0x9), register(0xa), register(0xb),
+	register(0xc), register(0xd), register(0xe), register(0xf),
+	{"I", 12, func(s *chip8.State) uint64 { return uint64(s.I) }},
+	{"PC", 12, func(s *chip8.State) uint64 { return uint64(s.PC) }},
+	{"SP", 12, func(s *chip8.State) uint64 { return uint64(s.SP) }},
+	{"DT", 8, func(s *chip8.State) uint64 { return uint64(s.DelayTimer()) }},
+	{"ST", 8, func(s *chip8.State) uint64 { return uint64(s.SoundTimer()) }},
+	{"keys", 16, func(s *chip8.State) uint64 {
+		var keys uint64
+		for k := byte(0); k < 16; k++ {
+			if s.Key(k) {
+				keys |= 1 << k
+			}
+		}
+		return keys
+	}},
+}
+
+// Signals returns the names of the signals, in the order they are dumped:
+// V0-VF, I, PC, SP, DT, ST and keys, whose bit k is set while key k is down.
+func Signals() []string {
+	names := make([]string, len(signals))
+	for i, s := range signals {
+		names[i] = s.name
+	}
+
+	return names
+}
+
+// Options select what a Writer dumps.
+type Options struct {
+	// Names of the signals to dump, in any case; all of them when empty.
+	Signals []string
+
+	// Count time in microseconds at the clock rate of the program instead of
+	// in instructions.
+	Realtime bool
+}
+
+// Writer is a hook writing a value change dump. Attach it with
+// chip8.State.AddHook and Close it when the program is done.
+type Writer struct {
+	state   *chip8.State
+	opts    Options
+	signals []signal
+
+	// Write errors are kept by buf and returned by Close
+	buf *bufio.Writer
+
+	// Values last written, and whether any were
+	values  []uint64
+	started bool
+
+	// Number of instructions executed, and the time in microseconds
+	cycles uint64
+	now    float64
+}
+
+// NewWriter returns a writer dumping the program running in state to w. It
+// fails on unknown signal names.
+func NewWriter(w io.Writer, state *chip8.State, opts Options) (*Writer, error) {
+	v := &Writer{state: state, opts: opts, buf: bufio.NewWriter(w)}
+
+	if len(opts.Signals) == 0 {
+		v.signals = signals
+	}
+	for _, name := range opts.Signals {
+		found := false
+		for _, s := range signals {
+			if strings.EqualFold(s.name, strings.TrimSpace(name)) {
+				v.signals = append(v.signals, s)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown signal %q", name)
+		}
+	}
+	v.values = make([]uint64, len(v.signals))
+
+	v.header()
+
+	return v, nil
+}
+
+// id returns the identifier code of the i-th signal.
+func id(i int) string {
+	const first, count = '!', '~' - '!' + 1
+
+	var b []byte
+	for {
+		b = append(b, byte(first+i%count))
+		if i /= count; i == 0 {
+			return string(b)
+		}
+		i--
+	}
+}
+
+func (v *Writer) header() {
+	timescale, comment := "1 ns", "one time unit per instruction"
+	if v.opts.Realtime {
+		timescale, comment = "1 us", fmt.Sprintf("instructions run at %d Hz", v.state.ClockRate())
+	}
+
+	fmt.Fprintf(v.buf, "$version chip8 $end\n$comment %s $end\n$timescale %s $end\n", comment, timescale)
+	fmt.Fprintln(v.buf, "$scope module chip8 $end")
+	for i, s := range v.signals {
+		fmt.Fprintf(v.buf, "$var wire %d %s %s $end\n", s.width, id(i), s.name)
+	}
+	fmt.Fprintln(v.buf, "$upscope $end\n$enddefinitions $end")
+}
+
+// sample writes the signals which changed since the last sample.
+func (v *Writer) sample() {
+	time := v.cycles
+	if v.opts.Realtime {
+		time = uint64(v.now + 0.5)
+	}
+
+	stamped := false
+	for i, s := range v.signals {
+		value := s.value(v.state)
+		if v.started && value == v.values[i] {
+			continue
+		}
+
+		if !stamped {
+			fmt.Fprintf(v.buf, "#%d\n", time)
+			if !v.started {
+				fmt.Fprintln(v.buf, "$dumpvars")
+			}
+			stamped = true
+		}
+		fmt.Fprintf(v.buf, "b%b %s\n", value, id(i))
+		v.values[i] = value
+	}
+
+	if !v.started {
+		fmt.Fprintln(v.buf, "$end")
+		v.started = true
+	}
+}
+
+func (v *Writer) OnBeforeInstruction(pc uint16, op chip8.OpCode) {
+	v.sample()
+
+	v.cycles++
+	v.now += 1e6 / float64(v.state.ClockRate())
+}
+
+// Close writes the state after the last instruction and flushes the dump.
+// It does not close the underlying writer.
+func (v *Writer) Close() error {
+	v.sample()
+
+	return v.buf.Flush()
+}
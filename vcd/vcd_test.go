@@ -0,0 +1,96 @@
+package vcd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janezkenda/chip8/chip8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram([]byte{
+		0x60, 0x05, // LD V0, 5
+		0x61, 0x02, // LD V1, 2
+		0xf1, 0x15, // LD DT, V1
+		0x12, 0x00, // JP 0x200
+	})
+
+	out := &bytes.Buffer{}
+	w, err := NewWriter(out, &c8, Options{Signals: []string{"v0", "PC", "DT", "keys"}})
+	require.NoError(t, err)
+	c8.AddHook(w)
+
+	for i := 0; i < 3; i++ {
+		c8.Step()
+	}
+	c8.SetKey(0xa, true)
+	c8.Step()
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, `$version chip8 $end
+$comment one time unit per instruction $end
+$timescale 1 ns $end
+$scope module chip8 $end
+$var wire 8 ! V0 $end
+$var wire 12 " PC $end
+$var wire 8 # DT $end
+$var wire 16 $ keys $end
+$upscope $end
+$enddefinitions $end
+#0
+$dumpvars
+b0 !
+b1000000000 "
+b0 #
+b0 $
+$end
+#1
+b101 !
+b1000000010 "
+#2
+b1000000100 "
+#3
+b1000000110 "
+b10 #
+b10000000000 $
+#4
+b1000000000 "
+`, out.String())
+}
+
+func TestWriter_realtime(t *testing.T) {
+	c8 := chip8.Init(nil)
+	c8.LoadProgram([]byte{0x70, 0x01, 0x12, 0x00})
+	c8.SetClockRate(1000)
+
+	out := &bytes.Buffer{}
+	w, err := NewWriter(out, &c8, Options{Signals: []string{"V0"}, Realtime: true})
+	require.NoError(t, err)
+	c8.AddHook(w)
+
+	for i := 0; i < 4; i++ {
+		c8.Step()
+	}
+	require.NoError(t, w.Close())
+
+	assert.Contains(t, out.String(), "$comment instructions run at 1000 Hz $end\n$timescale 1 us $end\n")
+	assert.Contains(t, out.String(), "#1000\nb1 !\n#3000\nb10 !\n")
+}
+
+func TestNewWriter_unknownSignal(t *testing.T) {
+	c8 := chip8.Init(nil)
+
+	_, err := NewWriter(&bytes.Buffer{}, &c8, Options{Signals: []string{"V0", "VG"}})
+	assert.EqualError(t, err, `unknown signal "VG"`)
+}
+
+func TestID(t *testing.T) {
+	assert.Equal(t, "!", id(0))
+	assert.Equal(t, "~", id(93))
+	assert.Equal(t, "!!", id(94))
+	assert.Equal(t, "\"!", id(95))
+	assert.Len(t, Signals(), 22)
+}